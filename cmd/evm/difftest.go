@@ -0,0 +1,153 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/eth/tracers/difftest"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+	"github.com/ethereum/go-ethereum/internal/flags"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/tests"
+	"github.com/urfave/cli/v2"
+)
+
+var diffTestCommand = &cli.Command{
+	Action:    diffTestCmd,
+	Name:      "difftest",
+	Usage:     "executes the given state tests with the native and the EVMC interpreters, reporting the first divergence",
+	ArgsUsage: "<file>",
+	Flags: []cli.Flag{
+		stateTestForkFlag,
+		utils.EVMInterpreterFlag,
+	},
+	Category: flags.DevCategory,
+	Description: `
+The executions are compared step by step (stack, memory, gas and storage) only if
+both interpreters report their steps, as stated by stepsCompared in the reports.
+EVMC interpreters don't report their steps, so in practice only the divergence
+in the call frames, the results, the logs and the post state is reported.`,
+}
+
+// DifftestResult contains the comparison of the executions of a state test by
+// the native and the EVMC interpreters.
+type DifftestResult struct {
+	Name   string           `json:"name"`
+	Fork   string           `json:"fork"`
+	Report *difftest.Report `json:"report,omitempty"`
+	Error  string           `json:"error,omitempty"`
+}
+
+func diffTestCmd(ctx *cli.Context) error {
+	if len(ctx.Args().First()) == 0 {
+		return errors.New("path-to-test argument required")
+	}
+	evmc := ctx.String(utils.EVMInterpreterFlag.Name)
+	if evmc == "" {
+		return fmt.Errorf("EVMC interpreter required (--%s)", utils.EVMInterpreterFlag.Name)
+	}
+	// Configure the go-ethereum logger
+	glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(false)))
+	glogger.Verbosity(log.Lvl(ctx.Int(VerbosityFlag.Name)))
+	log.Root().SetHandler(glogger)
+
+	vm.InitEVMCEVM(evmc)
+
+	config := &logger.Config{
+		EnableMemory:     !ctx.Bool(DisableMemoryFlag.Name),
+		DisableStack:     ctx.Bool(DisableStackFlag.Name),
+		DisableStorage:   ctx.Bool(DisableStorageFlag.Name),
+		EnableReturnData: !ctx.Bool(DisableReturnDataFlag.Name),
+	}
+	// Load the test content from the input file
+	src, err := os.ReadFile(ctx.Args().First())
+	if err != nil {
+		return err
+	}
+	var tests map[string]tests.StateTest
+	if err = json.Unmarshal(src, &tests); err != nil {
+		return err
+	}
+	var (
+		results  = make([]DifftestResult, 0, len(tests))
+		diverged int
+	)
+	for key, test := range tests {
+		for _, st := range test.Subtests(nil) {
+			if ctx.String(stateTestForkFlag.Name) != "" && ctx.String(stateTestForkFlag.Name) != st.Fork {
+				continue
+			}
+			result := DifftestResult{Name: key, Fork: st.Fork}
+			report, err := diffStateTest(&test, st, config, evmc)
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Report = report
+				if report.Divergence != nil {
+					diverged++
+				}
+			}
+			results = append(results, result)
+		}
+	}
+	out, _ := json.MarshalIndent(results, "", "  ")
+	fmt.Println(string(out))
+	if diverged > 0 {
+		return fmt.Errorf("%d of %d tests diverged", diverged, len(results))
+	}
+	return nil
+}
+
+// diffStateTest runs a state subtest with the native and the EVMC interpreters,
+// and compares the executions.
+func diffStateTest(test *tests.StateTest, st tests.StateSubtest, config *logger.Config, evmc string) (*difftest.Report, error) {
+	var (
+		recorders = make([]*difftest.Recorder, 2)
+		states    = make([]*state.StateDB, 2)
+	)
+	for i, interpreter := range []string{"", evmc} {
+		recorders[i] = difftest.NewRecorder(config)
+		cfg := vm.Config{Debug: true, Tracer: recorders[i], EVMInterpreter: interpreter}
+
+		// Execution errors are part of the compared results
+		_, statedb, _, err := test.RunNoVerify(st, cfg, false)
+		if statedb == nil {
+			return nil, err
+		}
+		states[i] = statedb
+	}
+	report := &difftest.Report{
+		Native:        recorders[0].Summary(),
+		EVMC:          recorders[1].Summary(),
+		StepsCompared: difftest.StepsComparable(recorders[0], recorders[1]),
+		Divergence:    difftest.Compare(recorders[0], recorders[1]),
+	}
+	if report.Divergence == nil {
+		report.Divergence = difftest.CompareLogs(states[0].Logs(), states[1].Logs())
+	}
+	if report.Divergence == nil {
+		report.Divergence = difftest.ComparePostState(states[0], states[1])
+	}
+	return report, nil
+}
@@ -227,6 +227,8 @@ func init() {
 		stateTransitionCommand,
 		transactionCommand,
 		blockBuilderCommand,
+		diffTestCommand,
+		verifyWitnessCommand,
 	}
 }
 
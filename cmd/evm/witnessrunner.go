@@ -0,0 +1,143 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/beacon"
+	"github.com/ethereum/go-ethereum/consensus/clique"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/stateless"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/internal/flags"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/params/types/ctypes"
+	"github.com/urfave/cli/v2"
+)
+
+// witnessNetworks are the chain configurations selectable by name when
+// verifying an execution witness.
+var witnessNetworks = map[string]ctypes.ChainConfigurator{
+	"classic": params.ClassicChainConfig,
+	"mordor":  params.MordorChainConfig,
+	"mainnet": params.MainnetChainConfig,
+	"sepolia": params.SepoliaChainConfig,
+	"goerli":  params.GoerliChainConfig,
+}
+
+var witnessNetworkFlag = &cli.StringFlag{
+	Name:  "network",
+	Usage: "Name of the network the witness belongs to (classic, mordor, mainnet, sepolia, goerli), alternatively to --prestate",
+}
+
+var verifyWitnessCommand = &cli.Command{
+	Action:    verifyWitnessCmd,
+	Name:      "verify-witness",
+	Usage:     "executes a block solely from its execution witness and verifies the post-state",
+	ArgsUsage: "<file>",
+	Description: `
+The verify-witness command re-executes the block contained in an execution witness,
+as returned by debug_executionWitness, without access to any chain data. The file
+may contain the bare witness or the full JSON-RPC response. The resulting state and
+receipt roots are checked against the block header.
+
+The chain configuration is selected by --network, or read from the genesis file
+given by --prestate.`,
+	Flags: []cli.Flag{
+		witnessNetworkFlag,
+		GenesisFlag,
+	},
+	Category: flags.DevCategory,
+}
+
+func verifyWitnessCmd(ctx *cli.Context) error {
+	if len(ctx.Args().First()) == 0 {
+		return errors.New("path-to-witness argument required")
+	}
+	// Configure the go-ethereum logger
+	glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(false)))
+	glogger.Verbosity(log.Lvl(ctx.Int(VerbosityFlag.Name)))
+	log.Root().SetHandler(glogger)
+
+	var config ctypes.ChainConfigurator
+	switch {
+	case ctx.IsSet(witnessNetworkFlag.Name):
+		name := strings.ToLower(ctx.String(witnessNetworkFlag.Name))
+		if config = witnessNetworks[name]; config == nil {
+			return fmt.Errorf("unknown network %q", name)
+		}
+	case ctx.IsSet(GenesisFlag.Name):
+		config = readGenesis(ctx.String(GenesisFlag.Name)).Config
+	default:
+		return fmt.Errorf("chain configuration required (--%s or --%s)", witnessNetworkFlag.Name, GenesisFlag.Name)
+	}
+	engine, err := witnessEngine(config)
+	if err != nil {
+		return err
+	}
+	// Load the witness, unwrapping it from a JSON-RPC response if needed
+	src, err := os.ReadFile(ctx.Args().First())
+	if err != nil {
+		return err
+	}
+	var response struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(src, &response); err == nil && len(response.Result) > 0 {
+		src = response.Result
+	}
+	witness := new(stateless.Witness)
+	if err := json.Unmarshal(src, witness); err != nil {
+		return fmt.Errorf("invalid witness: %v", err)
+	}
+	block := witness.Block
+	root, receiptRoot, err := core.ExecuteStateless(config, engine, witness, vm.Config{})
+	if err != nil {
+		return fmt.Errorf("block %d execution failed: %v", block.NumberU64(), err)
+	}
+	if root != block.Root() {
+		return fmt.Errorf("block %d state root mismatch: have %x, want %x", block.NumberU64(), root, block.Root())
+	}
+	if receiptRoot != block.ReceiptHash() {
+		return fmt.Errorf("block %d receipt root mismatch: have %x, want %x", block.NumberU64(), receiptRoot, block.ReceiptHash())
+	}
+	fmt.Printf("Block %d (%x) verified: %d trie nodes, %d codes, %d headers\n", block.NumberU64(), block.Hash(), len(witness.State), len(witness.Codes), len(witness.Headers))
+	return nil
+}
+
+// witnessEngine creates the consensus engine finalizing the blocks of the given
+// chain. Seals are not verified, only the state transitions of the engines are
+// needed.
+func witnessEngine(config ctypes.ChainConfigurator) (consensus.Engine, error) {
+	switch engine := config.GetConsensusEngineType(); {
+	case engine.IsEthash():
+		return beacon.New(ethash.NewFaker()), nil
+	case engine.IsClique():
+		return beacon.New(clique.New(&ctypes.CliqueConfig{Period: config.GetCliquePeriod(), Epoch: config.GetCliqueEpoch()}, rawdb.NewMemoryDatabase())), nil
+	default:
+		return nil, fmt.Errorf("unsupported consensus engine %v", engine)
+	}
+}
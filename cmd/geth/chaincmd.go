@@ -41,10 +41,16 @@ import (
 	"github.com/ethereum/go-ethereum/node"
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/params/types/genesisT"
-	"github.com/ethereum/go-ethereum/trie"
 	"github.com/urfave/cli/v2"
 )
 
+var (
+	historyFreezerFlag = &cli.BoolFlag{
+		Name:  "history.freezer",
+		Usage: "Write the imported history directly into the ancient store, without executing the blocks",
+	}
+)
+
 var (
 	initCommand = &cli.Command{
 		Action:    initGenesis,
@@ -119,6 +125,43 @@ Optional second and third arguments control the first and
 last block to write. In this mode, the file will be appended
 if already existing. If the file ends with .gz, the output will
 be gzipped.`,
+	}
+	importHistoryCommand = &cli.Command{
+		Action:    importHistory,
+		Name:      "import-history",
+		Usage:     "Import blockchain history from Era1 files",
+		ArgsUsage: "<dir>",
+		Flags: flags.Merge([]cli.Flag{
+			utils.CacheFlag,
+			utils.SyncModeFlag,
+			utils.GCModeFlag,
+			utils.SnapshotFlag,
+			utils.TxLookupLimitFlag,
+			historyFreezerFlag,
+		}, utils.DatabasePathFlags, utils.NetworkFlags),
+		Description: `
+The import-history command imports the blocks, receipts and total difficulties from
+the Era1 files of the network in the given directory, as written by export-history.
+The checksums of the files, their accumulator roots and the consistency of the blocks
+with their receipts are verified before importing.
+
+By default the blocks are executed. With --history.freezer, the blocks and receipts
+are written directly into the ancient store of an empty database instead, and the
+state is expected to be synced from the network afterwards.`,
+	}
+	exportHistoryCommand = &cli.Command{
+		Action:    exportHistory,
+		Name:      "export-history",
+		Usage:     "Export blockchain history to Era1 files",
+		ArgsUsage: "<dir> <first> <last>",
+		Flags: flags.Merge([]cli.Flag{
+			utils.CacheFlag,
+			utils.SyncModeFlag,
+		}, utils.DatabasePathFlags, utils.NetworkFlags),
+		Description: `
+The export-history command writes the blocks, receipts and total difficulties of
+the given range into the directory, as Era1 files of epochs of 8192 blocks along
+with a checksums.txt file. The first block must start an epoch.`,
 	}
 	importPreimagesCommand = &cli.Command{
 		Action:    importPreimages,
@@ -198,9 +241,7 @@ func initGenesis(ctx *cli.Context) error {
 		if err != nil {
 			utils.Fatalf("Failed to open database: %v", err)
 		}
-		triedb := trie.NewDatabaseWithConfig(chaindb, &trie.Config{
-			Preimages: ctx.Bool(utils.CachePreimagesFlag.Name),
-		})
+		triedb := utils.MakeTrieDatabase(ctx, chaindb, ctx.Bool(utils.CachePreimagesFlag.Name), false)
 		_, hash, err := core.SetupGenesisBlock(chaindb, triedb, genesis)
 		if err != nil {
 			utils.Fatalf("Failed to write genesis block: %v", err)
@@ -368,6 +409,63 @@ func exportChain(ctx *cli.Context) error {
 	return nil
 }
 
+// historyNetwork returns the name of the network the Era1 files are named after.
+func historyNetwork(ctx *cli.Context) string {
+	for _, flag := range utils.NetworkFlags {
+		if flag := flag.(*cli.BoolFlag); ctx.Bool(flag.Name) {
+			return flag.Name
+		}
+	}
+	return "mainnet"
+}
+
+// importHistory imports the chain history from the Era1 files of a directory.
+func importHistory(ctx *cli.Context) error {
+	if ctx.Args().Len() != 1 {
+		utils.Fatalf("This command requires an argument.")
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	chain, db := utils.MakeChain(ctx, stack, false)
+	defer db.Close()
+
+	var (
+		start   = time.Now()
+		network = historyNetwork(ctx)
+	)
+	if err := utils.ImportHistory(chain, ctx.Args().First(), network, ctx.Bool(historyFreezerFlag.Name)); err != nil {
+		chain.Stop()
+		utils.Fatalf("Import error: %v\n", err)
+	}
+	chain.Stop()
+	fmt.Printf("Import done in %v\n", time.Since(start))
+	return nil
+}
+
+// exportHistory exports the chain history into Era1 files.
+func exportHistory(ctx *cli.Context) error {
+	if ctx.Args().Len() != 3 {
+		utils.Fatalf("Usage: %s", ctx.Command.ArgsUsage)
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	chain, _ := utils.MakeChain(ctx, stack, true)
+	start := time.Now()
+
+	first, ferr := strconv.ParseUint(ctx.Args().Get(1), 10, 64)
+	last, lerr := strconv.ParseUint(ctx.Args().Get(2), 10, 64)
+	if ferr != nil || lerr != nil {
+		utils.Fatalf("Export error in parsing parameters: block number not an integer\n")
+	}
+	if err := utils.ExportHistory(chain, ctx.Args().First(), first, last, historyNetwork(ctx)); err != nil {
+		utils.Fatalf("Export error: %v\n", err)
+	}
+	fmt.Printf("Export done in %v\n", time.Since(start))
+	return nil
+}
+
 // importPreimages imports preimage data from the specified file.
 func importPreimages(ctx *cli.Context) error {
 	if ctx.Args().Len() < 1 {
@@ -471,10 +569,8 @@ func dump(ctx *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	config := &trie.Config{
-		Preimages: true, // always enable preimage lookup
-	}
-	state, err := state.New(root, state.NewDatabaseWithConfig(db, config), nil)
+	triedb := utils.MakeTrieDatabase(ctx, db, true, true) // always enable preimage lookup
+	state, err := state.New(root, state.NewDatabaseWithNodeDB(db, triedb), nil)
 	if err != nil {
 		return err
 	}
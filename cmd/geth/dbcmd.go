@@ -65,10 +65,12 @@ Remove blockchain and state databases`,
 			dbPutCmd,
 			dbGetSlotsCmd,
 			dbDumpFreezerIndex,
+			dbRecompressFreezerCmd,
 			dbImportCmd,
 			dbExportCmd,
 			dbMetadataCmd,
 			dbCheckStateContentCmd,
+			dbMigrateCmd,
 		},
 	}
 	dbInspectCmd = &cli.Command{
@@ -164,6 +166,30 @@ WARNING: This is a low-level operation which may cause database corruption!`,
 		}, utils.NetworkFlags, utils.DatabasePathFlags),
 		Description: "This command displays information about the freezer index.",
 	}
+	dbRecompressCodecFlag = &cli.StringFlag{
+		Name:  "codec",
+		Usage: "Compression codec to rewrite the freezer tables with ('none', 'snappy' or 'zstd')",
+	}
+	dbRecompressFreezerCmd = &cli.Command{
+		Action:    freezerRecompress,
+		Name:      "freezer-recompress",
+		Usage:     "Rewrite freezer tables with another compression codec",
+		ArgsUsage: "<freezer-type> <table-type> [<table-type>...]",
+		Flags: flags.Merge([]cli.Flag{
+			dbRecompressCodecFlag,
+			utils.SyncModeFlag,
+		}, utils.NetworkFlags, utils.DatabasePathFlags),
+		Description: `geth db freezer-recompress --codec zstd chain bodies receipts
+
+This command rewrites the given tables of a freezer with the given compression
+codec, e.g. the bodies and receipts of the chain freezer. The zstd codec uses a
+dictionary sampled from the items of each table. The codec is recorded in the
+table metadata, items appended later are compressed with it too.
+
+Every table is rewritten next to the original one, verified and swapped into
+place. The recompression can be interrupted at any time, running the command
+again resumes it from the last written item. The node must not be running.`,
+	}
 	dbImportCmd = &cli.Command{
 		Action:    importLDBdata,
 		Name:      "import",
@@ -184,6 +210,29 @@ WARNING: This is a low-level operation which may cause database corruption!`,
 		}, utils.NetworkFlags, utils.DatabasePathFlags),
 		Description: "Exports the specified chain data to an RLP encoded stream, optionally gzip-compressed.",
 	}
+	dbMigrateTargetFlag = &cli.StringFlag{
+		Name:  "to",
+		Usage: "Database engine to migrate to ('leveldb' or 'pebble')",
+	}
+	dbMigrateCmd = &cli.Command{
+		Action: migrateDatabase,
+		Name:   "migrate",
+		Usage:  "Migrate the chain database to another database engine",
+		Flags: flags.Merge([]cli.Flag{
+			dbMigrateTargetFlag,
+			utils.SyncModeFlag,
+			utils.CacheFlag,
+			utils.CacheDatabaseFlag,
+		}, utils.NetworkFlags, utils.DatabasePathFlags),
+		Description: `geth db migrate --to pebble
+
+This command copies every key of the chain database into a new database of the
+given engine, verifies the copy by comparing the checksums of both databases and
+swaps the migrated database into place. The ancient store is left untouched.
+
+The migration can be interrupted at any time, running the command again resumes
+it from the last written batch.`,
+	}
 	dbMetadataCmd = &cli.Command{
 		Action: showMetaData,
 		Name:   "metadata",
@@ -556,6 +605,45 @@ func freezerInspect(ctx *cli.Context) error {
 	return rawdb.InspectFreezerTable(ancient, freezer, table, start, end)
 }
 
+func freezerRecompress(ctx *cli.Context) error {
+	if ctx.NArg() < 2 {
+		return fmt.Errorf("required arguments: %v", ctx.Command.ArgsUsage)
+	}
+	codec := ctx.String(dbRecompressCodecFlag.Name)
+	if codec == "" {
+		return fmt.Errorf("missing compression codec, use --%s", dbRecompressCodecFlag.Name)
+	}
+	var (
+		stack, _  = makeConfigNode(ctx)
+		interrupt = make(chan os.Signal, 1)
+		stop      = make(chan struct{})
+	)
+	ancient := stack.ResolveAncient("chaindata", ctx.String(utils.AncientFlag.Name))
+	stack.Close()
+
+	signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	defer close(interrupt)
+	go func() {
+		if _, ok := <-interrupt; ok {
+			log.Info("Interrupted during freezer recompression, stopping at next batch")
+		}
+		close(stop)
+	}()
+	freezer := ctx.Args().Get(0)
+	for _, table := range ctx.Args().Slice()[1:] {
+		if err := rawdb.RecompressFreezerTable(ancient, freezer, table, codec, stop); err != nil {
+			return err
+		}
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+	}
+	return nil
+}
+
 func importLDBdata(ctx *cli.Context) error {
 	start := 0
 	switch ctx.NArg() {
@@ -686,6 +774,40 @@ func exportChaindata(ctx *cli.Context) error {
 	return utils.ExportChaindata(ctx.Args().Get(1), kind, exporter(db), stop)
 }
 
+func migrateDatabase(ctx *cli.Context) error {
+	if ctx.NArg() != 0 {
+		return fmt.Errorf("no arguments required: %v", ctx.Command.ArgsUsage)
+	}
+	engine := ctx.String(dbMigrateTargetFlag.Name)
+	if engine == "" {
+		return fmt.Errorf("missing target database engine, use --%s", dbMigrateTargetFlag.Name)
+	}
+	var (
+		stack, _  = makeConfigNode(ctx)
+		interrupt = make(chan os.Signal, 1)
+		stop      = make(chan struct{})
+	)
+	defer stack.Close()
+	signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	defer close(interrupt)
+	go func() {
+		if _, ok := <-interrupt; ok {
+			log.Info("Interrupted during database migration, stopping at next batch")
+		}
+		close(stop)
+	}()
+	var (
+		name    = "chaindata"
+		cache   = ctx.Int(utils.CacheFlag.Name) * ctx.Int(utils.CacheDatabaseFlag.Name) / 100
+		handles = utils.MakeDatabaseHandles(ctx.Int(utils.FDLimitFlag.Name))
+	)
+	if ctx.String(utils.SyncModeFlag.Name) == "light" {
+		name = "lightchaindata"
+	}
+	return utils.MigrateDatabase(stack.ResolvePath(name), engine, cache, handles, stop)
+}
+
 func showMetaData(ctx *cli.Context) error {
 	stack, _ := makeConfigNode(ctx)
 	defer stack.Close()
@@ -17,10 +17,18 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/ethereum/go-ethereum/cmd/utils"
@@ -31,6 +39,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/state/snapshot"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/internal/flags"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/rlp"
@@ -39,6 +48,19 @@ import (
 )
 
 var (
+	snapshotIntegrityThreadsFlag = &cli.IntFlag{
+		Name:  "threads",
+		Usage: "Number of snapshot ranges to check in parallel",
+		Value: runtime.NumCPU(),
+	}
+	snapshotIntegrityReportFlag = &cli.StringFlag{
+		Name:  "report",
+		Usage: "File to record the inconsistent snapshot ranges into",
+	}
+	snapshotIntegrityRepairFlag = &cli.BoolFlag{
+		Name:  "repair",
+		Usage: "Repair the inconsistent snapshot ranges from the state trie",
+	}
 	snapshotCommand = &cli.Command{
 		Name:        "snapshot",
 		Usage:       "A set of commands based on the snapshot",
@@ -90,6 +112,40 @@ In other words, this command does the snapshot to trie conversion.
 				Description: `
 geth snapshot check-dangling-storage <state-root> traverses the snap storage 
 data, and verifies that all snapshot storage data has a corresponding account. 
+`,
+			},
+			{
+				Name:   "check-integrity",
+				Usage:  "Check the snapshot against the state trie with range proofs",
+				Action: checkSnapshotIntegrity,
+				Flags: flags.Merge([]cli.Flag{
+					snapshotIntegrityThreadsFlag,
+					snapshotIntegrityReportFlag,
+					snapshotIntegrityRepairFlag,
+				}, utils.NetworkFlags, utils.DatabasePathFlags),
+				Description: `
+geth snapshot check-integrity [--report <file>] [--repair]
+will verify the persistent snapshot layer against the state trie of the same root
+in parallel ranges, using range proofs, and record the exact ranges found
+inconsistent into the report file. With --repair, only the inconsistent ranges are
+regenerated from the trie afterwards, without a full snapshot regeneration.
+
+The same check is available on a running node via admin.checkSnapshotIntegrity,
+running in the background. Its progress is reported by admin.snapshotIntegrityProgress
+and its outcome by admin.snapshotIntegrityReport.
+`,
+			},
+			{
+				Name:      "repair-integrity",
+				Usage:     "Repair the inconsistent snapshot ranges recorded by check-integrity",
+				ArgsUsage: "<report>",
+				Action:    repairSnapshotIntegrity,
+				Flags:     flags.Merge(utils.NetworkFlags, utils.DatabasePathFlags),
+				Description: `
+geth snapshot repair-integrity <report>
+will regenerate the snapshot ranges recorded as inconsistent by
+'geth snapshot check-integrity --report <report>' from the state trie, and verify
+them again afterwards.
 `,
 			},
 			{
@@ -151,6 +207,35 @@ as the backend data source, making this command a lot faster.
 
 The argument is interpreted as block number or hash. If none is provided, the latest
 block is used.
+`,
+			},
+			{
+				Name:      "export",
+				Usage:     "Export the state snapshot into a file, along with range proofs",
+				ArgsUsage: "<file> [<root>]",
+				Action:    exportSnapshot,
+				Flags:     flags.Merge(utils.NetworkFlags, utils.DatabasePathFlags),
+				Description: `
+geth snapshot export <file> <state-root>
+will write the flat accounts, storage slots and contract codes of the state with
+the given root into the file, in chunks carrying the Merkle proofs of their ranges.
+The state root needs to belong to a canonical block covered by the snapshot, the
+default is the HEAD state. If the file has the .gz suffix, gzip compression is used.
+`,
+			},
+			{
+				Name:      "import",
+				Usage:     "Import the state snapshot from a file, regenerating the state trie",
+				ArgsUsage: "<file>",
+				Action:    importSnapshot,
+				Flags:     flags.Merge(utils.NetworkFlags, utils.DatabasePathFlags),
+				Description: `
+geth snapshot import <file>
+will verify the state in the file exported by 'geth snapshot export' against the
+range proofs, persist it as the state snapshot and regenerate the state trie from
+it. The state is verified against the root of the block it was exported at, which
+needs to be present in the local canonical chain, e.g. imported by
+'geth import-history --history.freezer'. The block becomes the new chain head.
 `,
 			},
 		},
@@ -166,6 +251,9 @@ func pruneState(ctx *cli.Context) error {
 	chaindb := utils.MakeChainDatabase(ctx, stack, false)
 	defer chaindb.Close()
 
+	if rawdb.ReadStateScheme(chaindb) == rawdb.PathScheme {
+		log.Crit("Offline pruning is not required for path scheme")
+	}
 	prunerconfig := pruner.Config{
 		Datadir:   stack.ResolvePath(""),
 		Cachedir:  stack.ResolvePath(config.Eth.TrieCleanCacheJournal),
@@ -213,7 +301,7 @@ func verifyState(ctx *cli.Context) error {
 		NoBuild:    true,
 		AsyncBuild: false,
 	}
-	snaptree, err := snapshot.New(snapconfig, chaindb, trie.NewDatabase(chaindb), headBlock.Root())
+	snaptree, err := snapshot.New(snapconfig, chaindb, utils.MakeTrieDatabase(ctx, chaindb, false, true), headBlock.Root())
 	if err != nil {
 		log.Error("Failed to open snapshot tree", "err", err)
 		return err
@@ -238,6 +326,126 @@ func verifyState(ctx *cli.Context) error {
 	return snapshot.CheckDanglingStorage(chaindb)
 }
 
+// openSnapshotTree opens the snapshot tree of the chain head without generating
+// it if missing.
+func openSnapshotTree(ctx *cli.Context, chaindb ethdb.Database) (*snapshot.Tree, error) {
+	head := rawdb.ReadHeadHeader(chaindb)
+	if head == nil {
+		log.Error("Failed to load head header")
+		return nil, errors.New("no head header")
+	}
+	snapconfig := snapshot.Config{
+		CacheSize:  256,
+		Recovery:   false,
+		NoBuild:    true,
+		AsyncBuild: false,
+	}
+	snaptree, err := snapshot.New(snapconfig, chaindb, utils.MakeTrieDatabase(ctx, chaindb, false, true), head.Root)
+	if err != nil {
+		log.Error("Failed to open snapshot tree", "err", err)
+		return nil, err
+	}
+	return snaptree, nil
+}
+
+// makeInterrupt returns a channel closed when the process is interrupted, and
+// a function releasing the signal handler.
+func makeInterrupt(action string) (chan struct{}, func()) {
+	var (
+		interrupt = make(chan os.Signal, 1)
+		stop      = make(chan struct{})
+	)
+	signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		if _, ok := <-interrupt; ok {
+			log.Info("Interrupted during " + action)
+		}
+		close(stop)
+	}()
+	return stop, func() {
+		signal.Stop(interrupt)
+		close(interrupt)
+	}
+}
+
+// checkSnapshotIntegrity verifies the snapshot against the state trie in ranges,
+// recording and optionally repairing the inconsistent ones.
+func checkSnapshotIntegrity(ctx *cli.Context) error {
+	if ctx.NArg() > 0 {
+		return fmt.Errorf("no arguments required: %v", ctx.Command.ArgsUsage)
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	repair := ctx.Bool(snapshotIntegrityRepairFlag.Name)
+	chaindb := utils.MakeChainDatabase(ctx, stack, !repair)
+	defer chaindb.Close()
+
+	snaptree, err := openSnapshotTree(ctx, chaindb)
+	if err != nil {
+		return err
+	}
+	stop, release := makeInterrupt("snapshot integrity check")
+	defer release()
+
+	report, err := snaptree.CheckIntegrity(ctx.Int(snapshotIntegrityThreadsFlag.Name), stop)
+	if err != nil {
+		return err
+	}
+	if path := ctx.String(snapshotIntegrityReportFlag.Name); path != "" {
+		blob, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, blob, 0644); err != nil {
+			return err
+		}
+		log.Info("Recorded snapshot integrity report", "file", path, "inconsistent", len(report.Ranges))
+	}
+	if !repair || len(report.Ranges) == 0 {
+		return nil
+	}
+	if err := snaptree.RepairIntegrity(report, stop); err != nil {
+		return err
+	}
+	log.Info("Repaired snapshot", "ranges", report.Repaired)
+	return nil
+}
+
+// repairSnapshotIntegrity repairs the inconsistent snapshot ranges recorded in
+// the given integrity report.
+func repairSnapshotIntegrity(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return fmt.Errorf("required arguments: %v", ctx.Command.ArgsUsage)
+	}
+	blob, err := os.ReadFile(ctx.Args().First())
+	if err != nil {
+		return err
+	}
+	report := new(snapshot.IntegrityReport)
+	if err := json.Unmarshal(blob, report); err != nil {
+		return fmt.Errorf("invalid integrity report: %v", err)
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	chaindb := utils.MakeChainDatabase(ctx, stack, false)
+	defer chaindb.Close()
+
+	snaptree, err := openSnapshotTree(ctx, chaindb)
+	if err != nil {
+		return err
+	}
+	stop, release := makeInterrupt("snapshot repair")
+	defer release()
+
+	if err := snaptree.RepairIntegrity(report, stop); err != nil {
+		return err
+	}
+	log.Info("Repaired snapshot", "ranges", report.Repaired)
+	return nil
+}
+
 // checkDanglingStorage iterates the snap storage data, and verifies that all
 // storage also has corresponding account data.
 func checkDanglingStorage(ctx *cli.Context) error {
@@ -279,7 +487,7 @@ func traverseState(ctx *cli.Context) error {
 		root = headBlock.Root()
 		log.Info("Start traversing the state", "root", root, "number", headBlock.NumberU64())
 	}
-	triedb := trie.NewDatabase(chaindb)
+	triedb := utils.MakeTrieDatabase(ctx, chaindb, false, true)
 	t, err := trie.NewStateTrie(trie.StateTrieID(root), triedb)
 	if err != nil {
 		log.Error("Failed to open trie", "root", root, "err", err)
@@ -369,7 +577,7 @@ func traverseRawState(ctx *cli.Context) error {
 		root = headBlock.Root()
 		log.Info("Start traversing the state", "root", root, "number", headBlock.NumberU64())
 	}
-	triedb := trie.NewDatabase(chaindb)
+	triedb := utils.MakeTrieDatabase(ctx, chaindb, false, true)
 	t, err := trie.NewStateTrie(trie.StateTrieID(root), triedb)
 	if err != nil {
 		log.Error("Failed to open trie", "root", root, "err", err)
@@ -393,7 +601,7 @@ func traverseRawState(ctx *cli.Context) error {
 		// Check the present for non-empty hash node(embedded node doesn't
 		// have their own hash).
 		if node != (common.Hash{}) {
-			blob := rawdb.ReadLegacyTrieNode(chaindb, node)
+			blob := rawdb.ReadTrieNode(chaindb, common.Hash{}, accIter.Path(), node, triedb.Scheme())
 			if len(blob) == 0 {
 				log.Error("Missing trie node(account)", "hash", node)
 				return errors.New("missing account")
@@ -430,7 +638,7 @@ func traverseRawState(ctx *cli.Context) error {
 					// Check the presence for non-empty hash node(embedded node doesn't
 					// have their own hash).
 					if node != (common.Hash{}) {
-						blob := rawdb.ReadLegacyTrieNode(chaindb, node)
+						blob := rawdb.ReadTrieNode(chaindb, common.BytesToHash(accIter.LeafKey()), storageIter.Path(), node, triedb.Scheme())
 						if len(blob) == 0 {
 							log.Error("Missing trie node(storage)", "hash", node)
 							return errors.New("missing storage")
@@ -496,7 +704,7 @@ func dumpState(ctx *cli.Context) error {
 		NoBuild:    true,
 		AsyncBuild: false,
 	}
-	snaptree, err := snapshot.New(snapConfig, db, trie.NewDatabase(db), root)
+	snaptree, err := snapshot.New(snapConfig, db, utils.MakeTrieDatabase(ctx, db, false, true), root)
 	if err != nil {
 		return err
 	}
@@ -589,3 +797,128 @@ func checkAccount(ctx *cli.Context) error {
 	log.Info("Checked the snapshot journalled storage", "time", common.PrettyDuration(time.Since(start)))
 	return nil
 }
+
+// exportSnapshot writes the state snapshot at the given root into a file.
+func exportSnapshot(ctx *cli.Context) error {
+	if ctx.NArg() < 1 || ctx.NArg() > 2 {
+		return fmt.Errorf("required arguments: %v", ctx.Command.ArgsUsage)
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	chaindb := utils.MakeChainDatabase(ctx, stack, true)
+	defer chaindb.Close()
+
+	head := rawdb.ReadHeadHeader(chaindb)
+	if head == nil {
+		log.Error("Failed to load head header")
+		return errors.New("no head header")
+	}
+	root := head.Root
+	if ctx.NArg() == 2 {
+		var err error
+		if root, err = parseRoot(ctx.Args().Get(1)); err != nil {
+			log.Error("Failed to resolve state root", "err", err)
+			return err
+		}
+	}
+	snapconfig := snapshot.Config{
+		CacheSize:  256,
+		Recovery:   false,
+		NoBuild:    true,
+		AsyncBuild: false,
+	}
+	snaptree, err := snapshot.New(snapconfig, chaindb, utils.MakeTrieDatabase(ctx, chaindb, false, true), head.Root)
+	if err != nil {
+		log.Error("Failed to open snapshot tree", "err", err)
+		return err
+	}
+	if snaptree.Snapshot(root) == nil {
+		return fmt.Errorf("state %x not covered by the snapshot", root)
+	}
+	// Find the canonical block of the state, the snapshot only covers the recent ones
+	header := head
+	for header.Root != root {
+		if header.Number.Sign() == 0 {
+			return fmt.Errorf("no canonical block with state root %x", root)
+		}
+		if header = rawdb.ReadHeader(chaindb, header.ParentHash, header.Number.Uint64()-1); header == nil {
+			return fmt.Errorf("no canonical block with state root %x", root)
+		}
+	}
+	fn := ctx.Args().First()
+	fh, err := os.OpenFile(fn, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	var writer io.Writer = fh
+	if strings.HasSuffix(fn, ".gz") {
+		writer = gzip.NewWriter(writer)
+		defer writer.(*gzip.Writer).Close()
+	}
+	log.Info("Exporting state snapshot", "file", fn, "number", header.Number, "hash", header.Hash(), "root", root)
+	return snapshot.Export(writer, snaptree, header)
+}
+
+// importSnapshot imports the state snapshot from a file, regenerating the state
+// trie and setting the block of the state as the chain head.
+func importSnapshot(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return fmt.Errorf("required arguments: %v", ctx.Command.ArgsUsage)
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	chaindb := utils.MakeChainDatabase(ctx, stack, false)
+	defer chaindb.Close()
+
+	triedb := utils.MakeTrieDatabase(ctx, chaindb, false, false)
+
+	fn := ctx.Args().First()
+	fh, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	var reader io.Reader = bufio.NewReader(fh)
+	if strings.HasSuffix(fn, ".gz") {
+		if reader, err = gzip.NewReader(reader); err != nil {
+			return err
+		}
+	}
+	// The state is only trusted if it belongs to the local canonical chain
+	check := func(header *types.Header) error {
+		number, hash := header.Number.Uint64(), header.Hash()
+		if canonical := rawdb.ReadCanonicalHash(chaindb, number); canonical != hash {
+			return fmt.Errorf("block %d [%x..] of the state not in the local chain, have %x", number, hash[:4], canonical)
+		}
+		if !rawdb.HasBody(chaindb, hash, number) {
+			return fmt.Errorf("missing body of block %d [%x..]", number, hash[:4])
+		}
+		return nil
+	}
+	log.Info("Importing state snapshot", "file", fn)
+	header, err := snapshot.Import(reader, chaindb, triedb, check)
+	if err != nil {
+		log.Error("Failed to import state snapshot", "err", err)
+		return err
+	}
+	// The state was persisted bypassing the trie database, it has to be
+	// activated in path-based scheme.
+	if err := triedb.Enable(header.Root); err != nil {
+		return err
+	}
+	hash, number := header.Hash(), header.Number.Uint64()
+	rawdb.WriteHeadBlockHash(chaindb, hash)
+	if head := rawdb.ReadHeaderNumber(chaindb, rawdb.ReadHeadFastBlockHash(chaindb)); head == nil || *head < number {
+		rawdb.WriteHeadFastBlockHash(chaindb, hash)
+	}
+	if head := rawdb.ReadHeaderNumber(chaindb, rawdb.ReadHeadHeaderHash(chaindb)); head == nil || *head < number {
+		rawdb.WriteHeadHeaderHash(chaindb, hash)
+	}
+	log.Info("Imported state snapshot", "number", number, "hash", hash, "root", header.Root)
+	return nil
+}
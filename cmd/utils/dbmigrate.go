@@ -0,0 +1,312 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// migrationMarker is the progress of a database migration, persisted next to
+// the migrated database to allow resuming an interrupted migration.
+type migrationMarker struct {
+	Engine string        `json:"engine"` // Engine of the migrated database
+	Next   hexutil.Bytes `json:"next"`   // First key not yet migrated
+	Copied bool          `json:"copied"` // Whether all the keys are migrated
+}
+
+// migrationPaths returns the directory of the migrated database, the path of
+// the migration marker and the directory the original database is moved to
+// while swapping the databases.
+func migrationPaths(dir string) (string, string, string) {
+	dir = filepath.Clean(dir)
+	return dir + ".migrating", dir + ".migrating.json", dir + ".old"
+}
+
+func readMigrationMarker(path string) (*migrationMarker, error) {
+	blob, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	marker := new(migrationMarker)
+	if err := json.Unmarshal(blob, marker); err != nil {
+		return nil, fmt.Errorf("invalid migration marker %s: %v", path, err)
+	}
+	return marker, nil
+}
+
+func writeMigrationMarker(path string, marker *migrationMarker) error {
+	blob, err := json.Marshal(marker)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", blob, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// MigrateDatabase converts the key-value database in the given directory into
+// the given database engine. The keys are streamed into a new database in
+// batches, verified against the original by a checksum pass over both, after
+// which the databases are swapped. An interrupted migration is resumed from the
+// last written batch.
+//
+// The ancient store is not touched, if it resides in the database directory it
+// is moved into the migrated one as is.
+func MigrateDatabase(dir string, engine string, cache int, handles int, interrupt chan struct{}) error {
+	if engine != rawdb.DBLeveldb && engine != rawdb.DBPebble {
+		return fmt.Errorf("unknown database engine %q", engine)
+	}
+	if engine == rawdb.DBPebble && !rawdb.PebbleEnabled {
+		return errors.New("database engine 'pebble' not supported on this platform")
+	}
+	tmp, markerPath, old := migrationPaths(dir)
+
+	// Finish swapping the databases if the migration was interrupted doing so
+	if _, err := os.Stat(old); err == nil {
+		log.Info("Resuming database swap", "database", dir)
+		return swapMigratedDatabase(dir)
+	}
+	source := rawdb.PreexistingDatabase(dir)
+	switch {
+	case source == "":
+		return fmt.Errorf("no database found in %s", dir)
+	case source == engine:
+		return fmt.Errorf("database in %s is already %s", dir, engine)
+	}
+	marker, err := readMigrationMarker(markerPath)
+	if err != nil {
+		return err
+	}
+	if marker == nil {
+		marker = &migrationMarker{Engine: engine}
+	} else if marker.Engine != engine {
+		return fmt.Errorf("interrupted migration to %s in progress, remove %s to start over", marker.Engine, tmp)
+	}
+	done, err := migrateKeys(dir, source, engine, cache, handles, marker, interrupt)
+	if err != nil || !done {
+		return err
+	}
+	// Swap the databases, the recorded migration is resumed if interrupted
+	if err := os.Rename(dir, old); err != nil {
+		return err
+	}
+	return swapMigratedDatabase(dir)
+}
+
+// migrateKeys copies the keys of the source database into the migrated one and
+// verifies the result, returning whether the migration is complete.
+func migrateKeys(dir, source, engine string, cache, handles int, marker *migrationMarker, interrupt chan struct{}) (bool, error) {
+	tmp, markerPath, _ := migrationPaths(dir)
+
+	src, err := rawdb.Open(rawdb.OpenOptions{Type: source, Directory: dir, Cache: cache / 2, Handles: handles / 2, ReadOnly: true})
+	if err != nil {
+		return false, fmt.Errorf("failed to open %s database: %v", source, err)
+	}
+	defer src.Close()
+
+	dst, err := rawdb.Open(rawdb.OpenOptions{Type: engine, Directory: tmp, Cache: cache / 2, Handles: handles / 2})
+	if err != nil {
+		return false, fmt.Errorf("failed to open %s database: %v", engine, err)
+	}
+	defer dst.Close()
+
+	log.Info("Migrating database", "database", dir, "from", source, "to", engine, "resume", marker.Next)
+	if !marker.Copied {
+		done, err := copyDatabase(src, dst, marker, markerPath, interrupt)
+		if err != nil {
+			return false, err
+		}
+		if !done {
+			log.Info("Database migration interrupted, rerun to resume", "database", dir)
+			return false, nil
+		}
+	}
+	// Verify the migrated database against the original one
+	start := time.Now()
+	want, wantCount, err := checksumDatabase(src, "original", interrupt)
+	if err != nil {
+		return false, err
+	}
+	have, haveCount, err := checksumDatabase(dst, "migrated", interrupt)
+	if err != nil {
+		return false, err
+	}
+	if have != want || haveCount != wantCount {
+		return false, fmt.Errorf("migrated database mismatch: have %d keys (checksum %x), want %d keys (checksum %x), remove %s and %s to start over", haveCount, have, wantCount, want, tmp, markerPath)
+	}
+	log.Info("Verified migrated database", "keys", wantCount, "checksum", want, "elapsed", common.PrettyDuration(time.Since(start)))
+	return true, nil
+}
+
+// copyDatabase streams the keys of the source database not yet migrated into
+// the destination one, recording the progress after every written batch. It
+// returns whether all the keys are copied.
+func copyDatabase(src, dst ethdb.KeyValueStore, marker *migrationMarker, markerPath string, interrupt chan struct{}) (bool, error) {
+	var (
+		it    = src.NewIterator(nil, marker.Next)
+		batch = dst.NewBatch()
+
+		count  int64
+		size   common.StorageSize
+		start  = time.Now()
+		logged = time.Now()
+	)
+	defer it.Release()
+
+	flush := func(next []byte) error {
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		batch.Reset()
+		marker.Next = next
+		return writeMigrationMarker(markerPath, marker)
+	}
+	for it.Next() {
+		key, val := it.Key(), it.Value()
+		if err := batch.Put(key, val); err != nil {
+			return false, err
+		}
+		count++
+		size += common.StorageSize(len(key) + len(val))
+
+		if batch.ValueSize() < ethdb.IdealBatchSize {
+			continue
+		}
+		// Resume from the key right after the last written one
+		next := append(common.CopyBytes(key), 0)
+		if err := flush(next); err != nil {
+			return false, err
+		}
+		select {
+		case <-interrupt:
+			log.Info("Database migration interrupted", "keys", count, "size", size, "elapsed", common.PrettyDuration(time.Since(start)))
+			return false, nil
+		default:
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Migrating database", "keys", count, "size", size, "next", hexutil.Bytes(next), "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return false, err
+	}
+	marker.Copied = true
+	if err := flush(nil); err != nil {
+		return false, err
+	}
+	log.Info("Migrated database keys", "keys", count, "size", size, "elapsed", common.PrettyDuration(time.Since(start)))
+	return true, nil
+}
+
+// checksumDatabase returns the hash of all the length prefixed keys and values
+// in the database, in iteration order, along with the number of keys.
+func checksumDatabase(db ethdb.KeyValueStore, name string, interrupt chan struct{}) (common.Hash, uint64, error) {
+	var (
+		it     = db.NewIterator(nil, nil)
+		hasher = crypto.NewKeccakState()
+		buf    [binary.MaxVarintLen64]byte
+
+		count  uint64
+		start  = time.Now()
+		logged = time.Now()
+	)
+	defer it.Release()
+
+	for it.Next() {
+		for _, blob := range [][]byte{it.Key(), it.Value()} {
+			hasher.Write(buf[:binary.PutUvarint(buf[:], uint64(len(blob)))])
+			hasher.Write(blob)
+		}
+		count++
+		if count%100000 != 0 {
+			continue
+		}
+		select {
+		case <-interrupt:
+			return common.Hash{}, 0, errors.New("database verification interrupted")
+		default:
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Verifying migrated database", "database", name, "keys", count, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return common.Hash{}, 0, err
+	}
+	var hash common.Hash
+	hasher.Read(hash[:])
+	return hash, count, nil
+}
+
+// swapMigratedDatabase moves the migrated database into the place of the
+// original one, which is expected to have been moved aside already, carrying
+// over the ancient store if it resides in the database directory. Every step
+// is idempotent, so an interrupted swap can be finished by running it again.
+func swapMigratedDatabase(dir string) error {
+	tmp, markerPath, old := migrationPaths(dir)
+
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		if err := os.Rename(tmp, dir); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	ancient := filepath.Join(old, "ancient")
+	if _, err := os.Stat(ancient); err == nil {
+		if err := os.Rename(ancient, filepath.Join(dir, "ancient")); err != nil {
+			return err
+		}
+	}
+	// Make sure nothing but the database files is left before deleting it
+	entries, err := os.ReadDir(old)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			return fmt.Errorf("unexpected directory %s left in the original database", filepath.Join(old, entry.Name()))
+		}
+	}
+	if err := os.RemoveAll(old); err != nil {
+		return err
+	}
+	if err := os.Remove(markerPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	log.Info("Swapped migrated database", "database", dir, "engine", rawdb.PreexistingDatabase(dir))
+	return nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// Tests that an interrupted database migration is resumed, and that the
+// migrated database holds the same keys, with the ancient store carried over.
+func TestMigrateDatabase(t *testing.T) {
+	if !rawdb.PebbleEnabled {
+		t.Skip("pebble not supported on this platform")
+	}
+	var (
+		dir   = filepath.Join(t.TempDir(), "chaindata")
+		value = bytes.Repeat([]byte{0xff}, 100)
+		keys  = 5000
+	)
+	db, err := rawdb.Open(rawdb.OpenOptions{Type: rawdb.DBLeveldb, Directory: dir, Cache: 16, Handles: 16})
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	for i := 0; i < keys; i++ {
+		key := binary.BigEndian.AppendUint64(nil, uint64(i))
+		if err := db.Put(key, append(value, key...)); err != nil {
+			t.Fatalf("failed to write key %d: %v", i, err)
+		}
+	}
+	db.Close()
+
+	ancient := filepath.Join(dir, "ancient", "chain", "FLOCK")
+	if err := os.MkdirAll(filepath.Dir(ancient), 0755); err != nil {
+		t.Fatalf("failed to create ancient directory: %v", err)
+	}
+	if err := os.WriteFile(ancient, nil, 0644); err != nil {
+		t.Fatalf("failed to create ancient file: %v", err)
+	}
+	// Interrupt the migration after the first batch, then resume it
+	interrupt := make(chan struct{})
+	close(interrupt)
+	if err := MigrateDatabase(dir, rawdb.DBPebble, 16, 16, interrupt); err != nil {
+		t.Fatalf("failed to start migration: %v", err)
+	}
+	marker, err := readMigrationMarker(dir + ".migrating.json")
+	if err != nil || marker == nil || marker.Copied || len(marker.Next) == 0 {
+		t.Fatalf("unexpected migration marker after interruption: %v (%v)", marker, err)
+	}
+	if have := rawdb.PreexistingDatabase(dir); have != rawdb.DBLeveldb {
+		t.Fatalf("database swapped before migration finished: %s", have)
+	}
+	if err := MigrateDatabase(dir, rawdb.DBPebble, 16, 16, nil); err != nil {
+		t.Fatalf("failed to resume migration: %v", err)
+	}
+	if have := rawdb.PreexistingDatabase(dir); have != rawdb.DBPebble {
+		t.Fatalf("database engine mismatch: have %s, want %s", have, rawdb.DBPebble)
+	}
+	for _, path := range []string{dir + ".migrating", dir + ".migrating.json", dir + ".old"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("migration leftover %s", path)
+		}
+	}
+	if _, err := os.Stat(ancient); err != nil {
+		t.Fatalf("ancient store not carried over: %v", err)
+	}
+	db, err = rawdb.Open(rawdb.OpenOptions{Directory: dir, Cache: 16, Handles: 16, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("failed to open migrated database: %v", err)
+	}
+	defer db.Close()
+
+	it := db.NewIterator(nil, nil)
+	defer it.Release()
+
+	count := 0
+	for ; it.Next(); count++ {
+		want := binary.BigEndian.AppendUint64(nil, uint64(count))
+		if !bytes.Equal(it.Key(), want) || !bytes.Equal(it.Value(), append(value, want...)) {
+			t.Fatalf("key %d mismatch: have %x", count, it.Key())
+		}
+	}
+	if count != keys {
+		t.Fatalf("key count mismatch: have %d, want %d", count, keys)
+	}
+	// Migrating into the same engine is rejected
+	if err := MigrateDatabase(dir, rawdb.DBPebble, 16, 16, nil); err == nil {
+		t.Fatalf("migration into the same engine accepted")
+	}
+}
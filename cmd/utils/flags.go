@@ -80,6 +80,7 @@ import (
 	"github.com/ethereum/go-ethereum/params/vars"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
 	pcsclite "github.com/gballet/go-libpcsclite"
 	gopsutil "github.com/shirou/gopsutil/mem"
 	"github.com/urfave/cli/v2"
@@ -281,6 +282,22 @@ var (
 		Value:    "full",
 		Category: flags.EthCategory,
 	}
+	StateSchemeFlag = &cli.StringFlag{
+		Name:     "state.scheme",
+		Usage:    `Scheme to use for storing the state trie nodes ("hash", "path"), defaults to the stored one`,
+		Category: flags.EthCategory,
+	}
+	StateHistoryFlag = &cli.Uint64Flag{
+		Name:     "state.history",
+		Usage:    "Number of recent blocks to keep the state histories for, in path scheme (0 = entire chain)",
+		Value:    ethconfig.Defaults.StateHistory,
+		Category: flags.EthCategory,
+	}
+	StateDiffsFlag = &cli.Uint64Flag{
+		Name:     "state.diffs",
+		Usage:    "Number of recent blocks to keep the reverse state diffs for, serving their historical states (0 = disabled)",
+		Category: flags.EthCategory,
+	}
 	SnapshotFlag = &cli.BoolFlag{
 		Name:     "snapshot",
 		Usage:    `Enables snapshot-database mode (default = enable)`,
@@ -293,6 +310,22 @@ var (
 		Value:    ethconfig.Defaults.TxLookupLimit,
 		Category: flags.EthCategory,
 	}
+	HistoryRetainFlag = &cli.Uint64Flag{
+		Name:     "history.retain",
+		Usage:    "Number of recent blocks to keep the ancient bodies and receipts for, older ones are pruned (0 = entire chain)",
+		Category: flags.EthCategory,
+	}
+	TraceIndexFlag = &cli.BoolFlag{
+		Name:     "trace.index",
+		Usage:    "Enables indexing the call traces of the canonical chain to serve trace_filter and trace_block without re-execution",
+		Category: flags.EthCategory,
+	}
+	TraceIndexHistoryFlag = &cli.Uint64Flag{
+		Name:     "trace.index.history",
+		Usage:    "Number of recent blocks to maintain the trace index for (0 = entire chain)",
+		Value:    ethconfig.Defaults.TraceIndexHistory,
+		Category: flags.EthCategory,
+	}
 	LightKDFFlag = &cli.BoolFlag{
 		Name:     "lightkdf",
 		Usage:    "Reduce key-derivation RAM & CPU usage at some expense of KDF strength",
@@ -314,6 +347,12 @@ var (
 		Value:    2048,
 		Category: flags.EthCategory,
 	}
+	PruningThrottleFlag = &cli.DurationFlag{
+		Name:     "pruning.throttle",
+		Usage:    "Pause between two batches of stale state deleted by the online pruning",
+		Value:    ethconfig.Defaults.PruningThrottle,
+		Category: flags.EthCategory,
+	}
 	OverrideShanghai = &cli.Uint64Flag{
 		Name:     "override.shanghai",
 		Usage:    "Manually specify the Shanghai fork timestamp, overriding the bundled setting",
@@ -550,6 +589,12 @@ var (
 		Category: flags.PerfCategory,
 		Value:    ethconfig.Defaults.FilterLogCacheSize,
 	}
+	CacheTraceSizeFlag = &cli.IntFlag{
+		Name:     "cache.blocktraces",
+		Usage:    "Size (in number of blocks) of the trace result cache for block tracing (0 = disabled)",
+		Category: flags.PerfCategory,
+		Value:    ethconfig.Defaults.TraceCacheSize,
+	}
 	FDLimitFlag = &cli.IntFlag{
 		Name:     "fdlimit",
 		Usage:    "Raise the open file descriptor resource limit (default = system fd limit)",
@@ -617,6 +662,22 @@ var (
 		Value:    ethconfig.Defaults.Miner.NewPayloadTimeout,
 		Category: flags.MinerCategory,
 	}
+	MinerUncleRemoteFlag = &cli.StringFlag{
+		Name:     "miner.uncles.remote",
+		Usage:    `Remote uncle inclusion policy ("allow", "prefer" or "deny")`,
+		Value:    miner.UncleRemoteAllow,
+		Category: flags.MinerCategory,
+	}
+	MinerUncleMaxDepthFlag = &cli.Uint64Flag{
+		Name:     "miner.uncles.maxdepth",
+		Usage:    "Maximum depth of included uncles (0 = unlimited)",
+		Category: flags.MinerCategory,
+	}
+	MinerUncleDenyFlag = &cli.StringFlag{
+		Name:     "miner.uncles.deny",
+		Usage:    "Comma separated coinbase addresses whose blocks are never included as uncles",
+		Category: flags.MinerCategory,
+	}
 
 	// Account settings
 	UnlockedAccountFlag = &cli.StringFlag{
@@ -663,6 +724,28 @@ var (
 		Value:    ethconfig.Defaults.RPCEVMTimeout,
 		Category: flags.APICategory,
 	}
+	RPCJSTracerStepsFlag = &cli.Uint64Flag{
+		Name:     "rpc.jstracer.steps",
+		Usage:    "Sets a cap on the number of JS tracer method invocations per transaction (0=infinite)",
+		Value:    ethconfig.Defaults.JSTracerSteps,
+		Category: flags.APICategory,
+	}
+	RPCJSTracerMemoryFlag = &cli.Uint64Flag{
+		Name:     "rpc.jstracer.memory",
+		Usage:    "Sets a cap on the estimated bytes of JS state of a JS tracer per transaction (0=infinite)",
+		Value:    ethconfig.Defaults.JSTracerMemory,
+		Category: flags.APICategory,
+	}
+	RPCJSTracerDirFlag = &flags.DirectoryFlag{
+		Name:     "rpc.jstracer.dir",
+		Usage:    "Directory of named JS tracers (*.js) to serve",
+		Category: flags.APICategory,
+	}
+	RPCJSTracerNamedOnlyFlag = &cli.BoolFlag{
+		Name:     "rpc.jstracer.namedonly",
+		Usage:    "Serves only the named JS tracers, rejecting user-provided tracer code",
+		Category: flags.APICategory,
+	}
 	RPCGlobalTxFeeCapFlag = &cli.Float64Flag{
 		Name:     "rpc.txfeecap",
 		Usage:    "Sets a cap on transaction fee (in ether) that can be sent via the RPC APIs (0 = no cap)",
@@ -1845,6 +1928,26 @@ func setMiner(ctx *cli.Context, cfg *miner.Config) {
 	if ctx.IsSet(MinerNewPayloadTimeout.Name) {
 		cfg.NewPayloadTimeout = ctx.Duration(MinerNewPayloadTimeout.Name)
 	}
+	if ctx.IsSet(MinerUncleRemoteFlag.Name) {
+		switch policy := ctx.String(MinerUncleRemoteFlag.Name); policy {
+		case miner.UncleRemoteAllow, miner.UncleRemotePrefer, miner.UncleRemoteDeny:
+			cfg.UncleRemote = policy
+		default:
+			Fatalf("Invalid remote uncle policy in --%s: %s", MinerUncleRemoteFlag.Name, policy)
+		}
+	}
+	if ctx.IsSet(MinerUncleMaxDepthFlag.Name) {
+		cfg.UncleMaxDepth = ctx.Uint64(MinerUncleMaxDepthFlag.Name)
+	}
+	if ctx.IsSet(MinerUncleDenyFlag.Name) {
+		for _, coinbase := range strings.Split(ctx.String(MinerUncleDenyFlag.Name), ",") {
+			if trimmed := strings.TrimSpace(coinbase); !common.IsHexAddress(trimmed) {
+				Fatalf("Invalid coinbase in --%s: %s", MinerUncleDenyFlag.Name, trimmed)
+			} else {
+				cfg.UncleDeny = append(cfg.UncleDeny, common.HexToAddress(trimmed))
+			}
+		}
+	}
 }
 
 func setRequiredBlocks(ctx *cli.Context, cfg *ethconfig.Config) {
@@ -1976,6 +2079,15 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *ethconfig.Config) {
 	if ctx.IsSet(GCModeFlag.Name) {
 		cfg.NoPruning = ctx.String(GCModeFlag.Name) == "archive"
 	}
+	if ctx.IsSet(StateSchemeFlag.Name) {
+		cfg.StateScheme = ctx.String(StateSchemeFlag.Name)
+	}
+	if ctx.IsSet(StateHistoryFlag.Name) {
+		cfg.StateHistory = ctx.Uint64(StateHistoryFlag.Name)
+	}
+	if ctx.IsSet(StateDiffsFlag.Name) {
+		cfg.StateDiffs = ctx.Uint64(StateDiffsFlag.Name)
+	}
 	if ctx.IsSet(CacheNoPrefetchFlag.Name) {
 		cfg.NoPrefetch = ctx.Bool(CacheNoPrefetchFlag.Name)
 	}
@@ -1988,6 +2100,15 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *ethconfig.Config) {
 	if ctx.IsSet(TxLookupLimitFlag.Name) {
 		cfg.TxLookupLimit = ctx.Uint64(TxLookupLimitFlag.Name)
 	}
+	if ctx.IsSet(HistoryRetainFlag.Name) {
+		cfg.HistoryRetain = ctx.Uint64(HistoryRetainFlag.Name)
+	}
+	if ctx.IsSet(TraceIndexFlag.Name) {
+		cfg.TraceIndex = ctx.Bool(TraceIndexFlag.Name)
+	}
+	if ctx.IsSet(TraceIndexHistoryFlag.Name) {
+		cfg.TraceIndexHistory = ctx.Uint64(TraceIndexHistoryFlag.Name)
+	}
 	if ctx.IsSet(CacheFlag.Name) || ctx.IsSet(CacheTrieFlag.Name) {
 		cfg.TrieCleanCache = ctx.Int(CacheFlag.Name) * ctx.Int(CacheTrieFlag.Name) / 100
 	}
@@ -2006,6 +2127,15 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *ethconfig.Config) {
 	if ctx.IsSet(CacheLogSizeFlag.Name) {
 		cfg.FilterLogCacheSize = ctx.Int(CacheLogSizeFlag.Name)
 	}
+	if ctx.IsSet(CacheTraceSizeFlag.Name) {
+		cfg.TraceCacheSize = ctx.Int(CacheTraceSizeFlag.Name)
+	}
+	if ctx.IsSet(BloomFilterSizeFlag.Name) {
+		cfg.PruningBloomSize = ctx.Uint64(BloomFilterSizeFlag.Name)
+	}
+	if ctx.IsSet(PruningThrottleFlag.Name) {
+		cfg.PruningThrottle = ctx.Duration(PruningThrottleFlag.Name)
+	}
 	if !ctx.Bool(SnapshotFlag.Name) {
 		// If snap-sync is requested, this flag is also required
 		if cfg.SyncMode == downloader.SnapSync {
@@ -2015,6 +2145,9 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *ethconfig.Config) {
 			cfg.SnapshotCache = 0 // Disabled
 		}
 	}
+	if cfg.StateDiffs > 0 && cfg.SnapshotCache == 0 {
+		Fatalf("--%s requires the snapshot to be enabled", StateDiffsFlag.Name)
+	}
 	if ctx.IsSet(DocRootFlag.Name) {
 		cfg.DocRoot = ctx.String(DocRootFlag.Name)
 	}
@@ -2047,6 +2180,18 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *ethconfig.Config) {
 	if ctx.IsSet(RPCGlobalEVMTimeoutFlag.Name) {
 		cfg.RPCEVMTimeout = ctx.Duration(RPCGlobalEVMTimeoutFlag.Name)
 	}
+	if ctx.IsSet(RPCJSTracerStepsFlag.Name) {
+		cfg.JSTracerSteps = ctx.Uint64(RPCJSTracerStepsFlag.Name)
+	}
+	if ctx.IsSet(RPCJSTracerMemoryFlag.Name) {
+		cfg.JSTracerMemory = ctx.Uint64(RPCJSTracerMemoryFlag.Name)
+	}
+	if ctx.IsSet(RPCJSTracerDirFlag.Name) {
+		cfg.JSTracerDir = ctx.String(RPCJSTracerDirFlag.Name)
+	}
+	if ctx.IsSet(RPCJSTracerNamedOnlyFlag.Name) {
+		cfg.JSTracerNamedOnly = ctx.Bool(RPCJSTracerNamedOnlyFlag.Name)
+	}
 	if ctx.IsSet(RPCGlobalTxFeeCapFlag.Name) {
 		cfg.RPCTxFeeCap = ctx.Float64(RPCGlobalTxFeeCapFlag.Name)
 	}
@@ -2551,6 +2696,15 @@ func MakeChain(ctx *cli.Context, stack *node.Node, readonly bool) (*core.BlockCh
 		TrieTimeLimit:       ethconfig.Defaults.TrieTimeout,
 		SnapshotLimit:       ethconfig.Defaults.SnapshotCache,
 		Preimages:           ctx.Bool(CachePreimagesFlag.Name),
+		StateHistory:        ctx.Uint64(StateHistoryFlag.Name),
+	}
+	scheme, err := rawdb.ParseStateScheme(ctx.String(StateSchemeFlag.Name), chainDb)
+	if err != nil {
+		Fatalf("%v", err)
+	}
+	cache.StateScheme = scheme
+	if cache.StateScheme == rawdb.PathScheme && cache.TrieDirtyDisabled {
+		Fatalf("--%s=archive is not compatible with the path-based state scheme", GCModeFlag.Name)
 	}
 	if cache.TrieDirtyDisabled && !cache.Preimages {
 		cache.Preimages = true
@@ -2580,6 +2734,22 @@ func MakeChain(ctx *cli.Context, stack *node.Node, readonly bool) (*core.BlockCh
 	return chain, chainDb
 }
 
+// MakeTrieDatabase constructs a trie database over the given key-value store,
+// using the state scheme requested on the command line or the stored one.
+func MakeTrieDatabase(ctx *cli.Context, disk ethdb.Database, preimage bool, readOnly bool) *trie.Database {
+	config := &trie.Config{
+		Preimages: preimage,
+	}
+	scheme, err := rawdb.ParseStateScheme(ctx.String(StateSchemeFlag.Name), disk)
+	if err != nil {
+		Fatalf("%v", err)
+	}
+	if scheme == rawdb.PathScheme {
+		config.PathDB = &trie.PathConfig{ReadOnly: readOnly}
+	}
+	return trie.NewDatabaseWithConfig(disk, config)
+}
+
 // MakeConsolePreloads retrieves the absolute paths for the console JavaScript
 // scripts to preload before starting.
 func MakeConsolePreloads(ctx *cli.Context) []string {
@@ -0,0 +1,404 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/internal/era"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// checksumsFile is the file listing the sha256 checksums of the exported Era1
+// files, in the format of sha256sum.
+const checksumsFile = "checksums.txt"
+
+// ExportHistory exports the blocks, receipts and total difficulties of the
+// given range of the chain into Era1 files of whole epochs, along with their
+// checksums. The first block must start an epoch.
+func ExportHistory(bc *core.BlockChain, dir string, first, last uint64, network string) error {
+	log.Info("Exporting blockchain history", "dir", dir)
+	if first > last {
+		return fmt.Errorf("invalid range: first (%d) is greater than last (%d)", first, last)
+	}
+	if first%era.MaxEra1Size != 0 {
+		return fmt.Errorf("first block %d not at an epoch boundary (multiple of %d)", first, era.MaxEra1Size)
+	}
+	if head := bc.CurrentBlock().Number.Uint64(); head < last {
+		return fmt.Errorf("last block %d beyond the head %d", last, head)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating output directory: %w", err)
+	}
+	checksums, err := os.OpenFile(filepath.Join(dir, checksumsFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer checksums.Close()
+
+	var (
+		start    = time.Now()
+		reported = time.Now()
+	)
+	for epoch := first / era.MaxEra1Size; epoch*era.MaxEra1Size <= last; epoch++ {
+		end := (epoch+1)*era.MaxEra1Size - 1
+		if end > last {
+			end = last
+		}
+		name, sum, err := exportEpoch(bc, dir, network, epoch, end)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(checksums, "%x  %s\n", sum, name); err != nil {
+			return err
+		}
+		if time.Since(reported) >= 8*time.Second || end == last {
+			log.Info("Exported history epoch", "epoch", epoch, "file", name, "blocks", end+1, "elapsed", common.PrettyDuration(time.Since(start)))
+			reported = time.Now()
+		}
+	}
+	return nil
+}
+
+// exportEpoch writes the blocks of the epoch up to the given one into an Era1
+// file, named after its accumulator root, and returns its name and checksum.
+func exportEpoch(bc *core.BlockChain, dir, network string, epoch, last uint64) (string, []byte, error) {
+	tmp, err := os.CreateTemp(dir, "era1-*.tmp")
+	if err != nil {
+		return "", nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	var (
+		hasher  = sha256.New()
+		writer  = bufio.NewWriter(io.MultiWriter(tmp, hasher))
+		builder = era.NewBuilder(writer)
+		parent  common.Hash
+	)
+	for number := epoch * era.MaxEra1Size; number <= last; number++ {
+		block := bc.GetBlockByNumber(number)
+		if block == nil {
+			return "", nil, fmt.Errorf("export failed on #%d: not found", number)
+		}
+		if number > epoch*era.MaxEra1Size && block.ParentHash() != parent {
+			return "", nil, errors.New("export failed: chain reorg during export")
+		}
+		parent = block.Hash()
+
+		receipts := bc.GetReceiptsByHash(block.Hash())
+		if receipts == nil && len(block.Transactions()) > 0 {
+			return "", nil, fmt.Errorf("export failed on #%d: receipts not found", number)
+		}
+		td := bc.GetTd(block.Hash(), number)
+		if td == nil {
+			return "", nil, fmt.Errorf("export failed on #%d: total difficulty not found", number)
+		}
+		if err := builder.Add(block, receipts, td); err != nil {
+			return "", nil, err
+		}
+	}
+	root, err := builder.Finalize()
+	if err != nil {
+		return "", nil, err
+	}
+	if err := writer.Flush(); err != nil {
+		return "", nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", nil, err
+	}
+	name := era.Filename(network, int(epoch), root)
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return "", nil, err
+	}
+	return name, hasher.Sum(nil), nil
+}
+
+// ImportHistory imports the Era1 files of the given network from the directory,
+// verifying their checksums, accumulators and the consistency of the blocks
+// with their receipts and total difficulties.
+//
+// The blocks are either executed, or if seedFreezer is set, written directly
+// into the ancient store along with their receipts, without any state. In the
+// latter case the chain must be empty, and the state is expected to be synced
+// from the network afterwards.
+func ImportHistory(chain *core.BlockChain, dir, network string, seedFreezer bool) error {
+	// Watch for Ctrl-C while the import is running.
+	// If a signal is received, the import will stop at the next batch.
+	interrupt := make(chan os.Signal, 1)
+	stop := make(chan struct{})
+	signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	defer close(interrupt)
+	go func() {
+		if _, ok := <-interrupt; ok {
+			log.Info("Interrupted during import, stopping at next batch")
+		}
+		close(stop)
+	}()
+	checkInterrupt := func() bool {
+		select {
+		case <-stop:
+			return true
+		default:
+			return false
+		}
+	}
+	if seedFreezer {
+		if head := chain.CurrentHeader().Number.Uint64(); head != 0 {
+			return fmt.Errorf("freezer seeding requires an empty chain, head header #%d", head)
+		}
+	}
+	files, err := era.ReadDir(dir, network)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no %s era1 files found in %s", network, dir)
+	}
+	checksums, err := readChecksums(filepath.Join(dir, checksumsFile))
+	if err != nil {
+		return err
+	}
+	log.Info("Importing blockchain history", "dir", dir, "files", len(files))
+
+	var (
+		start  = time.Now()
+		parent = chain.Genesis().Header()
+		td     = new(big.Int)
+	)
+	for _, name := range files {
+		if checkInterrupt() {
+			return errors.New("interrupted")
+		}
+		path := filepath.Join(dir, name)
+		if checksums != nil {
+			want, ok := checksums[name]
+			if !ok {
+				return fmt.Errorf("checksum of %s missing", name)
+			}
+			if err := verifyChecksum(path, want); err != nil {
+				return err
+			}
+		}
+		blocks, receipts, err := readEpoch(path, parent, td)
+		if err != nil {
+			return fmt.Errorf("invalid era1 file %s: %w", name, err)
+		}
+		// The genesis is not imported, but must match the local one
+		if blocks[0].NumberU64() == 0 {
+			if blocks[0].Hash() != chain.Genesis().Hash() {
+				return fmt.Errorf("genesis mismatch: have %x, want %x", blocks[0].Hash(), chain.Genesis().Hash())
+			}
+			blocks, receipts = blocks[1:], receipts[1:]
+		}
+		for len(blocks) > 0 {
+			if checkInterrupt() {
+				return errors.New("interrupted")
+			}
+			n := len(blocks)
+			if n > importBatchSize {
+				n = importBatchSize
+			}
+			if seedFreezer {
+				err = seedAncients(chain, blocks[:n], receipts[:n])
+			} else {
+				err = insertBlocks(chain, blocks[:n])
+			}
+			if err != nil {
+				return err
+			}
+			parent = blocks[n-1].Header()
+			blocks, receipts = blocks[n:], receipts[n:]
+		}
+		log.Info("Imported history epoch", "file", name, "number", parent.Number, "elapsed", common.PrettyDuration(time.Since(start)))
+	}
+	return nil
+}
+
+// readEpoch reads and verifies the blocks and receipts of an Era1 file, which
+// must follow the given parent block with the given total difficulty. The
+// total difficulty is updated to the one of the last block.
+func readEpoch(path string, parent *types.Header, td *big.Int) ([]*types.Block, []types.Receipts, error) {
+	e, err := era.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer e.Close()
+
+	var (
+		blocks   = make([]*types.Block, 0, e.Count())
+		receipts = make([]types.Receipts, 0, e.Count())
+		hashes   = make([]common.Hash, 0, e.Count())
+		tds      = make([]*big.Int, 0, e.Count())
+	)
+	if e.Start() != 0 && e.Start() != parent.Number.Uint64()+1 {
+		return nil, nil, fmt.Errorf("non-contiguous epoch starting at #%d, expected #%d", e.Start(), parent.Number.Uint64()+1)
+	}
+	for number := e.Start(); number < e.Start()+e.Count(); number++ {
+		block, err := e.GetBlockByNumber(number)
+		if err != nil {
+			return nil, nil, fmt.Errorf("block #%d: %w", number, err)
+		}
+		rs, err := e.GetReceiptsByNumber(number)
+		if err != nil {
+			return nil, nil, fmt.Errorf("receipts #%d: %w", number, err)
+		}
+		have, err := e.GetTotalDifficultyByNumber(number)
+		if err != nil {
+			return nil, nil, fmt.Errorf("total difficulty #%d: %w", number, err)
+		}
+		if err := verifyBlock(block, rs); err != nil {
+			return nil, nil, fmt.Errorf("block #%d: %w", number, err)
+		}
+		if number > 0 && block.ParentHash() != parent.Hash() {
+			return nil, nil, fmt.Errorf("block #%d: parent hash mismatch: have %x, want %x", number, block.ParentHash(), parent.Hash())
+		}
+		td.Add(td, block.Difficulty())
+		if have.Cmp(td) != 0 {
+			return nil, nil, fmt.Errorf("block #%d: total difficulty mismatch: have %v, want %v", number, have, td)
+		}
+		blocks = append(blocks, block)
+		receipts = append(receipts, rs)
+		hashes = append(hashes, block.Hash())
+		tds = append(tds, have)
+		parent = block.Header()
+	}
+	want, err := e.Accumulator()
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := era.ComputeAccumulator(hashes, tds)
+	if err != nil {
+		return nil, nil, err
+	}
+	if root != want {
+		return nil, nil, fmt.Errorf("accumulator mismatch: have %x, want %x", root, want)
+	}
+	return blocks, receipts, nil
+}
+
+// verifyBlock checks that the body and receipts of a block match its header.
+func verifyBlock(block *types.Block, receipts types.Receipts) error {
+	if hash := types.DeriveSha(block.Transactions(), trie.NewStackTrie(nil)); hash != block.TxHash() {
+		return fmt.Errorf("transaction root mismatch: have %x, want %x", hash, block.TxHash())
+	}
+	if hash := types.CalcUncleHash(block.Uncles()); hash != block.UncleHash() {
+		return fmt.Errorf("uncle root mismatch: have %x, want %x", hash, block.UncleHash())
+	}
+	if want := block.Header().WithdrawalsHash; want != nil {
+		if hash := types.DeriveSha(block.Withdrawals(), trie.NewStackTrie(nil)); hash != *want {
+			return fmt.Errorf("withdrawals root mismatch: have %x, want %x", hash, *want)
+		}
+	}
+	if len(receipts) != len(block.Transactions()) {
+		return fmt.Errorf("receipt count mismatch: have %d, want %d", len(receipts), len(block.Transactions()))
+	}
+	if hash := types.DeriveSha(receipts, trie.NewStackTrie(nil)); hash != block.ReceiptHash() {
+		return fmt.Errorf("receipt root mismatch: have %x, want %x", hash, block.ReceiptHash())
+	}
+	return nil
+}
+
+// insertBlocks executes the given blocks, skipping the ones already present.
+func insertBlocks(chain *core.BlockChain, blocks []*types.Block) error {
+	missing := missingBlocks(chain, blocks)
+	if len(missing) == 0 {
+		log.Info("Skipping batch as all blocks present", "first", blocks[0].Number(), "last", blocks[len(blocks)-1].Number())
+		return nil
+	}
+	if n, err := chain.InsertChain(missing); err != nil {
+		return fmt.Errorf("invalid block %d: %v", missing[n].NumberU64(), err)
+	}
+	return nil
+}
+
+// seedAncients writes the given blocks along with their receipts directly into
+// the ancient store, after importing their headers.
+func seedAncients(chain *core.BlockChain, blocks []*types.Block, receipts []types.Receipts) error {
+	headers := make([]*types.Header, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.Header()
+	}
+	if n, err := chain.InsertHeaderChain(headers, 100); err != nil {
+		return fmt.Errorf("invalid header %d: %v", headers[n].Number, err)
+	}
+	last := blocks[len(blocks)-1].NumberU64()
+	if _, err := chain.InsertReceiptChain(blocks, receipts, last); err != nil {
+		return fmt.Errorf("failed to write ancients up to %d: %v", last, err)
+	}
+	return nil
+}
+
+// readChecksums parses the checksums file into a map from the file names to
+// their sha256 checksums, nil if there's no checksums file.
+func readChecksums(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Warn("Era1 checksums missing, importing unverified files", "file", path)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	checksums := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("malformed checksum line: %q", line)
+		}
+		checksums[fields[1]] = fields[0]
+	}
+	return checksums, nil
+}
+
+// verifyChecksum checks the sha256 checksum of the given file.
+func verifyChecksum(path, want string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return err
+	}
+	if have := fmt.Sprintf("%x", hasher.Sum(nil)); have != want {
+		return fmt.Errorf("checksum mismatch of %s: have %s, want %s", filepath.Base(path), have, want)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/internal/era"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/params/types/genesisT"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// Tests that the history exported into Era1 files is imported identically, both
+// by executing the blocks and by seeding the freezer.
+func TestHistoryImportAndExport(t *testing.T) {
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		genesis = &genesisT.Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  genesisT.GenesisAlloc{address: {Balance: big.NewInt(1000000000000000000)}},
+		}
+		signer = types.LatestSigner(genesis.Config)
+		engine = ethash.NewFaker()
+		count  = era.MaxEra1Size + 100
+	)
+	_, blocks, _ := core.GenerateChainWithGenesis(genesis, engine, count, func(i int, g *core.BlockGen) {
+		if i%64 != 0 {
+			return
+		}
+		tx, err := types.SignTx(types.NewTransaction(g.TxNonce(address), common.Address{0xaa}, big.NewInt(1000), 21000, g.BaseFee(), nil), signer, key)
+		if err != nil {
+			t.Fatalf("failed to sign transaction: %v", err)
+		}
+		g.AddTx(tx)
+	})
+	chain, err := core.NewBlockChain(rawdb.NewMemoryDatabase(), nil, genesis, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	// Export the history and check the files
+	dir := t.TempDir()
+	if err := ExportHistory(chain, dir, 0, uint64(count), "classic"); err != nil {
+		t.Fatalf("failed to export history: %v", err)
+	}
+	files, err := era.ReadDir(dir, "classic")
+	if err != nil {
+		t.Fatalf("failed to read era1 files: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("era1 file count mismatch: have %d, want 2", len(files))
+	}
+	// Import the history by executing the blocks and by seeding the freezer
+	for _, seed := range []bool{false, true} {
+		db, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), t.TempDir(), "", false)
+		if err != nil {
+			t.Fatalf("failed to create database: %v", err)
+		}
+		imported, err := core.NewBlockChain(db, nil, genesis, nil, engine, vm.Config{}, nil, nil)
+		if err != nil {
+			t.Fatalf("failed to create chain: %v", err)
+		}
+		if err := ImportHistory(imported, dir, "classic", seed); err != nil {
+			t.Fatalf("failed to import history (seed %v): %v", seed, err)
+		}
+		if seed {
+			if head := imported.CurrentSnapBlock().Number.Uint64(); head != uint64(count) {
+				t.Fatalf("snap head mismatch: have %d, want %d", head, count)
+			}
+			if frozen, _ := db.Ancients(); frozen != uint64(count)+1 {
+				t.Fatalf("ancient count mismatch: have %d, want %d", frozen, count+1)
+			}
+		} else if head := imported.CurrentBlock().Number.Uint64(); head != uint64(count) {
+			t.Fatalf("head mismatch: have %d, want %d", head, count)
+		}
+		for _, want := range blocks {
+			have := imported.GetBlockByNumber(want.NumberU64())
+			if have == nil || have.Hash() != want.Hash() {
+				t.Fatalf("block %d mismatch (seed %v)", want.NumberU64(), seed)
+			}
+			receipts := imported.GetReceiptsByHash(want.Hash())
+			if types.DeriveSha(receipts, trie.NewStackTrie(nil)) != want.ReceiptHash() {
+				t.Fatalf("receipts %d mismatch (seed %v)", want.NumberU64(), seed)
+			}
+		}
+		imported.Stop()
+		db.Close()
+	}
+	// Corrupt a file and check that the import fails
+	path := filepath.Join(dir, files[1])
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read era1 file: %v", err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("failed to write era1 file: %v", err)
+	}
+	imported, err := core.NewBlockChain(rawdb.NewMemoryDatabase(), nil, genesis, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer imported.Stop()
+	if err := ImportHistory(imported, dir, "classic", false); err == nil {
+		t.Fatalf("corrupted history imported")
+	}
+}
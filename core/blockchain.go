@@ -37,6 +37,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/state/snapshot"
+	"github.com/ethereum/go-ethereum/core/state/statediff"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/ethdb"
@@ -89,6 +90,10 @@ var (
 
 	errInsertionInterrupted = errors.New("insertion is interrupted")
 	errChainStopped         = errors.New("blockchain is stopped")
+
+	// historyPruneStep is the number of blocks the history tail may fall behind
+	// the retained window before the ancient bodies and receipts are pruned.
+	historyPruneStep = uint64(1024)
 )
 
 const (
@@ -138,6 +143,10 @@ type CacheConfig struct {
 	TrieTimeLimit       time.Duration // Time limit after which to flush the current in-memory trie to disk
 	SnapshotLimit       int           // Memory allowance (MB) to use for caching snapshot entries in memory
 	Preimages           bool          // Whether to store preimage of trie key to the disk
+	StateScheme         string        // Scheme used to store ethereum states and merkle tree nodes on top
+	StateHistory        uint64        // Number of blocks from head whose state histories are reserved, 0 keeps them all
+	StateDiffs          uint64        // Number of recent blocks whose reverse state diffs are kept, 0 disables them
+	HistoryRetain       uint64        // Number of recent blocks whose bodies and receipts are kept, 0 keeps them all
 
 	SnapshotNoBuild bool // Whether the background generation is allowed
 	SnapshotWait    bool // Wait for snapshot construction on startup. TODO(karalabe): This is a dirty hack for testing, nuke it
@@ -151,6 +160,23 @@ var defaultCacheConfig = &CacheConfig{
 	TrieTimeLimit:  5 * time.Minute,
 	SnapshotLimit:  256,
 	SnapshotWait:   true,
+	StateScheme:    rawdb.HashScheme,
+}
+
+// triedbConfig derives the configures for trie database.
+func (c *CacheConfig) triedbConfig() *trie.Config {
+	config := &trie.Config{
+		Cache:     c.TrieCleanLimit,
+		Journal:   c.TrieCleanJournal,
+		Preimages: c.Preimages,
+	}
+	if c.StateScheme == rawdb.PathScheme {
+		config.PathDB = &trie.PathConfig{
+			StateHistory: c.StateHistory,
+			DirtyCache:   c.TrieDirtyLimit,
+		}
+	}
+	return config
 }
 
 // BlockChain represents the canonical chain given a database with a genesis
@@ -173,6 +199,7 @@ type BlockChain struct {
 
 	db            ethdb.Database                   // Low level persistent database to store final content in
 	snaps         *snapshot.Tree                   // Snapshot tree for fast trie leaf access
+	stateDiffs    *statediff.Store                 // Reverse state diffs serving the recent historical states
 	triegc        *prque.Prque[int64, common.Hash] // Priority queue mapping block numbers to tries to gc
 	gcproc        time.Duration                    // Accumulates canonical block processing for trie dumping
 	lastWrite     uint64                           // Last block when the state was flushed
@@ -240,11 +267,7 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, genesis *genesis
 	}
 
 	// Open trie database with provided config
-	triedb := trie.NewDatabaseWithConfig(db, &trie.Config{
-		Cache:     cacheConfig.TrieCleanLimit,
-		Journal:   cacheConfig.TrieCleanJournal,
-		Preimages: cacheConfig.Preimages,
-	})
+	triedb := trie.NewDatabaseWithConfig(db, cacheConfig.triedbConfig())
 	// Setup the genesis block, commit the provided genesis specification
 	// to database if the genesis block is not present yet, or load the
 	// stored one from database.
@@ -411,6 +434,16 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, genesis *genesis
 		}
 		bc.snaps, _ = snapshot.New(snapconfig, bc.db, bc.triedb, head.Root)
 	}
+	// Record the reverse state diffs of the flattened snapshot layers if
+	// requested, serving the historical states of the recent blocks.
+	if bc.cacheConfig.StateDiffs > 0 {
+		if bc.snaps == nil {
+			return nil, errors.New("reverse state diffs require the snapshot")
+		}
+		if bc.stateDiffs, err = statediff.New(bc.db, bc, bc.snaps, bc.cacheConfig.StateDiffs); err != nil {
+			return nil, err
+		}
+	}
 
 	// Start future block processor.
 	bc.wg.Add(1)
@@ -442,9 +475,19 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, genesis *genesis
 	if txLookupLimit != nil {
 		bc.txLookupLimit = *txLookupLimit
 
+		// The transactions of the pruned blocks can't be indexed
+		if retain := bc.cacheConfig.HistoryRetain; retain > 0 && (bc.txLookupLimit == 0 || bc.txLookupLimit > retain) {
+			log.Warn("Capping transaction index to the retained history", "txlookuplimit", bc.txLookupLimit, "updated", retain)
+			bc.txLookupLimit = retain
+		}
 		bc.wg.Add(1)
 		go bc.maintainTxIndex()
 	}
+	// Start the history pruner if the chain history is limited.
+	if bc.cacheConfig.HistoryRetain > 0 {
+		bc.wg.Add(1)
+		go bc.maintainHistory()
+	}
 	return bc, nil
 }
 
@@ -654,7 +697,7 @@ func (bc *BlockChain) setHeadBeyondRoot(head uint64, time uint64, root common.Ha
 					if root != (common.Hash{}) && !beyondRoot && newHeadBlock.Root() == root {
 						beyondRoot, rootNumber = true, newHeadBlock.NumberU64()
 					}
-					if !bc.HasState(newHeadBlock.Root()) {
+					if !bc.HasState(newHeadBlock.Root()) && !bc.stateRecoverable(newHeadBlock.Root()) {
 						log.Trace("Block state missing, rewinding further", "number", newHeadBlock.NumberU64(), "hash", newHeadBlock.Hash())
 						if pivot == nil || newHeadBlock.NumberU64() > *pivot {
 							parent := bc.GetBlock(newHeadBlock.ParentHash(), newHeadBlock.NumberU64()-1)
@@ -676,8 +719,8 @@ func (bc *BlockChain) setHeadBeyondRoot(head uint64, time uint64, root common.Ha
 							// rewinding destination can be the earliest block stored in the chain
 							// if the historical chain pruning is enabled. In that case the logic
 							// needs to be improved here.
-							if !bc.HasState(bc.genesisBlock.Root()) {
-								if err := CommitGenesisState(bc.db, bc.genesisBlock.Hash()); err != nil {
+							if !bc.HasState(bc.genesisBlock.Root()) && !bc.stateRecoverable(bc.genesisBlock.Root()) {
+								if err := CommitGenesisState(bc.db, bc.triedb, bc.genesisBlock.Hash()); err != nil {
 									log.Crit("Failed to commit genesis state", "err", err)
 								}
 								log.Debug("Recommitted genesis state to disk")
@@ -689,6 +732,14 @@ func (bc *BlockChain) setHeadBeyondRoot(head uint64, time uint64, root common.Ha
 					log.Debug("Skipping block with threshold state", "number", newHeadBlock.NumberU64(), "hash", newHeadBlock.Hash(), "root", newHeadBlock.Root())
 					newHeadBlock = bc.GetBlock(newHeadBlock.ParentHash(), newHeadBlock.NumberU64()-1) // Keep rewinding
 				}
+				// If the state of the new head is only recoverable from the state
+				// histories (path-based scheme), revert the persisted state to it.
+				if !bc.HasState(newHeadBlock.Root()) {
+					if err := bc.triedb.Recover(newHeadBlock.Root()); err != nil {
+						log.Crit("Failed to rollback state", "err", err)
+					}
+					log.Info("Rewound to block with recovered state", "number", newHeadBlock.NumberU64(), "hash", newHeadBlock.Hash())
+				}
 			}
 			rawdb.WriteHeadBlockHash(db, newHeadBlock.Hash())
 
@@ -795,6 +846,11 @@ func (bc *BlockChain) SnapSyncCommitHead(hash common.Hash) error {
 		return fmt.Errorf("non existent block [%x..]", hash[:4])
 	}
 	root := block.Root()
+	// The synced state was persisted bypassing the trie database, it has
+	// to be activated in path-based scheme.
+	if err := bc.triedb.Enable(root); err != nil {
+		return err
+	}
 	if !bc.HasState(root) {
 		return fmt.Errorf("non existent state [%x..]", root[:4])
 	}
@@ -965,15 +1021,21 @@ func (bc *BlockChain) Stop() {
 	//  - HEAD:     So we don't need to reprocess any blocks in the general case
 	//  - HEAD-1:   So we don't do large reorgs if our HEAD becomes an uncle
 	//  - HEAD-127: So we have a hard limit on the number of blocks reexecuted
-	if !bc.cacheConfig.TrieDirtyDisabled {
+	if bc.triedb.Scheme() == rawdb.PathScheme {
+		// Save the in-memory layers, so that the recent states are available
+		// at the next start without flushing them.
+		if err := bc.triedb.Journal(bc.CurrentBlock().Root); err != nil {
+			log.Error("Failed to journal state trie", "err", err)
+		}
+	} else if !bc.cacheConfig.TrieDirtyDisabled {
 		triedb := bc.triedb
 
 		for _, offset := range []uint64{0, 1, TriesInMemory - 1} {
 			if number := bc.CurrentBlock().Number.Uint64(); number > offset {
-				recent := bc.GetBlockByNumber(number - offset)
+				recent := bc.GetHeaderByNumber(number - offset)
 
-				log.Info("Writing cached state to disk", "block", recent.Number(), "hash", recent.Hash(), "root", recent.Root())
-				if err := triedb.Commit(recent.Root(), true); err != nil {
+				log.Info("Writing cached state to disk", "block", recent.Number, "hash", recent.Hash(), "root", recent.Root)
+				if err := triedb.Commit(recent.Root, true); err != nil {
 					log.Error("Failed to commit recent state trie", "err", err)
 				}
 			}
@@ -1000,6 +1062,11 @@ func (bc *BlockChain) Stop() {
 	if bc.cacheConfig.TrieCleanJournal != "" {
 		bc.triedb.SaveCache(bc.cacheConfig.TrieCleanJournal)
 	}
+	if bc.stateDiffs != nil {
+		if err := bc.stateDiffs.Close(); err != nil {
+			log.Error("Failed to close reverse state diffs", "err", err)
+		}
+	}
 	log.Info("Blockchain stopped")
 }
 
@@ -1369,6 +1436,10 @@ func (bc *BlockChain) writeBlockWithState(block *types.Block, receipts []*types.
 	if err != nil {
 		return err
 	}
+	// The path-based scheme maintains the in-memory layers itself
+	if bc.triedb.Scheme() == rawdb.PathScheme {
+		return nil
+	}
 	// If we're running an archive node, always flush
 	if bc.cacheConfig.TrieDirtyDisabled {
 		return bc.triedb.Commit(root, false)
@@ -2470,6 +2541,66 @@ func (bc *BlockChain) maintainTxIndex() {
 	}
 }
 
+// maintainHistory is responsible for pruning the ancient bodies and receipts
+// of the blocks beyond the retained history window, following the chain head.
+func (bc *BlockChain) maintainHistory() {
+	defer bc.wg.Done()
+
+	headCh := make(chan ChainHeadEvent, 1) // Buffered to avoid locking up the event feed
+	sub := bc.SubscribeChainHeadEvent(headCh)
+	if sub == nil {
+		return
+	}
+	defer sub.Unsubscribe()
+
+	bc.pruneHistory(bc.CurrentBlock().Number.Uint64())
+	for {
+		select {
+		case head := <-headCh:
+			bc.pruneHistory(head.Block.NumberU64())
+		case <-bc.quit:
+			return
+		}
+	}
+}
+
+// pruneHistory truncates the ancient bodies and receipts below the retained
+// history window of the given head, once the window advanced far enough. The
+// headers, hashes and total difficulties are kept.
+func (bc *BlockChain) pruneHistory(head uint64) {
+	retain := bc.cacheConfig.HistoryRetain
+	if head < retain {
+		return
+	}
+	target := head - retain + 1
+
+	// Only the ancient data is pruned, and the indexed transactions are kept
+	// resolvable.
+	if frozen, err := bc.db.Ancients(); err != nil {
+		return
+	} else if target > frozen {
+		target = frozen
+	}
+	if tail := rawdb.ReadTxIndexTail(bc.db); tail != nil && *tail < target {
+		target = *tail
+	}
+	tail, err := bc.db.Tail()
+	if err != nil || target < tail+historyPruneStep {
+		return
+	}
+	// The genesis block is loaded on startup, retain its body in the key-value
+	// store.
+	if tail == 0 {
+		rawdb.WriteBody(bc.db, bc.genesisBlock.Hash(), 0, bc.genesisBlock.Body())
+	}
+	start := time.Now()
+	if err := bc.db.TruncateTail(target); err != nil {
+		log.Error("Failed to prune chain history", "tail", target, "err", err)
+		return
+	}
+	log.Info("Pruned chain history", "tail", target, "elapsed", common.PrettyDuration(time.Since(start)))
+}
+
 // reportBlock logs a bad block error.
 func (bc *BlockChain) reportBlock(block *types.Block, receipts types.Receipts, err error) {
 	rawdb.WriteBadBlock(bc.db, block)
@@ -2540,3 +2671,15 @@ func (bc *BlockChain) SetBlockValidatorAndProcessorForTesting(v Validator, p Pro
 func (bc *BlockChain) SetTrieFlushInterval(interval time.Duration) {
 	atomic.StoreInt64(&bc.flushInterval, int64(interval))
 }
+
+// WithChainLock runs the given function holding the chain mutex, so that no
+// block is written and no state is flushed meanwhile. The function is expected
+// to return quickly, as the block import is blocked until it does.
+func (bc *BlockChain) WithChainLock(fn func() error) error {
+	if !bc.chainmu.TryLock() {
+		return errChainStopped
+	}
+	defer bc.chainmu.Unlock()
+
+	return fn()
+}
@@ -0,0 +1,121 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/params/types/genesisT"
+)
+
+// Tests that the ancient bodies and receipts beyond the retained history window
+// are pruned, keeping the headers, also across a restart.
+func TestHistoryPruning(t *testing.T) {
+	defer func(old uint64) { historyPruneStep = old }(historyPruneStep)
+	historyPruneStep = 16
+
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		gspec   = &genesisT.Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  genesisT.GenesisAlloc{address: {Balance: big.NewInt(1000000000000000000)}},
+		}
+		signer = types.LatestSigner(gspec.Config)
+		engine = ethash.NewFaker()
+		blocks = 300
+		retain = uint64(100)
+	)
+	_, chain, _ := GenerateChainWithGenesis(gspec, engine, blocks, func(i int, b *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(b.TxNonce(address), common.Address{0xaa}, big.NewInt(1000), 21000, b.header.BaseFee, nil), signer, key)
+		if err != nil {
+			t.Fatalf("failed to sign transaction: %v", err)
+		}
+		b.AddTx(tx)
+	})
+	db, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), t.TempDir(), "", false)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	config := *defaultCacheConfig
+	config.HistoryRetain = retain
+	bc, err := NewBlockChain(db, &config, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	if _, err := bc.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	// Nothing is pruned until the blocks are frozen
+	bc.pruneHistory(uint64(blocks))
+	if tail := bc.HistoryTail(); tail != 0 {
+		t.Fatalf("unfrozen history pruned, tail %d", tail)
+	}
+	type freezer interface {
+		Freeze(threshold uint64) error
+	}
+	db.(freezer).Freeze(10)
+	bc.pruneHistory(uint64(blocks))
+
+	check := func(bc *BlockChain) {
+		t.Helper()
+
+		tail := uint64(blocks) - retain + 1
+		if have := bc.HistoryTail(); have != tail {
+			t.Fatalf("history tail mismatch: have %d, want %d", have, tail)
+		}
+		if bc.GetBlockByNumber(0) == nil {
+			t.Fatalf("genesis block missing")
+		}
+		for _, block := range chain {
+			number, hash := block.NumberU64(), block.Hash()
+			if header := bc.GetHeaderByNumber(number); header == nil || header.Hash() != hash {
+				t.Fatalf("header %d missing", number)
+			}
+			if td := bc.GetTd(hash, number); td == nil {
+				t.Fatalf("total difficulty %d missing", number)
+			}
+			pruned := number < tail
+			if have := rawdb.ReadBody(db, hash, number) == nil; have != pruned {
+				t.Fatalf("body %d pruned mismatch: have %v, want %v", number, have, pruned)
+			}
+			if have := rawdb.ReadRawReceipts(db, hash, number) == nil; have != pruned {
+				t.Fatalf("receipts %d pruned mismatch: have %v, want %v", number, have, pruned)
+			}
+		}
+	}
+	check(bc)
+
+	// Restart the chain, the pruned history stays pruned
+	bc.Stop()
+	bc, err = NewBlockChain(db, &config, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to recreate chain: %v", err)
+	}
+	defer bc.Stop()
+	check(bc)
+}
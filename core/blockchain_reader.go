@@ -17,6 +17,7 @@
 package core
 
 import (
+	"errors"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -208,6 +209,16 @@ func (bc *BlockChain) GetBlocksFromHash(hash common.Hash, n int) (blocks []*type
 	return
 }
 
+// HistoryTail returns the number of the first block whose body and receipts are
+// retained, non-zero if the ancient chain history was pruned.
+func (bc *BlockChain) HistoryTail() uint64 {
+	tail, err := bc.db.Tail()
+	if err != nil {
+		return 0
+	}
+	return tail
+}
+
 // GetReceiptsByHash retrieves the receipts for all transactions in a given block.
 func (bc *BlockChain) GetReceiptsByHash(hash common.Hash) types.Receipts {
 	if receipts, ok := bc.receiptsCache.Get(hash); ok {
@@ -278,6 +289,12 @@ func (bc *BlockChain) HasState(hash common.Hash) bool {
 	return err == nil
 }
 
+// stateRecoverable checks if the specified state is recoverable from the state
+// histories. It's only possible in path-based scheme.
+func (bc *BlockChain) stateRecoverable(root common.Hash) bool {
+	return bc.triedb.Recoverable(root)
+}
+
 // HasBlockAndState checks if a block and associated state trie is fully present
 // in the database or not, caching it if present.
 func (bc *BlockChain) HasBlockAndState(hash common.Hash, number uint64) bool {
@@ -323,6 +340,16 @@ func (bc *BlockChain) StateAt(root common.Hash) (*state.StateDB, error) {
 	return state.New(root, bc.stateCache, bc.snaps)
 }
 
+// HistoricState returns the state of the given canonical block rolled back by
+// the reverse state diffs, if the block is within the recorded window. The state
+// is isolated from the live database, its modifications are discarded.
+func (bc *BlockChain) HistoricState(header *types.Header) (*state.StateDB, error) {
+	if bc.stateDiffs == nil {
+		return nil, errors.New("reverse state diffs disabled")
+	}
+	return bc.stateDiffs.StateAt(header, bc.stateCache)
+}
+
 // Config retrieves the chain's fork configuration.
 func (bc *BlockChain) Config() ctypes.ChainConfigurator { return bc.chainConfig }
 
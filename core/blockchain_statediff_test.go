@@ -0,0 +1,175 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/params/types/genesisT"
+	"github.com/ethereum/go-ethereum/params/vars"
+)
+
+// Tests that the historical states of the blocks within the window of the
+// reverse state diffs are served identically to the archive ones, also after
+// a restart.
+func TestStateDiffs(t *testing.T) {
+	var (
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		sender   = crypto.PubkeyToAddress(key.PublicKey)
+		recorder = common.HexToAddress("0xaaaa") // Stores the block number at slots 0 and number
+		doomed   = common.HexToAddress("0xbbbb") // Self-destructs when called
+		gspec    = &genesisT.Genesis{
+			Config: params.TestChainConfig,
+			Alloc: genesisT.GenesisAlloc{
+				sender:   {Balance: big.NewInt(1000000000000000000)},
+				recorder: {Balance: common.Big0, Code: []byte{0x43, 0x43, 0x55, 0x43, 0x60, 0x00, 0x55, 0x00}},
+				doomed: {
+					Balance: big.NewInt(1000),
+					Code:    []byte{0x33, 0xff},
+					Storage: map[common.Hash]common.Hash{{0x01}: {0x01}, {0x02}: {0x02}},
+				},
+			},
+			BaseFee: big.NewInt(vars.InitialBaseFee),
+		}
+		signer = types.LatestSigner(gspec.Config)
+		engine = ethash.NewFaker()
+		blocks = 3 * TriesInMemory
+	)
+	_, chain, _ := GenerateChainWithGenesis(gspec, engine, blocks, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{0xcb})
+
+		to := common.Address{0x10, byte(i % 50)}
+		switch {
+		case i%3 == 0:
+			to = recorder
+		case i == 199:
+			to = doomed
+		}
+		tx, err := types.SignTx(types.NewTransaction(b.TxNonce(sender), to, big.NewInt(1000), 100000, b.header.BaseFee, nil), signer, key)
+		if err != nil {
+			t.Fatalf("failed to sign transaction: %v", err)
+		}
+		b.AddTx(tx)
+	})
+	// Import the chain into an archive node for reference
+	archive, err := NewBlockChain(rawdb.NewMemoryDatabase(), &CacheConfig{TrieDirtyDisabled: true, StateScheme: rawdb.HashScheme}, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create archive chain: %v", err)
+	}
+	defer archive.Stop()
+	if _, err := archive.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert archive chain: %v", err)
+	}
+	// Import the chain into a full node keeping the reverse state diffs
+	db, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), t.TempDir(), "", false)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	config := *defaultCacheConfig
+	config.StateDiffs = 100
+	full, err := NewBlockChain(db, &config, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	if _, err := full.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	check := func(full *BlockChain) {
+		t.Helper()
+
+		first, last, ok := full.stateDiffs.Range()
+		if !ok || last-first != 100 {
+			t.Fatalf("unexpected state range: %d-%d (%v)", first, last, ok)
+		}
+		if first > 198 || last < 200 {
+			t.Fatalf("state range %d-%d not covering the destruction", first, last)
+		}
+		if _, err := full.HistoricState(chain[first-2].Header()); err == nil {
+			t.Fatalf("state of block %d served beyond the window", first-1)
+		}
+		for number := first; number <= last; number++ {
+			header := chain[number-1].Header()
+			if full.HasState(header.Root) {
+				t.Fatalf("state of block %d not pruned", number)
+			}
+			have, err := full.HistoricState(header)
+			if err != nil {
+				t.Fatalf("failed to retrieve state of block %d: %v", number, err)
+			}
+			want, err := archive.StateAt(header.Root)
+			if err != nil {
+				t.Fatalf("failed to retrieve archive state of block %d: %v", number, err)
+			}
+			addrs := []common.Address{sender, recorder, doomed, {0xcb}}
+			for i := 0; i < 50; i++ {
+				addrs = append(addrs, common.Address{0x10, byte(i)})
+			}
+			for _, addr := range addrs {
+				checkStateAccount(t, number, have, want, addr)
+			}
+			for slot := 0; slot <= blocks; slot++ {
+				checkStateSlot(t, number, have, want, recorder, common.BigToHash(big.NewInt(int64(slot))))
+			}
+			checkStateSlot(t, number, have, want, doomed, common.Hash{0x01})
+			checkStateSlot(t, number, have, want, doomed, common.Hash{0x02})
+		}
+	}
+	check(full)
+
+	// Restart the chain, the recorded diffs are served again
+	full.Stop()
+	full, err = NewBlockChain(db, &config, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to recreate chain: %v", err)
+	}
+	defer full.Stop()
+	check(full)
+}
+
+func checkStateAccount(t *testing.T, number uint64, have, want *state.StateDB, addr common.Address) {
+	t.Helper()
+	if have.Exist(addr) != want.Exist(addr) {
+		t.Fatalf("block %d, account %x: existence mismatch: have %v, want %v", number, addr, have.Exist(addr), want.Exist(addr))
+	}
+	if have.GetBalance(addr).Cmp(want.GetBalance(addr)) != 0 {
+		t.Fatalf("block %d, account %x: balance mismatch: have %v, want %v", number, addr, have.GetBalance(addr), want.GetBalance(addr))
+	}
+	if have.GetNonce(addr) != want.GetNonce(addr) {
+		t.Fatalf("block %d, account %x: nonce mismatch: have %d, want %d", number, addr, have.GetNonce(addr), want.GetNonce(addr))
+	}
+	if have.GetCodeHash(addr) != want.GetCodeHash(addr) {
+		t.Fatalf("block %d, account %x: code mismatch", number, addr)
+	}
+}
+
+func checkStateSlot(t *testing.T, number uint64, have, want *state.StateDB, addr common.Address, slot common.Hash) {
+	t.Helper()
+	if h, w := have.GetState(addr, slot), want.GetState(addr, slot); h != w {
+		t.Fatalf("block %d, account %x, slot %x: have %x, want %x", number, addr, slot, h, w)
+	}
+}
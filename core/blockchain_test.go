@@ -1715,6 +1715,58 @@ func TestTrieForkGC(t *testing.T) {
 
 // Tests that doing large reorgs works even if the state associated with the
 // forking point is not available any more.
+// Tests that the states are retained across restarts in path-based scheme, and
+// that the chain can be rewound to the states reverted with the histories.
+func TestPathSchemeRestartAndRewind(t *testing.T) {
+	engine := ethash.NewFaker()
+	genesis := &genesisT.Genesis{
+		Config:  params.TestChainConfig,
+		BaseFee: big.NewInt(vars.InitialBaseFee),
+	}
+	_, blocks, _ := GenerateChainWithGenesis(genesis, engine, 2*TriesInMemory, func(i int, b *BlockGen) { b.SetCoinbase(common.Address{byte(i)}) })
+
+	var (
+		db     = rawdb.NewMemoryDatabase()
+		config = *defaultCacheConfig
+	)
+	config.StateScheme = rawdb.PathScheme
+	chain, err := NewBlockChain(db, &config, genesis, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	chain.Stop()
+
+	chain, err = NewBlockChain(db, &config, genesis, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to recreate tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if head := chain.CurrentBlock(); head.Hash() != blocks[len(blocks)-1].Hash() {
+		t.Fatalf("head mismatch: have %d, want %d", head.Number, blocks[len(blocks)-1].NumberU64())
+	}
+	for i := len(blocks) - TriesInMemory; i < len(blocks); i++ {
+		if !chain.HasState(blocks[i].Root()) {
+			t.Fatalf("state of block %d missing after restart", blocks[i].NumberU64())
+		}
+	}
+	if chain.HasState(blocks[0].Root()) {
+		t.Fatal("flattened state still available")
+	}
+	if err := chain.SetHead(blocks[9].NumberU64()); err != nil {
+		t.Fatalf("failed to rewind chain: %v", err)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != blocks[9].Hash() {
+		t.Fatalf("head mismatch after rewind: have %d, want %d", head.Number, blocks[9].NumberU64())
+	}
+	if !chain.HasState(blocks[9].Root()) {
+		t.Fatal("state missing after rewind")
+	}
+}
+
 func TestLargeReorgTrieGC(t *testing.T) {
 	// Generate the original common chain segment and the two competing forks
 	engine := ethash.NewFaker()
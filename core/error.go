@@ -33,8 +33,19 @@ var (
 	ErrNoGenesis = errors.New("genesis not found in chain")
 
 	errSideChainReceipts = errors.New("side blocks can't be accepted as ancient chain data")
+
+	// ErrPrunedHistory is returned if the body or receipts of a block below the
+	// history tail are requested, pruned by the history expiry.
+	ErrPrunedHistory error = &prunedHistoryError{}
 )
 
+// prunedHistoryError is the error of the pruned chain history, carrying a
+// distinct JSON-RPC error code.
+type prunedHistoryError struct{}
+
+func (e *prunedHistoryError) Error() string  { return "pruned history unavailable" }
+func (e *prunedHistoryError) ErrorCode() int { return 4444 }
+
 // List of evm-call-message pre-checking errors. All state transition messages will
 // be pre-checked before execution. If any invalidation detected, the corresponding
 // error should be returned which is defined here.
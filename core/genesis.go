@@ -123,7 +123,7 @@ func SetupGenesisBlockWithOverride(db ethdb.Database, triedb *trie.Database, gen
 	// We have the genesis block in database(perhaps in ancient database)
 	// but the corresponding state is missing.
 	header := rawdb.ReadHeader(db, stored, 0)
-	if header.Root != types.EmptyRootHash && !rawdb.HasLegacyTrieNode(db, header.Root) {
+	if header.Root != types.EmptyRootHash && !triedb.Initialized(header.Root) {
 		if genesis == nil {
 			genesis = params.DefaultGenesisBlock()
 		}
@@ -279,9 +279,13 @@ func configOrDefault(g *genesisT.Genesis, ghash common.Hash) ctypes.ChainConfigu
 }
 
 // Flush adds allocated genesis accounts into a fresh new statedb and
-// commit the state changes into the given database handler.
-func gaFlush(ga *genesisT.GenesisAlloc, db ethdb.Database) error {
-	statedb, err := state.New(common.Hash{}, state.NewDatabaseWithConfig(db, &trie.Config{Preimages: true}), nil)
+// commit the state changes into the given database handler. The state
+// is committed in hash-based scheme if no trie database is given.
+func gaFlush(ga *genesisT.GenesisAlloc, db ethdb.Database, triedb *trie.Database) error {
+	if triedb == nil {
+		triedb = trie.NewDatabaseWithConfig(db, &trie.Config{Preimages: true})
+	}
+	statedb, err := state.New(common.Hash{}, state.NewDatabaseWithNodeDB(db, triedb), nil)
 	if err != nil {
 		return err
 	}
@@ -346,8 +350,9 @@ func gaWrite(ga *genesisT.GenesisAlloc, db ethdb.KeyValueWriter, hash common.Has
 }
 
 // CommitGenesisState loads the stored genesis state with the given block
-// hash and commits them into the given database handler.
-func CommitGenesisState(db ethdb.Database, hash common.Hash) error {
+// hash and commits them into the given database handler. In path-based
+// scheme the existing state is wiped first.
+func CommitGenesisState(db ethdb.Database, triedb *trie.Database, hash common.Hash) error {
 	var alloc genesisT.GenesisAlloc
 	blob := rawdb.ReadGenesisStateSpec(db, hash)
 	if len(blob) != 0 {
@@ -383,8 +388,10 @@ func CommitGenesisState(db ethdb.Database, hash common.Hash) error {
 			return errors.New("not found")
 		}
 	}
-	err := gaFlush(&alloc, db)
-	return err
+	if err := triedb.Reset(); err != nil {
+		return err
+	}
+	return gaFlush(&alloc, db, triedb)
 }
 
 // GenesisToBlock creates the genesis block and writes state of a genesis specification
@@ -397,7 +404,7 @@ func GenesisToBlock(g *genesisT.Genesis, db ethdb.Database) *types.Block {
 	if err != nil {
 		panic(err)
 	}
-	err = gaFlush(&g.Alloc, db)
+	err = gaFlush(&g.Alloc, db, nil)
 	if err != nil {
 		panic(err)
 	}
@@ -442,7 +449,7 @@ func GenesisToBlock(g *genesisT.Genesis, db ethdb.Database) *types.Block {
 // CommitGenesis writes the block and state of a genesis specification to the database.
 // The block is committed as the canonical head block.
 func CommitGenesis(g *genesisT.Genesis, db ethdb.Database, triedb *trie.Database) (*types.Block, error) {
-	block := GenesisToBlock(g, db)
+	block := GenesisToBlock(g, nil)
 	if block.Number().Sign() != 0 {
 		return nil, errors.New("can't commit genesis block with number > 0")
 	}
@@ -461,6 +468,9 @@ func CommitGenesis(g *genesisT.Genesis, db ethdb.Database, triedb *trie.Database
 	// All the checks has passed, flush the states derived from the genesis
 	// specification as well as the specification itself into the provided
 	// database.
+	if err := gaFlush(&g.Alloc, db, triedb); err != nil {
+		return nil, err
+	}
 	if err := gaWrite(&g.Alloc, db, block.Hash()); err != nil {
 		return nil, err
 	}
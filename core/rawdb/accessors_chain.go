@@ -444,9 +444,12 @@ func ReadBodyRLP(db ethdb.Reader, hash common.Hash, number uint64) rlp.RawValue
 		// Check if the data is in ancients
 		if isCanon(reader, number, hash) {
 			data, _ = reader.Ancient(ChainFreezerBodiesTable, number)
-			return nil
+			if len(data) > 0 {
+				return nil
+			}
 		}
-		// If not, try reading from leveldb
+		// If not, try reading from leveldb. The genesis body is retained
+		// there if the ancient bodies are pruned.
 		data, _ = db.Get(blockBodyKey(number, hash))
 		return nil
 	})
@@ -17,6 +17,8 @@
 package rawdb
 
 import (
+	"encoding/binary"
+
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/log"
@@ -92,3 +94,195 @@ func DeleteCode(db ethdb.KeyValueWriter, hash common.Hash) {
 		log.Crit("Failed to delete contract code", "err", err)
 	}
 }
+
+// ReadStateID retrieves the state id with the provided state root.
+func ReadStateID(db ethdb.KeyValueReader, root common.Hash) *uint64 {
+	data, err := db.Get(stateIDKey(root))
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	number := binary.BigEndian.Uint64(data)
+	return &number
+}
+
+// WriteStateID writes the provided state lookup to database.
+func WriteStateID(db ethdb.KeyValueWriter, root common.Hash, id uint64) {
+	var buff [8]byte
+	binary.BigEndian.PutUint64(buff[:], id)
+	if err := db.Put(stateIDKey(root), buff[:]); err != nil {
+		log.Crit("Failed to store state ID", "err", err)
+	}
+}
+
+// DeleteStateID deletes the specified state lookup from the database.
+func DeleteStateID(db ethdb.KeyValueWriter, root common.Hash) {
+	if err := db.Delete(stateIDKey(root)); err != nil {
+		log.Crit("Failed to delete state ID", "err", err)
+	}
+}
+
+// ReadPersistentStateID retrieves the id of the persistent state from the database.
+func ReadPersistentStateID(db ethdb.KeyValueReader) uint64 {
+	data, _ := db.Get(persistentStateIDKey)
+	if len(data) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+// WritePersistentStateID stores the id of the persistent state into database.
+func WritePersistentStateID(db ethdb.KeyValueWriter, number uint64) {
+	if err := db.Put(persistentStateIDKey, encodeBlockNumber(number)); err != nil {
+		log.Crit("Failed to store the persistent state ID", "err", err)
+	}
+}
+
+// ReadStateHistoryTail retrieves the id of the oldest stored state history,
+// or nil if no history was ever pruned.
+func ReadStateHistoryTail(db ethdb.KeyValueReader) *uint64 {
+	data, _ := db.Get(stateHistoryTailKey)
+	if len(data) != 8 {
+		return nil
+	}
+	number := binary.BigEndian.Uint64(data)
+	return &number
+}
+
+// WriteStateHistoryTail stores the id of the oldest stored state history.
+func WriteStateHistoryTail(db ethdb.KeyValueWriter, number uint64) {
+	if err := db.Put(stateHistoryTailKey, encodeBlockNumber(number)); err != nil {
+		log.Crit("Failed to store the state history tail", "err", err)
+	}
+}
+
+// ReadStateHistory retrieves the RLP-encoded state history with the given id,
+// which reverts the state with the id to its parent.
+func ReadStateHistory(db ethdb.KeyValueReader, id uint64) []byte {
+	data, _ := db.Get(stateHistoryKey(id))
+	return data
+}
+
+// WriteStateHistory stores the RLP-encoded state history with the given id.
+func WriteStateHistory(db ethdb.KeyValueWriter, id uint64, blob []byte) {
+	if err := db.Put(stateHistoryKey(id), blob); err != nil {
+		log.Crit("Failed to store state history", "err", err)
+	}
+}
+
+// DeleteStateHistory deletes the state history with the given id.
+func DeleteStateHistory(db ethdb.KeyValueWriter, id uint64) {
+	if err := db.Delete(stateHistoryKey(id)); err != nil {
+		log.Crit("Failed to delete state history", "err", err)
+	}
+}
+
+// ReadTrieJournal retrieves the serialized in-memory trie node layers saved at
+// the last shutdown.
+func ReadTrieJournal(db ethdb.KeyValueReader) []byte {
+	data, _ := db.Get(trieJournalKey)
+	return data
+}
+
+// WriteTrieJournal stores the serialized in-memory trie node layers to save at
+// shutdown.
+func WriteTrieJournal(db ethdb.KeyValueWriter, journal []byte) {
+	if err := db.Put(trieJournalKey, journal); err != nil {
+		log.Crit("Failed to store tries journal", "err", err)
+	}
+}
+
+// DeleteTrieJournal deletes the serialized in-memory trie node layers saved at
+// the last shutdown.
+func DeleteTrieJournal(db ethdb.KeyValueWriter) {
+	if err := db.Delete(trieJournalKey); err != nil {
+		log.Crit("Failed to remove tries journal", "err", err)
+	}
+}
+
+// ReadOnlinePruning retrieves the serialized progress of the online state
+// pruning, if it's running.
+func ReadOnlinePruning(db ethdb.KeyValueReader) []byte {
+	data, _ := db.Get(onlinePruningKey)
+	return data
+}
+
+// WriteOnlinePruning stores the serialized progress of the online state pruning.
+func WriteOnlinePruning(db ethdb.KeyValueWriter, progress []byte) {
+	if err := db.Put(onlinePruningKey, progress); err != nil {
+		log.Crit("Failed to store online pruning progress", "err", err)
+	}
+}
+
+// DeleteOnlinePruning deletes the progress of the online state pruning.
+func DeleteOnlinePruning(db ethdb.KeyValueWriter) {
+	if err := db.Delete(onlinePruningKey); err != nil {
+		log.Crit("Failed to remove online pruning progress", "err", err)
+	}
+}
+
+// HasOnlinePruningNode checks whether the trie node with the given hash was
+// flushed to disk while the online state pruning was running.
+func HasOnlinePruningNode(db ethdb.KeyValueReader, hash common.Hash) bool {
+	ok, _ := db.Has(onlinePruningNodeKey(hash))
+	return ok
+}
+
+// WriteOnlinePruningNode marks the trie node with the given hash as flushed to
+// disk while the online state pruning is running.
+func WriteOnlinePruningNode(db ethdb.KeyValueWriter, hash common.Hash) {
+	if err := db.Put(onlinePruningNodeKey(hash), nil); err != nil {
+		log.Crit("Failed to store online pruning node marker", "err", err)
+	}
+}
+
+// DeleteOnlinePruningNodes deletes all the trie node markers of the online
+// state pruning.
+func DeleteOnlinePruningNodes(db ethdb.KeyValueStore) error {
+	var (
+		batch = db.NewBatch()
+		it    = db.NewIterator(onlinePruningNodePrefix, nil)
+	)
+	defer it.Release()
+
+	for it.Next() {
+		if len(it.Key()) != len(onlinePruningNodePrefix)+common.HashLength {
+			continue
+		}
+		batch.Delete(it.Key())
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+// ReadStateDiff retrieves the RLP-encoded reverse state diff with the given id
+// from the state diff freezer.
+func ReadStateDiff(db ethdb.AncientReaderOp, id uint64) []byte {
+	blob, err := db.Ancient(StateDiffFreezerTable, id)
+	if err != nil {
+		return nil
+	}
+	return blob
+}
+
+// ReadStateDiffs retrieves a batch of RLP-encoded reverse state diffs starting
+// with the given id, up to count items or maxBytes in total.
+func ReadStateDiffs(db ethdb.AncientReaderOp, start, count, maxBytes uint64) ([][]byte, error) {
+	return db.AncientRange(StateDiffFreezerTable, start, count, maxBytes)
+}
+
+// WriteStateDiff appends the RLP-encoded reverse state diff with the given id
+// to the state diff freezer.
+func WriteStateDiff(db ethdb.AncientWriter, id uint64, blob []byte) error {
+	_, err := db.ModifyAncients(func(op ethdb.AncientWriteOp) error {
+		return op.AppendRaw(StateDiffFreezerTable, id, blob)
+	})
+	return err
+}
@@ -0,0 +1,152 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"encoding/binary"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// ReadTraceIndexTail retrieves the number of oldest block whose call traces
+// have been indexed. If the corresponding entry is non-existent in database
+// it means the indexing has never been started.
+func ReadTraceIndexTail(db ethdb.KeyValueReader) *uint64 {
+	data, _ := db.Get(traceIndexTailKey)
+	if len(data) != 8 {
+		return nil
+	}
+	number := binary.BigEndian.Uint64(data)
+	return &number
+}
+
+// WriteTraceIndexTail stores the number of oldest block whose call traces have
+// been indexed.
+func WriteTraceIndexTail(db ethdb.KeyValueWriter, number uint64) {
+	if err := db.Put(traceIndexTailKey, encodeBlockNumber(number)); err != nil {
+		log.Crit("Failed to store the trace index tail", "err", err)
+	}
+}
+
+// HasBlockTraces verifies the existence of the indexed call traces of a block.
+func HasBlockTraces(db ethdb.KeyValueReader, hash common.Hash, number uint64) bool {
+	has, err := db.Has(blockTracesKey(number, hash))
+	return err == nil && has
+}
+
+// ReadBlockTraces retrieves the indexed call traces of every transaction in a
+// block, or nil if the block is not indexed.
+func ReadBlockTraces(db ethdb.KeyValueReader, hash common.Hash, number uint64) [][]byte {
+	data, _ := db.Get(blockTracesKey(number, hash))
+	if len(data) == 0 {
+		return nil
+	}
+	traces := [][]byte{}
+	if err := rlp.DecodeBytes(data, &traces); err != nil {
+		log.Error("Invalid block traces RLP", "hash", hash, "number", number, "err", err)
+		return nil
+	}
+	return traces
+}
+
+// WriteBlockTraces stores the call traces of every transaction in a block.
+func WriteBlockTraces(db ethdb.KeyValueWriter, hash common.Hash, number uint64, traces [][]byte) {
+	data, err := rlp.EncodeToBytes(traces)
+	if err != nil {
+		log.Crit("Failed to encode block traces", "err", err)
+	}
+	if err := db.Put(blockTracesKey(number, hash), data); err != nil {
+		log.Crit("Failed to store block traces", "err", err)
+	}
+}
+
+// DeleteBlockTraces removes the call traces of a block.
+func DeleteBlockTraces(db ethdb.KeyValueWriter, hash common.Hash, number uint64) {
+	if err := db.Delete(blockTracesKey(number, hash)); err != nil {
+		log.Crit("Failed to delete block traces", "err", err)
+	}
+}
+
+// ReadAllBlockTracesInRange retrieves the number and hash of all the blocks
+// with indexed call traces at certain heights, both canonical and reorged forks
+// included. This method considers both limits to be _inclusive_.
+func ReadAllBlockTracesInRange(db ethdb.Iteratee, first, last uint64) []*NumberHash {
+	var (
+		keyLength = len(blockTracesPrefix) + 8 + common.HashLength
+		hashes    []*NumberHash
+		it        = db.NewIterator(blockTracesPrefix, encodeBlockNumber(first))
+	)
+	defer it.Release()
+
+	for it.Next() {
+		key := it.Key()
+		if len(key) != keyLength {
+			continue
+		}
+		num := binary.BigEndian.Uint64(key[len(blockTracesPrefix) : len(blockTracesPrefix)+8])
+		if num > last {
+			break
+		}
+		hashes = append(hashes, &NumberHash{num, common.BytesToHash(key[len(key)-common.HashLength:])})
+	}
+	return hashes
+}
+
+// WriteTraceAddressIndex marks the block with the given number and hash as
+// containing call traces involving the given address.
+func WriteTraceAddressIndex(db ethdb.KeyValueWriter, address common.Address, number uint64, hash common.Hash) {
+	if err := db.Put(traceAddressKey(address, number, hash), nil); err != nil {
+		log.Crit("Failed to store trace address index", "err", err)
+	}
+}
+
+// DeleteTraceAddressIndex removes the mark of a block containing call traces
+// involving the given address.
+func DeleteTraceAddressIndex(db ethdb.KeyValueWriter, address common.Address, number uint64, hash common.Hash) {
+	if err := db.Delete(traceAddressKey(address, number, hash)); err != nil {
+		log.Crit("Failed to delete trace address index", "err", err)
+	}
+}
+
+// ReadTraceAddressBlocks retrieves the number and hash of all the blocks with
+// call traces involving the given address, both canonical and reorged forks
+// included. This method considers both limits to be _inclusive_.
+func ReadTraceAddressBlocks(db ethdb.Iteratee, address common.Address, first, last uint64) []*NumberHash {
+	var (
+		prefix    = append(append([]byte{}, traceAddressPrefix...), address.Bytes()...)
+		keyLength = len(prefix) + 8 + common.HashLength
+		hashes    []*NumberHash
+		it        = db.NewIterator(prefix, encodeBlockNumber(first))
+	)
+	defer it.Release()
+
+	for it.Next() {
+		key := it.Key()
+		if len(key) != keyLength {
+			continue
+		}
+		num := binary.BigEndian.Uint64(key[len(prefix) : len(prefix)+8])
+		if num > last {
+			break
+		}
+		hashes = append(hashes, &NumberHash{num, common.BytesToHash(key[len(key)-common.HashLength:])})
+	}
+	return hashes
+}
@@ -0,0 +1,83 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Tests block traces and trace address index storage and retrieval operations.
+func TestTraceStorage(t *testing.T) {
+	db := NewMemoryDatabase()
+
+	var (
+		addr   = common.HexToAddress("0x01")
+		other  = common.HexToAddress("0x02")
+		hashes = []common.Hash{{0x01}, {0x02}, {0x03}}
+		traces = [][]byte{[]byte(`[{"type":"call"}]`), []byte(`[]`)}
+	)
+	if HasBlockTraces(db, hashes[0], 1) || ReadBlockTraces(db, hashes[0], 1) != nil {
+		t.Fatalf("Non existent block traces returned")
+	}
+	WriteBlockTraces(db, hashes[0], 1, traces)
+	WriteBlockTraces(db, hashes[1], 2, [][]byte{})
+	WriteBlockTraces(db, hashes[2], 3, traces)
+
+	if !HasBlockTraces(db, hashes[0], 1) {
+		t.Fatalf("Stored block traces not found")
+	}
+	if stored := ReadBlockTraces(db, hashes[0], 1); !reflect.DeepEqual(stored, traces) {
+		t.Fatalf("Block traces mismatch: have %q, want %q", stored, traces)
+	}
+	if stored := ReadBlockTraces(db, hashes[1], 2); stored == nil || len(stored) != 0 {
+		t.Fatalf("Empty block traces mismatch: have %q", stored)
+	}
+	if blocks := ReadAllBlockTracesInRange(db, 2, 3); len(blocks) != 2 || blocks[0].Hash != hashes[1] || blocks[1].Hash != hashes[2] {
+		t.Fatalf("Block traces range mismatch: have %v", blocks)
+	}
+	// Check the address index
+	WriteTraceAddressIndex(db, addr, 1, hashes[0])
+	WriteTraceAddressIndex(db, addr, 3, hashes[2])
+	WriteTraceAddressIndex(db, other, 2, hashes[1])
+
+	if blocks := ReadTraceAddressBlocks(db, addr, 0, 10); len(blocks) != 2 || blocks[0].Number != 1 || blocks[1].Number != 3 {
+		t.Fatalf("Address blocks mismatch: have %v", blocks)
+	}
+	if blocks := ReadTraceAddressBlocks(db, addr, 2, 2); len(blocks) != 0 {
+		t.Fatalf("Address blocks outside range returned: %v", blocks)
+	}
+	DeleteTraceAddressIndex(db, addr, 1, hashes[0])
+	DeleteBlockTraces(db, hashes[0], 1)
+
+	if blocks := ReadTraceAddressBlocks(db, addr, 0, 10); len(blocks) != 1 || blocks[0].Number != 3 {
+		t.Fatalf("Deleted address blocks returned: %v", blocks)
+	}
+	if HasBlockTraces(db, hashes[0], 1) {
+		t.Fatalf("Deleted block traces returned")
+	}
+	// Check the index tail
+	if tail := ReadTraceIndexTail(db); tail != nil {
+		t.Fatalf("Non existent trace index tail returned: %d", *tail)
+	}
+	WriteTraceIndexTail(db, 2)
+	if tail := ReadTraceIndexTail(db); tail == nil || *tail != 2 {
+		t.Fatalf("Trace index tail mismatch: have %v", tail)
+	}
+}
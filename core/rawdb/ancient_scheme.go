@@ -34,20 +34,40 @@ const (
 	ChainFreezerDifficultyTable = "diffs"
 )
 
-// chainFreezerNoSnappy configures whether compression is disabled for the ancient-tables.
-// Hashes and difficulties don't compress well.
-var chainFreezerNoSnappy = map[string]bool{
-	ChainFreezerHeaderTable:     false,
-	ChainFreezerHashTable:       true,
-	ChainFreezerBodiesTable:     false,
-	ChainFreezerReceiptTable:    false,
-	ChainFreezerDifficultyTable: true,
+// freezerTableConfig contains the settings for a freezer table.
+type freezerTableConfig struct {
+	codec    freezerCodec // compression codec of new tables, unless recorded otherwise
+	prunable bool         // true for tables that can be pruned by TruncateTail
+}
+
+// chainFreezerTableConfigs configures the settings for tables in the chain freezer.
+// Hashes and difficulties don't compress well. The bodies and receipts can be
+// pruned by the history expiry, the headers, hashes and difficulties are kept.
+var chainFreezerTableConfigs = map[string]freezerTableConfig{
+	ChainFreezerHeaderTable:     {codec: codecSnappy, prunable: false},
+	ChainFreezerHashTable:       {codec: codecNone, prunable: false},
+	ChainFreezerBodiesTable:     {codec: codecSnappy, prunable: true},
+	ChainFreezerReceiptTable:    {codec: codecSnappy, prunable: true},
+	ChainFreezerDifficultyTable: {codec: codecNone, prunable: false},
+}
+
+// The list of table names of state diff freezer.
+const (
+	// StateDiffFreezerTable indicates the name of the freezer reverse state diff table.
+	StateDiffFreezerTable = "reverse"
+)
+
+// stateDiffFreezerTableConfigs configures the settings for tables in the state
+// diff freezer.
+var stateDiffFreezerTableConfigs = map[string]freezerTableConfig{
+	StateDiffFreezerTable: {codec: codecSnappy, prunable: true},
 }
 
 // The list of identifiers of ancient stores.
 var (
-	chainFreezerName = "chain" // the folder name of chain segment ancient store.
+	chainFreezerName     = "chain"      // the folder name of chain segment ancient store.
+	stateDiffFreezerName = "statediffs" // the folder name of reverse state diff ancient store.
 )
 
 // freezers the collections of all builtin freezers.
-var freezers = []string{chainFreezerName}
+var freezers = []string{chainFreezerName, stateDiffFreezerName}
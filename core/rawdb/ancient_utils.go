@@ -18,29 +18,26 @@ package rawdb
 
 import (
 	"fmt"
+	"path/filepath"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethdb"
 )
 
 type tableSize struct {
-	name string
-	size common.StorageSize
+	name  string
+	size  common.StorageSize
+	count uint64 // Number of items retained in the table
 }
 
 // freezerInfo contains the basic information of the freezer.
 type freezerInfo struct {
 	name  string      // The identifier of freezer
 	head  uint64      // The number of last stored item in the freezer
-	tail  uint64      // The number of first stored item in the freezer
+	tail  uint64      // The number of first stored item in the prunable tables
 	sizes []tableSize // The storage size per table
 }
 
-// count returns the number of stored items in the freezer.
-func (info *freezerInfo) count() uint64 {
-	return info.head - info.tail + 1
-}
-
 // size returns the storage size of the entire freezer.
 func (info *freezerInfo) size() common.StorageSize {
 	var total common.StorageSize
@@ -60,13 +57,6 @@ func inspectFreezers(db ethdb.Database) ([]freezerInfo, error) {
 			// with the key-value store, inspect the chain store directly.
 			info := freezerInfo{name: freezer}
 			// Retrieve storage size of every contained table.
-			for table := range chainFreezerNoSnappy {
-				size, err := db.AncientSize(table)
-				if err != nil {
-					return nil, err
-				}
-				info.sizes = append(info.sizes, tableSize{name: table, size: common.StorageSize(size)})
-			}
 			// Retrieve the number of last stored item
 			ancients, err := db.Ancients()
 			if err != nil {
@@ -74,14 +64,59 @@ func inspectFreezers(db ethdb.Database) ([]freezerInfo, error) {
 			}
 			info.head = ancients - 1
 
-			// Retrieve the number of first stored item
+			// Retrieve the number of first stored item of the prunable
+			// tables, the history tail.
 			tail, err := db.Tail()
 			if err != nil {
 				return nil, err
 			}
 			info.tail = tail
+
+			// Retrieve storage size of every contained table.
+			for table, config := range chainFreezerTableConfigs {
+				size, err := db.AncientSize(table)
+				if err != nil {
+					return nil, err
+				}
+				count := ancients
+				if config.prunable {
+					count -= tail
+				}
+				info.sizes = append(info.sizes, tableSize{name: table, size: common.StorageSize(size), count: count})
+			}
 			infos = append(infos, info)
 
+		case stateDiffFreezerName:
+			// The state diff store is optional, only inspect it if it exists.
+			ancient, err := db.AncientDatadir()
+			if err != nil {
+				continue
+			}
+			if !common.FileExist(filepath.Join(ancient, freezer)) {
+				continue
+			}
+			f, err := NewStateDiffFreezer(ancient, true)
+			if err != nil {
+				return nil, err
+			}
+			ancients, _ := f.Ancients()
+			info := freezerInfo{name: freezer, head: ancients - 1}
+			info.tail, _ = f.Tail()
+			for table := range stateDiffFreezerTableConfigs {
+				size, err := f.AncientSize(table)
+				if err != nil {
+					f.Close()
+					return nil, err
+				}
+				info.sizes = append(info.sizes, tableSize{name: table, size: common.StorageSize(size), count: ancients - info.tail})
+			}
+			f.Close()
+
+			// Skip the empty store, its count would underflow
+			if ancients > info.tail {
+				infos = append(infos, info)
+			}
+
 		default:
 			return nil, fmt.Errorf("unknown freezer, supported ones: %v", freezers)
 		}
@@ -89,30 +124,42 @@ func inspectFreezers(db ethdb.Database) ([]freezerInfo, error) {
 	return infos, nil
 }
 
-// InspectFreezerTable dumps out the index of a specific freezer table. The passed
-// ancient indicates the path of root ancient directory where the chain freezer can
-// be opened. Start and end specify the range for dumping out indexes.
-// Note this function can only be used for debugging purposes.
-func InspectFreezerTable(ancient string, freezerName string, tableName string, start, end int64) error {
+// resolveFreezerTable returns the directory of the given freezer in the root
+// ancient directory along with the configuration of the given table.
+func resolveFreezerTable(ancient string, freezerName string, tableName string) (string, freezerTableConfig, error) {
 	var (
 		path   string
-		tables map[string]bool
+		tables map[string]freezerTableConfig
 	)
 	switch freezerName {
 	case chainFreezerName:
-		path, tables = resolveChainFreezerDir(ancient), chainFreezerNoSnappy
+		path, tables = resolveChainFreezerDir(ancient), chainFreezerTableConfigs
+	case stateDiffFreezerName:
+		path, tables = filepath.Join(ancient, freezerName), stateDiffFreezerTableConfigs
 	default:
-		return fmt.Errorf("unknown freezer, supported ones: %v", freezers)
+		return "", freezerTableConfig{}, fmt.Errorf("unknown freezer, supported ones: %v", freezers)
 	}
-	noSnappy, exist := tables[tableName]
+	config, exist := tables[tableName]
 	if !exist {
 		var names []string
 		for name := range tables {
 			names = append(names, name)
 		}
-		return fmt.Errorf("unknown table, supported ones: %v", names)
+		return "", freezerTableConfig{}, fmt.Errorf("unknown table, supported ones: %v", names)
+	}
+	return path, config, nil
+}
+
+// InspectFreezerTable dumps out the index of a specific freezer table. The passed
+// ancient indicates the path of root ancient directory where the chain freezer can
+// be opened. Start and end specify the range for dumping out indexes.
+// Note this function can only be used for debugging purposes.
+func InspectFreezerTable(ancient string, freezerName string, tableName string, start, end int64) error {
+	path, config, err := resolveFreezerTable(ancient, freezerName, tableName)
+	if err != nil {
+		return err
 	}
-	table, err := newFreezerTable(path, tableName, noSnappy, true)
+	table, err := newFreezerTable(path, tableName, config.codec, true)
 	if err != nil {
 		return err
 	}
@@ -322,15 +322,16 @@ func NewLevelDBDatabase(file string, cache int, handles int, namespace string, r
 	return NewDatabase(db), nil
 }
 
+// The supported key-value database engines.
 const (
-	dbPebble  = "pebble"
-	dbLeveldb = "leveldb"
+	DBPebble  = "pebble"
+	DBLeveldb = "leveldb"
 )
 
-// hasPreexistingDb checks the given data directory whether a database is already
+// PreexistingDatabase checks the given data directory whether a database is already
 // instantiated at that location, and if so, returns the type of database (or the
 // empty string).
-func hasPreexistingDb(path string) string {
+func PreexistingDatabase(path string) string {
 	if _, err := os.Stat(filepath.Join(path, "CURRENT")); err != nil {
 		return "" // No pre-existing db
 	}
@@ -338,9 +339,9 @@ func hasPreexistingDb(path string) string {
 		if err != nil {
 			panic(err) // only possible if the pattern is malformed
 		}
-		return dbPebble
+		return DBPebble
 	}
-	return dbLeveldb
+	return DBLeveldb
 }
 
 // OpenOptions contains the options to apply when opening a database.
@@ -362,11 +363,11 @@ type OpenOptions struct {
 //	db is non-existent |  leveldb default  |  specified type
 //	db is existent     |  from db          |  specified type (if compatible)
 func openKeyValueDatabase(o OpenOptions) (ethdb.Database, error) {
-	existingDb := hasPreexistingDb(o.Directory)
+	existingDb := PreexistingDatabase(o.Directory)
 	if len(existingDb) != 0 && len(o.Type) != 0 && o.Type != existingDb {
 		return nil, fmt.Errorf("db.engine choice was %v but found pre-existing %v database in specified data directory", o.Type, existingDb)
 	}
-	if o.Type == dbPebble || existingDb == dbPebble {
+	if o.Type == DBPebble || existingDb == DBPebble {
 		if PebbleEnabled {
 			log.Info("Using pebble as the backing database")
 			return NewPebbleDBDatabase(o.Directory, o.Cache, o.Handles, o.Namespace, o.ReadOnly)
@@ -374,7 +375,7 @@ func openKeyValueDatabase(o OpenOptions) (ethdb.Database, error) {
 			return nil, errors.New("db.engine 'pebble' not supported on this platform")
 		}
 	}
-	if len(o.Type) != 0 && o.Type != dbLeveldb {
+	if len(o.Type) != 0 && o.Type != DBLeveldb {
 		return nil, fmt.Errorf("unknown db.engine %v", o.Type)
 	}
 	log.Info("Using leveldb as the backing database")
@@ -452,12 +453,18 @@ func InspectDatabase(db ethdb.Database, keyPrefix, keyStart []byte) error {
 		numHashPairings stat
 		hashNumPairings stat
 		tries           stat
+		accountTries    stat
+		storageTries    stat
+		stateLookups    stat
+		stateHistories  stat
+		pruningMarkers  stat
 		codes           stat
 		txLookups       stat
 		accountSnaps    stat
 		storageSnaps    stat
 		preimages       stat
 		bloomBits       stat
+		traces          stat
 		beaconHeaders   stat
 		cliqueSnaps     stat
 
@@ -494,6 +501,16 @@ func InspectDatabase(db ethdb.Database, keyPrefix, keyStart []byte) error {
 			hashNumPairings.Add(size)
 		case len(key) == common.HashLength:
 			tries.Add(size)
+		case bytes.HasPrefix(key, trieNodeAccountPrefix) && len(key) < len(trieNodeAccountPrefix)+2*common.HashLength:
+			accountTries.Add(size)
+		case bytes.HasPrefix(key, trieNodeStoragePrefix) && len(key) >= len(trieNodeStoragePrefix)+common.HashLength && len(key) < len(trieNodeStoragePrefix)+3*common.HashLength:
+			storageTries.Add(size)
+		case bytes.HasPrefix(key, stateIDPrefix) && len(key) == len(stateIDPrefix)+common.HashLength:
+			stateLookups.Add(size)
+		case bytes.HasPrefix(key, stateHistoryPrefix) && len(key) == len(stateHistoryPrefix)+8:
+			stateHistories.Add(size)
+		case bytes.HasPrefix(key, onlinePruningNodePrefix) && len(key) == len(onlinePruningNodePrefix)+common.HashLength:
+			pruningMarkers.Add(size)
 		case bytes.HasPrefix(key, CodePrefix) && len(key) == len(CodePrefix)+common.HashLength:
 			codes.Add(size)
 		case bytes.HasPrefix(key, txLookupPrefix) && len(key) == (len(txLookupPrefix)+common.HashLength):
@@ -512,6 +529,12 @@ func InspectDatabase(db ethdb.Database, keyPrefix, keyStart []byte) error {
 			bloomBits.Add(size)
 		case bytes.HasPrefix(key, BloomBitsIndexPrefix):
 			bloomBits.Add(size)
+		case bytes.HasPrefix(key, blockTracesPrefix) && len(key) == (len(blockTracesPrefix)+8+common.HashLength):
+			traces.Add(size)
+		case bytes.HasPrefix(key, traceAddressPrefix) && len(key) == (len(traceAddressPrefix)+common.AddressLength+8+common.HashLength):
+			traces.Add(size)
+		case bytes.HasPrefix(key, TraceIndexPrefix):
+			traces.Add(size)
 		case bytes.HasPrefix(key, skeletonHeaderPrefix) && len(key) == (len(skeletonHeaderPrefix)+8):
 			beaconHeaders.Add(size)
 		case bytes.HasPrefix(key, CliqueSnapshotPrefix) && len(key) == 7+common.HashLength:
@@ -529,8 +552,9 @@ func InspectDatabase(db ethdb.Database, keyPrefix, keyStart []byte) error {
 			for _, meta := range [][]byte{
 				databaseVersionKey, headHeaderKey, headBlockKey, headFastBlockKey, headFinalizedBlockKey,
 				lastPivotKey, fastTrieProgressKey, snapshotDisabledKey, SnapshotRootKey, snapshotJournalKey,
-				snapshotGeneratorKey, snapshotRecoveryKey, txIndexTailKey, fastTxLookupLimitKey,
+				snapshotGeneratorKey, snapshotRecoveryKey, txIndexTailKey, traceIndexTailKey, fastTxLookupLimitKey,
 				uncleanShutdownKey, badBlockKey, transitionStatusKey, skeletonSyncStatusKey,
+				persistentStateIDKey, stateHistoryTailKey, trieJournalKey, onlinePruningKey,
 			} {
 				if bytes.Equal(key, meta) {
 					metadata.Add(size)
@@ -558,8 +582,14 @@ func InspectDatabase(db ethdb.Database, keyPrefix, keyStart []byte) error {
 		{"Key-Value store", "Block hash->number", hashNumPairings.Size(), hashNumPairings.Count()},
 		{"Key-Value store", "Transaction index", txLookups.Size(), txLookups.Count()},
 		{"Key-Value store", "Bloombit index", bloomBits.Size(), bloomBits.Count()},
+		{"Key-Value store", "Trace index", traces.Size(), traces.Count()},
 		{"Key-Value store", "Contract codes", codes.Size(), codes.Count()},
 		{"Key-Value store", "Trie nodes", tries.Size(), tries.Count()},
+		{"Key-Value store", "Path trie account nodes", accountTries.Size(), accountTries.Count()},
+		{"Key-Value store", "Path trie storage nodes", storageTries.Size(), storageTries.Count()},
+		{"Key-Value store", "Path trie state lookups", stateLookups.Size(), stateLookups.Count()},
+		{"Key-Value store", "Path trie state histories", stateHistories.Size(), stateHistories.Count()},
+		{"Key-Value store", "Online pruning node markers", pruningMarkers.Size(), pruningMarkers.Count()},
 		{"Key-Value store", "Trie preimages", preimages.Size(), preimages.Count()},
 		{"Key-Value store", "Account snapshot", accountSnaps.Size(), accountSnaps.Count()},
 		{"Key-Value store", "Storage snapshot", storageSnaps.Size(), storageSnaps.Count()},
@@ -580,7 +610,7 @@ func InspectDatabase(db ethdb.Database, keyPrefix, keyStart []byte) error {
 				fmt.Sprintf("Ancient store (%s)", strings.Title(ancient.name)),
 				strings.Title(table.name),
 				table.size.String(),
-				fmt.Sprintf("%d", ancient.count()),
+				fmt.Sprintf("%d", table.count),
 			})
 		}
 		total += ancient.size()
@@ -591,6 +621,12 @@ func InspectDatabase(db ethdb.Database, keyPrefix, keyStart []byte) error {
 	table.AppendBulk(stats)
 	table.Render()
 
+	for _, ancient := range ancients {
+		// Report the history tail, the bodies and receipts below it are pruned
+		if ancient.name == chainFreezerName && ancient.head+1 > 0 {
+			log.Info("Ancient chain history", "tail", ancient.tail, "head", ancient.head, "pruned", ancient.tail > 0)
+		}
+	}
 	if unaccounted.size > 0 {
 		log.Error("Database contains unaccounted data", "size", unaccounted.size, "count", unaccounted.count)
 	}
@@ -0,0 +1,157 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang/snappy"
+)
+
+// freezerCodec identifies the compression algorithm applied to the items of
+// a freezer table.
+type freezerCodec uint8
+
+const (
+	// codecDefault is the codec recorded in the metadata of tables using the
+	// codec of their configuration, which is also the case for all the tables
+	// created before the codec was made selectable.
+	codecDefault freezerCodec = iota
+
+	// codecNone stores the items uncompressed.
+	codecNone
+
+	// codecSnappy compresses every item individually with snappy.
+	codecSnappy
+
+	// codecZstd compresses every item individually with zstd, using a
+	// dictionary shared by all items of the table.
+	codecZstd
+)
+
+var (
+	// errUnknownCodec is returned if the codec of a table is not supported.
+	errUnknownCodec = errors.New("unknown freezer codec")
+
+	// errMissingDictionary is returned if a table compressed with a dictionary
+	// codec doesn't carry its dictionary.
+	errMissingDictionary = errors.New("missing freezer codec dictionary")
+)
+
+// freezerCodecNames maps the supported codecs to their human readable names.
+var freezerCodecNames = map[freezerCodec]string{
+	codecNone:   "none",
+	codecSnappy: "snappy",
+	codecZstd:   "zstd",
+}
+
+// String implements fmt.Stringer.
+func (c freezerCodec) String() string {
+	if name, ok := freezerCodecNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(c))
+}
+
+// fileTag returns the letter prefixed to the extension of the index and data
+// files of a table compressed with the codec. The uncompressed and snappy tags
+// predate the codec selection and must be kept as they are.
+func (c freezerCodec) fileTag() string {
+	switch c {
+	case codecNone:
+		return "r"
+	case codecZstd:
+		return "z"
+	default:
+		return "c"
+	}
+}
+
+// indexFileName returns the name of the index file of the given table.
+func (c freezerCodec) indexFileName(table string) string {
+	return fmt.Sprintf("%s.%sidx", table, c.fileTag())
+}
+
+// dataFileName returns the name of the numbered data file of the given table.
+func (c freezerCodec) dataFileName(table string, num uint32) string {
+	return fmt.Sprintf("%s.%04d.%sdat", table, num, c.fileTag())
+}
+
+// ParseFreezerCodec resolves the codec with the given name.
+func ParseFreezerCodec(name string) (freezerCodec, error) {
+	for codec, n := range freezerCodecNames {
+		if n == strings.ToLower(name) {
+			return codec, nil
+		}
+	}
+	return codecDefault, fmt.Errorf("%w %q, supported ones: none, snappy, zstd", errUnknownCodec, name)
+}
+
+// itemCompressor compresses and decompresses the items of a freezer table.
+// Implementations must be safe for concurrent use.
+type itemCompressor interface {
+	// compress compresses the item, the capacity of the given destination
+	// buffer is reused if it's large enough.
+	compress(dst, item []byte) ([]byte, error)
+
+	// decompress decompresses the stored item into a newly allocated slice.
+	decompress(data []byte) ([]byte, error)
+}
+
+// newItemCompressor creates the item compressor of the given codec. Nil is
+// returned for tables storing their items uncompressed.
+func newItemCompressor(codec freezerCodec, dictionary []byte) (itemCompressor, error) {
+	switch codec {
+	case codecNone:
+		return nil, nil
+	case codecSnappy:
+		return snappyCompressor{}, nil
+	case codecZstd:
+		if len(dictionary) == 0 {
+			return nil, errMissingDictionary
+		}
+		return newZstdCompressor(dictionary)
+	default:
+		return nil, fmt.Errorf("%w %d", errUnknownCodec, codec)
+	}
+}
+
+// snappyCompressor compresses items in snappy block format.
+type snappyCompressor struct{}
+
+// compress implements itemCompressor.
+func (snappyCompressor) compress(dst, item []byte) ([]byte, error) {
+	// The snappy library does not care what the capacity of the buffer is,
+	// but only checks the length. If the length is too small, it will
+	// allocate a brand new buffer.
+	// To avoid that, we check the required size here, and grow the size of the
+	// buffer to utilize the full capacity.
+	if n := snappy.MaxEncodedLen(len(item)); len(dst) < n {
+		if cap(dst) < n {
+			dst = make([]byte, n)
+		}
+		dst = dst[:n]
+	}
+	return snappy.Encode(dst, item), nil
+}
+
+// decompress implements itemCompressor.
+func (snappyCompressor) decompress(data []byte) ([]byte, error) {
+	return snappy.Decode(nil, data)
+}
@@ -0,0 +1,72 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestItemCompressorRoundtrip(t *testing.T) {
+	items := [][]byte{
+		{},
+		{0x80},
+		{0xc0},
+		bytes.Repeat([]byte{0x01}, 16),
+		getChunk(1024, 7),
+		bytes.Repeat(getChunk(64, 3), 256),
+	}
+	for _, codec := range []freezerCodec{codecSnappy, codecZstd} {
+		if codec == codecZstd {
+			requireZstd(t)
+		}
+		compressor, err := newItemCompressor(codec, getChunk(256, 3))
+		if err != nil {
+			t.Fatalf("Failed to create %v compressor: %v", codec, err)
+		}
+		var buf []byte
+		for i, item := range items {
+			data, err := compressor.compress(buf, item)
+			if err != nil {
+				t.Fatalf("Failed to compress item %d with %v: %v", i, codec, err)
+			}
+			buf = data
+			have, err := compressor.decompress(data)
+			if err != nil {
+				t.Fatalf("Failed to decompress item %d with %v: %v", i, codec, err)
+			}
+			if !bytes.Equal(have, item) {
+				t.Fatalf("Item %d mismatch with %v: have %x, want %x", i, codec, have, item)
+			}
+		}
+	}
+	if _, err := newItemCompressor(codecZstd, nil); err != errMissingDictionary {
+		t.Fatalf("Unexpected error for missing dictionary: %v", err)
+	}
+}
+
+func TestParseFreezerCodec(t *testing.T) {
+	for codec, name := range freezerCodecNames {
+		have, err := ParseFreezerCodec(name)
+		if err != nil || have != codec {
+			t.Fatalf("Failed to parse codec %q: have %v, err %v", name, have, err)
+		}
+	}
+	if _, err := ParseFreezerCodec("lz4"); err == nil {
+		t.Fatal("Parsed unknown codec")
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+//go:build cgo
+
+package rawdb
+
+import "github.com/DataDog/zstd"
+
+// zstdCompressionLevel is the zstd level the freezer items are compressed
+// with. Items are compressed once when frozen and read many times, so the
+// level leans towards the compression ratio, the decompression speed barely
+// depends on it.
+const zstdCompressionLevel = 9
+
+// zstdMinFrameSize is the maximum size of a zstd frame header. The library only
+// trusts the content size recorded in frames of at least this size, it sizes
+// the output buffer for a megabyte for smaller ones. Frames of tiny items are
+// padded up to it with a skippable frame, which the decompressor ignores.
+const zstdMinFrameSize = 18
+
+// zstdSkippableFrame is the header of an empty skippable zstd frame, made up
+// of the magic number and the little endian size of the frame content.
+var zstdSkippableFrame = []byte{0x50, 0x2a, 0x4d, 0x18, 0x00, 0x00, 0x00, 0x00}
+
+// zstdCompressor compresses items with zstd using a dictionary shared by all
+// the items of the table. The digested dictionary is reused across calls.
+type zstdCompressor struct {
+	proc *zstd.BulkProcessor
+}
+
+// newZstdCompressor digests the given dictionary into a zstd compressor.
+func newZstdCompressor(dictionary []byte) (itemCompressor, error) {
+	proc, err := zstd.NewBulkProcessor(dictionary, zstdCompressionLevel)
+	if err != nil {
+		return nil, err
+	}
+	return &zstdCompressor{proc: proc}, nil
+}
+
+// compress implements itemCompressor.
+func (c *zstdCompressor) compress(dst, item []byte) ([]byte, error) {
+	data, err := c.proc.Compress(dst[:cap(dst)], item)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < zstdMinFrameSize {
+		pad := zstdMinFrameSize - len(data) - len(zstdSkippableFrame)
+		if pad < 0 {
+			pad = 0
+		}
+		data = append(data, zstdSkippableFrame...)
+		data[len(data)-4] = byte(pad)
+		data = append(data, make([]byte, pad)...)
+	}
+	return data, nil
+}
+
+// decompress implements itemCompressor.
+func (c *zstdCompressor) decompress(data []byte) ([]byte, error) {
+	return c.proc.Decompress(nil, data)
+}
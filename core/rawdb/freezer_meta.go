@@ -17,6 +17,7 @@
 package rawdb
 
 import (
+	"fmt"
 	"io"
 	"os"
 
@@ -24,7 +25,10 @@ import (
 	"github.com/ethereum/go-ethereum/rlp"
 )
 
-const freezerVersion = 1 // The initial version tag of freezer table metadata
+const (
+	freezerVersion      = 1 // The initial version tag of freezer table metadata
+	freezerCodecVersion = 2 // The version tag of metadata recording the item codec
+)
 
 // freezerTableMeta wraps all the metadata of the freezer table.
 type freezerTableMeta struct {
@@ -36,6 +40,15 @@ type freezerTableMeta struct {
 	// plus the number of items hidden in the table, so it should never
 	// be lower than the "actual tail".
 	VirtualTail uint64
+
+	// Codec is the compression codec of the table items. It's only recorded
+	// if it differs from the codec configured for the table, keeping the
+	// metadata of all other tables readable by older releases.
+	Codec freezerCodec `rlp:"optional"`
+
+	// Dictionary is the compression dictionary shared by all table items,
+	// only used by the zstd codec.
+	Dictionary []byte `rlp:"optional"`
 }
 
 // newMetadata initializes the metadata object with the given virtual tail.
@@ -46,6 +59,17 @@ func newMetadata(tail uint64) *freezerTableMeta {
 	}
 }
 
+// newCodecMetadata initializes the metadata object with the given virtual
+// tail, recording the given codec and dictionary.
+func newCodecMetadata(tail uint64, codec freezerCodec, dictionary []byte) *freezerTableMeta {
+	return &freezerTableMeta{
+		Version:     freezerCodecVersion,
+		VirtualTail: tail,
+		Codec:       codec,
+		Dictionary:  dictionary,
+	}
+}
+
 // readMetadata reads the metadata of the freezer table from the
 // given metadata file.
 func readMetadata(file *os.File) (*freezerTableMeta, error) {
@@ -107,3 +131,27 @@ func loadMetadata(file *os.File, tail uint64) (*freezerTableMeta, error) {
 	}
 	return m, nil
 }
+
+// loadCodec resolves the item codec of the freezer table from the given
+// metadata file, falling back to the configured codec if none is recorded.
+// The returned codec is codecDefault in the latter case.
+func loadCodec(file *os.File) (freezerCodec, []byte, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return codecDefault, nil, err
+	}
+	if stat.Size() == 0 {
+		return codecDefault, nil, nil
+	}
+	m, err := readMetadata(file)
+	if err != nil {
+		return codecDefault, nil, err
+	}
+	if m.Version < freezerCodecVersion {
+		return codecDefault, nil, nil
+	}
+	if _, ok := freezerCodecNames[m.Codec]; !ok && m.Codec != codecDefault {
+		return codecDefault, nil, fmt.Errorf("%w %d", errUnknownCodec, m.Codec)
+	}
+	return m.Codec, m.Dictionary, nil
+}
@@ -17,8 +17,11 @@
 package rawdb
 
 import (
+	"bytes"
 	"os"
 	"testing"
+
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
 func TestReadWriteFreezerTableMeta(t *testing.T) {
@@ -58,3 +61,48 @@ func TestInitializeFreezerTableMeta(t *testing.T) {
 		t.Fatalf("Unexpected virtual tail field")
 	}
 }
+
+func TestFreezerTableMetaCodec(t *testing.T) {
+	f, err := os.CreateTemp(os.TempDir(), "*")
+	if err != nil {
+		t.Fatalf("Failed to create file %v", err)
+	}
+	// The legacy metadata must keep its encoding and resolve to the
+	// configured codec
+	legacy, _ := rlp.EncodeToBytes(struct {
+		Version     uint16
+		VirtualTail uint64
+	}{freezerVersion, 100})
+	blob, _ := rlp.EncodeToBytes(newMetadata(100))
+	if !bytes.Equal(blob, legacy) {
+		t.Fatalf("Legacy metadata encoding mismatch: have %x, want %x", blob, legacy)
+	}
+	if err := writeMetadata(f, newMetadata(100)); err != nil {
+		t.Fatalf("Failed to write metadata %v", err)
+	}
+	codec, dict, err := loadCodec(f)
+	if err != nil {
+		t.Fatalf("Failed to load codec %v", err)
+	}
+	if codec != codecDefault || dict != nil {
+		t.Fatalf("Unexpected codec for legacy metadata: %v", codec)
+	}
+	// The recorded codec must be loaded along with its dictionary
+	if err := writeMetadata(f, newCodecMetadata(200, codecZstd, []byte{0x01, 0x02})); err != nil {
+		t.Fatalf("Failed to write metadata %v", err)
+	}
+	meta, err := loadMetadata(f, 100)
+	if err != nil {
+		t.Fatalf("Failed to read metadata %v", err)
+	}
+	if meta.Version != freezerCodecVersion || meta.VirtualTail != 200 {
+		t.Fatalf("Unexpected metadata %+v", meta)
+	}
+	codec, dict, err = loadCodec(f)
+	if err != nil {
+		t.Fatalf("Failed to load codec %v", err)
+	}
+	if codec != codecZstd || !bytes.Equal(dict, []byte{0x01, 0x02}) {
+		t.Fatalf("Unexpected codec %v, dictionary %x", codec, dict)
+	}
+}
@@ -0,0 +1,439 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/gofrs/flock"
+)
+
+const (
+	// recompressionDir is the folder inside the freezer directory the
+	// recompressed tables are staged in before being swapped into place.
+	recompressionDir = "recompression"
+
+	// zstdDictionarySize is the size limit of the zstd dictionary sampled
+	// from the items of a table.
+	zstdDictionarySize = 112 * 1024
+
+	// zstdDictionarySamples is the number of items sampled evenly across a
+	// table to assemble the zstd dictionary from.
+	zstdDictionarySamples = 1024
+)
+
+// RecompressFreezerTable rewrites the items of a freezer table with the given
+// compression codec. The items are copied into a table staged next to the
+// original one, verified and swapped into place, after which the new codec is
+// recorded in the table metadata. An interrupted recompression is resumed from
+// the last copied item.
+//
+// The freezer must not be in use while its tables are recompressed.
+func RecompressFreezerTable(ancient string, freezerName string, tableName string, codecName string, interrupt <-chan struct{}) error {
+	codec, err := ParseFreezerCodec(codecName)
+	if err != nil {
+		return err
+	}
+	path, config, err := resolveFreezerTable(ancient, freezerName, tableName)
+	if err != nil {
+		return err
+	}
+	lock := flock.New(filepath.Join(path, "FLOCK"))
+	if locked, err := lock.TryLock(); err != nil {
+		return err
+	} else if !locked {
+		return errors.New("locking failed, the freezer is in use")
+	}
+	defer lock.Unlock()
+
+	// Finish swapping the tables if the recompression was interrupted doing
+	// so, the staged metadata is the last file moved into place.
+	staging := filepath.Join(path, recompressionDir)
+	if !common.FileExist(filepath.Join(staging, tableName+".meta")) {
+		staged, err := listTableFiles(staging, tableName)
+		if err != nil {
+			return err
+		}
+		if len(staged) > 0 {
+			log.Info("Resuming recompressed table swap", "table", tableName)
+			current, err := readTableCodec(path, tableName, config.codec)
+			if err != nil {
+				return err
+			}
+			return finishRecompression(path, staging, tableName, current)
+		}
+	}
+	source, err := newFreezerTable(path, tableName, config.codec, false)
+	if err != nil {
+		return err
+	}
+	if source.codec == codec {
+		source.Close()
+		return fmt.Errorf("table %s already uses codec %s", tableName, codec)
+	}
+	staged, err := openStagedTable(source, staging, config.codec, codec)
+	if err != nil {
+		source.Close()
+		return err
+	}
+	done, err := copyTableItems(source, staged, interrupt)
+	if err == nil && done {
+		done, err = verifyTableItems(source, staged, interrupt)
+	}
+	if err := source.Close(); err != nil {
+		log.Warn("Failed to close freezer table", "table", tableName, "err", err)
+	}
+	if err := staged.Close(); err != nil {
+		log.Warn("Failed to close recompressed freezer table", "table", tableName, "err", err)
+	}
+	if err != nil || !done {
+		if !done && err == nil {
+			log.Info("Freezer table recompression interrupted, rerun to resume", "table", tableName)
+		}
+		return err
+	}
+	return swapRecompressedTable(path, staging, tableName, codec)
+}
+
+// openStagedTable opens the staged recompression of the source table, creating
+// it at the tail of the source table if it doesn't exist yet. Staged items not
+// matching the source table are discarded, e.g. if the table was modified after
+// the recompression was interrupted.
+func openStagedTable(source *freezerTable, staging string, def, codec freezerCodec) (*freezerTable, error) {
+	var (
+		name = source.name
+		tail = atomic.LoadUint64(&source.itemHidden)
+	)
+	if common.FileExist(filepath.Join(staging, name+".meta")) {
+		staged, err := newFreezerTable(staging, name, def, false)
+		if err != nil {
+			return nil, err
+		}
+		match, err := matchStagedTable(source, staged, codec)
+		if err != nil {
+			staged.Close()
+			return nil, err
+		}
+		if match {
+			log.Info("Resuming freezer table recompression", "table", name, "codec", codec, "copied", atomic.LoadUint64(&staged.items)-tail)
+			return staged, nil
+		}
+		staged.Close()
+
+		log.Warn("Discarding stale freezer table recompression", "table", name)
+		if err := removeTableFiles(staging, name); err != nil {
+			return nil, err
+		}
+	}
+	// Sample the dictionary if the codec needs one
+	var (
+		dictionary []byte
+		err        error
+	)
+	if codec == codecZstd {
+		if dictionary, err = sampleDictionary(source); err != nil {
+			return nil, err
+		}
+	}
+	// Initialize the staged table with the tail of the source one, dropping
+	// the hidden items. The metadata is written last, its presence marks the
+	// staged table as initialized.
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return nil, err
+	}
+	index := indexEntry{filenum: 0, offset: uint32(tail)}
+	if err := os.WriteFile(filepath.Join(staging, codec.indexFileName(name)), index.append(nil), 0644); err != nil {
+		return nil, err
+	}
+	meta := newMetadata(tail)
+	if codec != def || dictionary != nil {
+		meta = newCodecMetadata(tail, codec, dictionary)
+	}
+	file, err := openFreezerFileForAppend(filepath.Join(staging, name+".meta"))
+	if err != nil {
+		return nil, err
+	}
+	if err := writeMetadata(file, meta); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	log.Info("Recompressing freezer table", "table", name, "from", source.codec, "to", codec, "dictionary", common.StorageSize(len(dictionary)))
+	return newFreezerTable(staging, name, def, false)
+}
+
+// matchStagedTable reports whether the staged table is a prefix of the source
+// table recompressed with the given codec.
+func matchStagedTable(source, staged *freezerTable, codec freezerCodec) (bool, error) {
+	var (
+		tail  = atomic.LoadUint64(&source.itemHidden)
+		items = atomic.LoadUint64(&staged.items)
+	)
+	if staged.codec != codec || atomic.LoadUint64(&staged.itemOffset) != tail || items > atomic.LoadUint64(&source.items) {
+		return false, nil
+	}
+	if items == tail {
+		return true, nil
+	}
+	want, err := source.Retrieve(items - 1)
+	if err != nil {
+		return false, err
+	}
+	have, err := staged.Retrieve(items - 1)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(have, want), nil
+}
+
+// sampleDictionary assembles a raw content zstd dictionary from items sampled
+// evenly across the table. Zstd references the dictionary content as if it
+// preceded every item, so the samples capture the structure recurring across
+// the items, like the encoding of popular addresses and log topics.
+func sampleDictionary(t *freezerTable) ([]byte, error) {
+	var (
+		tail  = atomic.LoadUint64(&t.itemHidden)
+		items = atomic.LoadUint64(&t.items)
+	)
+	if items <= tail {
+		return nil, fmt.Errorf("no items in table %s to sample the dictionary from", t.name)
+	}
+	samples := uint64(zstdDictionarySamples)
+	if items-tail < samples {
+		samples = items - tail
+	}
+	var (
+		step  = (items - tail) / samples
+		limit = zstdDictionarySize / int(samples)
+		dict  = make([]byte, 0, zstdDictionarySize)
+	)
+	for i := uint64(0); i < samples; i++ {
+		item, err := t.Retrieve(tail + i*step)
+		if err != nil {
+			return nil, err
+		}
+		if len(item) > limit {
+			item = item[:limit]
+		}
+		dict = append(dict, item...)
+	}
+	if len(dict) == 0 {
+		return nil, fmt.Errorf("no content in table %s to sample the dictionary from", t.name)
+	}
+	return dict, nil
+}
+
+// copyTableItems appends the items of the source table missing in the staged
+// table, returning whether all the items are copied.
+func copyTableItems(source, staged *freezerTable, interrupt <-chan struct{}) (bool, error) {
+	var (
+		batch  = staged.newBatch()
+		tail   = atomic.LoadUint64(&source.itemHidden)
+		items  = atomic.LoadUint64(&source.items)
+		next   = atomic.LoadUint64(&staged.items)
+		start  = time.Now()
+		logged = time.Now()
+	)
+	for next < items {
+		select {
+		case <-interrupt:
+			return false, batch.commit()
+		default:
+		}
+		data, err := source.RetrieveItems(next, 1024, 4*1024*1024)
+		if err != nil {
+			return false, err
+		}
+		for _, item := range data {
+			if err := batch.AppendRaw(next, item); err != nil {
+				return false, err
+			}
+			next++
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Recompressing freezer table", "table", source.name, "copied", next-tail, "total", items-tail, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if err := batch.commit(); err != nil {
+		return false, err
+	}
+	log.Info("Recompressed freezer table", "table", source.name, "items", items-tail, "elapsed", common.PrettyDuration(time.Since(start)))
+	return true, nil
+}
+
+// verifyTableItems compares all the items of the staged table against the
+// source table, returning whether the verification was completed.
+func verifyTableItems(source, staged *freezerTable, interrupt <-chan struct{}) (bool, error) {
+	var (
+		items = atomic.LoadUint64(&source.items)
+		next  = atomic.LoadUint64(&source.itemHidden)
+		start = time.Now()
+	)
+	if have := atomic.LoadUint64(&staged.items); have != items {
+		return false, fmt.Errorf("recompressed table %s item count mismatch: have %d, want %d", source.name, have, items)
+	}
+	for next < items {
+		select {
+		case <-interrupt:
+			return false, nil
+		default:
+		}
+		want, err := source.RetrieveItems(next, 1024, 4*1024*1024)
+		if err != nil {
+			return false, err
+		}
+		have, err := staged.RetrieveItems(next, uint64(len(want)), 4*1024*1024)
+		if err != nil {
+			return false, err
+		}
+		// The byte limit may cut the batches at different items, as the
+		// stored sizes differ between the codecs.
+		if len(have) < len(want) {
+			want = want[:len(have)]
+		}
+		for i := range want {
+			if !bytes.Equal(have[i], want[i]) {
+				return false, fmt.Errorf("recompressed table %s mismatch at item %d", source.name, next+uint64(i))
+			}
+		}
+		next += uint64(len(want))
+	}
+	log.Info("Verified recompressed freezer table", "table", source.name, "elapsed", common.PrettyDuration(time.Since(start)))
+	return true, nil
+}
+
+// swapRecompressedTable moves the files of the staged table into the freezer
+// directory. The index and data files are linked into place first, they don't
+// collide with the files of the original table as the file names depend on the
+// codec. Moving the metadata, which records the codec, replaces the original
+// table atomically, its files are deleted afterwards.
+func swapRecompressedTable(path, staging, table string, codec freezerCodec) error {
+	staged, err := listTableFiles(staging, table)
+	if err != nil {
+		return err
+	}
+	for name := range staged {
+		dst := filepath.Join(path, name)
+		if err := os.Remove(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := os.Link(filepath.Join(staging, name), dst); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(filepath.Join(staging, table+".meta"), filepath.Join(path, table+".meta")); err != nil {
+		return err
+	}
+	return finishRecompression(path, staging, table, codec)
+}
+
+// finishRecompression deletes the index and data files of the given table not
+// belonging to the given codec, along with the staged table files.
+func finishRecompression(path, staging, table string, codec freezerCodec) error {
+	files, err := listTableFiles(path, table)
+	if err != nil {
+		return err
+	}
+	for name, tag := range files {
+		if tag != codec.fileTag() {
+			if err := os.Remove(filepath.Join(path, name)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := removeTableFiles(staging, table); err != nil {
+		return err
+	}
+	// Delete the staging dir, unless other tables are still staged
+	if entries, err := os.ReadDir(staging); err == nil && len(entries) == 0 {
+		os.Remove(staging)
+	}
+	log.Info("Swapped recompressed freezer table", "table", table, "codec", codec)
+	return nil
+}
+
+// readTableCodec returns the item codec of the given table from its metadata,
+// falling back to the given codec if none is recorded.
+func readTableCodec(path, table string, def freezerCodec) (freezerCodec, error) {
+	file, err := openFreezerFileForReadOnly(filepath.Join(path, table+".meta"))
+	if err != nil {
+		return codecDefault, err
+	}
+	defer file.Close()
+
+	codec, _, err := loadCodec(file)
+	if err != nil {
+		return codecDefault, err
+	}
+	if codec == codecDefault {
+		codec = def
+	}
+	return codec, nil
+}
+
+// listTableFiles returns the index and data files of the given table in the
+// directory, mapped to the codec tag of their extension.
+func listTableFiles(dir, table string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]string)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, table+".") {
+			continue
+		}
+		if ext := filepath.Ext(name); len(ext) == 5 && (strings.HasSuffix(ext, "idx") || strings.HasSuffix(ext, "dat")) {
+			files[name] = ext[1:2]
+		}
+	}
+	return files, nil
+}
+
+// removeTableFiles deletes the metadata, index and data files of the given
+// table in the directory.
+func removeTableFiles(dir, table string) error {
+	files, err := listTableFiles(dir, table)
+	if err != nil {
+		return err
+	}
+	for name := range files {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(filepath.Join(dir, table+".meta")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
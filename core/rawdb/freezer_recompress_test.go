@@ -0,0 +1,352 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"math/big"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// requireZstd skips the test if the zstd codec is not available in the build.
+func requireZstd(tb testing.TB) {
+	if _, err := newItemCompressor(codecZstd, []byte{0x01}); err != nil {
+		tb.Skip(err)
+	}
+}
+
+// makeReceiptsBlob returns the storage encoding of a block worth of receipts
+// carrying logs of a small set of popular contracts and events, resembling the
+// content of the receipts table.
+func makeReceiptsBlob(rng *rand.Rand) []byte {
+	receipts := make([]*types.ReceiptForStorage, rng.Intn(16))
+	for i := range receipts {
+		logs := make([]*types.Log, rng.Intn(4))
+		for j := range logs {
+			var (
+				holder common.Hash
+				amount common.Hash
+			)
+			rng.Read(holder[12:])
+			rng.Read(amount[24:])
+			logs[j] = &types.Log{
+				Address: common.BytesToAddress(crypto.Keccak256([]byte{byte(rng.Intn(32))})),
+				Topics:  []common.Hash{crypto.Keccak256Hash([]byte{byte(rng.Intn(8))}), holder},
+				Data:    amount[:],
+			}
+		}
+		receipts[i] = &types.ReceiptForStorage{
+			Status:            types.ReceiptStatusSuccessful,
+			CumulativeGasUsed: uint64(i+1) * 21000,
+			Logs:              logs,
+		}
+	}
+	blob, _ := rlp.EncodeToBytes(receipts)
+	return blob
+}
+
+// newRecompressTestFreezer creates a chain freezer in a temporary directory
+// holding the given number of items, pruned up to the given tail. The ancient
+// directory is returned along with the content of the receipts table.
+func newRecompressTestFreezer(tb testing.TB, items, tail uint64) (string, [][]byte) {
+	var (
+		ancient  = tb.TempDir()
+		rng      = rand.New(rand.NewSource(1))
+		receipts = make([][]byte, items)
+	)
+	f, err := NewChainFreezer(filepath.Join(ancient, chainFreezerName), "", false)
+	if err != nil {
+		tb.Fatalf("Failed to open freezer: %v", err)
+	}
+	defer f.Close()
+
+	_, err = f.ModifyAncients(func(op ethdb.AncientWriteOp) error {
+		for i := uint64(0); i < items; i++ {
+			receipts[i] = makeReceiptsBlob(rng)
+			if err := op.AppendRaw(ChainFreezerHeaderTable, i, getChunk(64, int(i))); err != nil {
+				return err
+			}
+			if err := op.AppendRaw(ChainFreezerHashTable, i, crypto.Keccak256([]byte{byte(i)})); err != nil {
+				return err
+			}
+			if err := op.AppendRaw(ChainFreezerBodiesTable, i, getChunk(32, int(i))); err != nil {
+				return err
+			}
+			if err := op.AppendRaw(ChainFreezerReceiptTable, i, receipts[i]); err != nil {
+				return err
+			}
+			if err := op.Append(ChainFreezerDifficultyTable, i, big.NewInt(int64(i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		tb.Fatalf("Failed to write freezer: %v", err)
+	}
+	if err := f.TruncateTail(tail); err != nil {
+		tb.Fatalf("Failed to prune freezer: %v", err)
+	}
+	return ancient, receipts
+}
+
+// checkRecompressedTable opens the chain freezer and checks that the receipts
+// table is compressed with the given codec and holds the given items.
+func checkRecompressedTable(t *testing.T, ancient string, codec freezerCodec, receipts [][]byte, tail uint64) {
+	t.Helper()
+
+	path := filepath.Join(ancient, chainFreezerName)
+	f, err := NewChainFreezer(path, "", false)
+	if err != nil {
+		t.Fatalf("Failed to open freezer: %v", err)
+	}
+	defer f.Close()
+
+	if have := f.tables[ChainFreezerReceiptTable].codec; have != codec {
+		t.Fatalf("Codec mismatch: have %v, want %v", have, codec)
+	}
+	if have, _ := f.Ancients(); have != uint64(len(receipts)) {
+		t.Fatalf("Item count mismatch: have %d, want %d", have, len(receipts))
+	}
+	if have, _ := f.Tail(); have != tail {
+		t.Fatalf("Tail mismatch: have %d, want %d", have, tail)
+	}
+	for i := tail; i < uint64(len(receipts)); i++ {
+		blob, err := f.Ancient(ChainFreezerReceiptTable, i)
+		if err != nil {
+			t.Fatalf("Failed to read item %d: %v", i, err)
+		}
+		if !bytes.Equal(blob, receipts[i]) {
+			t.Fatalf("Item %d mismatch: have %x, want %x", i, blob, receipts[i])
+		}
+	}
+	// Ensure no files of other codecs or staged tables are left behind
+	files, err := listTableFiles(path, ChainFreezerReceiptTable)
+	if err != nil {
+		t.Fatalf("Failed to list table files: %v", err)
+	}
+	for name, tag := range files {
+		if tag != codec.fileTag() {
+			t.Errorf("Leftover table file %s", name)
+		}
+	}
+	if common.FileExist(filepath.Join(path, recompressionDir)) {
+		t.Errorf("Leftover staging directory")
+	}
+}
+
+func TestRecompressFreezerTable(t *testing.T) {
+	requireZstd(t)
+
+	ancient, receipts := newRecompressTestFreezer(t, 3000, 100)
+	if err := RecompressFreezerTable(ancient, chainFreezerName, ChainFreezerReceiptTable, "zstd", nil); err != nil {
+		t.Fatalf("Failed to recompress table: %v", err)
+	}
+	checkRecompressedTable(t, ancient, codecZstd, receipts, 100)
+
+	if err := RecompressFreezerTable(ancient, chainFreezerName, ChainFreezerReceiptTable, "zstd", nil); err == nil {
+		t.Fatal("Recompressed table with its own codec")
+	}
+	// Append items to the recompressed table and prune it, the codec must
+	// be retained.
+	f, err := NewChainFreezer(filepath.Join(ancient, chainFreezerName), "", false)
+	if err != nil {
+		t.Fatalf("Failed to open freezer: %v", err)
+	}
+	rng := rand.New(rand.NewSource(2))
+	_, err = f.ModifyAncients(func(op ethdb.AncientWriteOp) error {
+		for i := uint64(len(receipts)); i < 3500; i++ {
+			receipts = append(receipts, makeReceiptsBlob(rng))
+			op.AppendRaw(ChainFreezerHeaderTable, i, getChunk(64, int(i)))
+			op.AppendRaw(ChainFreezerHashTable, i, crypto.Keccak256([]byte{byte(i)}))
+			op.AppendRaw(ChainFreezerBodiesTable, i, getChunk(32, int(i)))
+			op.AppendRaw(ChainFreezerReceiptTable, i, receipts[i])
+			op.Append(ChainFreezerDifficultyTable, i, big.NewInt(int64(i)))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to append items: %v", err)
+	}
+	if err := f.TruncateTail(200); err != nil {
+		t.Fatalf("Failed to prune freezer: %v", err)
+	}
+	f.Close()
+	checkRecompressedTable(t, ancient, codecZstd, receipts, 200)
+
+	// Recompress the table back to the configured codec, which shouldn't be
+	// recorded in the metadata anymore.
+	if err := RecompressFreezerTable(ancient, chainFreezerName, ChainFreezerReceiptTable, "snappy", nil); err != nil {
+		t.Fatalf("Failed to recompress table: %v", err)
+	}
+	checkRecompressedTable(t, ancient, codecSnappy, receipts, 200)
+
+	file, err := os.Open(filepath.Join(ancient, chainFreezerName, ChainFreezerReceiptTable+".meta"))
+	if err != nil {
+		t.Fatalf("Failed to open metadata: %v", err)
+	}
+	defer file.Close()
+	meta, err := readMetadata(file)
+	if err != nil {
+		t.Fatalf("Failed to read metadata: %v", err)
+	}
+	if meta.Version != freezerVersion || meta.Codec != codecDefault || meta.VirtualTail != 200 {
+		t.Fatalf("Unexpected metadata: %+v", meta)
+	}
+}
+
+func TestRecompressFreezerTableResume(t *testing.T) {
+	requireZstd(t)
+
+	// stage copies the given number of items into the staged table.
+	stage := func(ancient string, items uint64) {
+		path := filepath.Join(ancient, chainFreezerName)
+		source, err := newFreezerTable(path, ChainFreezerReceiptTable, codecSnappy, false)
+		if err != nil {
+			t.Fatalf("Failed to open table: %v", err)
+		}
+		defer source.Close()
+		staged, err := openStagedTable(source, filepath.Join(path, recompressionDir), codecSnappy, codecZstd)
+		if err != nil {
+			t.Fatalf("Failed to open staged table: %v", err)
+		}
+		defer staged.Close()
+
+		batch := staged.newBatch()
+		for i := staged.items; i < items; i++ {
+			blob, _ := source.Retrieve(i)
+			if err := batch.AppendRaw(i, blob); err != nil {
+				t.Fatalf("Failed to stage item %d: %v", i, err)
+			}
+		}
+		if err := batch.commit(); err != nil {
+			t.Fatalf("Failed to commit staged items: %v", err)
+		}
+	}
+	// Resume the recompression from the staged items
+	ancient, receipts := newRecompressTestFreezer(t, 3000, 100)
+	stage(ancient, 1000)
+	if err := RecompressFreezerTable(ancient, chainFreezerName, ChainFreezerReceiptTable, "zstd", nil); err != nil {
+		t.Fatalf("Failed to recompress table: %v", err)
+	}
+	checkRecompressedTable(t, ancient, codecZstd, receipts, 100)
+
+	// Interrupt the recompression and resume it afterwards
+	ancient, receipts = newRecompressTestFreezer(t, 3000, 100)
+	interrupt := make(chan struct{})
+	close(interrupt)
+	if err := RecompressFreezerTable(ancient, chainFreezerName, ChainFreezerReceiptTable, "zstd", interrupt); err != nil {
+		t.Fatalf("Failed to interrupt recompression: %v", err)
+	}
+	if !common.FileExist(filepath.Join(ancient, chainFreezerName, recompressionDir, ChainFreezerReceiptTable+".meta")) {
+		t.Fatal("Missing staged table of interrupted recompression")
+	}
+	if err := RecompressFreezerTable(ancient, chainFreezerName, ChainFreezerReceiptTable, "zstd", nil); err != nil {
+		t.Fatalf("Failed to recompress table: %v", err)
+	}
+	checkRecompressedTable(t, ancient, codecZstd, receipts, 100)
+
+	// Discard the staged items if the table was truncated below them
+	ancient, receipts = newRecompressTestFreezer(t, 3000, 100)
+	stage(ancient, 2000)
+
+	f, err := NewChainFreezer(filepath.Join(ancient, chainFreezerName), "", false)
+	if err != nil {
+		t.Fatalf("Failed to open freezer: %v", err)
+	}
+	if err := f.TruncateHead(1500); err != nil {
+		t.Fatalf("Failed to truncate freezer: %v", err)
+	}
+	f.Close()
+	receipts = receipts[:1500]
+
+	if err := RecompressFreezerTable(ancient, chainFreezerName, ChainFreezerReceiptTable, "zstd", nil); err != nil {
+		t.Fatalf("Failed to recompress table: %v", err)
+	}
+	checkRecompressedTable(t, ancient, codecZstd, receipts, 100)
+
+	// Finish the swap of the tables if it was interrupted after recording
+	// the new codec.
+	ancient, receipts = newRecompressTestFreezer(t, 3000, 100)
+	stage(ancient, 3000)
+
+	var (
+		path    = filepath.Join(ancient, chainFreezerName)
+		staging = filepath.Join(path, recompressionDir)
+	)
+	staged, err := listTableFiles(staging, ChainFreezerReceiptTable)
+	if err != nil {
+		t.Fatalf("Failed to list staged files: %v", err)
+	}
+	for name := range staged {
+		if err := os.Link(filepath.Join(staging, name), filepath.Join(path, name)); err != nil {
+			t.Fatalf("Failed to link staged file: %v", err)
+		}
+	}
+	if err := os.Rename(filepath.Join(staging, ChainFreezerReceiptTable+".meta"), filepath.Join(path, ChainFreezerReceiptTable+".meta")); err != nil {
+		t.Fatalf("Failed to move staged metadata: %v", err)
+	}
+	if err := RecompressFreezerTable(ancient, chainFreezerName, ChainFreezerReceiptTable, "zstd", nil); err != nil {
+		t.Fatalf("Failed to finish recompression: %v", err)
+	}
+	checkRecompressedTable(t, ancient, codecZstd, receipts, 100)
+}
+
+// BenchmarkFreezerTableRead measures the latency of random reads from the
+// receipts table compressed with the different codecs. The stored size per
+// item is reported along.
+func BenchmarkFreezerTableRead(b *testing.B) {
+	for _, codec := range []freezerCodec{codecNone, codecSnappy, codecZstd} {
+		b.Run(codec.String(), func(b *testing.B) {
+			if codec == codecZstd {
+				requireZstd(b)
+			}
+			ancient, receipts := newRecompressTestFreezer(b, 20000, 0)
+			if codec != codecSnappy {
+				if err := RecompressFreezerTable(ancient, chainFreezerName, ChainFreezerReceiptTable, codec.String(), nil); err != nil {
+					b.Fatalf("Failed to recompress table: %v", err)
+				}
+			}
+			table, err := newFreezerTable(filepath.Join(ancient, chainFreezerName), ChainFreezerReceiptTable, codecSnappy, true)
+			if err != nil {
+				b.Fatalf("Failed to open table: %v", err)
+			}
+			defer table.Close()
+
+			rng := rand.New(rand.NewSource(1))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := table.Retrieve(uint64(rng.Intn(len(receipts)))); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+
+			size, _ := table.size()
+			b.ReportMetric(float64(size)/float64(len(receipts)), "bytes/item")
+		})
+	}
+}
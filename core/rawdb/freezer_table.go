@@ -30,7 +30,6 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
-	"github.com/golang/snappy"
 )
 
 var (
@@ -85,7 +84,7 @@ func (i *indexEntry) bounds(end *indexEntry) (startOffset, endOffset, fileId uin
 }
 
 // freezerTable represents a single chained data table within the freezer (e.g. blocks).
-// It consists of a data file (snappy encoded arbitrary data blobs) and an indexEntry
+// It consists of a data file (compressed arbitrary data blobs) and an indexEntry
 // file (uncompressed 64 bit indices into the data file).
 type freezerTable struct {
 	// WARNING: The `items` field is accessed atomically. On 32 bit platforms, only
@@ -101,11 +100,14 @@ type freezerTable struct {
 	// should never be lower than itemOffset.
 	itemHidden uint64
 
-	noCompression bool // if true, disables snappy compression. Note: does not work retroactively
-	readonly      bool
-	maxFileSize   uint32 // Max file size for data-files
-	name          string
-	path          string
+	codec       freezerCodec   // Compression codec of the items. Note: changing it requires recompression
+	dictionary  []byte         // Compression dictionary shared by all items, if any
+	recorded    bool           // Whether the codec is recorded in the metadata
+	compressor  itemCompressor // Compressor of the codec, nil if items are stored raw
+	readonly    bool
+	maxFileSize uint32 // Max file size for data-files
+	name        string
+	path        string
 
 	head   *os.File            // File descriptor for the data head of the table
 	index  *os.File            // File descriptor for the indexEntry file of the table
@@ -124,24 +126,21 @@ type freezerTable struct {
 }
 
 // newFreezerTable opens the given path as a freezer table.
-func newFreezerTable(path, name string, disableSnappy, readonly bool) (*freezerTable, error) {
-	return newTable(path, name, metrics.NilMeter{}, metrics.NilMeter{}, metrics.NilGauge{}, freezerTableSize, disableSnappy, readonly)
+func newFreezerTable(path, name string, codec freezerCodec, readonly bool) (*freezerTable, error) {
+	return newTable(path, name, metrics.NilMeter{}, metrics.NilMeter{}, metrics.NilGauge{}, freezerTableSize, codec, readonly)
 }
 
 // newTable opens a freezer table, creating the data and index files if they are
 // non-existent. Both files are truncated to the shortest common length to ensure
 // they don't go out of sync.
-func newTable(path string, name string, readMeter metrics.Meter, writeMeter metrics.Meter, sizeGauge metrics.Gauge, maxFilesize uint32, noCompression, readonly bool) (*freezerTable, error) {
-	// Ensure the containing directory exists and open the indexEntry file
+//
+// The given codec is used to compress the items unless the table metadata
+// records a different one.
+func newTable(path string, name string, readMeter metrics.Meter, writeMeter metrics.Meter, sizeGauge metrics.Gauge, maxFilesize uint32, codec freezerCodec, readonly bool) (*freezerTable, error) {
+	// Ensure the containing directory exists and open the metadata file
 	if err := os.MkdirAll(path, 0755); err != nil {
 		return nil, err
 	}
-	var idxName string
-	if noCompression {
-		idxName = fmt.Sprintf("%s.ridx", name) // raw index file
-	} else {
-		idxName = fmt.Sprintf("%s.cidx", name) // compressed index file
-	}
 	var (
 		err   error
 		index *os.File
@@ -149,38 +148,53 @@ func newTable(path string, name string, readMeter metrics.Meter, writeMeter metr
 	)
 	if readonly {
 		// Will fail if table index file or meta file is not existent
-		index, err = openFreezerFileForReadOnly(filepath.Join(path, idxName))
-		if err != nil {
-			return nil, err
-		}
 		meta, err = openFreezerFileForReadOnly(filepath.Join(path, fmt.Sprintf("%s.meta", name)))
-		if err != nil {
-			return nil, err
-		}
 	} else {
-		index, err = openFreezerFileForAppend(filepath.Join(path, idxName))
-		if err != nil {
-			return nil, err
-		}
 		meta, err = openFreezerFileForAppend(filepath.Join(path, fmt.Sprintf("%s.meta", name)))
-		if err != nil {
-			return nil, err
-		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Resolve the item codec, it determines the name of the index and data files
+	recordedCodec, dictionary, err := loadCodec(meta)
+	if err != nil {
+		meta.Close()
+		return nil, err
+	}
+	if recordedCodec != codecDefault {
+		codec = recordedCodec
+	}
+	compressor, err := newItemCompressor(codec, dictionary)
+	if err != nil {
+		meta.Close()
+		return nil, err
+	}
+	if readonly {
+		index, err = openFreezerFileForReadOnly(filepath.Join(path, codec.indexFileName(name)))
+	} else {
+		index, err = openFreezerFileForAppend(filepath.Join(path, codec.indexFileName(name)))
+	}
+	if err != nil {
+		meta.Close()
+		return nil, err
 	}
 	// Create the table and repair any past inconsistency
 	tab := &freezerTable{
-		index:         index,
-		meta:          meta,
-		files:         make(map[uint32]*os.File),
-		readMeter:     readMeter,
-		writeMeter:    writeMeter,
-		sizeGauge:     sizeGauge,
-		name:          name,
-		path:          path,
-		logger:        log.New("database", path, "table", name),
-		noCompression: noCompression,
-		readonly:      readonly,
-		maxFileSize:   maxFilesize,
+		index:       index,
+		meta:        meta,
+		files:       make(map[uint32]*os.File),
+		readMeter:   readMeter,
+		writeMeter:  writeMeter,
+		sizeGauge:   sizeGauge,
+		name:        name,
+		path:        path,
+		logger:      log.New("database", path, "table", name),
+		codec:       codec,
+		dictionary:  dictionary,
+		recorded:    recordedCodec != codecDefault,
+		compressor:  compressor,
+		readonly:    readonly,
+		maxFileSize: maxFilesize,
 	}
 	if err := tab.repair(); err != nil {
 		tab.Close()
@@ -449,6 +463,15 @@ func (t *freezerTable) truncateHead(items uint64) error {
 	return nil
 }
 
+// newMetadata returns the table metadata with the given virtual tail. The
+// codec is kept in the metadata if it was recorded before.
+func (t *freezerTable) newMetadata(tail uint64) *freezerTableMeta {
+	if !t.recorded {
+		return newMetadata(tail)
+	}
+	return newCodecMetadata(tail, t.codec, t.dictionary)
+}
+
 // truncateTail discards any recent data before the provided threshold number.
 func (t *freezerTable) truncateTail(items uint64) error {
 	t.lock.Lock()
@@ -479,7 +502,7 @@ func (t *freezerTable) truncateTail(items uint64) error {
 	}
 	// Update the virtual tail marker and hidden these entries in table.
 	atomic.StoreUint64(&t.itemHidden, items)
-	if err := writeMetadata(t.meta, newMetadata(items)); err != nil {
+	if err := writeMetadata(t.meta, t.newMetadata(items)); err != nil {
 		return err
 	}
 	// Hidden items still fall in the current tail file, no data file
@@ -596,13 +619,7 @@ func (t *freezerTable) Close() error {
 func (t *freezerTable) openFile(num uint32, opener func(string) (*os.File, error)) (f *os.File, err error) {
 	var exist bool
 	if f, exist = t.files[num]; !exist {
-		var name string
-		if t.noCompression {
-			name = fmt.Sprintf("%s.%04d.rdat", t.name, num)
-		} else {
-			name = fmt.Sprintf("%s.%04d.cdat", t.name, num)
-		}
-		f, err = opener(filepath.Join(t.path, name))
+		f, err = opener(filepath.Join(t.path, t.codec.dataFileName(t.name, num)))
 		if err != nil {
 			return nil, err
 		}
@@ -711,23 +728,18 @@ func (t *freezerTable) RetrieveItems(start, count, maxBytes uint64) ([][]byte, e
 	for i, diskSize := range sizes {
 		item := diskData[offset : offset+diskSize]
 		offset += diskSize
-		decompressedSize := diskSize
-		if !t.noCompression {
-			decompressedSize, _ = snappy.DecodedLen(item)
-		}
-		if i > 0 && uint64(outputSize+decompressedSize) > maxBytes {
-			break
-		}
-		if !t.noCompression {
-			data, err := snappy.Decode(nil, item)
+		if t.compressor != nil {
+			data, err := t.compressor.decompress(item)
 			if err != nil {
 				return nil, err
 			}
-			output = append(output, data)
-		} else {
-			output = append(output, item)
+			item = data
+		}
+		if i > 0 && uint64(outputSize+len(item)) > maxBytes {
+			break
 		}
-		outputSize += decompressedSize
+		output = append(output, item)
+		outputSize += len(item)
 	}
 	return output, nil
 }
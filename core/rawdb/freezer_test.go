@@ -32,7 +32,7 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-var freezerTestTableDef = map[string]bool{"test": true}
+var freezerTestTableDef = map[string]freezerTableConfig{"test": {codec: codecNone, prunable: true}}
 
 func TestFreezerModify(t *testing.T) {
 	t.Parallel()
@@ -48,7 +48,7 @@ func TestFreezerModify(t *testing.T) {
 		valuesRLP = append(valuesRLP, iv)
 	}
 
-	tables := map[string]bool{"raw": true, "rlp": false}
+	tables := map[string]freezerTableConfig{"raw": {codec: codecNone, prunable: true}, "rlp": {codec: codecSnappy, prunable: true}}
 	f, _ := newFreezerForTesting(t, tables)
 	defer f.Close()
 
@@ -112,7 +112,7 @@ func TestFreezerModifyRollback(t *testing.T) {
 	f.Close()
 
 	// Reopen and check that the rolled-back data doesn't reappear.
-	tables := map[string]bool{"test": true}
+	tables := map[string]freezerTableConfig{"test": {codec: codecNone, prunable: true}}
 	f2, err := NewFreezer(dir, "", false, 2049, tables)
 	if err != nil {
 		t.Fatalf("can't reopen freezer after failed ModifyAncients: %v", err)
@@ -250,7 +250,7 @@ func TestFreezerConcurrentModifyTruncate(t *testing.T) {
 }
 
 func TestFreezerReadonlyValidate(t *testing.T) {
-	tables := map[string]bool{"a": true, "b": true}
+	tables := map[string]freezerTableConfig{"a": {codec: codecNone, prunable: true}, "b": {codec: codecNone, prunable: true}}
 	dir := t.TempDir()
 	// Open non-readonly freezer and fill individual tables
 	// with different amount of data.
@@ -283,7 +283,59 @@ func TestFreezerReadonlyValidate(t *testing.T) {
 	}
 }
 
-func newFreezerForTesting(t *testing.T, tables map[string]bool) (*Freezer, string) {
+// Tests that only the prunable tables are truncated by the tail truncation,
+// also after reopening the freezer.
+func TestFreezerTruncateTailPrunable(t *testing.T) {
+	t.Parallel()
+
+	tables := map[string]freezerTableConfig{"kept": {codec: codecNone}, "pruned": {codec: codecNone, prunable: true}}
+	f, dir := newFreezerForTesting(t, tables)
+
+	_, err := f.ModifyAncients(func(op ethdb.AncientWriteOp) error {
+		for i := uint64(0); i < 10; i++ {
+			require.NoError(t, op.AppendRaw("kept", i, make([]byte, 1024)))
+			require.NoError(t, op.AppendRaw("pruned", i, make([]byte, 1024)))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, f.TruncateTail(6))
+
+	check := func(f *Freezer) {
+		t.Helper()
+
+		tail, _ := f.Tail()
+		require.Equal(t, uint64(6), tail)
+		for i := uint64(0); i < 10; i++ {
+			_, err := f.Ancient("kept", i)
+			require.NoError(t, err, "kept item %d", i)
+
+			_, err = f.Ancient("pruned", i)
+			if i < 6 {
+				require.Error(t, err, "pruned item %d", i)
+			} else {
+				require.NoError(t, err, "pruned item %d", i)
+			}
+		}
+	}
+	check(f)
+	require.NoError(t, f.Close())
+
+	// Reopen the freezer, in both modes
+	for _, readonly := range []bool{false, true} {
+		f, err = NewFreezer(dir, "", readonly, 2049, tables)
+		require.NoError(t, err)
+		check(f)
+		require.NoError(t, f.Close())
+	}
+	// Truncating the head below the tail is still rejected
+	f, err = NewFreezer(dir, "", false, 2049, tables)
+	require.NoError(t, err)
+	defer f.Close()
+	require.Error(t, f.TruncateHead(5))
+}
+
+func newFreezerForTesting(t *testing.T, tables map[string]freezerTableConfig) (*Freezer, string) {
 	t.Helper()
 
 	dir := t.TempDir()
@@ -410,7 +462,7 @@ func TestRenameWindows(t *testing.T) {
 
 func TestFreezerCloseSync(t *testing.T) {
 	t.Parallel()
-	f, _ := newFreezerForTesting(t, map[string]bool{"a": true, "b": true})
+	f, _ := newFreezerForTesting(t, map[string]freezerTableConfig{"a": {codec: codecNone, prunable: true}, "b": {codec: codecNone, prunable: true}})
 	defer f.Close()
 
 	// Now, close and sync. This mimics the behaviour if the node is shut down,
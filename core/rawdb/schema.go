@@ -72,6 +72,9 @@ var (
 	// txIndexTailKey tracks the oldest block whose transactions have been indexed.
 	txIndexTailKey = []byte("TransactionIndexTail")
 
+	// traceIndexTailKey tracks the oldest block whose call traces have been indexed.
+	traceIndexTailKey = []byte("TraceIndexTail")
+
 	// fastTxLookupLimitKey tracks the transaction lookup limit during fast sync.
 	fastTxLookupLimitKey = []byte("FastTransactionLookupLimit")
 
@@ -84,6 +87,18 @@ var (
 	// transitionStatusKey tracks the eth2 transition status.
 	transitionStatusKey = []byte("eth2-transition")
 
+	// persistentStateIDKey tracks the id of latest stored state(for path-based only).
+	persistentStateIDKey = []byte("LastStateID")
+
+	// stateHistoryTailKey tracks the id of the oldest stored state history(for path-based only).
+	stateHistoryTailKey = []byte("StateHistoryTail")
+
+	// trieJournalKey tracks the in-memory trie node layers across restarts(for path-based only).
+	trieJournalKey = []byte("TrieJournal")
+
+	// onlinePruningKey tracks the progress of the online state pruning.
+	onlinePruningKey = []byte("OnlinePruning")
+
 	// Data item prefixes (use single byte to avoid mixing data types, avoid `i`, used for indexes).
 	headerPrefix       = []byte("h") // headerPrefix + num (uint64 big endian) + hash -> header
 	headerTDSuffix     = []byte("t") // headerPrefix + num (uint64 big endian) + hash + headerTDSuffix -> td
@@ -100,9 +115,16 @@ var (
 	CodePrefix            = []byte("c") // CodePrefix + code hash -> account code
 	skeletonHeaderPrefix  = []byte("S") // skeletonHeaderPrefix + num (uint64 big endian) -> header
 
+	blockTracesPrefix  = []byte("x") // blockTracesPrefix + num (uint64 big endian) + hash -> block call traces
+	traceAddressPrefix = []byte("X") // traceAddressPrefix + address + num (uint64 big endian) + hash -> nil
+
 	// Path-based trie node scheme.
 	trieNodeAccountPrefix = []byte("A") // trieNodeAccountPrefix + hexPath -> trie node
 	trieNodeStoragePrefix = []byte("O") // trieNodeStoragePrefix + accountHash + hexPath -> trie node
+	stateIDPrefix         = []byte("L") // stateIDPrefix + state root -> state id
+	stateHistoryPrefix    = []byte("R") // stateHistoryPrefix + state id (uint64 big endian) -> reverse state diff
+
+	onlinePruningNodePrefix = []byte("pruning-live-") // onlinePruningNodePrefix + hash -> empty, trie node flushed while pruning online
 
 	PreimagePrefix = []byte("secure-key-")       // PreimagePrefix + hash -> preimage
 	configPrefix   = []byte("ethereum-config-")  // config prefix for the db
@@ -111,6 +133,9 @@ var (
 	// BloomBitsIndexPrefix is the data table of a chain indexer to track its progress
 	BloomBitsIndexPrefix = []byte("iB")
 
+	// TraceIndexPrefix is the data table of the trace indexer to track its progress
+	TraceIndexPrefix = []byte("iT")
+
 	ChtPrefix           = []byte("chtRootV2-") // ChtPrefix + chtNum (uint64 big endian) -> trie root hash
 	ChtTablePrefix      = []byte("cht-")
 	ChtIndexTablePrefix = []byte("chtIndexV2-")
@@ -205,6 +230,16 @@ func bloomBitsKey(bit uint, section uint64, hash common.Hash) []byte {
 	return key
 }
 
+// blockTracesKey = blockTracesPrefix + num (uint64 big endian) + hash
+func blockTracesKey(number uint64, hash common.Hash) []byte {
+	return append(append(blockTracesPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
+}
+
+// traceAddressKey = traceAddressPrefix + address + num (uint64 big endian) + hash
+func traceAddressKey(address common.Address, number uint64, hash common.Hash) []byte {
+	return append(append(append(traceAddressPrefix, address.Bytes()...), encodeBlockNumber(number)...), hash.Bytes()...)
+}
+
 // skeletonHeaderKey = skeletonHeaderPrefix + num (uint64 big endian)
 func skeletonHeaderKey(number uint64) []byte {
 	return append(skeletonHeaderPrefix, encodeBlockNumber(number)...)
@@ -252,3 +287,18 @@ func accountTrieNodeKey(path []byte) []byte {
 func storageTrieNodeKey(accountHash common.Hash, path []byte) []byte {
 	return append(append(trieNodeStoragePrefix, accountHash.Bytes()...), path...)
 }
+
+// stateIDKey = stateIDPrefix + root (32 bytes)
+func stateIDKey(root common.Hash) []byte {
+	return append(stateIDPrefix, root.Bytes()...)
+}
+
+// stateHistoryKey = stateHistoryPrefix + id (uint64 big endian)
+func stateHistoryKey(id uint64) []byte {
+	return append(stateHistoryPrefix, encodeBlockNumber(id)...)
+}
+
+// onlinePruningNodeKey = onlinePruningNodePrefix + hash
+func onlinePruningNodeKey(hash common.Hash) []byte {
+	return append(onlinePruningNodePrefix, hash.Bytes()...)
+}
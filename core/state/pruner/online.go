@@ -0,0 +1,640 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package pruner
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+const (
+	// onlineBloomFilePrefix is the filename prefix of the state bloom filter of
+	// the online pruning, distinct from the offline one not to be recovered
+	// by RecoverPruning.
+	onlineBloomFilePrefix = "onlinebloom"
+
+	// sweepScanKeys is the maximum number of keys scanned before the progress
+	// is persisted, even if no stale trie node is found.
+	sweepScanKeys = 1000000
+)
+
+// sweepBatchKeys is the maximum number of stale trie nodes deleted in a single
+// batch, holding the chain mutex.
+var sweepBatchKeys = 16384
+
+var (
+	onlinePhaseGauge       = metrics.NewRegisteredGauge("state/pruner/online/phase", nil)
+	onlineProgressGauge    = metrics.NewRegisteredGauge("state/pruner/online/progress", nil)
+	onlineDeletedMeter     = metrics.NewRegisteredMeter("state/pruner/online/deleted", nil)
+	onlineDeletedSizeMeter = metrics.NewRegisteredMeter("state/pruner/online/deleted/size", nil)
+	onlineLockTimer        = metrics.NewRegisteredTimer("state/pruner/online/lock", nil)
+)
+
+var (
+	// errPruningRunning is returned if the online pruning is started while it's
+	// already running.
+	errPruningRunning = errors.New("state pruning already running")
+
+	// errPruningStopped is returned if the online pruning is interrupted by the
+	// shutdown.
+	errPruningStopped = errors.New("state pruning stopped")
+)
+
+// The phases of the online pruning, reported over RPC.
+const (
+	PhaseIdle    = "idle"
+	PhaseBloom   = "bloom"
+	PhaseSweep   = "sweep"
+	PhaseCompact = "compact"
+)
+
+// phaseMetric maps the phases to the values of the phase gauge.
+var phaseMetric = map[string]int64{PhaseIdle: 0, PhaseBloom: 1, PhaseSweep: 2, PhaseCompact: 3}
+
+// OnlineConfig includes the configurations of the online pruning.
+type OnlineConfig struct {
+	Datadir   string        // The directory to store the state bloom filter in
+	BloomSize uint64        // The Megabytes of memory allocated to bloom-filter
+	Throttle  time.Duration // Pause between two sweep batches, easing the disk load
+}
+
+// Chain is the blockchain the online pruner runs along with.
+type Chain interface {
+	// CurrentBlock retrieves the head of the chain.
+	CurrentBlock() *types.Header
+
+	// Snapshots returns the state snapshot tree, nil if disabled.
+	Snapshots() *snapshot.Tree
+
+	// TrieDB returns the trie database the states are flushed with.
+	TrieDB() *trie.Database
+
+	// WithChainLock runs the function with no block imported meanwhile.
+	WithChainLock(fn func() error) error
+}
+
+// OnlineProgress is the status of the online pruning.
+type OnlineProgress struct {
+	Phase    string         `json:"phase"`
+	Root     common.Hash    `json:"root"`
+	Number   hexutil.Uint64 `json:"number"`
+	Progress float64        `json:"progress"` // Swept portion of the key space
+	Deleted  hexutil.Uint64 `json:"deleted"`  // Number of stale trie nodes deleted
+	Size     hexutil.Uint64 `json:"size"`     // Size of the stale trie nodes deleted
+	Started  time.Time      `json:"started,omitempty"`
+	Error    string         `json:"error,omitempty"` // Failure of the last run
+}
+
+// sweepProgress is the persisted progress of the sweep, which is resumed from
+// at the next start if interrupted.
+type sweepProgress struct {
+	Root    common.Hash // Root of the state the bloom filter was built from
+	Number  uint64      // Number of the block of the state
+	Marker  []byte      // Last key swept
+	Deleted uint64
+	Size    uint64
+}
+
+// OnlinePruner deletes the stale state while the node keeps running. Like the
+// offline Pruner, it keeps the state of the bottom-most diff layer (HEAD-127),
+// but builds the state bloom in the background from its snapshot, which is
+// held from being flattened meanwhile. The trie nodes of the diff layers above
+// it, and those flushed to disk after the pruning is started, are marked in
+// the database along with the nodes themselves, so that they are kept as well.
+// The stale nodes are swept in throttled batches, each one holding the chain
+// mutex only briefly for the deletion.
+//
+// The sweep progress is persisted with each batch, and the sweep is resumed at
+// the next start if interrupted. Contract codes are left untouched, as they
+// are written outside the trie database.
+//
+// Only the hash-based scheme is supported, the path-based one doesn't need
+// pruning.
+type OnlinePruner struct {
+	config OnlineConfig
+	db     ethdb.Database
+	chain  Chain
+
+	status  OnlineProgress
+	running bool
+	lock    sync.Mutex
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewOnlinePruner creates the online pruner of the given chain.
+func NewOnlinePruner(db ethdb.Database, chain Chain, config OnlineConfig) *OnlinePruner {
+	if config.BloomSize < 256 {
+		config.BloomSize = 2048
+	}
+	return &OnlinePruner{
+		config: config,
+		db:     db,
+		chain:  chain,
+		status: OnlineProgress{Phase: PhaseIdle},
+		quit:   make(chan struct{}),
+	}
+}
+
+// Progress returns the status of the online pruning.
+func (p *OnlinePruner) Progress() *OnlineProgress {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	status := p.status
+	return &status
+}
+
+// Resume continues the sweep interrupted at the last shutdown, if any. It has
+// to be called before any block is imported, as the trie nodes flushed to disk
+// meanwhile would be deleted otherwise.
+func (p *OnlinePruner) Resume() error {
+	blob := rawdb.ReadOnlinePruning(p.db)
+	if len(blob) == 0 {
+		// No sweep pending, drop the leftovers of an interrupted bloom build
+		return p.cleanup()
+	}
+	var progress sweepProgress
+	if err := rlp.DecodeBytes(blob, &progress); err != nil {
+		return err
+	}
+	bloom, err := NewStateBloomFromDisk(onlineBloomName(p.config.Datadir, progress.Root))
+	if err != nil {
+		// The sweep can't be resumed, but it can be dropped safely as only the
+		// stale nodes were deleted so far.
+		log.Error("Failed to load online pruning bloom, dropping pruning", "root", progress.Root, "err", err)
+		rawdb.DeleteOnlinePruning(p.db)
+		return p.cleanup()
+	}
+	triedb := p.chain.TrieDB()
+	triedb.SetFlushHook(p.markNode)
+
+	p.lock.Lock()
+	p.running = true
+	p.setStatus(PhaseSweep, &progress, time.Now(), nil)
+	p.lock.Unlock()
+
+	log.Info("Resuming online state pruning", "root", progress.Root, "number", progress.Number, "marker", hexutil.Bytes(progress.Marker))
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.finish(&progress, p.sweep(&progress, bloom))
+	}()
+	return nil
+}
+
+// Start begins pruning the state, keeping the states of the recent blocks, and
+// returns once the pruning runs in the background.
+func (p *OnlinePruner) Start() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.running {
+		return errPruningRunning
+	}
+	triedb := p.chain.TrieDB()
+	if triedb.Scheme() != rawdb.HashScheme {
+		return errors.New("state pruning is not required for path scheme")
+	}
+	snaps := p.chain.Snapshots()
+	if snaps == nil {
+		return errors.New("state pruning requires snapshots")
+	}
+	if path, _, err := findBloomFilter(p.config.Datadir); err != nil {
+		return err
+	} else if path != "" {
+		return errors.New("offline state pruning pending")
+	}
+	if err := p.cleanup(); err != nil {
+		return err
+	}
+	bloom, err := newStateBloomWithSize(p.config.BloomSize)
+	if err != nil {
+		return err
+	}
+	// Keep the state of the bottom-most diff layer, the diff layers above it
+	// and all the trie nodes flushed from now on. The kept state is persisted
+	// too, to be available after a crash.
+	var (
+		progress sweepProgress
+		layers   []snapshot.Snapshot
+	)
+	err = p.chain.WithChainLock(func() error {
+		head := p.chain.CurrentBlock()
+		layers = snaps.Snapshots(head.Root, 128, true)
+		if len(layers) != 128 {
+			return fmt.Errorf("snapshot not old enough yet: need %d more blocks", 128-len(layers))
+		}
+		root := layers[len(layers)-1].Root()
+		if err := snaps.Hold(root); err != nil {
+			return err
+		}
+		triedb.SetFlushHook(p.markNode)
+		if err := triedb.Commit(root, false); err != nil {
+			triedb.SetFlushHook(nil)
+			snaps.Release(root)
+			return err
+		}
+		// The roots of consecutive blocks differ, so there is a layer per block
+		progress.Root, progress.Number = root, head.Number.Uint64()-uint64(len(layers)-1)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	p.running = true
+	p.setStatus(PhaseBloom, &progress, time.Now(), nil)
+
+	log.Info("Started online state pruning", "root", progress.Root, "number", progress.Number)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		err := p.markLayers(triedb, layers)
+		if err != nil {
+			snaps.Release(progress.Root)
+		} else {
+			err = p.generate(snaps, &progress, bloom)
+		}
+		if err == nil {
+			p.lock.Lock()
+			p.setStatus(PhaseSweep, &progress, p.status.Started, nil)
+			p.lock.Unlock()
+			err = p.sweep(&progress, bloom)
+		}
+		p.finish(&progress, err)
+	}()
+	return nil
+}
+
+// Stop interrupts the pruning. The sweep is resumed at the next start, but the
+// bloom build isn't. The flush hook is kept, so that the trie nodes flushed at
+// the shutdown are marked too.
+func (p *OnlinePruner) Stop() {
+	close(p.quit)
+	p.wg.Wait()
+}
+
+// markNode is the flush hook of the trie database, marking the node flushed
+// to disk in the same batch.
+func (p *OnlinePruner) markNode(batch ethdb.KeyValueWriter, hash common.Hash) {
+	rawdb.WriteOnlinePruningNode(batch, hash)
+}
+
+// markLayers marks the trie nodes of the states of the given diff layers,
+// apart from the bottom-most one, which are the nodes each one added to the
+// state of its parent. Along with the bloom of the bottom-most state, all the
+// states of the layers are kept. The states missing meanwhile were dropped
+// from memory, and don't need to be kept.
+func (p *OnlinePruner) markLayers(triedb *trie.Database, layers []snapshot.Snapshot) error {
+	var (
+		batch   = p.db.NewBatch()
+		missing *trie.MissingNodeError
+	)
+	for i := len(layers) - 2; i >= 0; i-- {
+		select {
+		case <-p.quit:
+			return errPruningStopped
+		default:
+		}
+		if err := markState(triedb, layers[i+1].Root(), layers[i].Root(), batch); err != nil && !errors.As(err, &missing) {
+			return err
+		}
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	return batch.Write()
+}
+
+// markState marks the trie nodes of the state with the given root missing from
+// the state of its parent, including those of the changed storage tries.
+func markState(triedb *trie.Database, parent common.Hash, root common.Hash, batch ethdb.KeyValueWriter) error {
+	oldTrie, err := trie.New(trie.StateTrieID(parent), triedb)
+	if err != nil {
+		return err
+	}
+	newTrie, err := trie.New(trie.StateTrieID(root), triedb)
+	if err != nil {
+		return err
+	}
+	// Resolve the storage roots of the parent from a distinct trie, not to
+	// interfere with the iteration
+	oldAccounts, err := trie.New(trie.StateTrieID(parent), triedb)
+	if err != nil {
+		return err
+	}
+	it, _ := trie.NewDifferenceIterator(oldTrie.NodeIterator(nil), newTrie.NodeIterator(nil))
+	for it.Next(true) {
+		if hash := it.Hash(); hash != (common.Hash{}) {
+			rawdb.WriteOnlinePruningNode(batch, hash)
+		}
+		if !it.Leaf() {
+			continue
+		}
+		var account types.StateAccount
+		if err := rlp.DecodeBytes(it.LeafBlob(), &account); err != nil {
+			return err
+		}
+		oldRoot := types.EmptyRootHash
+		blob, err := oldAccounts.TryGet(it.LeafKey())
+		if err != nil {
+			return err
+		}
+		if len(blob) > 0 {
+			var old types.StateAccount
+			if err := rlp.DecodeBytes(blob, &old); err != nil {
+				return err
+			}
+			oldRoot = old.Root
+		}
+		if account.Root == oldRoot || account.Root == types.EmptyRootHash {
+			continue
+		}
+		owner := common.BytesToHash(it.LeafKey())
+		oldStorage, err := trie.New(trie.StorageTrieID(parent, owner, oldRoot), triedb)
+		if err != nil {
+			return err
+		}
+		newStorage, err := trie.New(trie.StorageTrieID(root, owner, account.Root), triedb)
+		if err != nil {
+			return err
+		}
+		storageIt, _ := trie.NewDifferenceIterator(oldStorage.NodeIterator(nil), newStorage.NodeIterator(nil))
+		for storageIt.Next(true) {
+			if hash := storageIt.Hash(); hash != (common.Hash{}) {
+				rawdb.WriteOnlinePruningNode(batch, hash)
+			}
+		}
+		if err := storageIt.Error(); err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
+
+// setStatus updates the status reported, the lock is expected to be held.
+func (p *OnlinePruner) setStatus(phase string, progress *sweepProgress, started time.Time, err error) {
+	p.status = OnlineProgress{
+		Phase:   phase,
+		Root:    progress.Root,
+		Number:  hexutil.Uint64(progress.Number),
+		Deleted: hexutil.Uint64(progress.Deleted),
+		Size:    hexutil.Uint64(progress.Size),
+		Started: started,
+	}
+	if len(progress.Marker) >= 8 {
+		p.status.Progress = float64(binary.BigEndian.Uint64(progress.Marker[:8])) / math.MaxUint64
+	}
+	if err != nil {
+		p.status.Error = err.Error()
+	}
+	onlinePhaseGauge.Update(phaseMetric[phase])
+	onlineProgressGauge.Update(int64(p.status.Progress * 10000))
+}
+
+// generate builds the state bloom from the snapshot of the state to keep, and
+// persists it along with the sweep progress.
+func (p *OnlinePruner) generate(snaps *snapshot.Tree, progress *sweepProgress, bloom *stateBloom) error {
+	err := snapshot.GenerateTrieWithAbort(snaps, progress.Root, p.db, bloom, p.quit)
+	snaps.Release(progress.Root)
+	if err != nil {
+		if errors.Is(err, snapshot.ErrGenerationAborted) {
+			return errPruningStopped
+		}
+		return err
+	}
+	if err := extractGenesis(p.db, bloom); err != nil {
+		return err
+	}
+	name := onlineBloomName(p.config.Datadir, progress.Root)
+	if err := bloom.Commit(name, name+stateBloomFileTempSuffix); err != nil {
+		return err
+	}
+	blob, err := rlp.EncodeToBytes(progress)
+	if err != nil {
+		return err
+	}
+	rawdb.WriteOnlinePruning(p.db, blob)
+	log.Info("Committed online pruning bloom", "name", name)
+	return nil
+}
+
+// sweep deletes the stale trie nodes, neither in the bloom filter nor marked
+// as flushed since the pruning started, from the marker of the progress.
+func (p *OnlinePruner) sweep(progress *sweepProgress, bloom *stateBloom) error {
+	var (
+		start  = time.Now()
+		logged = time.Now()
+	)
+	for {
+		// Collect the stale candidates without holding the chain mutex
+		var (
+			keys    [][]byte
+			sizes   []int
+			scanned int
+			last    []byte
+			done    = true
+			iter    = p.db.NewIterator(nil, progress.Marker)
+		)
+		for iter.Next() {
+			key := iter.Key()
+			last = common.CopyBytes(key)
+			if scanned++; scanned%10000 == 0 {
+				select {
+				case <-p.quit:
+					iter.Release()
+					return errPruningStopped
+				default:
+				}
+			}
+			if len(key) == common.HashLength {
+				if ok, err := bloom.Contain(key); err != nil {
+					iter.Release()
+					return err
+				} else if !ok {
+					keys = append(keys, last)
+					sizes = append(sizes, len(key)+len(iter.Value()))
+				}
+			}
+			if len(keys) >= sweepBatchKeys || scanned >= sweepScanKeys {
+				done = false
+				break
+			}
+		}
+		err := iter.Error()
+		iter.Release()
+		if err != nil {
+			return err
+		}
+		// Delete the candidates not flushed since they were collected, holding
+		// the chain mutex so that none is flushed meanwhile
+		err = p.chain.WithChainLock(func() error {
+			defer onlineLockTimer.UpdateSince(time.Now())
+
+			batch := p.db.NewBatch()
+			for i, key := range keys {
+				if rawdb.HasOnlinePruningNode(p.db, common.BytesToHash(key)) {
+					continue
+				}
+				batch.Delete(key)
+				progress.Deleted++
+				progress.Size += uint64(sizes[i])
+				onlineDeletedMeter.Mark(1)
+				onlineDeletedSizeMeter.Mark(int64(sizes[i]))
+			}
+			if last != nil {
+				progress.Marker = last
+			}
+			blob, err := rlp.EncodeToBytes(progress)
+			if err != nil {
+				return err
+			}
+			rawdb.WriteOnlinePruning(batch, blob)
+			return batch.Write()
+		})
+		if err != nil {
+			return err
+		}
+		p.lock.Lock()
+		p.setStatus(PhaseSweep, progress, p.status.Started, nil)
+		p.lock.Unlock()
+
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Pruning state data online", "nodes", progress.Deleted, "size", common.StorageSize(progress.Size),
+				"progress", fmt.Sprintf("%.2f%%", p.Progress().Progress*100), "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+		if done {
+			break
+		}
+		select {
+		case <-time.After(p.config.Throttle):
+		case <-p.quit:
+			return errPruningStopped
+		}
+	}
+	log.Info("Pruned state data online", "nodes", progress.Deleted, "size", common.StorageSize(progress.Size), "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// finish concludes the pruning, successful or not. The sweep progress is
+// dropped before the node markers, so that an interrupted sweep is never
+// resumed without them.
+func (p *OnlinePruner) finish(progress *sweepProgress, err error) {
+	if errors.Is(err, errPruningStopped) {
+		log.Info("Online state pruning interrupted", "root", progress.Root)
+		return
+	}
+	triedb := p.chain.TrieDB()
+	if err == nil {
+		p.lock.Lock()
+		p.setStatus(PhaseCompact, progress, p.status.Started, nil)
+		p.lock.Unlock()
+	}
+	// Stop marking the flushed nodes, and forget the deleted ones which might
+	// still be cached
+	if lockErr := p.chain.WithChainLock(func() error {
+		rawdb.DeleteOnlinePruning(p.db)
+		triedb.SetFlushHook(nil)
+		triedb.ResetCleanCache()
+		return nil
+	}); lockErr != nil {
+		// The chain is stopped, the sweep is resumed at the next start
+		return
+	}
+	if cleanErr := p.cleanup(); cleanErr != nil {
+		log.Error("Failed to clean up online pruning", "err", cleanErr)
+	}
+	if err == nil && progress.Deleted >= rangeCompactionThreshold {
+		p.compact()
+	}
+	p.lock.Lock()
+	p.running = false
+	p.setStatus(PhaseIdle, progress, p.status.Started, err)
+	p.lock.Unlock()
+
+	if err != nil {
+		log.Error("Online state pruning failed", "root", progress.Root, "err", err)
+		return
+	}
+	log.Info("Online state pruning successful", "pruned", common.StorageSize(progress.Size), "elapsed", common.PrettyDuration(time.Since(p.status.Started)))
+}
+
+// compact compacts the whole key space range by range, removing the deleted
+// nodes from the disk.
+func (p *OnlinePruner) compact() {
+	cstart := time.Now()
+	for b := 0x00; b <= 0xf0; b += 0x10 {
+		var (
+			start = []byte{byte(b)}
+			end   = []byte{byte(b + 0x10)}
+		)
+		if b == 0xf0 {
+			end = nil
+		}
+		select {
+		case <-p.quit:
+			return
+		default:
+		}
+		log.Info("Compacting database", "range", fmt.Sprintf("%#x-%#x", start, end), "elapsed", common.PrettyDuration(time.Since(cstart)))
+		if err := p.db.Compact(start, end); err != nil {
+			log.Error("Database compaction failed", "error", err)
+			return
+		}
+	}
+	log.Info("Database compaction finished", "elapsed", common.PrettyDuration(time.Since(cstart)))
+}
+
+// cleanup deletes the state bloom files and the node markers of the online
+// pruning, not to be used any longer.
+func (p *OnlinePruner) cleanup() error {
+	files, err := filepath.Glob(filepath.Join(p.config.Datadir, onlineBloomFilePrefix+".*"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		os.Remove(file)
+	}
+	return rawdb.DeleteOnlinePruningNodes(p.db)
+}
+
+func onlineBloomName(datadir string, hash common.Hash) string {
+	return filepath.Join(datadir, fmt.Sprintf("%s.%s.%s", onlineBloomFilePrefix, hash.Hex(), stateBloomFileSuffix))
+}
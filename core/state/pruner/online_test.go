@@ -0,0 +1,217 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package pruner
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/params/types/genesisT"
+	"github.com/ethereum/go-ethereum/params/vars"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// onlineTester is an archive chain, keeping the states of all the blocks on
+// disk, with a set of blocks yet to import.
+type onlineTester struct {
+	db      ethdb.Database
+	genesis *genesisT.Genesis
+	config  *core.CacheConfig
+	chain   *core.BlockChain
+	blocks  []*types.Block
+}
+
+func newOnlineTester(t *testing.T, imported int, pending int) *onlineTester {
+	var (
+		engine  = ethash.NewFaker()
+		genesis = &genesisT.Genesis{
+			Config:  params.TestChainConfig,
+			BaseFee: big.NewInt(vars.InitialBaseFee),
+		}
+		config = &core.CacheConfig{
+			TrieCleanLimit:    16,
+			TrieDirtyDisabled: true,
+			TrieTimeLimit:     5 * time.Minute,
+			SnapshotLimit:     16,
+			SnapshotWait:      true,
+			StateScheme:       rawdb.HashScheme,
+		}
+	)
+	_, blocks, _ := core.GenerateChainWithGenesis(genesis, engine, imported+pending, func(i int, b *core.BlockGen) {
+		b.SetCoinbase(common.Address{byte(i), byte(i >> 8)})
+	})
+	tester := &onlineTester{
+		db:      rawdb.NewMemoryDatabase(),
+		genesis: genesis,
+		config:  config,
+		blocks:  blocks,
+	}
+	tester.open(t)
+	tester.insert(t, imported)
+	return tester
+}
+
+// open (re)creates the chain over the database.
+func (tester *onlineTester) open(t *testing.T) {
+	chain, err := core.NewBlockChain(tester.db, tester.config, tester.genesis, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	tester.chain = chain
+}
+
+// insert imports the next n pending blocks.
+func (tester *onlineTester) insert(t *testing.T, n int) {
+	head := tester.chain.CurrentBlock().Number.Uint64()
+	if _, err := tester.chain.InsertChain(tester.blocks[head : head+uint64(n)]); err != nil {
+		t.Fatalf("failed to insert blocks: %v", err)
+	}
+}
+
+// checkState ensures the state of the given block is complete on disk.
+func (tester *onlineTester) checkState(t *testing.T, number uint64) {
+	t.Helper()
+	root := tester.chain.GetHeaderByNumber(number).Root
+	tr, err := trie.New(trie.StateTrieID(root), trie.NewDatabase(tester.db))
+	if err != nil {
+		t.Fatalf("state of block %d missing: %v", number, err)
+	}
+	it := tr.NodeIterator(nil)
+	for it.Next(true) {
+	}
+	if err := it.Error(); err != nil {
+		t.Fatalf("state of block %d incomplete: %v", number, err)
+	}
+}
+
+// countNodes returns the number of legacy trie nodes on disk.
+func countNodes(db ethdb.Database) int {
+	var count int
+	it := db.NewIterator(nil, nil)
+	defer it.Release()
+	for it.Next() {
+		if len(it.Key()) == common.HashLength {
+			count++
+		}
+	}
+	return count
+}
+
+func TestOnlinePruning(t *testing.T) {
+	tester := newOnlineTester(t, 200, 16)
+	defer tester.chain.Stop()
+
+	before := countNodes(tester.db)
+	p := NewOnlinePruner(tester.db, tester.chain, OnlineConfig{Datadir: t.TempDir(), BloomSize: 256})
+	if err := p.Start(); err != nil {
+		t.Fatalf("failed to start pruning: %v", err)
+	}
+	if err := p.Start(); err == nil {
+		t.Fatal("pruning started twice")
+	}
+	// Keep importing blocks while the pruning runs
+	tester.insert(t, 16)
+	p.wg.Wait()
+
+	progress := p.Progress()
+	if progress.Phase != PhaseIdle || progress.Error != "" {
+		t.Fatalf("pruning not finished: phase %s, error %q", progress.Phase, progress.Error)
+	}
+	if progress.Number != 73 || progress.Deleted == 0 {
+		t.Fatalf("unexpected progress: number %d, deleted %d", progress.Number, progress.Deleted)
+	}
+	if after := countNodes(tester.db); after >= before {
+		t.Fatalf("no node deleted: before %d, after %d", before, after)
+	}
+	for number := uint64(73); number <= 216; number++ {
+		tester.checkState(t, number)
+	}
+	if tester.chain.HasState(tester.chain.GetHeaderByNumber(32).Root) {
+		t.Fatal("stale state still available")
+	}
+	if len(rawdb.ReadOnlinePruning(tester.db)) != 0 {
+		t.Fatal("pruning progress left")
+	}
+}
+
+func TestOnlinePruningRecentChain(t *testing.T) {
+	tester := newOnlineTester(t, 64, 0)
+	defer tester.chain.Stop()
+
+	p := NewOnlinePruner(tester.db, tester.chain, OnlineConfig{Datadir: t.TempDir(), BloomSize: 256})
+	if err := p.Start(); err == nil {
+		t.Fatal("pruning started without the diff layers of 128 blocks")
+	}
+}
+
+func TestOnlinePruningResume(t *testing.T) {
+	defer func(keys int) { sweepBatchKeys = keys }(sweepBatchKeys)
+	sweepBatchKeys = 8
+
+	tester := newOnlineTester(t, 200, 16)
+	datadir := t.TempDir()
+
+	// Interrupt the pruning after the first sweep batch
+	p := NewOnlinePruner(tester.db, tester.chain, OnlineConfig{Datadir: datadir, BloomSize: 256, Throttle: time.Hour})
+	if err := p.Start(); err != nil {
+		t.Fatalf("failed to start pruning: %v", err)
+	}
+	for deadline := time.Now().Add(time.Minute); len(rawdb.ReadOnlinePruning(tester.db)) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("pruning sweep not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	tester.insert(t, 8)
+	p.Stop()
+	tester.chain.Stop()
+
+	// Restart the chain, resuming the pruning before importing the rest
+	tester.open(t)
+	defer tester.chain.Stop()
+
+	p = NewOnlinePruner(tester.db, tester.chain, OnlineConfig{Datadir: datadir, BloomSize: 256})
+	if err := p.Resume(); err != nil {
+		t.Fatalf("failed to resume pruning: %v", err)
+	}
+	if phase := p.Progress().Phase; phase != PhaseSweep {
+		t.Fatalf("pruning not resumed: phase %s", phase)
+	}
+	tester.insert(t, 8)
+	p.wg.Wait()
+
+	if progress := p.Progress(); progress.Phase != PhaseIdle || progress.Error != "" {
+		t.Fatalf("pruning not finished: phase %s, error %q", progress.Phase, progress.Error)
+	}
+	for number := uint64(73); number <= 216; number++ {
+		tester.checkState(t, number)
+	}
+	it := tester.db.NewIterator([]byte("pruning-live-"), nil)
+	defer it.Release()
+	if it.Next() {
+		t.Fatal("node markers left")
+	}
+}
@@ -234,6 +234,11 @@ func prune(snaptree *snapshot.Tree, root common.Hash, maindb ethdb.Database, sta
 // specified state version. If user doesn't specify the state version, use
 // the bottom-most snapshot diff layer as the target.
 func (p *Pruner) Prune(root common.Hash) error {
+	// The online pruning has to be finished first, the nodes it keeps are
+	// unknown to the offline one.
+	if len(rawdb.ReadOnlinePruning(p.db)) > 0 {
+		return errors.New("online state pruning pending, restart the node to finish it")
+	}
 	// If the state bloom filter is already committed previously,
 	// reuse it for pruning instead of generating a new one. It's
 	// mandatory because a part of state may already be deleted,
@@ -65,11 +65,18 @@ func GenerateStorageTrieRoot(account common.Hash, it StorageIterator) (common.Ha
 // accounts as well as the corresponding storages and regenerate the whole state
 // (account trie + all storage tries).
 func GenerateTrie(snaptree *Tree, root common.Hash, src ethdb.Database, dst ethdb.KeyValueWriter) error {
+	return GenerateTrieWithAbort(snaptree, root, src, dst, nil)
+}
+
+// GenerateTrieWithAbort is GenerateTrie which can be interrupted by closing
+// the abort channel, failing with ErrGenerationAborted.
+func GenerateTrieWithAbort(snaptree *Tree, root common.Hash, src ethdb.Database, dst ethdb.KeyValueWriter, abort <-chan struct{}) error {
 	// Traverse all state by snapshot, re-generate the whole state trie
-	acctIt, err := snaptree.AccountIterator(root, common.Hash{})
+	it, err := snaptree.AccountIterator(root, common.Hash{})
 	if err != nil {
 		return err // The required snapshot might not exist.
 	}
+	acctIt := &abortableAccountIterator{AccountIterator: it, abort: abort}
 	defer acctIt.Release()
 
 	scheme := snaptree.triedb.Scheme()
@@ -83,28 +90,85 @@ func GenerateTrie(snaptree *Tree, root common.Hash, src ethdb.Database, dst ethd
 			rawdb.WriteCode(dst, codeHash, code)
 		}
 		// Then migrate all storage trie nodes into the tmp db.
-		storageIt, err := snaptree.StorageIterator(root, accountHash, common.Hash{})
+		it, err := snaptree.StorageIterator(root, accountHash, common.Hash{})
 		if err != nil {
 			return common.Hash{}, err
 		}
+		storageIt := &abortableStorageIterator{StorageIterator: it, abort: abort}
 		defer storageIt.Release()
 
 		hash, err := generateTrieRoot(dst, scheme, storageIt, accountHash, stackTrieGenerate, nil, stat, false)
 		if err != nil {
 			return common.Hash{}, err
 		}
+		if err := storageIt.Error(); err != nil {
+			return common.Hash{}, err
+		}
 		return hash, nil
 	}, newGenerateStats(), true)
 
 	if err != nil {
 		return err
 	}
+	if err := acctIt.Error(); err != nil {
+		return err
+	}
 	if got != root {
 		return fmt.Errorf("state root hash mismatch: got %x, want %x", got, root)
 	}
 	return nil
 }
 
+// abortableAccountIterator is an account iterator which stops once the abort
+// channel is closed, failing with ErrGenerationAborted.
+type abortableAccountIterator struct {
+	AccountIterator
+	abort <-chan struct{}
+	fail  error
+}
+
+func (it *abortableAccountIterator) Next() bool {
+	select {
+	case <-it.abort:
+		it.fail = ErrGenerationAborted
+		return false
+	default:
+		return it.AccountIterator.Next()
+	}
+}
+
+func (it *abortableAccountIterator) Error() error {
+	if it.fail != nil {
+		return it.fail
+	}
+	return it.AccountIterator.Error()
+}
+
+// abortableStorageIterator is a storage iterator which stops once the abort
+// channel is closed, failing with ErrGenerationAborted.
+type abortableStorageIterator struct {
+	StorageIterator
+	abort <-chan struct{}
+	fail  error
+}
+
+func (it *abortableStorageIterator) Next() bool {
+	select {
+	case <-it.abort:
+		it.fail = ErrGenerationAborted
+		return false
+	default:
+		return it.StorageIterator.Next()
+	}
+}
+
+func (it *abortableStorageIterator) Error() error {
+	if it.fail != nil {
+		return it.fail
+	}
+	return it.StorageIterator.Error()
+}
+
 // generateStats is a collection of statistics gathered by the trie generator
 // for logging purposes.
 type generateStats struct {
@@ -41,6 +41,10 @@ var (
 	// understanding all the implications.
 	aggregatorMemoryLimit = uint64(4 * 1024 * 1024)
 
+	// holdMemoryLimit is the maximum memory allowance of the diff layers kept
+	// while a layer is held, beyond which they are flattened regardless.
+	holdMemoryLimit = uint64(512 * 1024 * 1024)
+
 	// aggregatorItemLimit is an approximate number of items that will end up
 	// in the agregator layer before it's flushed out to disk. A plain account
 	// weighs around 14B (+hash), a storage slot 32B (+hash), a deleted slot
@@ -102,8 +106,9 @@ type diffLayer struct {
 	parent snapshot   // Parent snapshot modified by this one, never nil
 	memory uint64     // Approximate guess as to how much memory we use
 
-	root  common.Hash // Root hash to which this snapshot diff belongs to
-	stale uint32      // Signals that the layer became stale (state progressed)
+	root     common.Hash // Root hash to which this snapshot diff belongs to
+	stale    uint32      // Signals that the layer became stale (state progressed)
+	reported bool        // Whether the reverse diff was reported to the diff hook
 
 	// destructSet is a very special helper marker. If an account is marked as
 	// deleted, then it's recorded in this set. However it's allowed that an account
@@ -485,6 +490,7 @@ func (dl *diffLayer) flatten() snapshot {
 		storageList: make(map[common.Hash][]common.Hash),
 		diffed:      dl.diffed,
 		memory:      parent.memory + dl.memory,
+		reported:    dl.reported,
 	}
 }
 
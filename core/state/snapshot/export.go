@@ -0,0 +1,497 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+const (
+	exportMagic   = "gethsnapshot" // Identifier of the snapshot export files
+	exportVersion = 0              // Version of the snapshot export file format
+)
+
+// exportChunkSize is the approximate size of the keys and values of the account
+// and storage chunks, each chunk carrying its own range proof.
+var exportChunkSize = 512 * 1024
+
+// The kinds of the chunks of a snapshot export file.
+const (
+	chunkAccounts uint8 = iota
+	chunkStorage
+	chunkCodes
+)
+
+// exportHeader is the first item of a snapshot export file.
+type exportHeader struct {
+	Magic   string
+	Version uint64
+	Header  *types.Header // Header of the block the state belongs to
+}
+
+// exportChunk is a contiguous range of accounts or storage slots of the state,
+// along with the proof of the range boundaries, or a set of contract codes.
+//
+// The ranges are proven against the state root or the storage root of the
+// account and, without a proof, are required to span the entire trie.
+type exportChunk struct {
+	Kind    uint8
+	Account common.Hash   // Owner account of a storage range
+	Keys    []common.Hash // Hashes of the accounts or slots, empty for codes
+	Values  [][]byte      // Slim accounts, slots or contract codes
+	Proof   [][]byte      // Trie nodes proving the range boundaries
+}
+
+// Export writes the flat state of the given block into the writer as a stream
+// of chunks of accounts and storage slots, each carrying a range proof, along
+// with the contract codes. The state is read from the snapshot, the proofs are
+// created from the state trie.
+func Export(w io.Writer, t *Tree, header *types.Header) error {
+	root := header.Root
+	accTrie, err := trie.NewStateTrie(trie.StateTrieID(root), t.triedb)
+	if err != nil {
+		return err
+	}
+	it, err := t.AccountIterator(root, common.Hash{})
+	if err != nil {
+		return err
+	}
+	defer it.Release()
+
+	if err := rlp.Encode(w, &exportHeader{Magic: exportMagic, Version: exportVersion, Header: header}); err != nil {
+		return err
+	}
+	var (
+		seen   = make(map[common.Hash]struct{})
+		origin common.Hash
+		chunk  = &exportChunk{Kind: chunkAccounts}
+		size   int
+
+		accounts, slots, codes uint64
+		start                  = time.Now()
+		logged                 = time.Now()
+	)
+	// flush writes out the accounts accumulated so far, followed by their
+	// storage slots and the contract codes not written yet.
+	flush := func(more bool) error {
+		if len(chunk.Keys) == 0 {
+			return nil
+		}
+		if origin != (common.Hash{}) || more {
+			last := chunk.Keys[len(chunk.Keys)-1]
+			proof, err := proveRange(accTrie, origin, last)
+			if err != nil {
+				return err
+			}
+			chunk.Proof = proof
+		}
+		if err := rlp.Encode(w, chunk); err != nil {
+			return err
+		}
+		var blobs [][]byte
+		for i, hash := range chunk.Keys {
+			account, err := FullAccount(chunk.Values[i])
+			if err != nil {
+				return err
+			}
+			if storageRoot := common.BytesToHash(account.Root); storageRoot != types.EmptyRootHash {
+				n, err := exportStorage(w, t, root, hash, storageRoot)
+				if err != nil {
+					return err
+				}
+				slots += n
+			}
+			codeHash := common.BytesToHash(account.CodeHash)
+			if codeHash == types.EmptyCodeHash {
+				continue
+			}
+			if _, ok := seen[codeHash]; ok {
+				continue
+			}
+			code := rawdb.ReadCode(t.diskdb, codeHash)
+			if len(code) == 0 {
+				return fmt.Errorf("missing code %x of account %x", codeHash, hash)
+			}
+			seen[codeHash] = struct{}{}
+			blobs = append(blobs, code)
+		}
+		if len(blobs) > 0 {
+			if err := rlp.Encode(w, &exportChunk{Kind: chunkCodes, Values: blobs}); err != nil {
+				return err
+			}
+			codes += uint64(len(blobs))
+		}
+		accounts += uint64(len(chunk.Keys))
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Exporting state snapshot", "root", root, "at", chunk.Keys[len(chunk.Keys)-1], "accounts", accounts, "slots", slots, "codes", codes, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+		origin = incHash(chunk.Keys[len(chunk.Keys)-1])
+		chunk, size = &exportChunk{Kind: chunkAccounts}, 0
+		return nil
+	}
+	for it.Next() {
+		if size >= exportChunkSize {
+			if err := flush(true); err != nil {
+				return err
+			}
+		}
+		hash, account := it.Hash(), common.CopyBytes(it.Account())
+		chunk.Keys = append(chunk.Keys, hash)
+		chunk.Values = append(chunk.Values, account)
+		size += common.HashLength + len(account)
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	if err := flush(false); err != nil {
+		return err
+	}
+	log.Info("Exported state snapshot", "root", root, "accounts", accounts, "slots", slots, "codes", codes, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// exportStorage writes the storage slots of an account as a sequence of range
+// proven chunks, returning the number of slots written.
+func exportStorage(w io.Writer, t *Tree, root common.Hash, account common.Hash, storageRoot common.Hash) (uint64, error) {
+	it, err := t.StorageIterator(root, account, common.Hash{})
+	if err != nil {
+		return 0, err
+	}
+	defer it.Release()
+
+	var (
+		stTrie *trie.StateTrie
+		origin common.Hash
+		chunk  = &exportChunk{Kind: chunkStorage, Account: account}
+		size   int
+		slots  uint64
+	)
+	flush := func(more bool) error {
+		if origin != (common.Hash{}) || more {
+			if stTrie == nil {
+				if stTrie, err = trie.NewStateTrie(trie.StorageTrieID(root, account, storageRoot), t.triedb); err != nil {
+					return err
+				}
+			}
+			proof, err := proveRange(stTrie, origin, chunk.Keys[len(chunk.Keys)-1])
+			if err != nil {
+				return err
+			}
+			chunk.Proof = proof
+		}
+		if err := rlp.Encode(w, chunk); err != nil {
+			return err
+		}
+		slots += uint64(len(chunk.Keys))
+		origin = incHash(chunk.Keys[len(chunk.Keys)-1])
+		chunk, size = &exportChunk{Kind: chunkStorage, Account: account}, 0
+		return nil
+	}
+	for it.Next() {
+		if size >= exportChunkSize {
+			if err := flush(true); err != nil {
+				return 0, err
+			}
+		}
+		hash, slot := it.Hash(), common.CopyBytes(it.Slot())
+		chunk.Keys = append(chunk.Keys, hash)
+		chunk.Values = append(chunk.Values, slot)
+		size += common.HashLength + len(slot)
+	}
+	if err := it.Error(); err != nil {
+		return 0, err
+	}
+	if len(chunk.Keys) == 0 {
+		return 0, fmt.Errorf("missing storage of account %x", account)
+	}
+	if err := flush(false); err != nil {
+		return 0, err
+	}
+	return slots, nil
+}
+
+// proveRange returns the trie nodes proving the given range boundaries.
+func proveRange(tr *trie.StateTrie, origin common.Hash, last common.Hash) ([][]byte, error) {
+	proof := rawdb.NewMemoryDatabase()
+	if err := tr.Prove(origin[:], 0, proof); err != nil {
+		return nil, err
+	}
+	if err := tr.Prove(last[:], 0, proof); err != nil {
+		return nil, err
+	}
+	var nodes [][]byte
+	it := proof.NewIterator(nil, nil)
+	defer it.Release()
+	for it.Next() {
+		nodes = append(nodes, common.CopyBytes(it.Value()))
+	}
+	return nodes, nil
+}
+
+// incHash returns the hash following the given one, or the zero hash if the
+// addition overflows.
+func incHash(hash common.Hash) common.Hash {
+	var next common.Hash
+	if key := increaseKey(common.CopyBytes(hash[:])); key != nil {
+		copy(next[:], key)
+	}
+	return next
+}
+
+// importRange tracks the next expected storage range of an account.
+type importRange struct {
+	root common.Hash // Storage root of the account
+	next common.Hash // Origin of the next storage range
+}
+
+// Import reads a snapshot export file, verifying every range of the state
+// against the proofs and writing it into the database as the persistent
+// snapshot. The state trie is regenerated from the imported snapshot and
+// verified against the state root of the header in the file, which is passed
+// to the check callback before importing anything.
+//
+// Any existing snapshot in the database is wiped, and only marked as complete
+// once the import succeeds.
+func Import(r io.Reader, db ethdb.Database, triedb *trie.Database, check func(header *types.Header) error) (*types.Header, error) {
+	stream := rlp.NewStream(r, 0)
+
+	var head exportHeader
+	if err := stream.Decode(&head); err != nil {
+		return nil, fmt.Errorf("could not decode header: %v", err)
+	}
+	if head.Magic != exportMagic {
+		return nil, errors.New("incompatible data, wrong magic")
+	}
+	if head.Version != exportVersion {
+		return nil, fmt.Errorf("incompatible version %d, (support only %d)", head.Version, exportVersion)
+	}
+	if head.Header == nil {
+		return nil, errors.New("missing block header")
+	}
+	if check != nil {
+		if err := check(head.Header); err != nil {
+			return nil, err
+		}
+	}
+	root := head.Header.Root
+	log.Info("Importing state snapshot", "number", head.Header.Number, "hash", head.Header.Hash(), "root", root)
+
+	// Wipe the existing snapshot, it's only reactivated once the import is done
+	rawdb.DeleteSnapshotRoot(db)
+	rawdb.DeleteSnapshotJournal(db)
+	rawdb.DeleteSnapshotGenerator(db)
+	if err := wipeSnapshot(db); err != nil {
+		return nil, err
+	}
+	var (
+		batch    = db.NewBatch()
+		origin   common.Hash
+		done     = root == types.EmptyRootHash
+		storages = make(map[common.Hash]*importRange)
+		codes    = make(map[common.Hash]struct{})
+
+		accounts, slots, blobs uint64
+		start                  = time.Now()
+		logged                 = time.Now()
+	)
+	for {
+		var chunk exportChunk
+		if err := stream.Decode(&chunk); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch chunk.Kind {
+		case chunkAccounts:
+			if done {
+				return nil, errors.New("unexpected account range")
+			}
+			values := make([][]byte, len(chunk.Values))
+			for i, blob := range chunk.Values {
+				full, err := FullAccountRLP(blob)
+				if err != nil {
+					return nil, err
+				}
+				values[i] = full
+			}
+			more, err := verifyRange(root, origin, chunk.Keys, values, chunk.Proof)
+			if err != nil {
+				return nil, fmt.Errorf("invalid account range at %x: %v", origin, err)
+			}
+			for i, hash := range chunk.Keys {
+				account, err := FullAccount(chunk.Values[i])
+				if err != nil {
+					return nil, err
+				}
+				if storageRoot := common.BytesToHash(account.Root); storageRoot != types.EmptyRootHash {
+					storages[hash] = &importRange{root: storageRoot}
+				}
+				if codeHash := common.BytesToHash(account.CodeHash); codeHash != types.EmptyCodeHash {
+					codes[codeHash] = struct{}{}
+				}
+				rawdb.WriteAccountSnapshot(batch, hash, chunk.Values[i])
+			}
+			accounts += uint64(len(chunk.Keys))
+			done = !more
+			if more {
+				origin = incHash(chunk.Keys[len(chunk.Keys)-1])
+			}
+
+		case chunkStorage:
+			task, ok := storages[chunk.Account]
+			if !ok {
+				return nil, fmt.Errorf("unexpected storage range of account %x", chunk.Account)
+			}
+			more, err := verifyRange(task.root, task.next, chunk.Keys, chunk.Values, chunk.Proof)
+			if err != nil {
+				return nil, fmt.Errorf("invalid storage range of account %x at %x: %v", chunk.Account, task.next, err)
+			}
+			for i, hash := range chunk.Keys {
+				rawdb.WriteStorageSnapshot(batch, chunk.Account, hash, chunk.Values[i])
+			}
+			slots += uint64(len(chunk.Keys))
+			if more {
+				task.next = incHash(chunk.Keys[len(chunk.Keys)-1])
+			} else {
+				delete(storages, chunk.Account)
+			}
+
+		case chunkCodes:
+			for _, code := range chunk.Values {
+				hash := crypto.Keccak256Hash(code)
+				if _, ok := codes[hash]; !ok {
+					return nil, fmt.Errorf("unexpected code %x", hash)
+				}
+				delete(codes, hash)
+				rawdb.WriteCode(batch, hash, code)
+			}
+			blobs += uint64(len(chunk.Values))
+
+		default:
+			return nil, fmt.Errorf("unknown chunk kind %d", chunk.Kind)
+		}
+		if batch.ValueSize() > ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return nil, err
+			}
+			batch.Reset()
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Importing state snapshot", "root", root, "at", origin, "accounts", accounts, "slots", slots, "codes", blobs, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return nil, err
+	}
+	// Make sure the entire state was contained in the file
+	if !done {
+		return nil, fmt.Errorf("missing accounts from %x", origin)
+	}
+	if len(storages) > 0 {
+		return nil, fmt.Errorf("missing storage of %d accounts", len(storages))
+	}
+	for hash := range codes {
+		if !rawdb.HasCode(db, hash) {
+			return nil, fmt.Errorf("missing code %x", hash)
+		}
+	}
+	log.Info("Imported state snapshot", "root", root, "accounts", accounts, "slots", slots, "codes", blobs, "elapsed", common.PrettyDuration(time.Since(start)))
+
+	// Activate the imported snapshot and regenerate the state trie from it
+	rawdb.WriteSnapshotRoot(db, root)
+	journalProgress(db, nil, nil)
+
+	snaptree, err := New(Config{CacheSize: 256, NoBuild: true}, db, triedb, root)
+	if err != nil {
+		return nil, err
+	}
+	if err := GenerateTrie(snaptree, root, db, db); err != nil {
+		return nil, err
+	}
+	log.Info("Regenerated state trie", "root", root, "elapsed", common.PrettyDuration(time.Since(start)))
+	return head.Header, nil
+}
+
+// verifyRange verifies the range of keys and values starting at the origin
+// against the proof, returning whether there are more items in the trie. A
+// range without proof must span the entire trie.
+func verifyRange(root common.Hash, origin common.Hash, hashes []common.Hash, values [][]byte, proof [][]byte) (bool, error) {
+	if len(hashes) == 0 {
+		return false, errors.New("empty range")
+	}
+	keys := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		keys[i] = common.CopyBytes(hash[:])
+	}
+	if len(proof) == 0 {
+		if origin != (common.Hash{}) {
+			return false, errors.New("missing range proof")
+		}
+		return trie.VerifyRangeProof(root, nil, nil, keys, values, nil)
+	}
+	proofdb := rawdb.NewMemoryDatabase()
+	for _, node := range proof {
+		proofdb.Put(crypto.Keccak256(node), node)
+	}
+	return trie.VerifyRangeProof(root, origin[:], keys[len(keys)-1], keys, values, proofdb)
+}
+
+// wipeSnapshot deletes all the account and storage snapshot entries from the
+// database.
+func wipeSnapshot(db ethdb.KeyValueStore) error {
+	for _, prefix := range []struct {
+		prefix []byte
+		length int
+	}{
+		{rawdb.SnapshotAccountPrefix, len(rawdb.SnapshotAccountPrefix) + common.HashLength},
+		{rawdb.SnapshotStoragePrefix, len(rawdb.SnapshotStoragePrefix) + 2*common.HashLength},
+	} {
+		var (
+			batch = db.NewBatch()
+			it    = rawdb.NewKeyLengthIterator(db.NewIterator(prefix.prefix, nil), prefix.length)
+		)
+		for it.Next() {
+			batch.Delete(it.Key())
+			if batch.ValueSize() > ethdb.IdealBatchSize {
+				if err := batch.Write(); err != nil {
+					it.Release()
+					return err
+				}
+				batch.Reset()
+			}
+		}
+		it.Release()
+		if err := batch.Write(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// newExportTestTree creates a snapshot tree of a state with accounts spanning
+// multiple export chunks, some of them with contract code and storage.
+func newExportTestTree(t *testing.T) (*Tree, common.Hash) {
+	helper := newHelper()
+	for i := 0; i < 200; i++ {
+		var (
+			key  = fmt.Sprintf("acc-%d", i)
+			root = types.EmptyRootHash.Bytes()
+			code = types.EmptyCodeHash.Bytes()
+		)
+		if i%10 == 0 {
+			var keys, vals []string
+			for j := 0; j < 10*i; j++ {
+				keys = append(keys, fmt.Sprintf("key-%d", j))
+				vals = append(vals, fmt.Sprintf("val-%d", j))
+			}
+			if len(keys) > 0 {
+				root = helper.makeStorageTrie(common.Hash{}, hashData([]byte(key)), keys, vals, true)
+			}
+		}
+		if i%7 == 0 {
+			blob := []byte{byte(i % 3), 0x60, 0x00}
+			rawdb.WriteCode(helper.diskdb, crypto.Keccak256Hash(blob), blob)
+			code = crypto.Keccak256(blob)
+		}
+		helper.addTrieAccount(key, &Account{Nonce: uint64(i), Balance: big.NewInt(int64(i)), Root: root, CodeHash: code})
+	}
+	root, snap := helper.CommitAndGenerate()
+	select {
+	case <-snap.genPending:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("snapshot generation failed")
+	}
+	stop := make(chan *generatorStats)
+	snap.genAbort <- stop
+	<-stop
+
+	return &Tree{diskdb: helper.diskdb, triedb: helper.triedb, layers: map[common.Hash]snapshot{root: snap}}, root
+}
+
+// Tests that an exported state snapshot is imported identically, regenerating
+// the state trie.
+func TestExportImport(t *testing.T) {
+	defer func(old int) { exportChunkSize = old }(exportChunkSize)
+	exportChunkSize = 1024
+
+	tree, root := newExportTestTree(t)
+	header := &types.Header{Number: big.NewInt(1), Root: root, Difficulty: common.Big1}
+
+	var file bytes.Buffer
+	if err := Export(&file, tree, header); err != nil {
+		t.Fatalf("failed to export snapshot: %v", err)
+	}
+	db := rawdb.NewMemoryDatabase()
+	imported, err := Import(bytes.NewReader(file.Bytes()), db, trie.NewDatabase(db), func(h *types.Header) error {
+		if h.Hash() != header.Hash() {
+			t.Errorf("header mismatch: have %x, want %x", h.Hash(), header.Hash())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to import snapshot: %v", err)
+	}
+	if imported.Hash() != header.Hash() {
+		t.Fatalf("imported header mismatch: have %x, want %x", imported.Hash(), header.Hash())
+	}
+	if have := rawdb.ReadSnapshotRoot(db); have != root {
+		t.Fatalf("snapshot root mismatch: have %x, want %x", have, root)
+	}
+	// The flat state and the contract codes are identical
+	for _, prefix := range [][]byte{rawdb.SnapshotAccountPrefix, rawdb.SnapshotStoragePrefix, rawdb.CodePrefix} {
+		want, have := tree.diskdb.NewIterator(prefix, nil), db.NewIterator(prefix, nil)
+		for want.Next() {
+			if !have.Next() || !bytes.Equal(have.Key(), want.Key()) || !bytes.Equal(have.Value(), want.Value()) {
+				t.Fatalf("flat state mismatch at %x", want.Key())
+			}
+		}
+		if have.Next() {
+			t.Fatalf("extra flat state at %x", have.Key())
+		}
+		want.Release()
+		have.Release()
+	}
+	// The state trie is regenerated
+	tr, err := trie.NewStateTrie(trie.StateTrieID(root), trie.NewDatabase(db))
+	if err != nil {
+		t.Fatalf("failed to open imported trie: %v", err)
+	}
+	it := trie.NewIterator(tr.NodeIterator(nil))
+	count := 0
+	for it.Next() {
+		count++
+	}
+	if it.Err != nil || count != 200 {
+		t.Fatalf("imported trie mismatch: %d accounts (%v)", count, it.Err)
+	}
+}
+
+// Tests that importing a snapshot fails if the state doesn't match the proofs.
+func TestImportInvalid(t *testing.T) {
+	defer func(old int) { exportChunkSize = old }(exportChunkSize)
+	exportChunkSize = 1024
+
+	tree, root := newExportTestTree(t)
+	header := &types.Header{Number: big.NewInt(1), Root: root, Difficulty: common.Big1}
+
+	// Corrupt a storage slot of the flat state
+	account := hashData([]byte("acc-150"))
+	rawdb.WriteStorageSnapshot(tree.diskdb, account, hashData([]byte("key-700")), []byte("bad"))
+	tree.disklayer().cache.Reset()
+
+	var file bytes.Buffer
+	if err := Export(&file, tree, header); err != nil {
+		t.Fatalf("failed to export snapshot: %v", err)
+	}
+	db := rawdb.NewMemoryDatabase()
+	if _, err := Import(&file, db, trie.NewDatabase(db), nil); err == nil || !strings.Contains(err.Error(), "invalid storage range") {
+		t.Fatalf("corrupted snapshot imported: %v", err)
+	}
+	if have := rawdb.ReadSnapshotRoot(db); have != (common.Hash{}) {
+		t.Fatalf("corrupted snapshot activated")
+	}
+}
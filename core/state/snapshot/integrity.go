@@ -0,0 +1,874 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+const (
+	// integrityTasks is the number of account ranges the snapshot is split into
+	// for checking its integrity in parallel.
+	integrityTasks = 256
+
+	// integrityRepairRounds is the maximum number of repair rounds, each of which
+	// verifies the ranges repaired in the previous one.
+	integrityRepairRounds = 4
+)
+
+// integrityRepairChunk is the number of snapshot entries merged with the trie
+// ones at most before the repairs found are written.
+var integrityRepairChunk = 4096
+
+var (
+	// errIntegrityInterrupted is returned if an integrity check or repair is
+	// aborted by the caller.
+	errIntegrityInterrupted = errors.New("snapshot integrity check interrupted")
+
+	// errIntegrityRunning is returned if a background integrity check is started
+	// while another one is running.
+	errIntegrityRunning = errors.New("snapshot integrity check already running")
+
+	// errNoIntegrityReport is returned if the report of a background integrity
+	// check is requested before any has finished.
+	errNoIntegrityReport = errors.New("no snapshot integrity report available")
+
+	// maxHash is the last hash of the key space.
+	maxHash = common.HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff")
+)
+
+// The phases of a background integrity check, reported over RPC.
+const (
+	IntegrityPhaseIdle   = "idle"
+	IntegrityPhaseCheck  = "check"
+	IntegrityPhaseRepair = "repair"
+)
+
+// IntegrityRange is a range of the snapshot found inconsistent with the trie.
+type IntegrityRange struct {
+	Account *common.Hash `json:"account,omitempty"` // Owner of a storage range, nil for account ranges
+	Origin  common.Hash  `json:"origin"`            // First hash of the range
+	Limit   common.Hash  `json:"limit"`             // Last hash of the range, inclusive
+	Reason  string       `json:"reason"`            // Description of the inconsistency
+}
+
+// logCtx returns the contextual logging fields describing the range.
+func (r *IntegrityRange) logCtx() []interface{} {
+	ctx := []interface{}{"origin", r.Origin, "limit", r.Limit}
+	if r.Account != nil {
+		ctx = append([]interface{}{"account", *r.Account}, ctx...)
+	}
+	return ctx
+}
+
+// IntegrityReport is the outcome of a snapshot integrity check.
+type IntegrityReport struct {
+	Root     common.Hash      `json:"root"`     // Root of the disk layer the check started at
+	Accounts uint64           `json:"accounts"` // Number of accounts checked
+	Slots    uint64           `json:"slots"`    // Number of storage slots checked
+	Ranges   []IntegrityRange `json:"ranges"`   // Ranges found inconsistent with the trie
+	Repaired int              `json:"repaired"` // Number of ranges repaired
+}
+
+// integrityChecker verifies ranges of the snapshot disk layer against the trie
+// of the same root with range proofs.
+type integrityChecker struct {
+	tree      *Tree
+	interrupt <-chan struct{} // Channel closed by the caller to abort the check
+	abort     chan struct{}   // Channel closed if any of the parallel checks failed
+	abortOnce sync.Once
+
+	tasks        atomic.Uint64 // Number of account ranges checked
+	accounts     atomic.Uint64 // Number of accounts checked
+	slots        atomic.Uint64 // Number of storage slots checked
+	inconsistent atomic.Uint64 // Number of inconsistent ranges found
+	repaired     atomic.Uint64 // Number of ranges repaired
+}
+
+func newIntegrityChecker(tree *Tree, interrupt <-chan struct{}) *integrityChecker {
+	return &integrityChecker{
+		tree:      tree,
+		interrupt: interrupt,
+		abort:     make(chan struct{}),
+	}
+}
+
+// interrupted reports whether the check should be aborted.
+func (c *integrityChecker) interrupted() bool {
+	select {
+	case <-c.interrupt:
+		return true
+	case <-c.abort:
+		return true
+	default:
+		return false
+	}
+}
+
+// checkRange verifies the given range, including the storage of the accounts
+// within in case of an account range.
+func (c *integrityChecker) checkRange(r IntegrityRange) ([]IntegrityRange, error) {
+	if r.Account == nil {
+		return c.checkAccounts(r.Origin, r.Limit)
+	}
+	for {
+		dl, err := c.tree.liveDiskLayer()
+		if err != nil {
+			return nil, err
+		}
+		root, err := dl.storageRoot(*r.Account)
+		if err != nil {
+			err = dl.staleOr(err)
+		}
+		if errors.Is(err, ErrSnapshotStale) {
+			continue // The disk layer progressed, retry on the new one
+		}
+		if err != nil {
+			return nil, err
+		}
+		ranges, err := c.checkStorage(dl, *r.Account, root, r.Origin, r.Limit)
+		if errors.Is(err, ErrSnapshotStale) {
+			continue // The disk layer progressed, retry on the new one
+		}
+		return ranges, err
+	}
+}
+
+// checkAccounts verifies the accounts in the range [origin, limit] along with
+// their storage, chunk by chunk. Every chunk is verified against the disk layer
+// it was read from, moving on to the new disk layer if the state progressed.
+func (c *integrityChecker) checkAccounts(origin, limit common.Hash) ([]IntegrityRange, error) {
+	var ranges []IntegrityRange
+	for {
+		if c.interrupted() {
+			return nil, errIntegrityInterrupted
+		}
+		dl, err := c.tree.liveDiskLayer()
+		if err != nil {
+			return nil, err
+		}
+		chunk, end, more, err := c.checkAccountChunk(dl, origin, limit)
+		if errors.Is(err, ErrSnapshotStale) {
+			continue // The disk layer progressed, retry on the new one
+		}
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, chunk...)
+		if !more {
+			return ranges, nil
+		}
+		origin = incHash(end)
+	}
+}
+
+// checkAccountChunk verifies the next chunk of accounts starting at the origin,
+// returning the inconsistent ranges found, the last hash covered and whether
+// there are more accounts left in the range.
+func (c *integrityChecker) checkAccountChunk(dl *diskLayer, origin, limit common.Hash) ([]IntegrityRange, common.Hash, bool, error) {
+	keys, vals, more, err := dl.readRange(rawdb.SnapshotAccountPrefix, origin, limit, accountCheckRange)
+	if err != nil {
+		return nil, common.Hash{}, false, err
+	}
+	end := limit
+	if more {
+		end = common.BytesToHash(keys[len(keys)-1])
+	}
+	full := make([][]byte, len(vals))
+	for i, val := range vals {
+		if full[i], err = FullAccountRLP(val); err != nil {
+			full[i] = val // Corrupted entry, let the range proof fail
+		}
+	}
+	reason, err := verifyTrieRange(dl, trie.StateTrieID(dl.root), origin, end, keys, full)
+	if err != nil {
+		return nil, common.Hash{}, false, err
+	}
+	if reason != "" {
+		c.accounts.Add(uint64(len(keys)))
+		c.inconsistent.Add(1)
+		return []IntegrityRange{{Origin: origin, Limit: end, Reason: reason}}, end, more, nil
+	}
+	// The accounts are consistent, verify their storage too
+	var ranges []IntegrityRange
+	for i, key := range keys {
+		var account types.StateAccount
+		if err := rlp.DecodeBytes(full[i], &account); err != nil {
+			return nil, common.Hash{}, false, err
+		}
+		if account.Root == types.EmptyRootHash {
+			// Accounts without storage mustn't have any slots left in the snapshot
+			dangling, err := c.checkDangling(dl, common.BytesToHash(key))
+			if err != nil {
+				return nil, common.Hash{}, false, err
+			}
+			ranges = append(ranges, dangling...)
+			continue
+		}
+		storage, err := c.checkStorage(dl, common.BytesToHash(key), account.Root, common.Hash{}, maxHash)
+		if err != nil {
+			return nil, common.Hash{}, false, err
+		}
+		ranges = append(ranges, storage...)
+	}
+	c.accounts.Add(uint64(len(keys)))
+	return ranges, end, more, nil
+}
+
+// checkStorage verifies the storage slots of the account in the range [origin,
+// limit] against the storage trie of the given root, chunk by chunk.
+func (c *integrityChecker) checkStorage(dl *diskLayer, account common.Hash, root common.Hash, origin, limit common.Hash) ([]IntegrityRange, error) {
+	var (
+		ranges []IntegrityRange
+		prefix = append(common.CopyBytes(rawdb.SnapshotStoragePrefix), account[:]...)
+		id     = trie.StorageTrieID(dl.root, account, root)
+	)
+	for {
+		if c.interrupted() {
+			return nil, errIntegrityInterrupted
+		}
+		keys, vals, more, err := dl.readRange(prefix, origin, limit, storageCheckRange)
+		if err != nil {
+			return nil, err
+		}
+		end := limit
+		if more {
+			end = common.BytesToHash(keys[len(keys)-1])
+		}
+		reason, err := verifyTrieRange(dl, id, origin, end, keys, vals)
+		if err != nil {
+			return nil, err
+		}
+		c.slots.Add(uint64(len(keys)))
+		if reason != "" {
+			c.inconsistent.Add(1)
+			ranges = append(ranges, IntegrityRange{Account: &account, Origin: origin, Limit: end, Reason: reason})
+		}
+		if !more {
+			return ranges, nil
+		}
+		origin = incHash(end)
+	}
+}
+
+// checkDangling verifies that the account has no storage slots in the snapshot,
+// as its storage trie is empty, returning its whole storage range if it does.
+func (c *integrityChecker) checkDangling(dl *diskLayer, account common.Hash) ([]IntegrityRange, error) {
+	prefix := append(common.CopyBytes(rawdb.SnapshotStoragePrefix), account[:]...)
+	keys, _, _, err := dl.readRange(prefix, common.Hash{}, maxHash, 1)
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	c.inconsistent.Add(1)
+	return []IntegrityRange{{Account: &account, Origin: common.Hash{}, Limit: maxHash, Reason: "dangling storage"}}, nil
+}
+
+// verifyTrieRange verifies that the given keys and values are exactly the items
+// of the trie in the range [origin, end], returning the reason if not. Errors
+// are only returned if the trie itself is unavailable. As the trie of a layer
+// flattened meanwhile may be pruned already, ErrSnapshotStale is returned in
+// that case instead, for the range to be checked again on the new layer.
+func verifyTrieRange(dl *diskLayer, id *trie.ID, origin, end common.Hash, keys [][]byte, vals [][]byte) (string, error) {
+	reason, err := verifyTrieItems(dl, id, origin, end, keys, vals)
+	if err != nil {
+		return "", dl.staleOr(err)
+	}
+	return reason, nil
+}
+
+// verifyTrieItems implements verifyTrieRange, regardless of the staleness of
+// the layer.
+func verifyTrieItems(dl *diskLayer, id *trie.ID, origin, end common.Hash, keys [][]byte, vals [][]byte) (string, error) {
+	tr, err := trie.New(id, dl.triedb)
+	if err != nil {
+		return "", fmt.Errorf("trie unavailable: %w", err)
+	}
+	if len(keys) > 0 {
+		last := keys[len(keys)-1]
+
+		proof := rawdb.NewMemoryDatabase()
+		if err := tr.Prove(origin[:], 0, proof); err != nil {
+			return "", err
+		}
+		if err := tr.Prove(last, 0, proof); err != nil {
+			return "", err
+		}
+		if _, err := trie.VerifyRangeProof(id.Root, origin[:], last, keys, vals, proof); err != nil {
+			return fmt.Sprintf("invalid range: %v", err), nil
+		}
+		if bytes.Equal(last, end[:]) {
+			return "", nil
+		}
+		origin = incHash(common.BytesToHash(last))
+	}
+	// The proof only covers the range up to the last key, ensure the trie has no
+	// further items in the rest of the range
+	it := trie.NewIterator(tr.NodeIterator(origin[:]))
+	if it.Next() {
+		if bytes.Compare(it.Key, end[:]) <= 0 {
+			return fmt.Sprintf("missing item %x", it.Key), nil
+		}
+		return "", nil
+	}
+	return "", it.Err
+}
+
+// liveDiskLayer returns the current disk layer of the tree, ensuring that it's
+// fully generated.
+func (t *Tree) liveDiskLayer() (*diskLayer, error) {
+	t.lock.RLock()
+	dl := t.disklayer()
+	t.lock.RUnlock()
+
+	if dl == nil {
+		return nil, errors.New("disk layer is missing")
+	}
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	if dl.genMarker != nil {
+		return nil, ErrNotConstructed
+	}
+	return dl, nil
+}
+
+// readRange reads at most max snapshot entries with the given prefix in the
+// range [origin, limit], returning whether there are more entries left. The
+// read is consistent with the root of the layer, ErrSnapshotStale is returned
+// if the layer was flattened already.
+func (dl *diskLayer) readRange(prefix []byte, origin, limit common.Hash, max int) ([][]byte, [][]byte, bool, error) {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	if dl.stale {
+		return nil, nil, false, ErrSnapshotStale
+	}
+	var (
+		keys [][]byte
+		vals [][]byte
+		it   = rawdb.NewKeyLengthIterator(dl.diskdb.NewIterator(prefix, origin[:]), len(prefix)+common.HashLength)
+	)
+	defer it.Release()
+
+	for it.Next() {
+		key := it.Key()[len(prefix):]
+		if bytes.Compare(key, limit[:]) > 0 {
+			break
+		}
+		if len(keys) == max {
+			return keys, vals, true, nil
+		}
+		keys = append(keys, common.CopyBytes(key))
+		vals = append(vals, common.CopyBytes(it.Value()))
+	}
+	return keys, vals, false, it.Error()
+}
+
+// staleOr returns ErrSnapshotStale if the layer was flattened already, or the
+// given error otherwise.
+func (dl *diskLayer) staleOr(err error) error {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	if dl.stale {
+		return ErrSnapshotStale
+	}
+	return err
+}
+
+// storageRoot returns the storage root of the account in the trie of the layer.
+func (dl *diskLayer) storageRoot(account common.Hash) (common.Hash, error) {
+	tr, err := trie.New(trie.StateTrieID(dl.root), dl.triedb)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("trie unavailable: %w", err)
+	}
+	blob, err := tr.TryGet(account[:])
+	if err != nil || len(blob) == 0 {
+		return types.EmptyRootHash, err
+	}
+	var data types.StateAccount
+	if err := rlp.DecodeBytes(blob, &data); err != nil {
+		return common.Hash{}, err
+	}
+	return data.Root, nil
+}
+
+// repairEntry is a snapshot entry regenerated from the trie, or deleted from the
+// snapshot if missing from the trie.
+type repairEntry struct {
+	hash common.Hash
+	val  []byte // Nil if the entry is deleted
+}
+
+// repairRange regenerates the snapshot entries in the given range from the trie
+// of the layer, chunk by chunk. Entries missing from the trie are deleted, along
+// with the storage of the deleted accounts. Each chunk is collected under the
+// read lock of the layer and only written under its write lock, so that the
+// layer isn't flattened meanwhile without being blocked for the whole range.
+func (dl *diskLayer) repairRange(r IntegrityRange) error {
+	var (
+		origin           = r.Origin
+		written, deleted int
+	)
+	for {
+		entries, end, more, err := dl.collectRepair(r, origin)
+		if err != nil {
+			return err
+		}
+		if err := dl.writeRepair(r.Account, entries); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.val == nil {
+				deleted++
+			} else {
+				written++
+			}
+		}
+		if !more {
+			break
+		}
+		origin = incHash(end)
+	}
+	log.Info("Repaired snapshot range", append(r.logCtx(), "written", written, "deleted", deleted)...)
+	return nil
+}
+
+// collectRepair merges the snapshot entries of the range starting at the origin
+// into the trie ones, returning the entries to write or delete, the last hash
+// covered and whether the range was cut short after integrityRepairChunk items.
+func (dl *diskLayer) collectRepair(r IntegrityRange, origin common.Hash) ([]repairEntry, common.Hash, bool, error) {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	if dl.stale {
+		return nil, common.Hash{}, false, ErrSnapshotStale
+	}
+	var (
+		id     = trie.StateTrieID(dl.root)
+		prefix = rawdb.SnapshotAccountPrefix
+	)
+	if r.Account != nil {
+		root, err := dl.storageRoot(*r.Account)
+		if err != nil {
+			return nil, common.Hash{}, false, err
+		}
+		id = trie.StorageTrieID(dl.root, *r.Account, root)
+		prefix = append(common.CopyBytes(rawdb.SnapshotStoragePrefix), r.Account[:]...)
+	}
+	tr, err := trie.New(id, dl.triedb)
+	if err != nil {
+		return nil, common.Hash{}, false, fmt.Errorf("trie unavailable: %w", err)
+	}
+	var (
+		snapIt = rawdb.NewKeyLengthIterator(dl.diskdb.NewIterator(prefix, origin[:]), len(prefix)+common.HashLength)
+		trieIt = trie.NewIterator(tr.NodeIterator(origin[:]))
+
+		entries []repairEntry
+		end     common.Hash
+		items   int
+	)
+	defer snapIt.Release()
+
+	nextSnap := func() ([]byte, []byte) {
+		if snapIt.Next() {
+			if key := snapIt.Key()[len(prefix):]; bytes.Compare(key, r.Limit[:]) <= 0 {
+				return common.CopyBytes(key), common.CopyBytes(snapIt.Value())
+			}
+		}
+		return nil, nil
+	}
+	nextTrie := func() ([]byte, []byte) {
+		if trieIt.Next() && bytes.Compare(trieIt.Key, r.Limit[:]) <= 0 {
+			return trieIt.Key, trieIt.Value
+		}
+		return nil, nil
+	}
+	// Merge the snapshot entries into the trie ones, both are sorted by hash
+	snapKey, snapVal := nextSnap()
+	trieKey, trieVal := nextTrie()
+	for snapKey != nil || trieKey != nil {
+		if items >= integrityRepairChunk {
+			return entries, end, true, nil
+		}
+		items++
+
+		if trieKey == nil || (snapKey != nil && bytes.Compare(snapKey, trieKey) < 0) {
+			end = common.BytesToHash(snapKey)
+			entries = append(entries, repairEntry{hash: end})
+			snapKey, snapVal = nextSnap()
+			continue
+		}
+		val := trieVal
+		if r.Account == nil {
+			var account types.StateAccount
+			if err := rlp.DecodeBytes(trieVal, &account); err != nil {
+				return nil, common.Hash{}, false, err
+			}
+			val = SlimAccountRLP(account.Nonce, account.Balance, account.Root, account.CodeHash)
+		}
+		end = common.BytesToHash(trieKey)
+		if !bytes.Equal(snapKey, trieKey) || !bytes.Equal(snapVal, val) {
+			entries = append(entries, repairEntry{hash: end, val: common.CopyBytes(val)})
+		}
+		if bytes.Equal(snapKey, trieKey) {
+			snapKey, snapVal = nextSnap()
+		}
+		trieKey, trieVal = nextTrie()
+	}
+	if trieIt.Err != nil {
+		return nil, common.Hash{}, false, trieIt.Err
+	}
+	if err := snapIt.Error(); err != nil {
+		return nil, common.Hash{}, false, err
+	}
+	return entries, r.Limit, false, nil
+}
+
+// writeRepair writes the entries collected from the layer, unless it was
+// flattened since.
+func (dl *diskLayer) writeRepair(account *common.Hash, entries []repairEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	if dl.stale {
+		return ErrSnapshotStale
+	}
+	batch := dl.diskdb.NewBatch()
+	for _, entry := range entries {
+		if entry.val == nil {
+			if err := dl.deleteEntry(batch, account, entry.hash); err != nil {
+				return err
+			}
+		} else {
+			dl.writeEntry(batch, account, entry.hash, entry.val)
+		}
+		if batch.ValueSize() > ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	return batch.Write()
+}
+
+// writeEntry writes an account or a storage slot of the given account into the
+// snapshot, updating the cache.
+func (dl *diskLayer) writeEntry(batch ethdb.Batch, account *common.Hash, hash common.Hash, val []byte) {
+	if account == nil {
+		rawdb.WriteAccountSnapshot(batch, hash, val)
+		dl.cache.Set(hash[:], val)
+		return
+	}
+	rawdb.WriteStorageSnapshot(batch, *account, hash, val)
+	dl.cache.Set(append(account[:], hash[:]...), val)
+}
+
+// deleteEntry deletes an account along with its storage or a storage slot of
+// the given account from the snapshot, updating the cache.
+func (dl *diskLayer) deleteEntry(batch ethdb.Batch, account *common.Hash, hash common.Hash) error {
+	if account != nil {
+		rawdb.DeleteStorageSnapshot(batch, *account, hash)
+		dl.cache.Set(append(account[:], hash[:]...), nil)
+		return nil
+	}
+	rawdb.DeleteAccountSnapshot(batch, hash)
+	dl.cache.Set(hash[:], nil)
+
+	it := rawdb.IterateStorageSnapshots(dl.diskdb, hash)
+	defer it.Release()
+
+	for it.Next() {
+		key := it.Key()
+		batch.Delete(key)
+		dl.cache.Del(key[1:])
+	}
+	return it.Error()
+}
+
+// repairRange regenerates the given range on the current disk layer.
+func (t *Tree) repairRange(r IntegrityRange) error {
+	for {
+		dl, err := t.liveDiskLayer()
+		if err != nil {
+			return err
+		}
+		if err := dl.repairRange(r); !errors.Is(err, ErrSnapshotStale) {
+			return err
+		}
+	}
+}
+
+// CheckIntegrity verifies the snapshot disk layer against the state trie with
+// range proofs, in parallel account ranges and chunk by chunk, recording the
+// exact ranges found inconsistent. It can be run on a live snapshot, each chunk
+// is verified against the root of the disk layer it was read from, but the trie
+// of the disk layer must be available.
+func (t *Tree) CheckIntegrity(threads int, interrupt <-chan struct{}) (*IntegrityReport, error) {
+	return t.checkIntegrity(newIntegrityChecker(t, interrupt), threads)
+}
+
+// checkIntegrity implements CheckIntegrity with the given checker.
+func (t *Tree) checkIntegrity(checker *integrityChecker, threads int) (*IntegrityReport, error) {
+	dl, err := t.liveDiskLayer()
+	if err != nil {
+		return nil, err
+	}
+	if threads < 1 {
+		threads = 1
+	}
+	var (
+		found  = make([][]IntegrityRange, integrityTasks)
+		tasks  = make(chan int, integrityTasks)
+		errc   = make(chan error, threads)
+		start  = time.Now()
+		logged = time.NewTicker(8 * time.Second)
+	)
+	defer logged.Stop()
+
+	log.Info("Checking snapshot integrity", "root", dl.root, "threads", threads)
+	for i := 0; i < integrityTasks; i++ {
+		tasks <- i
+	}
+	close(tasks)
+	for i := 0; i < threads; i++ {
+		go func() {
+			for task := range tasks {
+				origin, limit := common.Hash{byte(task)}, maxHash
+				limit[0] = byte(task)
+
+				ranges, err := checker.checkAccounts(origin, limit)
+				if err != nil {
+					errc <- err
+					return
+				}
+				found[task] = ranges
+				checker.tasks.Add(1)
+			}
+			errc <- nil
+		}()
+	}
+	var failure error
+	for done := 0; done < threads; {
+		select {
+		case err := <-errc:
+			if err != nil && failure == nil {
+				failure = err
+				checker.abortOnce.Do(func() { close(checker.abort) })
+			}
+			done++
+		case <-logged.C:
+			log.Info("Checking snapshot integrity", "accounts", checker.accounts.Load(), "slots", checker.slots.Load(),
+				"inconsistent", checker.inconsistent.Load(), "elapsed", common.PrettyDuration(time.Since(start)))
+		}
+	}
+	if failure != nil {
+		return nil, failure
+	}
+	report := &IntegrityReport{
+		Root:     dl.root,
+		Accounts: checker.accounts.Load(),
+		Slots:    checker.slots.Load(),
+		Ranges:   []IntegrityRange{},
+	}
+	for _, ranges := range found {
+		report.Ranges = append(report.Ranges, ranges...)
+	}
+	for _, r := range report.Ranges {
+		log.Warn("Inconsistent snapshot range", append(r.logCtx(), "reason", r.Reason)...)
+	}
+	log.Info("Checked snapshot integrity", "accounts", report.Accounts, "slots", report.Slots,
+		"inconsistent", len(report.Ranges), "elapsed", common.PrettyDuration(time.Since(start)))
+	return report, nil
+}
+
+// RepairIntegrity regenerates the inconsistent ranges of the report from the
+// trie, leaving the rest of the snapshot untouched. The repaired ranges are
+// verified again afterwards: repairing an account range may reveal inconsistent
+// storage ranges of the accounts within, which are repaired in turn.
+func (t *Tree) RepairIntegrity(report *IntegrityReport, interrupt <-chan struct{}) error {
+	return t.repairIntegrity(newIntegrityChecker(t, interrupt), report)
+}
+
+// repairIntegrity implements RepairIntegrity with the given checker.
+func (t *Tree) repairIntegrity(checker *integrityChecker, report *IntegrityReport) error {
+	pending := report.Ranges
+	for round := 0; len(pending) > 0; round++ {
+		if round == integrityRepairRounds {
+			return fmt.Errorf("%d snapshot ranges still inconsistent after repair", len(pending))
+		}
+		var next []IntegrityRange
+		for _, r := range pending {
+			if checker.interrupted() {
+				return errIntegrityInterrupted
+			}
+			if err := t.repairRange(r); err != nil {
+				return err
+			}
+			report.Repaired++
+			checker.repaired.Add(1)
+
+			ranges, err := checker.checkRange(r)
+			if err != nil {
+				return err
+			}
+			next = append(next, ranges...)
+		}
+		pending = next
+	}
+	return nil
+}
+
+// IntegrityProgress is the status of a background integrity check.
+type IntegrityProgress struct {
+	Phase        string      `json:"phase"`
+	Root         common.Hash `json:"root"`         // Root of the disk layer the check started at
+	Progress     float64     `json:"progress"`     // Checked portion of the account ranges
+	Accounts     uint64      `json:"accounts"`     // Number of accounts checked
+	Slots        uint64      `json:"slots"`        // Number of storage slots checked
+	Inconsistent uint64      `json:"inconsistent"` // Number of inconsistent ranges found
+	Repaired     uint64      `json:"repaired"`     // Number of ranges repaired
+	Started      time.Time   `json:"started,omitempty"`
+	Error        string      `json:"error,omitempty"` // Failure of the last check
+}
+
+// IntegrityJob runs integrity checks of the snapshot in the background, along
+// with the repair of the inconsistent ranges if requested, while the node keeps
+// running. Only one check runs at a time, the status of the last one and its
+// report are retained once finished.
+type IntegrityJob struct {
+	tree *Tree
+
+	checker *integrityChecker // Checker of the running check, nil if idle
+	status  IntegrityProgress
+	report  *IntegrityReport // Report of the last finished check
+	lock    sync.Mutex
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewIntegrityJob creates the background integrity checker of the given tree.
+func NewIntegrityJob(tree *Tree) *IntegrityJob {
+	return &IntegrityJob{
+		tree:   tree,
+		status: IntegrityProgress{Phase: IntegrityPhaseIdle},
+		quit:   make(chan struct{}),
+	}
+}
+
+// Start begins checking the snapshot with the given number of threads, and
+// returns once the check runs in the background. If requested, the ranges found
+// inconsistent are repaired afterwards.
+func (j *IntegrityJob) Start(threads int, repair bool) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if j.checker != nil {
+		return errIntegrityRunning
+	}
+	dl, err := j.tree.liveDiskLayer()
+	if err != nil {
+		return err
+	}
+	checker := newIntegrityChecker(j.tree, j.quit)
+	j.checker = checker
+	j.status = IntegrityProgress{Phase: IntegrityPhaseCheck, Root: dl.root, Started: time.Now()}
+
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+
+		report, err := j.tree.checkIntegrity(checker, threads)
+		if err == nil && repair && len(report.Ranges) > 0 {
+			j.lock.Lock()
+			j.status.Phase = IntegrityPhaseRepair
+			j.lock.Unlock()
+
+			err = j.tree.repairIntegrity(checker, report)
+		}
+		j.lock.Lock()
+		defer j.lock.Unlock()
+
+		j.fill(&j.status)
+		j.status.Phase = IntegrityPhaseIdle
+		if err != nil {
+			j.status.Error = err.Error()
+		}
+		j.checker, j.report = nil, report
+	}()
+	return nil
+}
+
+// Stop interrupts the running check, if any.
+func (j *IntegrityJob) Stop() {
+	close(j.quit)
+	j.wg.Wait()
+}
+
+// Progress returns the status of the running check, or of the last one if
+// none is running.
+func (j *IntegrityJob) Progress() *IntegrityProgress {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	status := j.status
+	j.fill(&status)
+	return &status
+}
+
+// Report returns the report of the last finished check. In case the repair of
+// the check failed, the report covers the ranges repaired until then.
+func (j *IntegrityJob) Report() (*IntegrityReport, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if j.report == nil {
+		return nil, errNoIntegrityReport
+	}
+	return j.report, nil
+}
+
+// fill updates the counters of the status from the running checker, if any.
+// The lock is expected to be held.
+func (j *IntegrityJob) fill(status *IntegrityProgress) {
+	if j.checker == nil {
+		return
+	}
+	status.Progress = float64(j.checker.tasks.Load()) / integrityTasks
+	status.Accounts = j.checker.accounts.Load()
+	status.Slots = j.checker.slots.Load()
+	status.Inconsistent = j.checker.inconsistent.Load()
+	status.Repaired = j.checker.repaired.Load()
+}
@@ -0,0 +1,293 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// Tests that the integrity checker finds the exact inconsistent ranges of the
+// snapshot, and that repairing them restores the snapshot.
+func TestCheckRepairIntegrity(t *testing.T) {
+	tree, root := newExportTestTree(t)
+	dl := tree.disklayer()
+
+	// A sound snapshot should pass the check
+	report, err := tree.CheckIntegrity(4, nil)
+	if err != nil {
+		t.Fatalf("failed to check snapshot: %v", err)
+	}
+	if len(report.Ranges) != 0 {
+		t.Fatalf("sound snapshot reported inconsistent: %v", report.Ranges)
+	}
+	if report.Accounts != 200 {
+		t.Errorf("checked account count mismatch: have %d, want %d", report.Accounts, 200)
+	}
+	// Corrupt accounts as well as storage slots
+	var (
+		modified = hashData([]byte("acc-5"))
+		deleted  = hashData([]byte("acc-7"))
+		extra    = hashData([]byte("acc-extra"))
+		contract = hashData([]byte("acc-190"))
+		missing  = hashData([]byte("acc-20"))
+		dangling = hashData([]byte("acc-3"))
+	)
+	original := rawdb.ReadAccountSnapshot(tree.diskdb, modified)
+	rawdb.WriteAccountSnapshot(tree.diskdb, modified, SlimAccountRLP(5, big.NewInt(1000), types.EmptyRootHash, types.EmptyCodeHash[:]))
+	rawdb.DeleteAccountSnapshot(tree.diskdb, deleted)
+	rawdb.WriteAccountSnapshot(tree.diskdb, extra, SlimAccountRLP(1, big.NewInt(1), common.Hash{0x01}, types.EmptyCodeHash[:]))
+	rawdb.WriteStorageSnapshot(tree.diskdb, extra, hashData([]byte("key-1")), []byte("val-1"))
+	rawdb.WriteStorageSnapshot(tree.diskdb, contract, hashData([]byte("key-1500")), []byte("bad"))
+	rawdb.DeleteStorageSnapshot(tree.diskdb, missing, hashData([]byte("key-3")))
+	rawdb.WriteStorageSnapshot(tree.diskdb, dangling, hashData([]byte("key-1")), []byte("val-1"))
+	dl.cache.Reset()
+
+	report, err = tree.CheckIntegrity(4, nil)
+	if err != nil {
+		t.Fatalf("failed to check snapshot: %v", err)
+	}
+	reported := func(account *common.Hash, hash common.Hash) bool {
+		for _, r := range report.Ranges {
+			if (r.Account == nil) != (account == nil) || (account != nil && *r.Account != *account) {
+				continue
+			}
+			if bytes.Compare(r.Origin[:], hash[:]) <= 0 && bytes.Compare(hash[:], r.Limit[:]) <= 0 {
+				return true
+			}
+		}
+		return false
+	}
+	for _, hash := range []common.Hash{modified, deleted, extra} {
+		if !reported(nil, hash) {
+			t.Errorf("inconsistent account %x not reported", hash)
+		}
+	}
+	if !reported(&contract, hashData([]byte("key-1500"))) {
+		t.Errorf("inconsistent slot not reported")
+	}
+	if !reported(&missing, hashData([]byte("key-3"))) {
+		t.Errorf("missing slot not reported")
+	}
+	if !reported(&dangling, hashData([]byte("key-1"))) {
+		t.Errorf("dangling slot not reported")
+	}
+	if len(report.Ranges) != 6 {
+		t.Fatalf("inconsistent range count mismatch: have %d, want %d", len(report.Ranges), 6)
+	}
+	// Repair the ranges and ensure the snapshot is sound again
+	if err := tree.RepairIntegrity(report, nil); err != nil {
+		t.Fatalf("failed to repair snapshot: %v", err)
+	}
+	if report.Repaired != 6 {
+		t.Errorf("repaired range count mismatch: have %d, want %d", report.Repaired, 6)
+	}
+	if blob := rawdb.ReadStorageSnapshot(tree.diskdb, dangling, hashData([]byte("key-1"))); len(blob) != 0 {
+		t.Errorf("dangling slot not deleted: %x", blob)
+	}
+	if blob, _ := dl.AccountRLP(modified); !bytes.Equal(blob, original) {
+		t.Errorf("repaired account mismatch: have %x, want %x", blob, original)
+	}
+	checkSnapRoot(t, dl, root)
+
+	report, err = tree.CheckIntegrity(4, nil)
+	if err != nil {
+		t.Fatalf("failed to check snapshot: %v", err)
+	}
+	if len(report.Ranges) != 0 {
+		t.Fatalf("repaired snapshot reported inconsistent: %v", report.Ranges)
+	}
+}
+
+// Tests that a range is repaired chunk by chunk, and that a chunk collected from
+// a layer flattened meanwhile isn't written.
+func TestRepairIntegrityChunked(t *testing.T) {
+	defer func(old int) { integrityRepairChunk = old }(integrityRepairChunk)
+	integrityRepairChunk = 3
+
+	tree, root := newExportTestTree(t)
+	dl := tree.disklayer()
+
+	// Corrupt accounts all over the key space
+	for i := 0; i < 200; i += 10 {
+		rawdb.DeleteAccountSnapshot(tree.diskdb, hashData([]byte(fmt.Sprintf("acc-%d", i))))
+		rawdb.WriteAccountSnapshot(tree.diskdb, hashData([]byte(fmt.Sprintf("acc-%d", i+1))), []byte("bad"))
+		rawdb.WriteAccountSnapshot(tree.diskdb, hashData([]byte(fmt.Sprintf("acc-extra-%d", i))), []byte("extra"))
+	}
+	dl.cache.Reset()
+
+	// A chunk collected before the layer is flattened is dropped
+	var (
+		r       = IntegrityRange{Origin: common.Hash{}, Limit: maxHash}
+		origin  = r.Origin
+		entries []repairEntry
+	)
+	for len(entries) == 0 {
+		chunk, end, more, err := dl.collectRepair(r, origin)
+		if err != nil {
+			t.Fatalf("failed to collect repairs: %v", err)
+		}
+		if !more {
+			t.Fatalf("range not split into chunks")
+		}
+		entries, origin = chunk, incHash(end)
+	}
+	dl.lock.Lock()
+	dl.stale = true
+	dl.lock.Unlock()
+	if err := dl.writeRepair(r.Account, entries); !errors.Is(err, ErrSnapshotStale) {
+		t.Fatalf("stale layer error mismatch: have %v, want %v", err, ErrSnapshotStale)
+	}
+	for _, entry := range entries {
+		if blob := rawdb.ReadAccountSnapshot(tree.diskdb, entry.hash); bytes.Equal(blob, entry.val) {
+			t.Fatalf("stale chunk written: %x", entry.hash)
+		}
+	}
+	dl.lock.Lock()
+	dl.stale = false
+	dl.lock.Unlock()
+
+	// Repairing the whole range restores the snapshot
+	if err := dl.repairRange(r); err != nil {
+		t.Fatalf("failed to repair range: %v", err)
+	}
+	checkSnapRoot(t, dl, root)
+}
+
+// Tests that a check moves on to the new disk layer if the one it was reading
+// from is flattened meanwhile, even if the trie of the old one is pruned.
+func TestCheckIntegrityFlatten(t *testing.T) {
+	defer func(old int) { accountCheckRange = old }(accountCheckRange)
+	accountCheckRange = 16
+
+	tree, root := newExportTestTree(t)
+	stale := tree.disklayer()
+	stale.genAbort = nil // Generator stopped already, don't abort it on flattening
+	checker := newIntegrityChecker(tree, nil)
+
+	// Check the first chunk of accounts on the current disk layer
+	ranges, end, more, err := checker.checkAccountChunk(stale, common.Hash{}, maxHash)
+	if err != nil {
+		t.Fatalf("failed to check chunk: %v", err)
+	}
+	if len(ranges) != 0 || !more {
+		t.Fatalf("unexpected chunk check result: ranges %v, more %v", ranges, more)
+	}
+	// Flatten a new state into the disk layer and prune the old trie root
+	tr, err := trie.NewStateTrie(trie.StateTrieID(root), tree.triedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, _ := rlp.EncodeToBytes(&Account{Nonce: 1, Balance: big.NewInt(100), Root: types.EmptyRootHash[:], CodeHash: types.EmptyCodeHash[:]})
+	tr.Update([]byte("acc-1"), val)
+	newRoot, nodes := tr.Commit(true)
+	if err := tree.triedb.Update(trie.NewWithNodeSet(nodes)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.triedb.Commit(newRoot, false); err != nil {
+		t.Fatal(err)
+	}
+	accounts := map[common.Hash][]byte{
+		hashData([]byte("acc-1")): SlimAccountRLP(1, big.NewInt(100), types.EmptyRootHash, types.EmptyCodeHash[:]),
+	}
+	if err := tree.Update(newRoot, root, nil, accounts, nil); err != nil {
+		t.Fatalf("failed to create diff layer: %v", err)
+	}
+	if err := tree.Cap(newRoot, 0); err != nil {
+		t.Fatalf("failed to flatten diff layer: %v", err)
+	}
+	rawdb.DeleteLegacyTrieNode(tree.diskdb, root)
+
+	// Verifying against the pruned trie reports the layer stale, not an error
+	origin := incHash(end)
+	if _, err := verifyTrieRange(stale, trie.StateTrieID(root), origin, maxHash, nil, nil); !errors.Is(err, ErrSnapshotStale) {
+		t.Fatalf("pruned trie error mismatch: have %v, want %v", err, ErrSnapshotStale)
+	}
+	// The rest of the accounts are checked on the new disk layer
+	ranges, err = checker.checkAccounts(origin, maxHash)
+	if err != nil {
+		t.Fatalf("failed to check accounts: %v", err)
+	}
+	if len(ranges) != 0 {
+		t.Fatalf("flattened snapshot reported inconsistent: %v", ranges)
+	}
+	report, err := tree.CheckIntegrity(4, nil)
+	if err != nil {
+		t.Fatalf("failed to check snapshot: %v", err)
+	}
+	if report.Root != newRoot || len(report.Ranges) != 0 {
+		t.Fatalf("unexpected report: root %x, ranges %v", report.Root, report.Ranges)
+	}
+}
+
+// Tests that a background integrity check reports its progress, and repairs
+// the inconsistent ranges if requested.
+func TestIntegrityJob(t *testing.T) {
+	tree, _ := newExportTestTree(t)
+	job := NewIntegrityJob(tree)
+	defer job.Stop()
+
+	if _, err := job.Report(); !errors.Is(err, errNoIntegrityReport) {
+		t.Fatalf("report error mismatch: have %v, want %v", err, errNoIntegrityReport)
+	}
+	account := hashData([]byte("acc-5"))
+	rawdb.WriteAccountSnapshot(tree.diskdb, account, SlimAccountRLP(5, big.NewInt(1000), types.EmptyRootHash, types.EmptyCodeHash[:]))
+	tree.disklayer().cache.Reset()
+
+	if err := job.Start(4, true); err != nil {
+		t.Fatalf("failed to start check: %v", err)
+	}
+	var progress *IntegrityProgress
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if progress = job.Progress(); progress.Phase == IntegrityPhaseIdle {
+			break
+		}
+		if time.Since(start) > time.Minute {
+			t.Fatalf("check not finished, phase %s", progress.Phase)
+		}
+	}
+	if progress.Error != "" || progress.Progress != 1 || progress.Accounts < 200 {
+		t.Errorf("unexpected progress: %+v", progress)
+	}
+	if progress.Inconsistent != 1 || progress.Repaired != 1 {
+		t.Errorf("range counts mismatch: inconsistent %d, repaired %d", progress.Inconsistent, progress.Repaired)
+	}
+	report, err := job.Report()
+	if err != nil {
+		t.Fatalf("failed to retrieve report: %v", err)
+	}
+	if len(report.Ranges) != 1 || report.Repaired != 1 {
+		t.Fatalf("unexpected report: ranges %v, repaired %d", report.Ranges, report.Repaired)
+	}
+	report, err = tree.CheckIntegrity(4, nil)
+	if err != nil {
+		t.Fatalf("failed to check snapshot: %v", err)
+	}
+	if len(report.Ranges) != 0 {
+		t.Fatalf("repaired snapshot reported inconsistent: %v", report.Ranges)
+	}
+}
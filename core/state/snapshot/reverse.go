@@ -0,0 +1,200 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// ReverseDiff is the set of the original values of the accounts and storage
+// slots modified by a diff layer, reverting the state of the layer to the one
+// of its parent. Missing accounts and slots are denoted by empty values.
+//
+// The storage of the accounts destructed by the layer is included entirely.
+type ReverseDiff struct {
+	Parent   common.Hash                            // Root of the state reverted to
+	Root     common.Hash                            // Root of the state reverted
+	Accounts map[common.Hash][]byte                 // Original accounts in the slim format
+	Storage  map[common.Hash]map[common.Hash][]byte // Original storage slots of the accounts
+}
+
+// DiffHook is called with the reverse diff of every diff layer about to be
+// flattened, in the order of the layers. It's invoked with the tree lock held,
+// so it must not access the tree.
+type DiffHook func(diff *ReverseDiff)
+
+// SetDiffHook registers the hook invoked with the reverse diffs of the diff
+// layers being flattened. The layers flattened while the snapshot is being
+// generated are not reported, as their original values are unknown.
+func (t *Tree) SetDiffHook(hook DiffHook) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.diffHook = hook
+}
+
+// reportDiffs invokes the diff hook with the reverse diffs of the given layer
+// and of all its unreported ancestors, from the bottom up. It must be called
+// with the tree lock held, before the layers are flattened.
+func (t *Tree) reportDiffs(dl *diffLayer) {
+	if t.diffHook == nil {
+		return
+	}
+	var layers []*diffLayer
+	for {
+		if dl.reported {
+			break
+		}
+		layers = append(layers, dl)
+
+		parent, ok := dl.parent.(*diffLayer)
+		if !ok {
+			break
+		}
+		dl = parent
+	}
+	for i := len(layers) - 1; i >= 0; i-- {
+		diff, err := reverseDiff(layers[i])
+		if err != nil {
+			log.Debug("Skipped reverse state diff", "root", layers[i].root, "err", err)
+		} else {
+			t.diffHook(diff)
+		}
+		layers[i].reported = true
+	}
+}
+
+// reverseDiff gathers the original values of the data modified by the given
+// diff layer from its parent.
+func reverseDiff(dl *diffLayer) (*ReverseDiff, error) {
+	dl.origin.lock.RLock()
+	generating := dl.origin.genMarker != nil
+	dl.origin.lock.RUnlock()
+	if generating {
+		return nil, ErrNotCoveredYet
+	}
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	var (
+		parent = dl.parent
+		diff   = &ReverseDiff{
+			Parent:   parent.Root(),
+			Root:     dl.root,
+			Accounts: make(map[common.Hash][]byte),
+			Storage:  make(map[common.Hash]map[common.Hash][]byte),
+		}
+	)
+	account := func(hash common.Hash) error {
+		if _, ok := diff.Accounts[hash]; ok {
+			return nil
+		}
+		blob, err := parent.AccountRLP(hash)
+		if err != nil {
+			return err
+		}
+		diff.Accounts[hash] = blob
+		return nil
+	}
+	slot := func(accountHash, storageHash common.Hash) error {
+		slots := diff.Storage[accountHash]
+		if slots == nil {
+			slots = make(map[common.Hash][]byte)
+			diff.Storage[accountHash] = slots
+		}
+		if _, ok := slots[storageHash]; ok {
+			return nil
+		}
+		blob, err := parent.Storage(accountHash, storageHash)
+		if err != nil {
+			return err
+		}
+		slots[storageHash] = blob
+		return nil
+	}
+	for hash := range dl.destructSet {
+		if err := account(hash); err != nil {
+			return nil, err
+		}
+		slots, err := storageSlots(parent, hash)
+		if err != nil {
+			return nil, err
+		}
+		for storageHash := range slots {
+			if err := slot(hash, storageHash); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for hash := range dl.accountData {
+		if err := account(hash); err != nil {
+			return nil, err
+		}
+	}
+	for accountHash, storage := range dl.storageData {
+		for storageHash := range storage {
+			if err := slot(accountHash, storageHash); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return diff, nil
+}
+
+// storageSlots returns the hashes of all the storage slots of the account in
+// the state of the given layer.
+func storageSlots(layer snapshot, account common.Hash) (map[common.Hash]struct{}, error) {
+	switch layer := layer.(type) {
+	case *diskLayer:
+		slots := make(map[common.Hash]struct{})
+		it := rawdb.IterateStorageSnapshots(layer.diskdb, account)
+		defer it.Release()
+		for it.Next() {
+			slots[common.BytesToHash(it.Key()[len(rawdb.SnapshotStoragePrefix)+common.HashLength:])] = struct{}{}
+		}
+		return slots, it.Error()
+
+	case *diffLayer:
+		layer.lock.RLock()
+		_, destructed := layer.destructSet[account]
+		storage := layer.storageData[account]
+		layer.lock.RUnlock()
+
+		slots := make(map[common.Hash]struct{})
+		if !destructed {
+			var err error
+			if slots, err = storageSlots(layer.parent, account); err != nil {
+				return nil, err
+			}
+		}
+		for hash, data := range storage {
+			if len(data) > 0 {
+				slots[hash] = struct{}{}
+			} else {
+				delete(slots, hash)
+			}
+		}
+		return slots, nil
+
+	default:
+		return nil, fmt.Errorf("unknown data layer: %T", layer)
+	}
+}
@@ -91,6 +91,10 @@ var (
 	// while the generation is not finished yet.
 	ErrNotConstructed = errors.New("snapshot is not constructed")
 
+	// ErrGenerationAborted is returned if the trie generation from the snapshot
+	// is interrupted.
+	ErrGenerationAborted = errors.New("trie generation aborted")
+
 	// errSnapshotCycle is returned if a snapshot is attempted to be inserted
 	// that forms a cycle in the snapshot tree.
 	errSnapshotCycle = errors.New("snapshot cycle")
@@ -170,8 +174,11 @@ type Tree struct {
 	diskdb ethdb.KeyValueStore      // Persistent database to store the snapshot
 	triedb *trie.Database           // In-memory cache to access the trie through
 	layers map[common.Hash]snapshot // Collection of all known layers
+	held   common.Hash              // Root of the layer kept from flattening, empty if none
 	lock   sync.RWMutex
 
+	diffHook DiffHook // Hook invoked with the reverse diffs of the flattened layers
+
 	// Test hooks
 	onFlatten func() // Hook invoked when the bottom most diff layers are flattened
 }
@@ -400,6 +407,8 @@ func (t *Tree) Cap(root common.Hash, layers int) error {
 	// child for the capping and then remove it.
 	if layers == 0 {
 		// If full commit was requested, flatten the diffs and merge onto disk
+		t.reportDiffs(diff)
+
 		diff.lock.RLock()
 		base := diffToDisk(diff.flatten().(*diffLayer))
 		diff.lock.RUnlock()
@@ -408,6 +417,18 @@ func (t *Tree) Cap(root common.Hash, layers int) error {
 		t.layers = map[common.Hash]snapshot{base.root: base}
 		return nil
 	}
+	// Keep all the diff layers while a layer is held, unless they outgrow the
+	// allowance. The held layer will be reported stale to its iterators then.
+	if t.held != (common.Hash{}) {
+		if _, ok := t.layers[t.held]; !ok {
+			t.held = common.Hash{}
+		} else if memory := diffMemory(diff); memory < holdMemoryLimit {
+			return nil
+		} else {
+			log.Warn("Released snapshot hold, diff layers too large", "root", t.held, "memory", common.StorageSize(memory))
+			t.held = common.Hash{}
+		}
+	}
 	persisted := t.cap(diff, layers)
 
 	// Remove any layer that is stale or links into a stale layer
@@ -476,6 +497,9 @@ func (t *Tree) cap(diff *diffLayer, layers int) *diskLayer {
 		return nil
 
 	case *diffLayer:
+		// Report the layers about to be flattened while their parents are intact
+		t.reportDiffs(parent)
+
 		// Hold the write lock until the flattened parent is linked correctly.
 		// Otherwise, the stale layer may be accessed by external reads in the
 		// meantime.
@@ -516,6 +540,57 @@ func (t *Tree) cap(diff *diffLayer, layers int) *diskLayer {
 	return base
 }
 
+// diffMemory returns the memory used by the given diff layer and all the diff
+// layers below it.
+func diffMemory(diff *diffLayer) uint64 {
+	var memory uint64
+	for {
+		memory += diff.memory
+		parent, ok := diff.parent.(*diffLayer)
+		if !ok {
+			return memory
+		}
+		diff = parent
+	}
+}
+
+// Hold stops flattening the diff layers until Release is called, so that the
+// layer with the given root stays iterable for a long time while the chain
+// keeps progressing. The hold is dropped once the diff layers outgrow the
+// allowance of holdMemoryLimit, and the held layer turns stale. Only a single
+// layer can be held at a time, and the snapshot must be fully generated.
+func (t *Tree) Hold(root common.Hash) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.held != (common.Hash{}) {
+		return fmt.Errorf("snapshot [%#x] already held", t.held)
+	}
+	if _, ok := t.layers[root]; !ok {
+		return fmt.Errorf("snapshot [%#x] missing", root)
+	}
+	disk := t.disklayer()
+	disk.lock.RLock()
+	generating := disk.genMarker != nil
+	disk.lock.RUnlock()
+	if generating {
+		return ErrNotConstructed
+	}
+	t.held = root
+	return nil
+}
+
+// Release drops the hold of the layer with the given root, letting the diff
+// layers be flattened again at the next cap.
+func (t *Tree) Release(root common.Hash) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.held == root {
+		t.held = common.Hash{}
+	}
+}
+
 // diffToDisk merges a bottom-most diff into the persistent disk layer underneath
 // it. The method will panic if called onto a non-bottom-most diff layer.
 //
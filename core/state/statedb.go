@@ -27,6 +27,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state/snapshot"
+	"github.com/ethereum/go-ethereum/core/stateless"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
@@ -102,6 +103,9 @@ type StateDB struct {
 	// Transient storage
 	transientStorage transientStorage
 
+	// Execution witness collecting the state accessed, if enabled
+	witness *stateless.Witness
+
 	// Journal of state modifications. This is the backbone of
 	// Snapshot and RevertToSnapshot.
 	journal        *journal
@@ -630,6 +634,10 @@ func (s *StateDB) createObject(addr common.Address) (newobj, prev *stateObject)
 		}
 	}
 	newobj = newObject(s, addr, types.StateAccount{})
+	if prev != nil && prev.trie != nil && s.witness != nil {
+		// The storage trie of the replaced object is lost, collect its nodes
+		s.witness.AddState(prev.trie.Witness())
+	}
 	if prev == nil {
 		s.journal.append(createObjectChange{account: &addr})
 	} else {
@@ -939,7 +947,31 @@ func (s *StateDB) IntermediateRoot(deleteEmptyObjects bool) common.Hash {
 	if metrics.EnabledExpensive {
 		defer func(start time.Time) { s.AccountHashes += time.Since(start) }(time.Now())
 	}
-	return s.trie.Hash()
+	root := s.trie.Hash()
+
+	// Collect all the trie nodes resolved so far, including the ones needed to
+	// restructure the tries on deletions, into the witness
+	if s.witness != nil {
+		s.witness.AddState(s.trie.Witness())
+		for _, obj := range s.stateObjects {
+			if obj.trie != nil {
+				s.witness.AddState(obj.trie.Witness())
+			}
+		}
+	}
+	return root
+}
+
+// SetWitness enables collecting the state accessed into the given execution
+// witness. It has to be called before any state is accessed, and the state
+// should be opened without a snapshot for all the trie nodes to be reached.
+func (s *StateDB) SetWitness(witness *stateless.Witness) {
+	s.witness = witness
+}
+
+// Witness returns the execution witness being collected, if any.
+func (s *StateDB) Witness() *stateless.Witness {
+	return s.witness
 }
 
 // SetTxContext sets the current transaction hash and index which are
@@ -1073,7 +1105,7 @@ func (s *StateDB) Commit(deleteEmptyObjects bool) (common.Hash, error) {
 	}
 	if root != origin {
 		start := time.Now()
-		if err := s.db.TrieDB().Update(nodes); err != nil {
+		if err := s.db.TrieDB().UpdateState(root, origin, nodes); err != nil {
 			return common.Hash{}, err
 		}
 		s.originalRoot = root
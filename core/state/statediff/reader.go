@@ -0,0 +1,355 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package statediff
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// readBatchBytes is the maximum size of the reverse diffs read from the freezer
+// in a single batch.
+const readBatchBytes = 16 * 1024 * 1024
+
+// errReadOnly is returned when a historical state is asked for a proof or for
+// an iteration, which are not supported by the flat state diffs.
+var errReadOnly = errors.New("not supported by historical state")
+
+// stateReader serves the accounts and storage slots of a historical state. It
+// rolls back the latest recorded state, read from the snapshot, by the original
+// values in the reverse diffs of the blocks in between. As the chain progresses
+// and the base snapshot layer turns stale, the diffs of the newly recorded
+// blocks are rolled back as well.
+type stateReader struct {
+	store  *Store
+	number uint64      // Number of the block of the served state
+	root   common.Hash // Root of the served state
+
+	head     uint64      // Number of the block of the base state
+	headRoot common.Hash // Root of the base state, read from the snapshot
+	accounts map[common.Hash][]byte
+	storage  map[common.Hash]map[common.Hash][]byte
+	lock     sync.Mutex
+}
+
+func newStateReader(store *Store, number uint64, root common.Hash) *stateReader {
+	return &stateReader{
+		store:    store,
+		number:   number,
+		root:     root,
+		head:     number,
+		headRoot: root,
+		accounts: make(map[common.Hash][]byte),
+		storage:  make(map[common.Hash]map[common.Hash][]byte),
+	}
+}
+
+// extend rolls back the diffs recorded since the last call, moving the base to
+// the latest recorded state. It reports whether the base moved.
+func (r *stateReader) extend() (bool, error) {
+	s := r.store
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	first, last, ok := s.stateRange()
+	if !ok || r.head < first || last < r.head {
+		return false, fmt.Errorf("%w: block #%d", errUnavailable, r.number)
+	}
+	if last == r.head {
+		if s.root != r.headRoot {
+			return false, fmt.Errorf("%w: block #%d", errUnavailable, r.number)
+		}
+		return false, nil
+	}
+	for next := r.head + 1; next <= last; {
+		blobs, err := rawdb.ReadStateDiffs(s.freezer, next-s.offset, last-next+1, readBatchBytes)
+		if err != nil {
+			return false, err
+		}
+		for _, blob := range blobs {
+			diff := new(item)
+			if err := rlp.DecodeBytes(blob, diff); err != nil {
+				return false, err
+			}
+			if diff.Number != next {
+				return false, fmt.Errorf("state diff of block #%d mismatch: have #%d", next, diff.Number)
+			}
+			// Ensure the diffs link to the served block
+			if next == r.number+1 {
+				if header := s.chain.GetHeaderByNumber(next); header == nil || header.Hash() != diff.Hash {
+					return false, fmt.Errorf("%w: state diff of block #%d not canonical", errUnavailable, next)
+				}
+			}
+			r.apply(diff)
+			next++
+		}
+	}
+	r.head, r.headRoot = last, s.root
+	return true, nil
+}
+
+// apply rolls back the given diff, keeping the values of the lower blocks.
+func (r *stateReader) apply(diff *item) {
+	for _, account := range diff.Accounts {
+		if _, ok := r.accounts[account.Hash]; !ok {
+			r.accounts[account.Hash] = account.Blob
+		}
+	}
+	for _, storage := range diff.Storage {
+		slots := r.storage[storage.Account]
+		if slots == nil {
+			slots = make(map[common.Hash][]byte)
+			r.storage[storage.Account] = slots
+		}
+		for _, slot := range storage.Slots {
+			if _, ok := slots[slot.Hash]; !ok {
+				slots[slot.Hash] = slot.Blob
+			}
+		}
+	}
+}
+
+// read retrieves a value with the given getter, from the rolled back diffs or
+// from the base snapshot, moving the base if it turned stale meanwhile.
+func (r *stateReader) read(diffed func() ([]byte, bool), base func(snap snapshot.Snapshot) ([]byte, error)) ([]byte, error) {
+	for {
+		r.lock.Lock()
+		blob, ok := diffed()
+		root := r.headRoot
+		r.lock.Unlock()
+		if ok {
+			return blob, nil
+		}
+		if snap := r.store.snaps.Snapshot(root); snap != nil {
+			blob, err := base(snap)
+			if !errors.Is(err, snapshot.ErrSnapshotStale) {
+				return blob, err
+			}
+		}
+		r.lock.Lock()
+		moved, err := r.extend()
+		r.lock.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		if !moved {
+			return nil, fmt.Errorf("%w: snapshot %x missing", errUnavailable, root)
+		}
+	}
+}
+
+// account retrieves the account with the given hash in the slim format.
+func (r *stateReader) account(hash common.Hash) ([]byte, error) {
+	return r.read(func() ([]byte, bool) {
+		blob, ok := r.accounts[hash]
+		return blob, ok
+	}, func(snap snapshot.Snapshot) ([]byte, error) {
+		return snap.AccountRLP(hash)
+	})
+}
+
+// slot retrieves the storage slot of the given account.
+func (r *stateReader) slot(account, hash common.Hash) ([]byte, error) {
+	return r.read(func() ([]byte, bool) {
+		blob, ok := r.storage[account][hash]
+		return blob, ok
+	}, func(snap snapshot.Snapshot) ([]byte, error) {
+		return snap.Storage(account, hash)
+	})
+}
+
+// stateDatabase opens the tries of a historical state, served by a state reader
+// instead of the trie nodes. The contract codes are retrieved from the wrapped
+// database.
+type stateDatabase struct {
+	state.Database
+	reader *stateReader
+}
+
+// OpenTrie opens the account trie of the historical state.
+func (db *stateDatabase) OpenTrie(root common.Hash) (state.Trie, error) {
+	if root != db.reader.root {
+		return nil, fmt.Errorf("%w: state %x", errUnavailable, root)
+	}
+	return newStateTrie(db, root, false, common.Hash{}), nil
+}
+
+// OpenStorageTrie opens the storage trie of an account of the historical state.
+func (db *stateDatabase) OpenStorageTrie(stateRoot common.Hash, addrHash, root common.Hash) (state.Trie, error) {
+	if stateRoot != db.reader.root {
+		return nil, fmt.Errorf("%w: state %x", errUnavailable, stateRoot)
+	}
+	return newStateTrie(db, root, true, addrHash), nil
+}
+
+// CopyTrie returns an independent copy of the given trie.
+func (db *stateDatabase) CopyTrie(t state.Trie) state.Trie {
+	switch t := t.(type) {
+	case *stateTrie:
+		cpy := newStateTrie(t.db, t.root, t.storage, t.owner)
+		for key, blob := range t.dirty {
+			cpy.dirty[key] = blob
+		}
+		return cpy
+	default:
+		panic(fmt.Errorf("unknown trie type %T", t))
+	}
+}
+
+// stateTrie is a flat view of an account or storage trie of a historical state.
+// The modifications are kept in memory, never committed. As the root of the
+// modified trie can't be computed, the empty hash is reported instead.
+type stateTrie struct {
+	db      *stateDatabase
+	root    common.Hash // Original root of the trie
+	storage bool        // Whether the trie is a storage one
+	owner   common.Hash // Hash of the account owning the storage trie
+	dirty   map[common.Hash][]byte
+}
+
+func newStateTrie(db *stateDatabase, root common.Hash, storage bool, owner common.Hash) *stateTrie {
+	return &stateTrie{
+		db:      db,
+		root:    root,
+		storage: storage,
+		owner:   owner,
+		dirty:   make(map[common.Hash][]byte),
+	}
+}
+
+// GetKey returns the preimage of a hashed key, if known.
+func (t *stateTrie) GetKey(key []byte) []byte {
+	return rawdb.ReadPreimage(t.db.DiskDB(), common.BytesToHash(key))
+}
+
+// get retrieves the raw value of the given hashed key.
+func (t *stateTrie) get(hash common.Hash) ([]byte, error) {
+	if blob, ok := t.dirty[hash]; ok {
+		return blob, nil
+	}
+	if t.storage {
+		return t.db.reader.slot(t.owner, hash)
+	}
+	return t.db.reader.account(hash)
+}
+
+// TryGet returns the value of the key, the account ones in the consensus format.
+func (t *stateTrie) TryGet(key []byte) ([]byte, error) {
+	blob, err := t.get(crypto.Keccak256Hash(key))
+	if err != nil || t.storage || len(blob) == 0 {
+		return blob, err
+	}
+	return snapshot.FullAccountRLP(blob)
+}
+
+// TryGetAccount returns the account with the given address, nil if missing.
+func (t *stateTrie) TryGetAccount(address common.Address) (*types.StateAccount, error) {
+	blob, err := t.get(crypto.Keccak256Hash(address.Bytes()))
+	if err != nil || len(blob) == 0 {
+		return nil, err
+	}
+	account, err := snapshot.FullAccount(blob)
+	if err != nil {
+		return nil, err
+	}
+	return &types.StateAccount{
+		Nonce:    account.Nonce,
+		Balance:  account.Balance,
+		Root:     common.BytesToHash(account.Root),
+		CodeHash: account.CodeHash,
+	}, nil
+}
+
+// TryUpdate sets the value of the key in memory.
+func (t *stateTrie) TryUpdate(key, value []byte) error {
+	t.dirty[crypto.Keccak256Hash(key)] = common.CopyBytes(value)
+	return nil
+}
+
+// TryUpdateAccount sets the account in memory.
+func (t *stateTrie) TryUpdateAccount(address common.Address, account *types.StateAccount) error {
+	t.dirty[crypto.Keccak256Hash(address.Bytes())] = snapshot.SlimAccountRLP(account.Nonce, account.Balance, account.Root, account.CodeHash)
+	return nil
+}
+
+// TryDelete removes the key in memory.
+func (t *stateTrie) TryDelete(key []byte) error {
+	t.dirty[crypto.Keccak256Hash(key)] = nil
+	return nil
+}
+
+// TryDeleteAccount removes the account in memory.
+func (t *stateTrie) TryDeleteAccount(address common.Address) error {
+	t.dirty[crypto.Keccak256Hash(address.Bytes())] = nil
+	return nil
+}
+
+// Hash returns the root of the unmodified historical trie, the empty hash
+// otherwise.
+func (t *stateTrie) Hash() common.Hash {
+	if len(t.dirty) > 0 {
+		return common.Hash{}
+	}
+	return t.root
+}
+
+// Commit returns the root of the trie without collecting any node.
+func (t *stateTrie) Commit(collectLeaf bool) (common.Hash, *trie.NodeSet) {
+	return t.Hash(), nil
+}
+
+// NodeIterator returns an iterator failing immediately, as there is no trie
+// node in the historical state.
+func (t *stateTrie) NodeIterator(startKey []byte) trie.NodeIterator {
+	return errIterator{}
+}
+
+// Prove always fails, as there is no trie node in the historical state.
+func (t *stateTrie) Prove(key []byte, fromLevel uint, proofDb ethdb.KeyValueWriter) error {
+	return errReadOnly
+}
+
+// Witness returns nothing, as there is no trie node in the historical state.
+func (t *stateTrie) Witness() map[string]struct{} {
+	return nil
+}
+
+// errIterator is a node iterator failing immediately.
+type errIterator struct{}
+
+func (errIterator) Next(bool) bool                { return false }
+func (errIterator) Error() error                  { return errReadOnly }
+func (errIterator) Hash() common.Hash             { return common.Hash{} }
+func (errIterator) Parent() common.Hash           { return common.Hash{} }
+func (errIterator) Path() []byte                  { return nil }
+func (errIterator) NodeBlob() []byte              { return nil }
+func (errIterator) Leaf() bool                    { return false }
+func (errIterator) LeafKey() []byte               { panic("not at leaf") }
+func (errIterator) LeafBlob() []byte              { panic("not at leaf") }
+func (errIterator) LeafProof() [][]byte           { panic("not at leaf") }
+func (errIterator) AddResolver(trie.NodeResolver) {}
@@ -0,0 +1,364 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package statediff maintains the reverse state diffs of a window of recent
+// blocks, serving their historical states without keeping them in full.
+package statediff
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// searchLimit is the maximum number of blocks searched back from the head for
+// the block of a state diff not following the latest recorded one.
+const searchLimit = 8192
+
+// errUnavailable is returned if the requested state is not covered by the
+// recorded reverse state diffs.
+var errUnavailable = errors.New("historical state unavailable")
+
+// ChainReader is the chain the reverse state diffs are recorded along.
+type ChainReader interface {
+	// CurrentBlock retrieves the head of the chain.
+	CurrentBlock() *types.Header
+
+	// GetHeaderByNumber retrieves a canonical header from the database.
+	GetHeaderByNumber(number uint64) *types.Header
+}
+
+// entry is an original account or storage slot value, empty if it didn't exist.
+type entry struct {
+	Hash common.Hash
+	Blob []byte
+}
+
+// storageEntries are the original storage slot values of an account.
+type storageEntries struct {
+	Account common.Hash
+	Slots   []entry
+}
+
+// item is the reverse state diff of a single block, stored in the freezer.
+type item struct {
+	Number   uint64
+	Hash     common.Hash // Hash of the block
+	Root     common.Hash // State root of the block, reverted by the diff
+	Accounts []entry
+	Storage  []storageEntries
+}
+
+// newItem converts the reverse diff of the given block into its sorted form.
+// The diff is nil for the blocks not changing the state.
+func newItem(header *types.Header, diff *snapshot.ReverseDiff) *item {
+	it := &item{
+		Number: header.Number.Uint64(),
+		Hash:   header.Hash(),
+		Root:   header.Root,
+	}
+	if diff == nil {
+		return it
+	}
+	for hash, blob := range diff.Accounts {
+		it.Accounts = append(it.Accounts, entry{Hash: hash, Blob: blob})
+	}
+	sort.Slice(it.Accounts, func(i, j int) bool {
+		return bytes.Compare(it.Accounts[i].Hash[:], it.Accounts[j].Hash[:]) < 0
+	})
+	for account, slots := range diff.Storage {
+		storage := storageEntries{Account: account}
+		for hash, blob := range slots {
+			storage.Slots = append(storage.Slots, entry{Hash: hash, Blob: blob})
+		}
+		sort.Slice(storage.Slots, func(i, j int) bool {
+			return bytes.Compare(storage.Slots[i].Hash[:], storage.Slots[j].Hash[:]) < 0
+		})
+		it.Storage = append(it.Storage, storage)
+	}
+	sort.Slice(it.Storage, func(i, j int) bool {
+		return bytes.Compare(it.Storage[i].Account[:], it.Storage[j].Account[:]) < 0
+	})
+	return it
+}
+
+// Store records the reverse state diffs of the blocks flattened into the disk
+// layer of the snapshot, in a dedicated freezer. The historical states of the
+// blocks within the window are served by rolling back the latest recorded
+// state, still available in the snapshot, by the diffs of the blocks above.
+//
+// The diffs are recorded contiguously along the canonical chain. Whenever the
+// continuity is broken, e.g. by a rewind or by a snapshot regeneration, the
+// recorded diffs are dropped and the recording starts over.
+type Store struct {
+	freezer *rawdb.Freezer
+	chain   ChainReader
+	snaps   *snapshot.Tree
+	window  uint64 // Number of diffs kept in the freezer
+
+	offset uint64      // Number of the block of the freezer item 0
+	number uint64      // Number of the block of the latest recorded state
+	root   common.Hash // Root of the latest recorded state, empty if unknown
+	lock   sync.RWMutex
+}
+
+// New opens the reverse state diff freezer of the database, and starts
+// recording the diffs of the given snapshot tree.
+func New(db ethdb.Database, chain ChainReader, snaps *snapshot.Tree, window uint64) (*Store, error) {
+	ancient, err := db.AncientDatadir()
+	if err != nil {
+		return nil, fmt.Errorf("state diffs require an ancient store: %w", err)
+	}
+	freezer, err := rawdb.NewStateDiffFreezer(ancient, false)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		freezer: freezer,
+		chain:   chain,
+		snaps:   snaps,
+		window:  window,
+	}
+	// Resume from the last recorded diff, the block numbers of the items are
+	// derived from it.
+	items, _ := freezer.Ancients()
+	tail, _ := freezer.Tail()
+	if items > tail {
+		last, err := s.read(items - 1)
+		if err == nil && last.Number < items-1 {
+			err = fmt.Errorf("invalid number %d of item %d", last.Number, items-1)
+		}
+		if err != nil {
+			log.Warn("Dropping corrupted state diffs", "err", err)
+			if err := s.reset(0, common.Hash{}); err != nil {
+				freezer.Close()
+				return nil, err
+			}
+		} else {
+			s.offset = last.Number - (items - 1)
+			s.number, s.root = last.Number, last.Root
+		}
+	}
+	if err := s.prune(); err != nil {
+		freezer.Close()
+		return nil, err
+	}
+	snaps.SetDiffHook(s.record)
+
+	first, last, _ := s.Range()
+	log.Info("Opened reverse state diffs", "window", window, "first", first, "last", last)
+	return s, nil
+}
+
+// Close stops the recording and closes the freezer.
+func (s *Store) Close() error {
+	s.snaps.SetDiffHook(nil)
+	return s.freezer.Close()
+}
+
+// Range returns the numbers of the first and last blocks whose states can be
+// served, false if none.
+func (s *Store) Range() (uint64, uint64, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.stateRange()
+}
+
+// stateRange returns the range of the served states, the store lock must be
+// held.
+func (s *Store) stateRange() (uint64, uint64, bool) {
+	if s.root == (common.Hash{}) {
+		return 0, 0, false
+	}
+	items, _ := s.freezer.Ancients()
+	tail, _ := s.freezer.Tail()
+	if items == tail {
+		return s.number, s.number, true
+	}
+	// The first diff reverts the state of its block to the parent one
+	return s.offset + tail - 1, s.number, true
+}
+
+// StateAt returns the historical state of the given canonical block, rolled
+// back from the latest recorded state. The state is isolated from the given
+// database, which is used to retrieve contract codes only.
+func (s *Store) StateAt(header *types.Header, db state.Database) (*state.StateDB, error) {
+	number := header.Number.Uint64()
+	if canonical := s.chain.GetHeaderByNumber(number); canonical == nil || canonical.Hash() != header.Hash() {
+		return nil, fmt.Errorf("%w: block #%d [%x] not canonical", errUnavailable, number, header.Hash())
+	}
+	reader := newStateReader(s, number, header.Root)
+	if _, err := reader.extend(); err != nil {
+		return nil, err
+	}
+	return state.New(header.Root, &stateDatabase{Database: db, reader: reader}, nil)
+}
+
+// read retrieves the reverse state diff with the given id from the freezer.
+func (s *Store) read(id uint64) (*item, error) {
+	blob := rawdb.ReadStateDiff(s.freezer, id)
+	if len(blob) == 0 {
+		return nil, fmt.Errorf("state diff %d missing", id)
+	}
+	diff := new(item)
+	if err := rlp.DecodeBytes(blob, diff); err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// record is the snapshot diff hook, appending the reverse diff of a flattened
+// layer to the freezer.
+func (s *Store) record(diff *snapshot.ReverseDiff) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.append(diff); err != nil {
+		log.Error("Failed to record reverse state diff", "root", diff.Root, "err", err)
+		if err := s.reset(0, common.Hash{}); err != nil {
+			log.Error("Failed to drop reverse state diffs", "err", err)
+		}
+	}
+}
+
+// append locates the block of the given reverse diff in the canonical chain,
+// and writes it after the latest recorded state.
+func (s *Store) append(diff *snapshot.ReverseDiff) error {
+	head := s.chain.CurrentBlock().Number.Uint64()
+
+	// The diff most likely follows the latest recorded state, possibly after
+	// a few blocks not changing the state.
+	if s.root != (common.Hash{}) && diff.Parent == s.root {
+		var headers []*types.Header
+		for n := s.number + 1; n <= head; n++ {
+			header := s.chain.GetHeaderByNumber(n)
+			if header == nil {
+				break
+			}
+			headers = append(headers, header)
+			if header.Root == diff.Root {
+				return s.write(headers, diff)
+			}
+			if header.Root != s.root {
+				break
+			}
+		}
+	}
+	// Otherwise search for the block of the diff around the head
+	var header *types.Header
+	for n := head; n+searchLimit > head; n-- {
+		if h := s.chain.GetHeaderByNumber(n); h != nil && h.Root == diff.Root {
+			header = h
+			break
+		}
+		if n == 0 {
+			break
+		}
+	}
+	if header == nil {
+		return fmt.Errorf("block of state %x not found", diff.Root)
+	}
+	number := header.Number.Uint64()
+	if s.root == diff.Root && s.number == number {
+		return nil // Already recorded, e.g. a layer loaded from the journal
+	}
+	// If the diff reverts the block to its parent, rewind the recorded diffs
+	// to the parent, restarting the recording from it if it's not covered.
+	if number > 0 {
+		if parent := s.chain.GetHeaderByNumber(number - 1); parent != nil && parent.Root == diff.Parent {
+			if first, last, ok := s.stateRange(); ok && first < number-1 && number-1 <= last {
+				if err := s.freezer.TruncateHead(number - s.offset); err != nil {
+					return err
+				}
+				s.number, s.root = number-1, diff.Parent
+			} else if err := s.reset(number-1, diff.Parent); err != nil {
+				return err
+			}
+			return s.write([]*types.Header{header}, diff)
+		}
+	}
+	// The diff spans multiple blocks, start over from its state
+	log.Debug("Restarting reverse state diffs", "number", number, "root", diff.Root)
+	return s.reset(number, diff.Root)
+}
+
+// write appends the reverse diffs of the given consecutive blocks, all but the
+// last one not changing the state.
+func (s *Store) write(headers []*types.Header, diff *snapshot.ReverseDiff) error {
+	for i, header := range headers {
+		items, _ := s.freezer.Ancients()
+		tail, _ := s.freezer.Tail()
+
+		number := header.Number.Uint64()
+		if items == tail {
+			// Empty freezer, the first item determines the offset
+			if number < items {
+				return fmt.Errorf("block #%d below the state diff freezer length %d", number, items)
+			}
+			s.offset = number - items
+		} else if number-s.offset != items {
+			return fmt.Errorf("non-contiguous state diff, block #%d, offset %d, items %d", number, s.offset, items)
+		}
+		var it *item
+		if i == len(headers)-1 {
+			it = newItem(header, diff)
+		} else {
+			it = newItem(header, nil)
+		}
+		blob, err := rlp.EncodeToBytes(it)
+		if err != nil {
+			return err
+		}
+		if err := rawdb.WriteStateDiff(s.freezer, items, blob); err != nil {
+			return err
+		}
+		s.number, s.root = number, header.Root
+	}
+	return s.prune()
+}
+
+// prune drops the diffs beyond the window.
+func (s *Store) prune() error {
+	items, _ := s.freezer.Ancients()
+	tail, _ := s.freezer.Tail()
+	if items-tail <= s.window {
+		return nil
+	}
+	return s.freezer.TruncateTail(items - s.window)
+}
+
+// reset drops all the recorded diffs, restarting the recording from the given
+// state, or from the next located one if the root is empty.
+func (s *Store) reset(number uint64, root common.Hash) error {
+	items, _ := s.freezer.Ancients()
+	if err := s.freezer.TruncateTail(items); err != nil {
+		return err
+	}
+	s.number, s.root = number, root
+	return nil
+}
@@ -37,10 +37,17 @@ import (
 // StateProcessor implements Processor.
 type StateProcessor struct {
 	config ctypes.ChainConfigurator // Chain configuration options
-	bc     *BlockChain              // Canonical block chain
+	bc     processorChain           // Canonical block chain
 	engine consensus.Engine         // Consensus engine used for block rewards
 }
 
+// processorChain is the chain access required to process a block, satisfied by
+// the canonical block chain as well as by an execution witness.
+type processorChain interface {
+	ChainContext
+	consensus.ChainHeaderReader
+}
+
 // NewStateProcessor initialises a new StateProcessor.
 func NewStateProcessor(config ctypes.ChainConfigurator, bc *BlockChain, engine consensus.Engine) *StateProcessor {
 	return &StateProcessor{
@@ -74,7 +81,12 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 			mutations.ApplyDAOHardFork(statedb)
 		}
 	}
-	blockContext := NewEVMBlockContext(header, p.bc, nil)
+	// Record the ancestor headers accessed by BLOCKHASH if a witness is collected
+	var chain processorChain = p.bc
+	if witness := statedb.Witness(); witness != nil {
+		chain = &witnessRecorder{processorChain: chain, witness: witness}
+	}
+	blockContext := NewEVMBlockContext(header, chain, nil)
 	vmenv := vm.NewEVM(blockContext, vm.TxContext{}, statedb, p.config, cfg)
 	// Iterate over and process the individual transactions
 	for i, tx := range block.Transactions() {
@@ -97,7 +109,7 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 		return nil, nil, 0, fmt.Errorf("withdrawals before shanghai")
 	}
 	// Finalize the block, applying any consensus engine specific extras (e.g. block rewards)
-	p.engine.Finalize(p.bc, header, statedb, block.Transactions(), block.Uncles(), withdrawals)
+	p.engine.Finalize(chain, header, statedb, block.Transactions(), block.Uncles(), withdrawals)
 
 	return receipts, allLogs, *usedGas, nil
 }
@@ -0,0 +1,154 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/stateless"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params/types/ctypes"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// witnessRecorder wraps a chain, collecting the headers retrieved through it
+// into an execution witness.
+type witnessRecorder struct {
+	processorChain
+	witness *stateless.Witness
+}
+
+// GetHeader retrieves a header from the wrapped chain, adding it to the witness.
+func (r *witnessRecorder) GetHeader(hash common.Hash, number uint64) *types.Header {
+	header := r.processorChain.GetHeader(hash, number)
+	r.witness.AddHeader(header)
+	return header
+}
+
+// witnessChain is a chain backed solely by the headers of an execution witness.
+type witnessChain struct {
+	config  ctypes.ChainConfigurator
+	engine  consensus.Engine
+	headers map[common.Hash]*types.Header
+	parent  *types.Header
+}
+
+func (c *witnessChain) Config() ctypes.ChainConfigurator { return c.config }
+func (c *witnessChain) Engine() consensus.Engine         { return c.engine }
+func (c *witnessChain) CurrentHeader() *types.Header     { return c.parent }
+
+func (c *witnessChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if header := c.headers[hash]; header != nil && header.Number.Uint64() == number {
+		return header
+	}
+	return nil
+}
+
+func (c *witnessChain) GetHeaderByHash(hash common.Hash) *types.Header {
+	return c.headers[hash]
+}
+
+func (c *witnessChain) GetHeaderByNumber(number uint64) *types.Header {
+	for _, header := range c.headers {
+		if header.Number.Uint64() == number {
+			return header
+		}
+	}
+	return nil
+}
+
+func (c *witnessChain) GetTd(hash common.Hash, number uint64) *big.Int {
+	return nil
+}
+
+// ExecutionWitness re-executes the given block on top of its parent state and
+// collects every trie node, contract code and ancestor header accessed into an
+// execution witness. The parent state must be available.
+func (bc *BlockChain) ExecutionWitness(block *types.Block) (*stateless.Witness, error) {
+	parent := bc.GetHeader(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return nil, fmt.Errorf("parent block %x not found", block.ParentHash())
+	}
+	// Open the state without the snapshot, all the reads must go through the tries
+	statedb, err := state.New(parent.Root, bc.stateCache, nil)
+	if err != nil {
+		return nil, err
+	}
+	witness := stateless.NewWitness(block, parent)
+	statedb.SetWitness(witness)
+
+	if _, _, _, err := bc.processor.Process(block, statedb, bc.vmConfig); err != nil {
+		return nil, err
+	}
+	root := statedb.IntermediateRoot(bc.chainConfig.IsEnabled(bc.chainConfig.GetEIP161dTransition, block.Number()))
+	if err := statedb.Error(); err != nil {
+		return nil, err
+	}
+	if root != block.Root() {
+		return nil, fmt.Errorf("state root mismatch: have %x, want %x", root, block.Root())
+	}
+	return witness, nil
+}
+
+// ExecuteStateless runs the block of the witness on top of the state contained
+// in the witness, without access to any chain data. It returns the resulting
+// state root and receipt root, which are to be compared against the block header
+// by the caller.
+func ExecuteStateless(config ctypes.ChainConfigurator, engine consensus.Engine, witness *stateless.Witness, cfg vm.Config) (common.Hash, common.Hash, error) {
+	var (
+		block   = witness.Block
+		headers = make(map[common.Hash]*types.Header, len(witness.Headers))
+	)
+	if len(witness.Headers) == 0 {
+		return common.Hash{}, common.Hash{}, errors.New("witness without parent header")
+	}
+	// Ensure the headers form a contiguous chain down from the block's parent
+	want := block.ParentHash()
+	for i, header := range witness.Headers {
+		if hash := header.Hash(); hash != want {
+			return common.Hash{}, common.Hash{}, fmt.Errorf("witness header %d mismatch: have %x, want %x", i, hash, want)
+		}
+		headers[want] = header
+		want = header.ParentHash
+	}
+	chain := &witnessChain{
+		config:  config,
+		engine:  engine,
+		headers: headers,
+		parent:  witness.Headers[0],
+	}
+	statedb, err := state.New(witness.Root(), state.NewDatabase(witness.MakeHashDB()), nil)
+	if err != nil {
+		return common.Hash{}, common.Hash{}, err
+	}
+	processor := &StateProcessor{config: config, bc: chain, engine: engine}
+	receipts, _, _, err := processor.Process(block, statedb, cfg)
+	if err != nil {
+		return common.Hash{}, common.Hash{}, err
+	}
+	root := statedb.IntermediateRoot(config.IsEnabled(config.GetEIP161dTransition, block.Number()))
+	if err := statedb.Error(); err != nil {
+		return common.Hash{}, common.Hash{}, err
+	}
+	return root, types.DeriveSha(receipts, trie.NewStackTrie(nil)), nil
+}
@@ -0,0 +1,193 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package stateless contains the execution witness of a block, the minimal set
+// of data required to re-execute the block without access to the chain state.
+package stateless
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Witness encompasses the block, the headers and the state required to execute
+// the block on top of its parent.
+type Witness struct {
+	Block   *types.Block        // Block to execute
+	Headers []*types.Header     // Parent header first, followed by the ancestors accessed via BLOCKHASH
+	Codes   map[string]struct{} // Contract bytecodes accessed during execution
+	State   map[string]struct{} // Trie nodes resolved during execution and state root computation
+
+	lock sync.Mutex // Lock protecting the witness from concurrent insertions
+}
+
+// NewWitness creates an empty witness for the given block, to be filled during
+// its execution.
+func NewWitness(block *types.Block, parent *types.Header) *Witness {
+	return &Witness{
+		Block:   block,
+		Headers: []*types.Header{parent},
+		Codes:   make(map[string]struct{}),
+		State:   make(map[string]struct{}),
+	}
+}
+
+// AddHeader adds an ancestor header accessed during execution to the witness.
+func (w *Witness) AddHeader(header *types.Header) {
+	if header == nil {
+		return
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	hash := header.Hash()
+	for _, have := range w.Headers {
+		if have.Hash() == hash {
+			return
+		}
+	}
+	w.Headers = append(w.Headers, header)
+}
+
+// AddCode adds a contract bytecode accessed during execution to the witness.
+func (w *Witness) AddCode(code []byte) {
+	if len(code) == 0 {
+		return
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.Codes[string(code)] = struct{}{}
+}
+
+// AddState adds a set of trie nodes resolved during execution to the witness.
+func (w *Witness) AddState(nodes map[string]struct{}) {
+	if len(nodes) == 0 {
+		return
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	for node := range nodes {
+		w.State[node] = struct{}{}
+	}
+}
+
+// Root returns the pre-state root the block is executed on top of.
+func (w *Witness) Root() common.Hash {
+	return w.Headers[0].Root
+}
+
+// MakeHashDB writes the trie nodes and the contract codes of the witness into
+// a fresh in-memory database, keyed by their hashes, usable as the backing
+// store of a hash based state database.
+func (w *Witness) MakeHashDB() ethdb.Database {
+	var (
+		db     = rawdb.NewMemoryDatabase()
+		hasher = crypto.NewKeccakState()
+		hash   = make([]byte, 32)
+	)
+	for code := range w.Codes {
+		hasher.Reset()
+		hasher.Write([]byte(code))
+		hasher.Read(hash)
+
+		rawdb.WriteCode(db, common.BytesToHash(hash), []byte(code))
+	}
+	for node := range w.State {
+		hasher.Reset()
+		hasher.Write([]byte(node))
+		hasher.Read(hash)
+
+		rawdb.WriteLegacyTrieNode(db, common.BytesToHash(hash), []byte(node))
+	}
+	return db
+}
+
+// extWitness is the witness representation used for JSON encoding.
+type extWitness struct {
+	Block   hexutil.Bytes   `json:"block"`
+	Headers []*types.Header `json:"headers"`
+	Codes   []hexutil.Bytes `json:"codes"`
+	State   []hexutil.Bytes `json:"state"`
+}
+
+// sortedBlobs returns the items of a blob set in a deterministic order.
+func sortedBlobs(set map[string]struct{}) []hexutil.Bytes {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	blobs := make([]hexutil.Bytes, len(keys))
+	for i, key := range keys {
+		blobs[i] = hexutil.Bytes(key)
+	}
+	return blobs
+}
+
+// MarshalJSON implements json.Marshaler.
+func (w *Witness) MarshalJSON() ([]byte, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	block, err := rlp.EncodeToBytes(w.Block)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&extWitness{
+		Block:   block,
+		Headers: w.Headers,
+		Codes:   sortedBlobs(w.Codes),
+		State:   sortedBlobs(w.State),
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (w *Witness) UnmarshalJSON(input []byte) error {
+	var ext extWitness
+	if err := json.Unmarshal(input, &ext); err != nil {
+		return err
+	}
+	if len(ext.Headers) == 0 {
+		return errors.New("witness without parent header")
+	}
+	block := new(types.Block)
+	if err := rlp.DecodeBytes(ext.Block, block); err != nil {
+		return err
+	}
+	w.Block, w.Headers = block, ext.Headers
+	w.Codes = make(map[string]struct{}, len(ext.Codes))
+	for _, code := range ext.Codes {
+		w.Codes[string(code)] = struct{}{}
+	}
+	w.State = make(map[string]struct{}, len(ext.State))
+	for _, node := range ext.State {
+		w.State[string(node)] = struct{}{}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/stateless"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params/types/ctypes"
+	"github.com/ethereum/go-ethereum/params/types/genesisT"
+	"github.com/ethereum/go-ethereum/params/types/goethereum"
+)
+
+// Tests that the execution witness of a block contains everything needed to
+// re-execute the block without the chain state.
+func TestExecutionWitness(t *testing.T) {
+	var (
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address  = crypto.PubkeyToAddress(key.PublicKey)
+		contract = common.HexToAddress("0xaa")
+		config   = &goethereum.ChainConfig{
+			ChainID:             big.NewInt(1),
+			HomesteadBlock:      big.NewInt(0),
+			EIP150Block:         big.NewInt(0),
+			EIP155Block:         big.NewInt(0),
+			EIP158Block:         big.NewInt(0),
+			ByzantiumBlock:      big.NewInt(0),
+			ConstantinopleBlock: big.NewInt(0),
+			PetersburgBlock:     big.NewInt(0),
+			IstanbulBlock:       big.NewInt(0),
+			Ethash:              new(ctypes.EthashConfig),
+		}
+		gspec = &genesisT.Genesis{
+			Config: config,
+			Alloc: genesisT.GenesisAlloc{
+				address: {Balance: big.NewInt(1000000000000000000)},
+				contract: {
+					Balance: new(big.Int),
+					// slot[1] = blockhash(number-3)
+					// slot[2] = extcodesize(0xbb)
+					// slot[3] = slot[0]
+					// slot[0] = 0
+					Code: common.FromHex("6003430340600155" + "60bb3b600255" + "600054600355" + "6000600055" + "00"),
+					Storage: map[common.Hash]common.Hash{
+						{0x00}: {0x01},
+						{0x05}: {0x02},
+						{0x06}: {0x03},
+					},
+				},
+				common.HexToAddress("0xbb"): {Balance: new(big.Int), Code: common.FromHex("6001600101")},
+			},
+		}
+	)
+	// Pad the account trie to make the proofs non-trivial
+	for i := 0; i < 64; i++ {
+		gspec.Alloc[common.BytesToAddress(crypto.Keccak256([]byte{byte(i)}))] = genesisT.GenesisAccount{Balance: big.NewInt(1)}
+	}
+	db := rawdb.NewMemoryDatabase()
+	chain, err := NewBlockChain(db, &CacheConfig{TrieDirtyDisabled: true, StateScheme: rawdb.HashScheme}, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+
+	// Generate the blocks one by one, so that BLOCKHASH can access the ancestors
+	signer := types.LatestSigner(config)
+	for i := 0; i < 5; i++ {
+		parent := chain.GetBlockByHash(chain.CurrentBlock().Hash())
+		blocks, _ := GenerateChain(config, parent, ethash.NewFaker(), db, 1, func(i int, b *BlockGen) {
+			tx, err := types.SignTx(types.NewTransaction(b.TxNonce(address), contract, new(big.Int), 100000, big.NewInt(1), nil), signer, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.AddTxWithChain(chain, tx)
+		})
+		if _, err := chain.InsertChain(blocks); err != nil {
+			t.Fatalf("failed to insert block %d: %v", i+1, err)
+		}
+	}
+	block := chain.GetBlockByHash(chain.CurrentBlock().Hash())
+	witness, err := chain.ExecutionWitness(block)
+	if err != nil {
+		t.Fatalf("failed to collect witness: %v", err)
+	}
+	// The hash of block 2 is proven by the header of block 3, next to the parent
+	if have := len(witness.Headers); have != 2 {
+		t.Errorf("witness header count mismatch: have %d, want %d", have, 2)
+	}
+	if have := len(witness.Codes); have != 2 {
+		t.Errorf("witness code count mismatch: have %d, want %d", have, 2)
+	}
+	// Roundtrip the witness through its JSON encoding and execute it
+	blob, err := json.Marshal(witness)
+	if err != nil {
+		t.Fatalf("failed to encode witness: %v", err)
+	}
+	decoded := new(stateless.Witness)
+	if err := json.Unmarshal(blob, decoded); err != nil {
+		t.Fatalf("failed to decode witness: %v", err)
+	}
+	root, receiptRoot, err := ExecuteStateless(config, ethash.NewFaker(), decoded, vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to execute witness: %v", err)
+	}
+	if root != block.Root() {
+		t.Errorf("state root mismatch: have %x, want %x", root, block.Root())
+	}
+	if receiptRoot != block.ReceiptHash() {
+		t.Errorf("receipt root mismatch: have %x, want %x", receiptRoot, block.ReceiptHash())
+	}
+	// Ensure execution fails if any trie node is missing from the witness
+	for node := range decoded.State {
+		delete(decoded.State, node)
+		break
+	}
+	if root, _, err := ExecuteStateless(config, ethash.NewFaker(), decoded, vm.Config{}); err == nil && root == block.Root() {
+		t.Errorf("executed incomplete witness")
+	}
+	// Ensure execution fails if the ancestor headers are not linked
+	decoded.Headers[1].Extra = []byte("corrupt")
+	if _, _, err := ExecuteStateless(config, ethash.NewFaker(), decoded, vm.Config{}); err == nil {
+		t.Errorf("executed witness with unlinked headers")
+	}
+}
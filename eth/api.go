@@ -26,6 +26,7 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -33,9 +34,13 @@ import (
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/state/pruner"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
+	"github.com/ethereum/go-ethereum/core/stateless"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/internal/ethapi"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/miner"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/ethereum/go-ethereum/trie"
@@ -134,6 +139,62 @@ func (api *MinerAPI) SetRecommitInterval(interval int) {
 	api.e.Miner().SetRecommitInterval(time.Duration(interval) * time.Millisecond)
 }
 
+// GetBlockTemplate returns the candidate block currently being sealed: its header,
+// the ordered transaction list and the fees paid by each transaction.
+func (api *MinerAPI) GetBlockTemplate() (*miner.BlockTemplate, error) {
+	return api.e.Miner().BlockTemplate()
+}
+
+// SubmitBlockTemplate accepts an externally constructed, ordered list of signed
+// transactions for the next block. The transactions are executed on top of the
+// chain head and, if all of them are valid, the resulting block is sealed until
+// the next chain head arrives.
+func (api *MinerAPI) SubmitBlockTemplate(txs []hexutil.Bytes) (*miner.BlockTemplate, error) {
+	decoded := make(types.Transactions, 0, len(txs))
+	for i, input := range txs {
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalBinary(input); err != nil {
+			return nil, fmt.Errorf("tx %d: %w", i, err)
+		}
+		decoded = append(decoded, tx)
+	}
+	return api.e.Miner().SubmitBlockTemplate(decoded)
+}
+
+// MinedBlocks returns the recently mined local blocks with their chain inclusion
+// status (pending, canonical, uncled or orphaned) and the realized block reward.
+func (api *MinerAPI) MinedBlocks() []*miner.MinedBlock {
+	return api.e.Miner().MinedBlocks()
+}
+
+// NewMinedBlocks creates a subscription that is triggered each time a locally
+// mined block reaches its final chain inclusion status.
+func (api *MinerAPI) NewMinedBlocks(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		blocks := make(chan *miner.MinedBlock, 16)
+		sub := api.e.Miner().SubscribeMinedBlocks(blocks)
+		defer sub.Unsubscribe()
+
+		for {
+			select {
+			case block := <-blocks:
+				notifier.Notify(rpcSub.ID, block)
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
+
 // AdminAPI is the collection of Ethereum full node related APIs for node
 // administration.
 type AdminAPI struct {
@@ -267,6 +328,57 @@ func (api *AdminAPI) MaxPeers(n int) (bool, error) {
 	return true, nil
 }
 
+// PruneState starts deleting the stale state in the background, keeping the
+// states of the last 128 blocks, while the node keeps running. Its progress is
+// reported by PruningProgress.
+func (api *AdminAPI) PruneState() (bool, error) {
+	if atomic.LoadUint32(&api.eth.handler.snapSync) == 1 {
+		return false, errors.New("state pruning unavailable during snap sync")
+	}
+	if err := api.eth.pruner.Start(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PruningProgress returns the status of the online state pruning.
+func (api *AdminAPI) PruningProgress() *pruner.OnlineProgress {
+	return api.eth.pruner.Progress()
+}
+
+// CheckSnapshotIntegrity starts verifying the persistent state snapshot layer
+// against the state trie with range proofs in the background, while the node
+// keeps running. If requested, only the ranges found inconsistent are repaired
+// from the trie afterwards. The trie of the snapshot layer must be available,
+// which is generally only the case for archive nodes. Its progress is reported
+// by SnapshotIntegrityProgress, and its outcome by SnapshotIntegrityReport.
+func (api *AdminAPI) CheckSnapshotIntegrity(repair bool) (bool, error) {
+	if api.eth.snapIntegrity == nil {
+		return false, errors.New("state snapshot disabled")
+	}
+	if err := api.eth.snapIntegrity.Start(runtime.NumCPU()/2, repair); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SnapshotIntegrityProgress returns the status of the snapshot integrity check.
+func (api *AdminAPI) SnapshotIntegrityProgress() (*snapshot.IntegrityProgress, error) {
+	if api.eth.snapIntegrity == nil {
+		return nil, errors.New("state snapshot disabled")
+	}
+	return api.eth.snapIntegrity.Progress(), nil
+}
+
+// SnapshotIntegrityReport returns the ranges found inconsistent by the last
+// finished snapshot integrity check.
+func (api *AdminAPI) SnapshotIntegrityReport() (*snapshot.IntegrityReport, error) {
+	if api.eth.snapIntegrity == nil {
+		return nil, errors.New("state snapshot disabled")
+	}
+	return api.eth.snapIntegrity.Report()
+}
+
 // DebugAPI is the collection of Ethereum full node APIs for debugging the
 // protocol.
 type DebugAPI struct {
@@ -643,6 +755,23 @@ func (api *DebugAPI) RemovePendingTransaction(hash common.Hash) (*types.Transact
 	return api.eth.txPool.RemoveTx(hash), nil
 }
 
+// ExecutionWitness re-executes the given block and returns every trie node,
+// contract code and ancestor header needed to execute it again without access
+// to the chain state. The state of the block's parent must be available.
+func (api *DebugAPI) ExecutionWitness(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (*stateless.Witness, error) {
+	block, err := api.eth.APIBackend.BlockByNumberOrHash(ctx, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, errors.New("block not found")
+	}
+	if block.NumberU64() == 0 {
+		return nil, errors.New("genesis is not executable")
+	}
+	return api.eth.blockchain.ExecutionWitness(block)
+}
+
 // PrivateTraceAPI is the collection of Ethereum full node APIs exposed over
 // the private debugging endpoint.
 type PrivateTraceAPI struct {
@@ -144,11 +144,30 @@ func (b *EthAPIBackend) BlockByNumber(ctx context.Context, number rpc.BlockNumbe
 		header := b.eth.blockchain.CurrentSafeBlock()
 		return b.eth.blockchain.GetBlock(header.Hash(), header.Number.Uint64()), nil
 	}
-	return b.eth.blockchain.GetBlockByNumber(uint64(number)), nil
+	if block := b.eth.blockchain.GetBlockByNumber(uint64(number)); block != nil {
+		return block, nil
+	}
+	return nil, b.historyErr(uint64(number))
 }
 
 func (b *EthAPIBackend) BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error) {
-	return b.eth.blockchain.GetBlockByHash(hash), nil
+	header := b.eth.blockchain.GetHeaderByHash(hash)
+	if header == nil {
+		return nil, nil
+	}
+	if block := b.eth.blockchain.GetBlock(hash, header.Number.Uint64()); block != nil {
+		return block, nil
+	}
+	return nil, b.historyErr(header.Number.Uint64())
+}
+
+// historyErr returns ErrPrunedHistory if the block with the given number is
+// below the history tail, its body and receipts being pruned.
+func (b *EthAPIBackend) historyErr(number uint64) error {
+	if number > 0 && number < b.eth.blockchain.HistoryTail() {
+		return core.ErrPrunedHistory
+	}
+	return nil
 }
 
 // GetBody returns body of a block. It does not resolve special block numbers.
@@ -159,6 +178,9 @@ func (b *EthAPIBackend) GetBody(ctx context.Context, hash common.Hash, number rp
 	if body := b.eth.blockchain.GetBody(hash); body != nil {
 		return body, nil
 	}
+	if err := b.historyErr(uint64(number)); err != nil {
+		return nil, err
+	}
 	return nil, errors.New("block body not found")
 }
 
@@ -176,6 +198,9 @@ func (b *EthAPIBackend) BlockByNumberOrHash(ctx context.Context, blockNrOrHash r
 		}
 		block := b.eth.blockchain.GetBlock(hash, header.Number.Uint64())
 		if block == nil {
+			if err := b.historyErr(header.Number.Uint64()); err != nil {
+				return nil, err
+			}
 			return nil, errors.New("header found, but block body is missing")
 		}
 		return block, nil
@@ -201,7 +226,7 @@ func (b *EthAPIBackend) StateAndHeaderByNumber(ctx context.Context, number rpc.B
 	if header == nil {
 		return nil, nil, errors.New("header not found")
 	}
-	stateDb, err := b.eth.BlockChain().StateAt(header.Root)
+	stateDb, err := b.stateAt(header)
 	return stateDb, header, err
 }
 
@@ -220,18 +245,39 @@ func (b *EthAPIBackend) StateAndHeaderByNumberOrHash(ctx context.Context, blockN
 		if blockNrOrHash.RequireCanonical && b.eth.blockchain.GetCanonicalHash(header.Number.Uint64()) != hash {
 			return nil, nil, errors.New("hash is not currently canonical")
 		}
-		stateDb, err := b.eth.BlockChain().StateAt(header.Root)
+		stateDb, err := b.stateAt(header)
 		return stateDb, header, err
 	}
 	return nil, nil, errors.New("invalid arguments; neither block nor hash specified")
 }
 
+// stateAt returns the state of the given block, falling back to the historical
+// state rolled back by the reverse state diffs if the state is pruned.
+func (b *EthAPIBackend) stateAt(header *types.Header) (*state.StateDB, error) {
+	stateDb, err := b.eth.BlockChain().StateAt(header.Root)
+	if err != nil {
+		if historic, herr := b.eth.BlockChain().HistoricState(header); herr == nil {
+			return historic, nil
+		}
+	}
+	return stateDb, err
+}
+
 func (b *EthAPIBackend) GetReceipts(ctx context.Context, hash common.Hash) (types.Receipts, error) {
-	return b.eth.blockchain.GetReceiptsByHash(hash), nil
+	if receipts := b.eth.blockchain.GetReceiptsByHash(hash); receipts != nil {
+		return receipts, nil
+	}
+	if header := b.eth.blockchain.GetHeaderByHash(hash); header != nil {
+		return nil, b.historyErr(header.Number.Uint64())
+	}
+	return nil, nil
 }
 
 func (b *EthAPIBackend) GetLogs(ctx context.Context, hash common.Hash, number uint64) ([][]*types.Log, error) {
-	return rawdb.ReadLogs(b.eth.chainDb, hash, number, b.ChainConfig()), nil
+	if logs := rawdb.ReadLogs(b.eth.chainDb, hash, number, b.ChainConfig()); logs != nil {
+		return logs, nil
+	}
+	return nil, b.historyErr(number)
 }
 
 func (b *EthAPIBackend) GetTd(ctx context.Context, hash common.Hash) *big.Int {
@@ -300,6 +346,10 @@ func (b *EthAPIBackend) GetPoolNonce(ctx context.Context, addr common.Address) (
 	return b.eth.txPool.Nonce(addr), nil
 }
 
+func (b *EthAPIBackend) TxPoolPriceBump() uint64 {
+	return b.eth.txPool.PriceBump()
+}
+
 func (b *EthAPIBackend) Stats() (pending int, queued int) {
 	return b.eth.txPool.Stats()
 }
@@ -336,6 +386,17 @@ func (b *EthAPIBackend) ChainDb() ethdb.Database {
 	return b.eth.ChainDb()
 }
 
+// TraceCache returns the cache of block trace results, nil if disabled.
+func (b *EthAPIBackend) TraceCache() *tracers.TraceCache {
+	return b.eth.traceCache
+}
+
+// EVMInterpreter returns the configuration of the EVMC interpreter, empty if
+// the native interpreter is used.
+func (b *EthAPIBackend) EVMInterpreter() string {
+	return b.eth.config.EVMInterpreter
+}
+
 func (b *EthAPIBackend) EventMux() *event.TypeMux {
 	return b.eth.EventMux()
 }
@@ -0,0 +1,242 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/miner"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// BundleAPI provides an API to submit and simulate atomic transaction bundles,
+// which are included by the miner at the top of the block.
+type BundleAPI struct {
+	e *Ethereum
+}
+
+// NewBundleAPI creates a new BundleAPI instance.
+func NewBundleAPI(e *Ethereum) *BundleAPI {
+	return &BundleAPI{e}
+}
+
+// SendBundleArgs represents the arguments for an eth_sendBundle call.
+type SendBundleArgs struct {
+	Txs               []hexutil.Bytes `json:"txs"`
+	BlockNumber       hexutil.Uint64  `json:"blockNumber"`
+	MinTimestamp      *hexutil.Uint64 `json:"minTimestamp"`
+	MaxTimestamp      *hexutil.Uint64 `json:"maxTimestamp"`
+	RevertingTxHashes []common.Hash   `json:"revertingTxHashes"`
+}
+
+// SendBundleResult is the result of an eth_sendBundle call.
+type SendBundleResult struct {
+	BundleHash common.Hash `json:"bundleHash"`
+}
+
+// decodeBundleTxs decodes the given signed raw transactions, ensuring their
+// senders can be derived.
+func decodeBundleTxs(signer types.Signer, raw []hexutil.Bytes) (types.Transactions, error) {
+	if len(raw) == 0 {
+		return nil, errors.New("bundle missing txs")
+	}
+	txs := make(types.Transactions, 0, len(raw))
+	for i, input := range raw {
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalBinary(input); err != nil {
+			return nil, fmt.Errorf("tx %d: %w", i, err)
+		}
+		if _, err := types.Sender(signer, tx); err != nil {
+			return nil, fmt.Errorf("tx %d: %w", i, err)
+		}
+		txs = append(txs, tx)
+	}
+	return txs, nil
+}
+
+// SendBundle submits a bundle of signed transactions to be included atomically
+// and in order at the top of the block with the given number. Unless listed in
+// revertingTxHashes, a reverting transaction causes the whole bundle to be left
+// out of the block. The target block must be one of the next 25 blocks, and
+// the number of pending bundles per sender of the first transaction is limited.
+func (api *BundleAPI) SendBundle(ctx context.Context, args SendBundleArgs) (*SendBundleResult, error) {
+	txs, err := decodeBundleTxs(types.LatestSigner(api.e.blockchain.Config()), args.Txs)
+	if err != nil {
+		return nil, err
+	}
+	bundle := &miner.Bundle{
+		Txs:               txs,
+		BlockNumber:       uint64(args.BlockNumber),
+		RevertingTxHashes: args.RevertingTxHashes,
+	}
+	if args.MinTimestamp != nil {
+		bundle.MinTimestamp = uint64(*args.MinTimestamp)
+	}
+	if args.MaxTimestamp != nil {
+		bundle.MaxTimestamp = uint64(*args.MaxTimestamp)
+	}
+	if err := api.e.Miner().AddBundle(bundle); err != nil {
+		return nil, err
+	}
+	return &SendBundleResult{BundleHash: bundle.Hash()}, nil
+}
+
+// CallBundleArgs represents the arguments for an eth_callBundle call.
+type CallBundleArgs struct {
+	Txs              []hexutil.Bytes       `json:"txs"`
+	BlockNumber      *hexutil.Uint64       `json:"blockNumber"`
+	StateBlockNumber rpc.BlockNumberOrHash `json:"stateBlockNumber"`
+	Coinbase         *common.Address       `json:"coinbase"`
+	Timestamp        *hexutil.Uint64       `json:"timestamp"`
+	GasLimit         *hexutil.Uint64       `json:"gasLimit"`
+	BaseFee          *hexutil.Big          `json:"baseFee"`
+}
+
+// CallBundleTxResult is the outcome of a single transaction of a simulated bundle.
+type CallBundleTxResult struct {
+	TxHash       common.Hash     `json:"txHash"`
+	From         common.Address  `json:"fromAddress"`
+	To           *common.Address `json:"toAddress"`
+	GasUsed      hexutil.Uint64  `json:"gasUsed"`
+	GasPrice     *hexutil.Big    `json:"gasPrice"`
+	GasFees      *hexutil.Big    `json:"gasFees"`
+	CoinbaseDiff *hexutil.Big    `json:"coinbaseDiff"`
+	Logs         []*types.Log    `json:"logs"`
+	Error        string          `json:"error,omitempty"`
+}
+
+// CallBundleResult is the result of an eth_callBundle call.
+type CallBundleResult struct {
+	BundleHash        common.Hash           `json:"bundleHash"`
+	StateBlockNumber  hexutil.Uint64        `json:"stateBlockNumber"`
+	TotalGasUsed      hexutil.Uint64        `json:"totalGasUsed"`
+	BundleGasPrice    *hexutil.Big          `json:"bundleGasPrice"`
+	GasFees           *hexutil.Big          `json:"gasFees"`
+	CoinbaseDiff      *hexutil.Big          `json:"coinbaseDiff"`
+	EthSentToCoinbase *hexutil.Big          `json:"ethSentToCoinbase"`
+	Results           []*CallBundleTxResult `json:"results"`
+}
+
+// CallBundle simulates a bundle of signed transactions on top of the state of
+// the given block, as if they were included at the top of the following block.
+// It reports the gas used, logs and the coinbase profit of every transaction.
+func (api *BundleAPI) CallBundle(ctx context.Context, args CallBundleArgs) (*CallBundleResult, error) {
+	config := api.e.blockchain.Config()
+	statedb, parent, err := api.e.APIBackend.StateAndHeaderByNumberOrHash(ctx, args.StateBlockNumber)
+	if statedb == nil || err != nil {
+		return nil, err
+	}
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number, common.Big1),
+		GasLimit:   parent.GasLimit,
+		Time:       parent.Time + 1,
+		Difficulty: parent.Difficulty,
+		Coinbase:   parent.Coinbase,
+	}
+	if args.BlockNumber != nil {
+		header.Number = new(big.Int).SetUint64(uint64(*args.BlockNumber))
+	}
+	if args.Timestamp != nil {
+		header.Time = uint64(*args.Timestamp)
+	}
+	if args.GasLimit != nil {
+		header.GasLimit = uint64(*args.GasLimit)
+	}
+	if args.Coinbase != nil {
+		header.Coinbase = *args.Coinbase
+	}
+	if config.IsEnabled(config.GetEIP1559Transition, header.Number) {
+		header.BaseFee = misc.CalcBaseFee(config, parent)
+	}
+	if args.BaseFee != nil {
+		header.BaseFee = args.BaseFee.ToInt()
+	}
+	signer := types.MakeSigner(config, header.Number)
+	txs, err := decodeBundleTxs(signer, args.Txs)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		gp       = new(core.GasPool).AddGas(header.GasLimit)
+		vmconfig = *api.e.blockchain.GetVMConfig()
+		gasUsed  uint64
+
+		coinbaseBefore = statedb.GetBalance(header.Coinbase)
+		gasFees        = new(big.Int)
+		results        = make([]*CallBundleTxResult, 0, len(txs))
+	)
+	for i, tx := range txs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		before := statedb.GetBalance(header.Coinbase)
+
+		statedb.SetTxContext(tx.Hash(), i)
+		receipt, err := core.ApplyTransaction(config, api.e.blockchain, &header.Coinbase, gp, statedb, header, tx, &gasUsed, vmconfig)
+		if err != nil {
+			return nil, fmt.Errorf("tx %x: %w", tx.Hash(), err)
+		}
+		from, _ := types.Sender(signer, tx)
+		price, _ := tx.EffectiveGasTip(header.BaseFee)
+		fees := new(big.Int).Mul(price, new(big.Int).SetUint64(receipt.GasUsed))
+		gasFees.Add(gasFees, fees)
+
+		result := &CallBundleTxResult{
+			TxHash:       tx.Hash(),
+			From:         from,
+			To:           tx.To(),
+			GasUsed:      hexutil.Uint64(receipt.GasUsed),
+			GasPrice:     (*hexutil.Big)(price),
+			GasFees:      (*hexutil.Big)(fees),
+			CoinbaseDiff: (*hexutil.Big)(new(big.Int).Sub(statedb.GetBalance(header.Coinbase), before)),
+			Logs:         receipt.Logs,
+		}
+		if result.Logs == nil {
+			result.Logs = []*types.Log{}
+		}
+		if receipt.Status == types.ReceiptStatusFailed {
+			result.Error = "execution reverted"
+		}
+		results = append(results, result)
+	}
+	var (
+		coinbaseDiff = new(big.Int).Sub(statedb.GetBalance(header.Coinbase), coinbaseBefore)
+		bundlePrice  = new(big.Int)
+	)
+	if gasUsed > 0 {
+		bundlePrice.Div(coinbaseDiff, new(big.Int).SetUint64(gasUsed))
+	}
+	return &CallBundleResult{
+		BundleHash:        (&miner.Bundle{Txs: txs}).Hash(),
+		StateBlockNumber:  hexutil.Uint64(parent.Number.Uint64()),
+		TotalGasUsed:      hexutil.Uint64(gasUsed),
+		BundleGasPrice:    (*hexutil.Big)(bundlePrice),
+		GasFees:           (*hexutil.Big)(gasFees),
+		CoinbaseDiff:      (*hexutil.Big)(coinbaseDiff),
+		EthSentToCoinbase: (*hexutil.Big)(new(big.Int).Sub(coinbaseDiff, gasFees)),
+		Results:           results,
+	}, nil
+}
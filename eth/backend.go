@@ -37,6 +37,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/bloombits"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state/pruner"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
 	"github.com/ethereum/go-ethereum/core/txpool"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
@@ -45,6 +46,8 @@ import (
 	"github.com/ethereum/go-ethereum/eth/gasprice"
 	"github.com/ethereum/go-ethereum/eth/protocols/eth"
 	"github.com/ethereum/go-ethereum/eth/protocols/snap"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/eth/tracers/js"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/internal/ethapi"
@@ -89,6 +92,10 @@ type Ethereum struct {
 
 	bloomRequests     chan chan *bloombits.Retrieval // Channel receiving bloom data retrieval requests
 	bloomIndexer      *core.ChainIndexer             // Bloom indexer operating during block imports
+	traceIndexer      *core.ChainIndexer             // Trace indexer operating during block imports, nil if disabled
+	pruner            *pruner.OnlinePruner           // State pruner running along with the block imports
+	snapIntegrity     *snapshot.IntegrityJob         // Background snapshot integrity checker, nil if snapshots are disabled
+	traceCache        *tracers.TraceCache            // Cache of block trace results, nil if disabled
 	closeBloomHandler chan struct{}
 
 	APIBackend *EthAPIBackend
@@ -196,6 +203,13 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 			rawdb.WriteDatabaseVersion(chainDb, core.BlockChainVersion)
 		}
 	}
+	scheme, err := rawdb.ParseStateScheme(config.StateScheme, chainDb)
+	if err != nil {
+		return nil, err
+	}
+	if scheme == rawdb.PathScheme && config.NoPruning {
+		return nil, errors.New("path-based state scheme is not compatible with archive mode")
+	}
 	var (
 		vmConfig = vm.Config{
 			EnablePreimageRecording: config.EnablePreimageRecording,
@@ -212,6 +226,10 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 			TrieTimeLimit:       config.TrieTimeout,
 			SnapshotLimit:       config.SnapshotCache,
 			Preimages:           config.Preimages,
+			StateScheme:         scheme,
+			StateHistory:        config.StateHistory,
+			StateDiffs:          config.StateDiffs,
+			HistoryRetain:       config.HistoryRetain,
 		}
 	)
 	// Override the chain config with provided settings.
@@ -224,6 +242,19 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 		return nil, err
 	}
 	eth.bloomIndexer.Start(eth.blockchain)
+
+	// Resume the online state pruning before any block is imported
+	eth.pruner = pruner.NewOnlinePruner(chainDb, eth.blockchain, pruner.OnlineConfig{
+		Datadir:   stack.ResolvePath(""),
+		BloomSize: config.PruningBloomSize,
+		Throttle:  config.PruningThrottle,
+	})
+	if err := eth.pruner.Resume(); err != nil {
+		log.Error("Failed to resume online state pruning", "err", err)
+	}
+	if snaps := eth.blockchain.Snapshots(); snaps != nil {
+		eth.snapIntegrity = snapshot.NewIntegrityJob(snaps)
+	}
 	// Handle artificial finality config override cases.
 	if config.ECBP1100 != nil {
 		if n := config.ECBP1100.Uint64(); n != math.MaxUint64 {
@@ -281,6 +312,29 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 	}
 	eth.APIBackend.gpo = gasprice.NewOracle(eth.APIBackend, gpoParams)
 
+	limits := js.Limits{Steps: config.JSTracerSteps, Memory: config.JSTracerMemory}
+	if err := js.RegisterTracers(limits); err != nil {
+		return nil, fmt.Errorf("failed to register JS tracers: %v", err)
+	}
+	if config.JSTracerDir != "" {
+		names, err := js.LoadTracers(config.JSTracerDir, limits)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load JS tracers: %v", err)
+		}
+		log.Info("Loaded JS tracers", "dir", config.JSTracerDir, "tracers", names)
+	}
+	if config.JSTracerNamedOnly {
+		tracers.DefaultDirectory.DisableJSEval()
+		log.Info("Evaluation of user-provided JS tracers disabled")
+	}
+	if config.TraceCacheSize > 0 {
+		eth.traceCache = tracers.NewTraceCache(config.TraceCacheSize, eth.blockchain)
+	}
+	if config.TraceIndex {
+		eth.traceIndexer = tracers.NewTraceIndexer(eth.APIBackend, chainDb, config.TraceIndexHistory)
+		eth.traceIndexer.Start(eth.blockchain)
+	}
+
 	// Setup DNS discovery iterators.
 	dnsclient := dnsdisc.NewClient(dnsdisc.Config{})
 	eth.ethDialCandidates, err = dnsclient.NewIterator(eth.config.EthDiscoveryURLs...)
@@ -336,6 +390,9 @@ func (s *Ethereum) APIs() []rpc.API {
 		{
 			Namespace: "eth",
 			Service:   NewEthereumAPI(s),
+		}, {
+			Namespace: "eth",
+			Service:   NewBundleAPI(s),
 		}, {
 			Namespace: "miner",
 			Service:   NewMinerAPI(s),
@@ -569,7 +626,17 @@ func (s *Ethereum) Stop() error {
 	s.handler.Stop()
 
 	// Then stop everything else.
+	s.pruner.Stop()
+	if s.snapIntegrity != nil {
+		s.snapIntegrity.Stop()
+	}
 	s.bloomIndexer.Close()
+	if s.traceIndexer != nil {
+		s.traceIndexer.Close()
+	}
+	if s.traceCache != nil {
+		s.traceCache.Stop()
+	}
 	close(s.closeBloomHandler)
 	s.txPool.Stop()
 	s.miner.Close()
@@ -87,7 +87,11 @@ var Defaults = Config{
 	TrieDirtyCache:          256,
 	TrieTimeout:             60 * time.Minute,
 	SnapshotCache:           102,
+	StateHistory:            90000,
+	PruningBloomSize:        2048,
+	PruningThrottle:         100 * time.Millisecond,
 	FilterLogCacheSize:      32,
+	TraceCacheSize:          64,
 	Miner:                   miner.DefaultConfig,
 	TxPool:                  txpool.DefaultConfig,
 	RPCGasCap:               50000000,
@@ -139,6 +143,10 @@ type Config struct {
 	NoPrefetch bool // Whether to disable prefetching and only load state on demand
 
 	TxLookupLimit uint64 `toml:",omitempty"` // The maximum number of blocks from head whose tx indices are reserved.
+	HistoryRetain uint64 `toml:",omitempty"` // The number of blocks from head whose bodies and receipts are kept, 0 for all
+
+	TraceIndex        bool   `toml:",omitempty"` // Whether to index the call traces of the canonical chain
+	TraceIndexHistory uint64 `toml:",omitempty"` // The maximum number of blocks from head whose call traces are indexed, 0 for all
 
 	// RequiredBlocks is a set of block number -> hash mappings which must be in the
 	// canonical chain of all remote peers. Setting the option makes geth verify the
@@ -174,9 +182,23 @@ type Config struct {
 	SnapshotCache           int
 	Preimages               bool
 
+	// StateScheme is the scheme used to store the states and trie nodes on
+	// top. It can be 'hash', 'path', or none to use the scheme consistent
+	// with the persisted state.
+	StateScheme  string `toml:",omitempty"`
+	StateHistory uint64 `toml:",omitempty"` // Number of blocks from head whose state histories are reserved in path-based scheme, 0 for all
+	StateDiffs   uint64 `toml:",omitempty"` // Number of blocks from head whose reverse state diffs are kept, 0 to disable
+
+	// Online state pruning options
+	PruningBloomSize uint64        // Megabytes of memory allocated to the state bloom
+	PruningThrottle  time.Duration // Pause between two batches of stale state deleted
+
 	// This is the number of blocks for which logs will be cached in the filter system.
 	FilterLogCacheSize int
 
+	// This is the number of traced blocks whose results are cached, 0 to disable.
+	TraceCacheSize int
+
 	// Mining options
 	Miner miner.Config
 
@@ -207,6 +229,19 @@ type Config struct {
 	// RPCEVMTimeout is the global timeout for eth-call.
 	RPCEVMTimeout time.Duration
 
+	// JSTracerSteps and JSTracerMemory limit the number of tracer method
+	// invocations and the estimated bytes of JS state of a JS tracer for a
+	// single transaction (0 for no limit).
+	JSTracerSteps  uint64
+	JSTracerMemory uint64
+
+	// JSTracerDir is the directory of the named JS tracers to serve ("" for none).
+	JSTracerDir string
+
+	// JSTracerNamedOnly disables the evaluation of user-provided JS tracer
+	// code, serving only the registered named tracers.
+	JSTracerNamedOnly bool
+
 	// RPCTxFeeCap is the global transaction fee(price * gaslimit) cap for
 	// send-transaction variants. The unit is ether.
 	RPCTxFeeCap float64
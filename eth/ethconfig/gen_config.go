@@ -28,6 +28,9 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		NoPruning               bool
 		NoPrefetch              bool
 		TxLookupLimit           uint64                 `toml:",omitempty"`
+		HistoryRetain           uint64                 `toml:",omitempty"`
+		TraceIndex              bool                   `toml:",omitempty"`
+		TraceIndexHistory       uint64                 `toml:",omitempty"`
 		RequiredBlocks          map[uint64]common.Hash `toml:"-"`
 		LightServ               int                    `toml:",omitempty"`
 		LightIngress            int                    `toml:",omitempty"`
@@ -51,7 +54,13 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		TrieTimeout             time.Duration
 		SnapshotCache           int
 		Preimages               bool
+		StateScheme             string `toml:",omitempty"`
+		StateHistory            uint64 `toml:",omitempty"`
+		StateDiffs              uint64 `toml:",omitempty"`
+		PruningBloomSize        uint64
+		PruningThrottle         time.Duration
 		FilterLogCacheSize      int
+		TraceCacheSize          int
 		Miner                   miner.Config
 		Ethash                  ethash.Config
 		TxPool                  txpool.Config
@@ -62,6 +71,10 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		EVMInterpreter          string
 		RPCGasCap               uint64
 		RPCEVMTimeout           time.Duration
+		JSTracerSteps           uint64
+		JSTracerMemory          uint64
+		JSTracerDir             string
+		JSTracerNamedOnly       bool
 		RPCTxFeeCap             float64
 		Checkpoint              *ctypes.TrustedCheckpoint      `toml:",omitempty"`
 		CheckpointOracle        *ctypes.CheckpointOracleConfig `toml:",omitempty"`
@@ -79,6 +92,9 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.NoPruning = c.NoPruning
 	enc.NoPrefetch = c.NoPrefetch
 	enc.TxLookupLimit = c.TxLookupLimit
+	enc.HistoryRetain = c.HistoryRetain
+	enc.TraceIndex = c.TraceIndex
+	enc.TraceIndexHistory = c.TraceIndexHistory
 	enc.RequiredBlocks = c.RequiredBlocks
 	enc.LightServ = c.LightServ
 	enc.LightIngress = c.LightIngress
@@ -102,7 +118,13 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.TrieTimeout = c.TrieTimeout
 	enc.SnapshotCache = c.SnapshotCache
 	enc.Preimages = c.Preimages
+	enc.StateScheme = c.StateScheme
+	enc.StateHistory = c.StateHistory
+	enc.StateDiffs = c.StateDiffs
+	enc.PruningBloomSize = c.PruningBloomSize
+	enc.PruningThrottle = c.PruningThrottle
 	enc.FilterLogCacheSize = c.FilterLogCacheSize
+	enc.TraceCacheSize = c.TraceCacheSize
 	enc.Miner = c.Miner
 	enc.Ethash = c.Ethash
 	enc.TxPool = c.TxPool
@@ -113,6 +135,10 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.EVMInterpreter = c.EVMInterpreter
 	enc.RPCGasCap = c.RPCGasCap
 	enc.RPCEVMTimeout = c.RPCEVMTimeout
+	enc.JSTracerSteps = c.JSTracerSteps
+	enc.JSTracerMemory = c.JSTracerMemory
+	enc.JSTracerDir = c.JSTracerDir
+	enc.JSTracerNamedOnly = c.JSTracerNamedOnly
 	enc.RPCTxFeeCap = c.RPCTxFeeCap
 	enc.Checkpoint = c.Checkpoint
 	enc.CheckpointOracle = c.CheckpointOracle
@@ -134,6 +160,9 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		NoPruning               *bool
 		NoPrefetch              *bool
 		TxLookupLimit           *uint64                `toml:",omitempty"`
+		HistoryRetain           *uint64                `toml:",omitempty"`
+		TraceIndex              *bool                  `toml:",omitempty"`
+		TraceIndexHistory       *uint64                `toml:",omitempty"`
 		RequiredBlocks          map[uint64]common.Hash `toml:"-"`
 		LightServ               *int                   `toml:",omitempty"`
 		LightIngress            *int                   `toml:",omitempty"`
@@ -157,7 +186,13 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		TrieTimeout             *time.Duration
 		SnapshotCache           *int
 		Preimages               *bool
+		StateScheme             *string `toml:",omitempty"`
+		StateHistory            *uint64 `toml:",omitempty"`
+		StateDiffs              *uint64 `toml:",omitempty"`
+		PruningBloomSize        *uint64
+		PruningThrottle         *time.Duration
 		FilterLogCacheSize      *int
+		TraceCacheSize          *int
 		Miner                   *miner.Config
 		Ethash                  *ethash.Config
 		TxPool                  *txpool.Config
@@ -168,6 +203,10 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		EVMInterpreter          *string
 		RPCGasCap               *uint64
 		RPCEVMTimeout           *time.Duration
+		JSTracerSteps           *uint64
+		JSTracerMemory          *uint64
+		JSTracerDir             *string
+		JSTracerNamedOnly       *bool
 		RPCTxFeeCap             *float64
 		Checkpoint              *ctypes.TrustedCheckpoint      `toml:",omitempty"`
 		CheckpointOracle        *ctypes.CheckpointOracleConfig `toml:",omitempty"`
@@ -206,6 +245,15 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.TxLookupLimit != nil {
 		c.TxLookupLimit = *dec.TxLookupLimit
 	}
+	if dec.HistoryRetain != nil {
+		c.HistoryRetain = *dec.HistoryRetain
+	}
+	if dec.TraceIndex != nil {
+		c.TraceIndex = *dec.TraceIndex
+	}
+	if dec.TraceIndexHistory != nil {
+		c.TraceIndexHistory = *dec.TraceIndexHistory
+	}
 	if dec.RequiredBlocks != nil {
 		c.RequiredBlocks = dec.RequiredBlocks
 	}
@@ -275,9 +323,27 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.Preimages != nil {
 		c.Preimages = *dec.Preimages
 	}
+	if dec.StateScheme != nil {
+		c.StateScheme = *dec.StateScheme
+	}
+	if dec.StateHistory != nil {
+		c.StateHistory = *dec.StateHistory
+	}
+	if dec.StateDiffs != nil {
+		c.StateDiffs = *dec.StateDiffs
+	}
+	if dec.PruningBloomSize != nil {
+		c.PruningBloomSize = *dec.PruningBloomSize
+	}
+	if dec.PruningThrottle != nil {
+		c.PruningThrottle = *dec.PruningThrottle
+	}
 	if dec.FilterLogCacheSize != nil {
 		c.FilterLogCacheSize = *dec.FilterLogCacheSize
 	}
+	if dec.TraceCacheSize != nil {
+		c.TraceCacheSize = *dec.TraceCacheSize
+	}
 	if dec.Miner != nil {
 		c.Miner = *dec.Miner
 	}
@@ -308,6 +374,18 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.RPCEVMTimeout != nil {
 		c.RPCEVMTimeout = *dec.RPCEVMTimeout
 	}
+	if dec.JSTracerSteps != nil {
+		c.JSTracerSteps = *dec.JSTracerSteps
+	}
+	if dec.JSTracerMemory != nil {
+		c.JSTracerMemory = *dec.JSTracerMemory
+	}
+	if dec.JSTracerDir != nil {
+		c.JSTracerDir = *dec.JSTracerDir
+	}
+	if dec.JSTracerNamedOnly != nil {
+		c.JSTracerNamedOnly = *dec.JSTracerNamedOnly
+	}
 	if dec.RPCTxFeeCap != nil {
 		c.RPCTxFeeCap = *dec.RPCTxFeeCap
 	}
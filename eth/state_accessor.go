@@ -24,6 +24,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
@@ -57,7 +58,27 @@ var noopReleaser = tracers.StateReleaseFunc(func() {})
 //   - preferDisk: this arg can be used by the caller to signal that even though the 'base' is
 //     provided, it would be preferable to start from a fresh state, if we have it
 //     on disk.
-func (eth *Ethereum) StateAtBlock(ctx context.Context, block *types.Block, reexec uint64, base *state.StateDB, readOnly bool, preferDisk bool) (statedb *state.StateDB, release tracers.StateReleaseFunc, err error) {
+func (eth *Ethereum) StateAtBlock(ctx context.Context, block *types.Block, reexec uint64, base *state.StateDB, readOnly bool, preferDisk bool) (*state.StateDB, tracers.StateReleaseFunc, error) {
+	if eth.blockchain.TrieDB().Scheme() == rawdb.PathScheme {
+		return eth.pathState(block)
+	}
+	return eth.hashState(ctx, block, reexec, base, readOnly, preferDisk)
+}
+
+// pathState returns the state of the given block in path-based scheme. Only
+// the recent states maintained by the live database are available, as the
+// blocks can't be reexecuted over an ephemeral database.
+func (eth *Ethereum) pathState(block *types.Block) (*state.StateDB, tracers.StateReleaseFunc, error) {
+	statedb, err := eth.blockchain.StateAt(block.Root())
+	if err != nil {
+		return nil, nil, errors.New("historical state not available in path scheme")
+	}
+	return statedb, noopReleaser, nil
+}
+
+// hashState returns the state of the given block in hash-based scheme, see
+// StateAtBlock.
+func (eth *Ethereum) hashState(ctx context.Context, block *types.Block, reexec uint64, base *state.StateDB, readOnly bool, preferDisk bool) (statedb *state.StateDB, release tracers.StateReleaseFunc, err error) {
 	var (
 		current  *types.Block
 		database state.Database
@@ -77,6 +98,11 @@ func (eth *Ethereum) StateAtBlock(ctx context.Context, block *types.Block, reexe
 			}, nil
 		}
 	}
+	// The state might be rolled back by the reverse state diffs, isolated from
+	// the live database.
+	if statedb, err = eth.blockchain.HistoricState(block.Header()); err == nil {
+		return statedb, noopReleaser, nil
+	}
 	// The state is both for reading and writing, or it's unavailable in disk,
 	// try to construct/recover the state over an ephemeral trie.Database for
 	// isolating the live one.
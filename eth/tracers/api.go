@@ -95,11 +95,16 @@ type Backend interface {
 // API is the collection of tracing APIs exposed over the private debugging endpoint.
 type API struct {
 	backend Backend
+	cache   *TraceCache // Cache of block trace results, nil if disabled
 }
 
 // NewAPI creates a new API definition for the tracing methods of the Ethereum service.
 func NewAPI(backend Backend) *API {
-	return &API{backend: backend}
+	api := &API{backend: backend}
+	if b, ok := backend.(traceCacheBackend); ok {
+		api.cache = b.TraceCache()
+	}
+	return api
 }
 
 type chainContext struct {
@@ -607,11 +612,28 @@ func (api *API) StandardTraceBadBlockToFile(ctx context.Context, hash common.Has
 
 // traceBlock configures a new tracer according to the provided configuration, and
 // executes all the transactions contained within. The return value will be one item
-// per transaction, dependent on the requested tracer.
+// per transaction, dependent on the requested tracer. The results are served from
+// the trace cache if enabled.
 func (api *API) traceBlock(ctx context.Context, block *types.Block, config *TraceConfig) ([]*txTraceResult, error) {
 	if block.NumberU64() == 0 {
 		return nil, errors.New("genesis is not traceable")
 	}
+	key, cacheable := newTraceCacheKey(block.Hash(), config)
+	if cacheable {
+		if results, ok := api.cache.get(key); ok {
+			return results, nil
+		}
+	}
+	results, err := api.traceBlockUncached(ctx, block, config)
+	if err == nil && cacheable {
+		api.cache.add(key, results)
+	}
+	return results, err
+}
+
+// traceBlockUncached configures a new tracer according to the provided
+// configuration, and executes all the transactions contained within a block.
+func (api *API) traceBlockUncached(ctx context.Context, block *types.Block, config *TraceConfig) ([]*txTraceResult, error) {
 	// Prepare base state
 	parent, err := api.blockByNumberAndHash(ctx, rpc.BlockNumber(block.NumberU64()-1), block.ParentHash())
 	if err != nil {
@@ -1030,10 +1052,8 @@ func (api *API) TraceCallMany(ctx context.Context, txs []ethapi.TransactionArgs,
 // be tracer dependent.
 func (api *API) traceTx(ctx context.Context, message *core.Message, txctx *Context, vmctx vm.BlockContext, statedb *state.StateDB, config *TraceConfig) (interface{}, error) {
 	var (
-		tracer    Tracer
-		err       error
-		timeout   = defaultTraceTimeout
-		txContext = core.NewEVMTxContext(message)
+		tracer Tracer
+		err    error
 	)
 	if config == nil {
 		config = &TraceConfig{}
@@ -1046,11 +1066,22 @@ func (api *API) traceTx(ctx context.Context, message *core.Message, txctx *Conte
 			return nil, err
 		}
 	}
+	return api.traceTxWithTracer(ctx, message, txctx, vmctx, statedb, tracer, config.Timeout)
+}
+
+// traceTxWithTracer traces a single transaction with the given tracer, within
+// the given timeout if any, returning the result of the tracer.
+func (api *API) traceTxWithTracer(ctx context.Context, message *core.Message, txctx *Context, vmctx vm.BlockContext, statedb *state.StateDB, tracer Tracer, traceTimeout *string) (interface{}, error) {
+	var (
+		err       error
+		timeout   = defaultTraceTimeout
+		txContext = core.NewEVMTxContext(message)
+	)
 	vmenv := vm.NewEVM(vmctx, txContext, statedb, api.backend.ChainConfig(), vm.Config{Debug: true, Tracer: tracer, NoBaseFee: true})
 
 	// Define a meaningful timeout of a single transaction trace
-	if config.Timeout != nil {
-		if timeout, err = time.ParseDuration(*config.Timeout); err != nil {
+	if traceTimeout != nil {
+		if timeout, err = time.ParseDuration(*traceTimeout); err != nil {
 			return nil, err
 		}
 	}
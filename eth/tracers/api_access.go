@@ -0,0 +1,189 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// accessSetTracer is the native tracer recording the state accessed by txs.
+const accessSetTracer = "accessSetTracer"
+
+// accessSet is the state read and written by a tx, as reported by the
+// accessSetTracer.
+type accessSet struct {
+	Reads  map[common.Address]*accountAccess `json:"reads"`
+	Writes map[common.Address]*accountAccess `json:"writes"`
+}
+
+type accountAccess struct {
+	Balance bool          `json:"balance"`
+	Nonce   bool          `json:"nonce"`
+	Code    bool          `json:"code"`
+	Storage []common.Hash `json:"storage"`
+}
+
+// stateKey is a part of the state accessed by txs.
+type stateKey struct {
+	addr  common.Address
+	field string      // "balance", "nonce", "code" or "storage"
+	slot  common.Hash // Storage slot, if any
+}
+
+// accessKeys returns the parts of the state of the accesses.
+func accessKeys(accesses map[common.Address]*accountAccess) []stateKey {
+	var keys []stateKey
+	for addr, access := range accesses {
+		if access.Balance {
+			keys = append(keys, stateKey{addr: addr, field: "balance"})
+		}
+		if access.Nonce {
+			keys = append(keys, stateKey{addr: addr, field: "nonce"})
+		}
+		if access.Code {
+			keys = append(keys, stateKey{addr: addr, field: "code"})
+		}
+		for _, slot := range access.Storage {
+			keys = append(keys, stateKey{addr: addr, field: "storage", slot: slot})
+		}
+	}
+	return keys
+}
+
+// AccessConflict is a dependency of a tx on an earlier tx of the same block,
+// preventing their parallel execution.
+type AccessConflict struct {
+	From            int  `json:"from"` // Index of the earlier tx
+	To              int  `json:"to"`   // Index of the later tx
+	ReadAfterWrite  bool `json:"readAfterWrite"`
+	WriteAfterWrite bool `json:"writeAfterWrite"`
+	WriteAfterRead  bool `json:"writeAfterRead"`
+}
+
+// BlockAccessSets is the state accessed by the txs of a block, with the
+// conflicts between them.
+type BlockAccessSets struct {
+	Transactions []*txTraceResult  `json:"transactions"`
+	Conflicts    []*AccessConflict `json:"conflicts"`
+
+	// CriticalPath is the length of the longest chain of txs reading the writes
+	// of each other, the lower bound of sequential steps to execute the block.
+	CriticalPath int `json:"criticalPath"`
+}
+
+// TraceBlockAccessSets returns the state read and written by each tx of a
+// block, along with the conflicts between the txs. The txs are traced with
+// the accessSetTracer, and any tracer given in the config is ignored.
+func (api *API) TraceBlockAccessSets(ctx context.Context, number rpc.BlockNumber, config *TraceConfig) (*BlockAccessSets, error) {
+	block, err := api.blockByNumber(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	tracer := accessSetTracer
+	traceConfig := &TraceConfig{Tracer: &tracer}
+	if config != nil {
+		traceConfig.Timeout, traceConfig.Reexec = config.Timeout, config.Reexec
+	}
+	results, err := api.traceBlock(ctx, block, traceConfig)
+	if err != nil {
+		return nil, err
+	}
+	sets := make([]*accessSet, len(results))
+	for i, result := range results {
+		if result.Error != "" {
+			return nil, fmt.Errorf("tx %d: %s", i, result.Error)
+		}
+		raw, ok := result.Result.(json.RawMessage)
+		if !ok {
+			return nil, fmt.Errorf("tx %d: unexpected trace result %T", i, result.Result)
+		}
+		sets[i] = new(accessSet)
+		if err := json.Unmarshal(raw, sets[i]); err != nil {
+			return nil, fmt.Errorf("tx %d: %v", i, err)
+		}
+	}
+	conflicts, path := accessConflicts(sets)
+	return &BlockAccessSets{Transactions: results, Conflicts: conflicts, CriticalPath: path}, nil
+}
+
+// accessConflicts returns the conflicts between the txs of the given access
+// sets, ordered by the later and then the earlier tx, along with the length of
+// the critical path of the read-after-write dependencies.
+func accessConflicts(sets []*accessSet) ([]*AccessConflict, int) {
+	var (
+		readers   = make(map[stateKey][]int) // Txs reading a part of the state
+		writers   = make(map[stateKey][]int) // Txs writing a part of the state
+		depth     = make([]int, len(sets))   // Length of the longest chain ending at each tx
+		conflicts = []*AccessConflict{}
+		path      int
+	)
+	for i, set := range sets {
+		var (
+			reads  = accessKeys(set.Reads)
+			writes = accessKeys(set.Writes)
+			edges  = make(map[int]*AccessConflict)
+		)
+		edge := func(from int) *AccessConflict {
+			if c, ok := edges[from]; ok {
+				return c
+			}
+			c := &AccessConflict{From: from, To: i}
+			edges[from] = c
+			return c
+		}
+		for _, key := range reads {
+			for _, from := range writers[key] {
+				edge(from).ReadAfterWrite = true
+			}
+		}
+		for _, key := range writes {
+			for _, from := range writers[key] {
+				edge(from).WriteAfterWrite = true
+			}
+			for _, from := range readers[key] {
+				edge(from).WriteAfterRead = true
+			}
+		}
+		depth[i] = 1
+		for from := 0; from < i; from++ {
+			c, ok := edges[from]
+			if !ok {
+				continue
+			}
+			conflicts = append(conflicts, c)
+			if c.ReadAfterWrite && depth[from]+1 > depth[i] {
+				depth[i] = depth[from] + 1
+			}
+		}
+		if depth[i] > path {
+			path = depth[i]
+		}
+		// Record the accesses of the tx for the later ones
+		for _, key := range reads {
+			readers[key] = append(readers[key], i)
+		}
+		for _, key := range writes {
+			writers[key] = append(writers[key], i)
+		}
+	}
+	return conflicts, path
+}
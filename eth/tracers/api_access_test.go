@@ -0,0 +1,71 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestAccessConflicts(t *testing.T) {
+	var (
+		a    = common.HexToAddress("0xaa")
+		b    = common.HexToAddress("0xbb")
+		c    = common.HexToAddress("0xcc")
+		slot = common.Hash{0x01}
+	)
+	sets := []*accessSet{
+		// Transfer from a to b
+		{
+			Reads:  map[common.Address]*accountAccess{a: {Balance: true, Nonce: true}, b: {Code: true}},
+			Writes: map[common.Address]*accountAccess{a: {Balance: true, Nonce: true}, b: {Balance: true}},
+		},
+		// Independent storage write by c
+		{
+			Reads:  map[common.Address]*accountAccess{c: {Balance: true, Nonce: true, Storage: []common.Hash{slot}}},
+			Writes: map[common.Address]*accountAccess{c: {Balance: true, Nonce: true, Storage: []common.Hash{slot}}},
+		},
+		// Transfer from b to a
+		{
+			Reads:  map[common.Address]*accountAccess{b: {Balance: true, Nonce: true}, a: {Code: true}},
+			Writes: map[common.Address]*accountAccess{b: {Balance: true, Nonce: true}, a: {Balance: true}},
+		},
+		// Storage read by a
+		{
+			Reads:  map[common.Address]*accountAccess{a: {Balance: true, Nonce: true}, c: {Storage: []common.Hash{slot}}},
+			Writes: map[common.Address]*accountAccess{a: {Balance: true, Nonce: true}},
+		},
+	}
+	conflicts, path := accessConflicts(sets)
+	want := []*AccessConflict{
+		{From: 0, To: 2, ReadAfterWrite: true, WriteAfterWrite: true, WriteAfterRead: true},
+		{From: 0, To: 3, ReadAfterWrite: true, WriteAfterWrite: true, WriteAfterRead: true},
+		{From: 1, To: 3, ReadAfterWrite: true},
+		{From: 2, To: 3, ReadAfterWrite: true, WriteAfterWrite: true},
+	}
+	if !reflect.DeepEqual(conflicts, want) {
+		for i, c := range conflicts {
+			t.Logf("conflict %d: %+v", i, c)
+		}
+		t.Fatalf("conflicts mismatch")
+	}
+	if path != 3 {
+		t.Errorf("critical path mismatch: have %d, want 3", path)
+	}
+}
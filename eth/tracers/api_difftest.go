@@ -0,0 +1,146 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/eth/tracers/difftest"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// errNoEVMC is returned if the interpreters are compared on a node without
+// an EVMC interpreter.
+var errNoEVMC = errors.New("no EVMC interpreter configured (use --vm.evm)")
+
+// evmcBackend is implemented by the backends running an EVMC interpreter.
+type evmcBackend interface {
+	EVMInterpreter() string
+}
+
+// DiffTransaction executes a transaction with both the native interpreter and
+// the EVMC interpreter of the node, reporting the first divergence between
+// the executions in the steps, the call frames, the results, the logs or the
+// written state.
+//
+// The steps (stack, memory, gas and storage) are only compared if both
+// interpreters report them, as stated by the report's stepsCompared. EVMC
+// interpreters don't report the execution of single opcodes, so in practice
+// only the divergence in the call frames, the results, the logs and the written
+// state is reported, and the step of a divergence can't be pinpointed.
+func (api *API) DiffTransaction(ctx context.Context, hash common.Hash, config *TraceConfig) (*difftest.Report, error) {
+	var evmc string
+	if b, ok := api.backend.(evmcBackend); ok {
+		evmc = b.EVMInterpreter()
+	}
+	if evmc == "" {
+		return nil, errNoEVMC
+	}
+	tx, blockHash, blockNumber, index, err := api.backend.GetTransaction(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	// Only mined txes are supported
+	if tx == nil {
+		return nil, errTxNotFound
+	}
+	// It shouldn't happen in practice.
+	if blockNumber == 0 {
+		return nil, errors.New("genesis is not traceable")
+	}
+	if config == nil {
+		config = &TraceConfig{Config: &logger.Config{EnableMemory: true}}
+	}
+	reexec := defaultTraceReexec
+	if config.Reexec != nil {
+		reexec = *config.Reexec
+	}
+	block, err := api.blockByNumberAndHash(ctx, rpc.BlockNumber(blockNumber), blockHash)
+	if err != nil {
+		return nil, err
+	}
+	msg, vmctx, statedb, release, err := api.backend.StateAtTransaction(ctx, block, int(index), reexec)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var (
+		recorders = make([]*difftest.Recorder, 2)
+		states    = make([]*difftest.StateRecorder, 2)
+		isEIP161D = api.backend.ChainConfig().IsEnabled(api.backend.ChainConfig().GetEIP161dTransition, block.Number())
+	)
+	for i, interpreter := range []string{"", evmc} {
+		recorders[i] = difftest.NewRecorder(config.Config)
+		states[i] = difftest.NewStateRecorder(statedb.Copy())
+		states[i].SetTxContext(hash, int(index))
+
+		vmenv := vm.NewEVM(vmctx, core.NewEVMTxContext(msg), states[i], api.backend.ChainConfig(), vm.Config{Debug: true, Tracer: recorders[i], NoBaseFee: true, EVMInterpreter: interpreter})
+		if err := applyWithTimeout(ctx, vmenv, msg, config.Timeout); err != nil {
+			return nil, err
+		}
+		states[i].Finalise(isEIP161D)
+	}
+	report := &difftest.Report{
+		Native:        recorders[0].Summary(),
+		EVMC:          recorders[1].Summary(),
+		StepsCompared: difftest.StepsComparable(recorders[0], recorders[1]),
+		Divergence:    difftest.Compare(recorders[0], recorders[1]),
+	}
+	if report.Divergence == nil {
+		report.Divergence = difftest.CompareLogs(states[0].GetLogs(hash, blockNumber, blockHash), states[1].GetLogs(hash, blockNumber, blockHash))
+	}
+	if report.Divergence == nil {
+		report.Divergence = difftest.CompareState(states[0], states[1])
+	}
+	return report, nil
+}
+
+// applyWithTimeout executes the message, cancelling the execution once the
+// given timeout elapses.
+func applyWithTimeout(ctx context.Context, vmenv *vm.EVM, msg *core.Message, traceTimeout *string) error {
+	timeout := defaultTraceTimeout
+	if traceTimeout != nil {
+		var err error
+		if timeout, err = time.ParseDuration(*traceTimeout); err != nil {
+			return err
+		}
+	}
+	deadlineCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	go func() {
+		<-deadlineCtx.Done()
+		if errors.Is(deadlineCtx.Err(), context.DeadlineExceeded) {
+			// Stop evm execution. Note cancellation is not necessarily immediate.
+			vmenv.Cancel()
+		}
+	}()
+	if _, err := core.ApplyMessage(vmenv, msg, new(core.GasPool).AddGas(msg.GasLimit)); err != nil {
+		return fmt.Errorf("tracing failed: %w", err)
+	}
+	if deadlineCtx.Err() != nil {
+		return errors.New("execution timeout")
+	}
+	return nil
+}
@@ -20,11 +20,16 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/internal/ethapi"
+	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/params/mutations"
 	"github.com/ethereum/go-ethereum/rpc"
 )
@@ -129,6 +134,8 @@ func decorateNestedTraceResponse(res interface{}, tracer string) interface{} {
 		out["trace"] = res
 	} else if tracer == "stateDiffTracer" {
 		out["stateDiff"] = res
+	} else if tracer == "vmTraceParity" {
+		out["vmTrace"] = res
 	} else {
 		return res
 	}
@@ -195,9 +202,11 @@ func (api *TraceAPI) Block(ctx context.Context, number rpc.BlockNumber, config *
 		return nil, err
 	}
 
-	traceResults, err := api.debugAPI.traceBlock(ctx, block, config)
-	if err != nil {
-		return nil, err
+	traceResults := api.indexedBlockTraces(block.NumberU64(), block.Hash(), config)
+	if traceResults == nil {
+		if traceResults, err = api.debugAPI.traceBlock(ctx, block, config); err != nil {
+			return nil, err
+		}
 	}
 
 	traceReward, err := api.traceBlockReward(ctx, block, config)
@@ -246,14 +255,275 @@ func (api *TraceAPI) Transaction(ctx context.Context, hash common.Hash, config *
 // Filter configures a new tracer according to the provided configuration, and
 // executes all the transactions contained within. The return value will be one item
 // per transaction, dependent on the requested tracer.
+// If the interval is covered by the trace index, the traces are served from it
+// instead. Either way the traces of the trace index tracer are filtered by the
+// sender and recipient addresses, and paginated by the after and count arguments,
+// if any. The results of other tracers are returned unfiltered.
 func (api *TraceAPI) Filter(ctx context.Context, args TraceFilterArgs, config *TraceConfig) (*rpc.Subscription, error) {
 	config = setTraceConfigDefaultTracer(config)
 
+	var filter *traceFilter
+	if *config.Tracer == traceIndexTracer {
+		filter = newTraceFilter(args)
+	}
 	// Fetch the block interval that we want to trace
 	start := rpc.BlockNumber(args.FromBlock)
 	end := rpc.BlockNumber(args.ToBlock)
 
-	return api.debugAPI.TraceChain(ctx, start, end, config)
+	from, err := api.debugAPI.blockByNumber(ctx, start)
+	if err != nil {
+		return nil, err
+	}
+	to, err := api.debugAPI.blockByNumber(ctx, end)
+	if err != nil {
+		return nil, err
+	}
+	if from.Number().Cmp(to.Number()) >= 0 {
+		return nil, fmt.Errorf("end block (#%d) needs to come after start block (#%d)", end, start)
+	}
+	// Tracing a chain is a **long** operation, only do with subscriptions
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+
+	// Abort tracing once the subscription is gone or enough traces were sent
+	var (
+		closed = make(chan interface{})
+		once   sync.Once
+		abort  = func() { once.Do(func() { close(closed) }) }
+	)
+	go func() {
+		select {
+		case <-notifier.Closed():
+			abort()
+		case <-closed:
+		}
+	}()
+	var resCh chan *blockTraceResult
+	if isIndexedTraceConfig(config) && api.indexCovers(from.NumberU64()+1, to.NumberU64()) {
+		resCh = api.traceIndexed(from.NumberU64()+1, to.NumberU64(), filter, config, closed)
+	} else {
+		resCh = api.debugAPI.traceChain(from, to, config, closed)
+	}
+	go func() {
+		defer abort()
+		for result := range resCh {
+			if filter != nil {
+				if result = filter.apply(result); result == nil {
+					continue
+				}
+			}
+			notifier.Notify(sub.ID, result)
+			if filter.done() {
+				abort()
+			}
+		}
+	}()
+	return sub, nil
+}
+
+// isIndexedTraceConfig returns whether the results of the given trace config are
+// the ones stored by the trace index.
+func isIndexedTraceConfig(config *TraceConfig) bool {
+	return config.Tracer != nil && *config.Tracer == traceIndexTracer && len(config.TracerConfig) == 0
+}
+
+// indexCovers returns whether the trace index contains the canonical blocks of
+// the given inclusive range. As sections are indexed in order, the tail and the
+// last block being indexed implies all the blocks in between are too.
+func (api *TraceAPI) indexCovers(first, last uint64) bool {
+	db := api.debugAPI.backend.ChainDb()
+	if tail := rawdb.ReadTraceIndexTail(db); tail == nil || *tail > first {
+		return false
+	}
+	return rawdb.HasBlockTraces(db, rawdb.ReadCanonicalHash(db, last), last)
+}
+
+// indexedBlockTraces returns the results of tracing the given block from the
+// trace index, or nil if the results of the config are not indexed.
+func (api *TraceAPI) indexedBlockTraces(number uint64, hash common.Hash, config *TraceConfig) []*txTraceResult {
+	if !isIndexedTraceConfig(config) {
+		return nil
+	}
+	traces := rawdb.ReadBlockTraces(api.debugAPI.backend.ChainDb(), hash, number)
+	if traces == nil {
+		return nil
+	}
+	results := make([]*txTraceResult, len(traces))
+	for i, trace := range traces {
+		results[i] = &txTraceResult{Result: json.RawMessage(trace)}
+	}
+	return results
+}
+
+// traceIndexed streams the indexed traces of the given inclusive range of
+// canonical blocks which may match the filter, if any. Blocks whose traces are
+// missing from the index are traced again instead.
+// The streaming should be aborted in case the closed signal is received.
+func (api *TraceAPI) traceIndexed(first, last uint64, filter *traceFilter, config *TraceConfig, closed <-chan interface{}) chan *blockTraceResult {
+	var (
+		db      = api.debugAPI.backend.ChainDb()
+		from    *common.Address
+		to      *common.Address
+		resCh   = make(chan *blockTraceResult)
+		numbers []uint64
+	)
+	if filter != nil {
+		from, to = filter.from, filter.to
+	}
+	numbers = indexedBlockNumbers(db, first, last, from, to)
+
+	go func() {
+		defer close(resCh)
+
+		for _, number := range numbers {
+			hash := rawdb.ReadCanonicalHash(db, number)
+			result := &blockTraceResult{Block: hexutil.Uint64(number), Hash: hash}
+			if result.Traces = api.indexedBlockTraces(number, hash, config); result.Traces == nil {
+				log.Debug("Indexed traces unavailable, tracing block", "number", number, "hash", hash)
+				traces, err := api.traceBlockByNumber(number, config)
+				if err != nil {
+					log.Warn("Tracing failed", "block", number, "err", err)
+					traces = []*txTraceResult{{Error: err.Error()}}
+				}
+				result.Traces = traces
+			}
+			select {
+			case resCh <- result:
+			case <-closed:
+				return
+			}
+		}
+	}()
+	return resCh
+}
+
+// traceBlockByNumber traces the canonical block of the given number.
+func (api *TraceAPI) traceBlockByNumber(number uint64, config *TraceConfig) ([]*txTraceResult, error) {
+	ctx := context.Background()
+	block, err := api.debugAPI.blockByNumber(ctx, rpc.BlockNumber(number))
+	if err != nil {
+		return nil, err
+	}
+	return api.debugAPI.traceBlock(ctx, block, config)
+}
+
+// indexedBlockNumbers returns the canonical blocks of the given inclusive range
+// which may contain traces sent from and to the given addresses, nil matching any.
+func indexedBlockNumbers(db ethdb.Database, first, last uint64, from, to *common.Address) []uint64 {
+	var numbers []uint64
+	if from == nil && to == nil {
+		for number := first; number <= last; number++ {
+			numbers = append(numbers, number)
+		}
+		return numbers
+	}
+	addr := from
+	if addr == nil {
+		addr = to
+	}
+	for _, nh := range rawdb.ReadTraceAddressBlocks(db, *addr, first, last) {
+		if rawdb.ReadCanonicalHash(db, nh.Number) == nh.Hash {
+			numbers = append(numbers, nh.Number)
+		}
+	}
+	return numbers
+}
+
+// traceFilter selects the traces sent from and to the given addresses, nil
+// matching any, skipping the first after of them and keeping at most count
+// (0 for no limit).
+type traceFilter struct {
+	from, to     *common.Address
+	after, count uint64
+
+	skipped uint64 // Number of matching traces skipped so far
+	kept    uint64 // Number of matching traces kept so far
+}
+
+// newTraceFilter creates a filter for the given arguments, or nil if they
+// select all traces.
+func newTraceFilter(args TraceFilterArgs) *traceFilter {
+	if args.FromAddress == nil && args.ToAddress == nil && args.After == 0 && args.Count == 0 {
+		return nil
+	}
+	return &traceFilter{
+		from:  args.FromAddress,
+		to:    args.ToAddress,
+		after: args.After,
+		count: args.Count,
+	}
+}
+
+// done returns whether the filter has kept as many traces as requested.
+func (f *traceFilter) done() bool {
+	return f != nil && f.count != 0 && f.kept >= f.count
+}
+
+// apply returns the traces of the given block result selected by the filter,
+// or nil if there are none. Failed transactions are kept as is.
+func (f *traceFilter) apply(result *blockTraceResult) *blockTraceResult {
+	filtered := &blockTraceResult{Block: result.Block, Hash: result.Hash, Traces: []*txTraceResult{}}
+	for _, res := range result.Traces {
+		if res.Error != "" {
+			filtered.Traces = append(filtered.Traces, res)
+			continue
+		}
+		blob, ok := res.Result.(json.RawMessage)
+		if !ok {
+			var err error
+			if blob, err = json.Marshal(res.Result); err != nil {
+				filtered.Traces = append(filtered.Traces, &txTraceResult{Error: err.Error()})
+				continue
+			}
+		}
+		traces, err := f.filter(blob)
+		if err != nil {
+			filtered.Traces = append(filtered.Traces, &txTraceResult{Error: err.Error()})
+			continue
+		}
+		if traces != nil {
+			filtered.Traces = append(filtered.Traces, &txTraceResult{Result: json.RawMessage(traces)})
+		}
+	}
+	if len(filtered.Traces) == 0 {
+		return nil
+	}
+	return filtered
+}
+
+// filter returns the traces of a transaction selected by the filter, or nil
+// if there are none.
+func (f *traceFilter) filter(blob []byte) ([]byte, error) {
+	var traces []json.RawMessage
+	if err := json.Unmarshal(blob, &traces); err != nil {
+		return nil, err
+	}
+	var matches []json.RawMessage
+	for _, trace := range traces {
+		if f.done() {
+			break
+		}
+		var decoded indexedTrace
+		if err := json.Unmarshal(trace, &decoded); err != nil {
+			return nil, err
+		}
+		if !decoded.matches(f.from, f.to) {
+			continue
+		}
+		if f.skipped < f.after {
+			f.skipped++
+			continue
+		}
+		matches = append(matches, trace)
+		f.kept++
+	}
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(matches)
 }
 
 // Call lets you trace a given eth_call. It collects the structured logs created during the execution of EVM
@@ -276,3 +546,219 @@ func (api *TraceAPI) CallMany(ctx context.Context, txs []ethapi.TransactionArgs,
 	config = setTraceCallConfigDefaultTracer(config)
 	return api.debugAPI.TraceCallMany(ctx, txs, blockNrOrHash, config)
 }
+
+// parityTraceTypeTracers maps the Parity trace types to the tracers producing them.
+var parityTraceTypeTracers = map[string]string{
+	"trace":     "callTracerParity",
+	"vmTrace":   "vmTraceParity",
+	"stateDiff": "stateDiffTracer",
+}
+
+// TraceReplayResult is the result of replaying a transaction with the requested
+// Parity trace types. Trace types not requested are left empty.
+type TraceReplayResult struct {
+	Output          hexutil.Bytes   `json:"output"`
+	StateDiff       json.RawMessage `json:"stateDiff"`
+	Trace           json.RawMessage `json:"trace"`
+	VmTrace         json.RawMessage `json:"vmTrace"`
+	TransactionHash *common.Hash    `json:"transactionHash,omitempty"`
+}
+
+// newReplayConfig creates the trace config running all the tracers producing the
+// given Parity trace types in one execution, through the mux tracer. The call
+// tracer is always run, as the output of the transaction is taken from it.
+func newReplayConfig(traceTypes []string) (*TraceConfig, error) {
+	tracers := map[string]json.RawMessage{"callTracerParity": nil}
+	for _, traceType := range traceTypes {
+		tracer, ok := parityTraceTypeTracers[traceType]
+		if !ok {
+			return nil, fmt.Errorf("unknown trace type %q", traceType)
+		}
+		tracers[tracer] = nil
+	}
+	tracerConfig, err := json.Marshal(tracers)
+	if err != nil {
+		return nil, err
+	}
+	tracer := "muxTracer"
+	return &TraceConfig{Tracer: &tracer, TracerConfig: tracerConfig}, nil
+}
+
+// newReplayResult assembles the replay result of the given trace types from the
+// result of the mux tracer.
+func newReplayResult(res interface{}, traceTypes []string) (*TraceReplayResult, error) {
+	raw, ok := res.(json.RawMessage)
+	if !ok {
+		return nil, errors.New("unexpected trace result")
+	}
+	var results map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &results); err != nil {
+		return nil, err
+	}
+	var calls []struct {
+		Result *struct {
+			Code   *hexutil.Bytes `json:"code"`
+			Output *hexutil.Bytes `json:"output"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(results["callTracerParity"], &calls); err != nil {
+		return nil, err
+	}
+	replay := &TraceReplayResult{Output: hexutil.Bytes{}, Trace: json.RawMessage("[]")}
+	if len(calls) > 0 && calls[0].Result != nil {
+		if calls[0].Result.Output != nil {
+			replay.Output = *calls[0].Result.Output
+		} else if calls[0].Result.Code != nil {
+			replay.Output = *calls[0].Result.Code
+		}
+	}
+	for _, traceType := range traceTypes {
+		result := results[parityTraceTypeTracers[traceType]]
+		switch traceType {
+		case "trace":
+			replay.Trace = result
+		case "vmTrace":
+			replay.VmTrace = result
+		case "stateDiff":
+			replay.StateDiff = result
+		}
+	}
+	return replay, nil
+}
+
+// ReplayTransaction replays the transaction with the given hash, returning the
+// requested Parity trace types ("trace", "vmTrace" and "stateDiff"). All of them
+// are produced by a single execution.
+func (api *TraceAPI) ReplayTransaction(ctx context.Context, hash common.Hash, traceTypes []string) (*TraceReplayResult, error) {
+	config, err := newReplayConfig(traceTypes)
+	if err != nil {
+		return nil, err
+	}
+	res, err := api.debugAPI.TraceTransaction(ctx, hash, config)
+	if err != nil {
+		return nil, err
+	}
+	return newReplayResult(res, traceTypes)
+}
+
+// ReplayBlockTransactions replays all the transactions of the given block,
+// returning the requested Parity trace types for each of them.
+func (api *TraceAPI) ReplayBlockTransactions(ctx context.Context, number rpc.BlockNumber, traceTypes []string) ([]*TraceReplayResult, error) {
+	config, err := newReplayConfig(traceTypes)
+	if err != nil {
+		return nil, err
+	}
+	block, err := api.debugAPI.blockByNumber(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	traceResults, err := api.debugAPI.traceBlock(ctx, block, config)
+	if err != nil {
+		return nil, err
+	}
+	txs := block.Transactions()
+	results := make([]*TraceReplayResult, len(traceResults))
+	for i, result := range traceResults {
+		if result.Error != "" {
+			return nil, errors.New(result.Error)
+		}
+		replay, err := newReplayResult(result.Result, traceTypes)
+		if err != nil {
+			return nil, err
+		}
+		hash := txs[i].Hash()
+		replay.TransactionHash = &hash
+		results[i] = replay
+	}
+	return results, nil
+}
+
+// Get returns the trace of the transaction with the given hash addressed by the
+// given trace address, or nil if there is no such trace.
+func (api *TraceAPI) Get(ctx context.Context, hash common.Hash, indices []hexutil.Uint64) (json.RawMessage, error) {
+	res, err := api.Transaction(ctx, hash, nil)
+	if err != nil {
+		return nil, err
+	}
+	raw, ok := res.(json.RawMessage)
+	if !ok {
+		return nil, errors.New("unexpected trace result")
+	}
+	return findTrace(raw, indices)
+}
+
+// findTrace returns the trace addressed by the given trace address out of the
+// given flat list of Parity traces, or nil if there is no such trace.
+func findTrace(raw json.RawMessage, indices []hexutil.Uint64) (json.RawMessage, error) {
+	var traces []json.RawMessage
+	if err := json.Unmarshal(raw, &traces); err != nil {
+		return nil, err
+	}
+	for _, trace := range traces {
+		var addressed struct {
+			TraceAddress []uint64 `json:"traceAddress"`
+		}
+		if err := json.Unmarshal(trace, &addressed); err != nil {
+			return nil, err
+		}
+		if len(addressed.TraceAddress) != len(indices) {
+			continue
+		}
+		match := true
+		for i, index := range indices {
+			if addressed.TraceAddress[i] != uint64(index) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return trace, nil
+		}
+	}
+	return nil, nil
+}
+
+// RawTransaction traces the given signed raw transaction on top of the latest
+// block, without broadcasting it, returning the requested Parity trace types.
+func (api *TraceAPI) RawTransaction(ctx context.Context, input hexutil.Bytes, traceTypes []string) (*TraceReplayResult, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(input); err != nil {
+		return nil, err
+	}
+	from, err := types.Sender(types.LatestSigner(api.debugAPI.backend.ChainConfig()), tx)
+	if err != nil {
+		return nil, err
+	}
+	config, err := newReplayConfig(traceTypes)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		gas   = hexutil.Uint64(tx.Gas())
+		nonce = hexutil.Uint64(tx.Nonce())
+		data  = hexutil.Bytes(tx.Data())
+		args  = ethapi.TransactionArgs{
+			From:  &from,
+			To:    tx.To(),
+			Gas:   &gas,
+			Value: (*hexutil.Big)(tx.Value()),
+			Nonce: &nonce,
+			Input: &data,
+		}
+	)
+	if tx.Type() == types.DynamicFeeTxType {
+		args.MaxFeePerGas = (*hexutil.Big)(tx.GasFeeCap())
+		args.MaxPriorityFeePerGas = (*hexutil.Big)(tx.GasTipCap())
+	} else {
+		args.GasPrice = (*hexutil.Big)(tx.GasPrice())
+	}
+	if tx.Type() != types.LegacyTxType {
+		accessList := tx.AccessList()
+		args.AccessList = &accessList
+	}
+	res, err := api.debugAPI.TraceCall(ctx, args, rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber), &TraceCallConfig{TraceConfig: *config})
+	if err != nil {
+		return nil, err
+	}
+	return newReplayResult(res, traceTypes)
+}
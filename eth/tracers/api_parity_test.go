@@ -1,7 +1,10 @@
 package tracers
 
 import (
+	"encoding/json"
 	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
 )
 
 // BenchmarkTraceResultsAppend1 compares performance against BenchmarkTraceResultsAppend2,
@@ -39,3 +42,47 @@ func BenchmarkTraceResultsAppend2(b *testing.B) {
 		results = append(results, traceResults...) // nolint:ineffassign,staticcheck
 	}
 }
+
+func TestReplayResult(t *testing.T) {
+	if _, err := newReplayConfig([]string{"trace", "bogus"}); err == nil {
+		t.Fatalf("unknown trace type accepted")
+	}
+	config, err := newReplayConfig([]string{"vmTrace", "stateDiff"})
+	if err != nil {
+		t.Fatalf("failed to create replay config: %v", err)
+	}
+	if *config.Tracer != "muxTracer" || string(config.TracerConfig) != `{"callTracerParity":null,"stateDiffTracer":null,"vmTraceParity":null}` {
+		t.Fatalf("replay config mismatch: %s %s", *config.Tracer, config.TracerConfig)
+	}
+	res := json.RawMessage(`{"callTracerParity":[{"result":{"gasUsed":"0x0","output":"0x2a"}}],"stateDiffTracer":{},"vmTraceParity":{"code":"0x","ops":[]}}`)
+	replay, err := newReplayResult(res, []string{"vmTrace"})
+	if err != nil {
+		t.Fatalf("failed to assemble replay result: %v", err)
+	}
+	have, _ := json.Marshal(replay)
+	if want := `{"output":"0x2a","stateDiff":null,"trace":[],"vmTrace":{"code":"0x","ops":[]}}`; string(have) != want {
+		t.Fatalf("replay result mismatch\n have: %s\n want: %s", have, want)
+	}
+}
+
+func TestFindTrace(t *testing.T) {
+	traces := json.RawMessage(`[{"traceAddress":[],"type":"call"},{"traceAddress":[0],"type":"create"},{"traceAddress":[0,1],"type":"suicide"}]`)
+	tests := []struct {
+		indices []hexutil.Uint64
+		want    string
+	}{
+		{[]hexutil.Uint64{}, `{"traceAddress":[],"type":"call"}`},
+		{[]hexutil.Uint64{0}, `{"traceAddress":[0],"type":"create"}`},
+		{[]hexutil.Uint64{0, 1}, `{"traceAddress":[0,1],"type":"suicide"}`},
+		{[]hexutil.Uint64{1}, ``},
+	}
+	for i, tt := range tests {
+		trace, err := findTrace(traces, tt.indices)
+		if err != nil {
+			t.Fatalf("test %d: failed to find trace: %v", i, err)
+		}
+		if string(trace) != tt.want {
+			t.Errorf("test %d: trace mismatch: have %s, want %s", i, trace, tt.want)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// traceStreamBuffer is the number of notifications queued for a subscriber
+// of a block trace stream, before the tracing waits for the subscriber.
+const traceStreamBuffer = 256
+
+// TraceStreamEvent is a notification of a block trace stream. It carries
+// either a step of the struct logger, or the result of tracing a transaction
+// once finished.
+type TraceStreamEvent struct {
+	TxIndex int                  `json:"txIndex"`
+	TxHash  common.Hash          `json:"txHash"`
+	Step    *logger.StructLogRes `json:"step,omitempty"`
+	Result  interface{}          `json:"result,omitempty"`
+	Error   string               `json:"error,omitempty"`
+}
+
+// TraceBlockStream traces the transactions of a block like TraceBlockByNumber,
+// but notifies the result of each transaction as soon as it is traced instead
+// of returning them at once. The struct logger notifies each opcode step as
+// it is executed too, so the steps are never held in memory.
+//
+// The tracing waits for slow subscribers once the notification queue is full.
+// The timeout of a transaction includes the time spent waiting.
+func (api *API) TraceBlockStream(ctx context.Context, number rpc.BlockNumber, config *TraceConfig) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	block, err := api.blockByNumber(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	if block.NumberU64() == 0 {
+		return nil, errors.New("genesis is not traceable")
+	}
+	parent, err := api.blockByNumberAndHash(ctx, rpc.BlockNumber(block.NumberU64()-1), block.ParentHash())
+	if err != nil {
+		return nil, err
+	}
+	reexec := defaultTraceReexec
+	if config != nil && config.Reexec != nil {
+		reexec = *config.Reexec
+	}
+	statedb, release, err := api.backend.StateAtBlock(ctx, parent, reexec, nil, true, false)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		rpcSub      = notifier.CreateSubscription()
+		events      = make(chan *TraceStreamEvent, traceStreamBuffer)
+		quit, abort = context.WithCancel(context.Background())
+	)
+	// Trace the block, waiting for the subscriber if it's lagging behind
+	go func() {
+		defer release()
+		defer close(events)
+
+		send := func(ev *TraceStreamEvent) bool {
+			select {
+			case events <- ev:
+				return true
+			case <-quit.Done():
+				return false
+			}
+		}
+		api.streamBlock(quit, block, statedb, config, send)
+	}()
+	// Deliver the notifications until the subscriber leaves
+	go func() {
+		defer abort()
+		for {
+			select {
+			case ev, ok := <-events:
+				if !ok {
+					return
+				}
+				if err := notifier.Notify(rpcSub.ID, ev); err != nil {
+					return
+				}
+			case <-rpcSub.Err():
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
+
+// streamBlock traces the transactions of a block on top of its parent state,
+// handing the events to the given function, until it fails.
+func (api *API) streamBlock(ctx context.Context, block *types.Block, statedb *state.StateDB, config *TraceConfig, send func(*TraceStreamEvent) bool) {
+	if config == nil {
+		config = &TraceConfig{}
+	}
+	var (
+		blockHash = block.Hash()
+		isEIP161D = api.backend.ChainConfig().IsEnabled(api.backend.ChainConfig().GetEIP161dTransition, block.Number())
+		blockCtx  = core.NewEVMBlockContext(block.Header(), api.chainContext(ctx), nil)
+		signer    = types.MakeSigner(api.backend.ChainConfig(), block.Number())
+	)
+	for i, tx := range block.Transactions() {
+		if ctx.Err() != nil {
+			return
+		}
+		var (
+			msg, _ = core.TransactionToMessage(tx, signer, block.BaseFee())
+			txctx  = &Context{
+				BlockHash:   blockHash,
+				BlockNumber: block.Number(),
+				TxIndex:     i,
+				TxHash:      tx.Hash(),
+			}
+			tracer Tracer
+			err    error
+		)
+		if config.Tracer != nil {
+			tracer, err = DefaultDirectory.New(*config.Tracer, txctx, config.TracerConfig)
+		} else {
+			index, hash := i, tx.Hash()
+			tracer = logger.NewStreamingStructLogger(config.Config, func(log logger.StructLog) {
+				step := logger.FormatLog(log)
+				send(&TraceStreamEvent{TxIndex: index, TxHash: hash, Step: &step})
+			})
+		}
+		ev := &TraceStreamEvent{TxIndex: i, TxHash: tx.Hash()}
+		if err == nil {
+			ev.Result, err = api.traceTxWithTracer(ctx, msg, txctx, blockCtx, statedb, tracer, config.Timeout)
+		}
+		if err != nil {
+			// The following transactions can't be traced on a diverged state
+			ev.Error = err.Error()
+			send(ev)
+			return
+		}
+		if !send(ev) {
+			return
+		}
+		// Finalize the state so any modifications are written to the trie
+		// Only delete empty objects if EIP158/161 (a.k.a Spurious Dragon) is in effect
+		statedb.Finalise(isEIP161D)
+	}
+}
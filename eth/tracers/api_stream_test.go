@@ -0,0 +1,109 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/params/types/genesisT"
+	"github.com/ethereum/go-ethereum/params/vars"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func TestTraceBlockStream(t *testing.T) {
+	accounts := newAccounts(2)
+	contract := common.HexToAddress("0x00000000000000000000000000000000000000cc")
+	genesis := &genesisT.Genesis{
+		Config: params.TestChainConfig,
+		Alloc: genesisT.GenesisAlloc{
+			accounts[0].addr: {Balance: big.NewInt(vars.Ether)},
+			contract:         {Balance: big.NewInt(0), Code: []byte{byte(vm.PUSH1), 0x1, byte(vm.PUSH1), 0x0, byte(vm.SSTORE), byte(vm.STOP)}},
+		},
+	}
+	signer := types.HomesteadSigner{}
+	backend := newTestBackend(t, 1, genesis, func(i int, b *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(0, contract, big.NewInt(0), 100000, b.BaseFee(), nil), signer, accounts[0].key)
+		b.AddTx(tx)
+		tx, _ = types.SignTx(types.NewTransaction(1, accounts[1].addr, big.NewInt(1000), vars.TxGas, b.BaseFee(), nil), signer, accounts[0].key)
+		b.AddTx(tx)
+	})
+	defer backend.teardown()
+
+	server := rpc.NewServer()
+	defer server.Stop()
+	if err := server.RegisterName("debug", NewAPI(backend)); err != nil {
+		t.Fatalf("failed to register API: %v", err)
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+
+	events := make(chan *TraceStreamEvent)
+	sub, err := client.Subscribe(context.Background(), "debug", events, "traceBlockStream", rpc.BlockNumber(1), nil)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	// The steps of the call come first, followed by the results
+	var (
+		ops     []string
+		results []*TraceStreamEvent
+	)
+	for len(results) < 2 {
+		select {
+		case ev := <-events:
+			if ev.Step != nil {
+				if ev.TxIndex != 0 || len(results) > 0 {
+					t.Fatalf("unexpected step: %+v", ev)
+				}
+				ops = append(ops, ev.Step.Op)
+				continue
+			}
+			results = append(results, ev)
+		case err := <-sub.Err():
+			t.Fatalf("subscription failed: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for the traces")
+		}
+	}
+	if want := []string{"PUSH1", "PUSH1", "SSTORE", "STOP"}; len(ops) != len(want) || ops[0] != want[0] || ops[2] != want[2] {
+		t.Errorf("steps mismatch: have %v, want %v", ops, want)
+	}
+	for i, ev := range results {
+		if ev.TxIndex != i || ev.Error != "" {
+			t.Fatalf("result %d mismatch: %+v", i, ev)
+		}
+		blob, _ := json.Marshal(ev.Result)
+		var res logger.ExecutionResult
+		if err := json.Unmarshal(blob, &res); err != nil {
+			t.Fatalf("failed to decode result %d: %v", i, err)
+		}
+		if res.Failed || len(res.StructLogs) != 0 {
+			t.Errorf("result %d: steps are buffered: %s", i, blob)
+		}
+	}
+}
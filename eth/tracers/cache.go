@@ -0,0 +1,153 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+var (
+	traceCacheHitMeter   = metrics.NewRegisteredMeter("trace/cache/hit", nil)
+	traceCacheMissMeter  = metrics.NewRegisteredMeter("trace/cache/miss", nil)
+	traceCacheEvictMeter = metrics.NewRegisteredMeter("trace/cache/evict", nil)
+)
+
+// traceCacheBackend is implemented by the backends providing a cache for the
+// results of tracing blocks.
+type traceCacheBackend interface {
+	TraceCache() *TraceCache
+}
+
+// chainSideSubscriber is the chain the trace cache follows for reorgs.
+type chainSideSubscriber interface {
+	SubscribeChainSideEvent(ch chan<- core.ChainSideEvent) event.Subscription
+}
+
+// traceCacheKey identifies the results of tracing a block with a tracer and
+// its configuration.
+type traceCacheKey struct {
+	block  common.Hash // Hash of the traced block
+	tracer string      // Name (or code) of the tracer
+	config common.Hash // Hash of the tracer configuration
+}
+
+// newTraceCacheKey returns the cache key of tracing the given block with the
+// given config, or false if the results of the config are not cached.
+func newTraceCacheKey(block common.Hash, config *TraceConfig) (traceCacheKey, bool) {
+	// The struct logger output is excessively large, never cache it
+	if config == nil || config.Tracer == nil || *config.Tracer == "" {
+		return traceCacheKey{}, false
+	}
+	blob, err := json.Marshal(struct {
+		Logger       interface{}
+		TracerConfig json.RawMessage
+	}{config.Config, config.TracerConfig})
+	if err != nil {
+		return traceCacheKey{}, false
+	}
+	return traceCacheKey{block: block, tracer: *config.Tracer, config: crypto.Keccak256Hash(blob)}, true
+}
+
+// TraceCache is a bounded LRU cache of the results of tracing whole blocks,
+// sparing the re-execution of blocks traced repeatedly. The results of blocks
+// reorged out of the canonical chain are dropped.
+type TraceCache struct {
+	cache *lru.Cache[traceCacheKey, []*txTraceResult]
+
+	sideCh chan core.ChainSideEvent
+	sub    event.Subscription
+	quit   chan struct{}
+}
+
+// NewTraceCache creates a trace cache holding the results of tracing the given
+// number of blocks, following the given chain for reorgs.
+func NewTraceCache(size int, chain chainSideSubscriber) *TraceCache {
+	c := &TraceCache{
+		cache:  lru.NewCache[traceCacheKey, []*txTraceResult](size),
+		sideCh: make(chan core.ChainSideEvent, 16),
+		quit:   make(chan struct{}),
+	}
+	c.sub = chain.SubscribeChainSideEvent(c.sideCh)
+	go c.loop()
+	return c
+}
+
+// Stop terminates the reorg tracking of the cache.
+func (c *TraceCache) Stop() {
+	c.sub.Unsubscribe()
+	close(c.quit)
+}
+
+// loop drops the cached results of the blocks becoming side blocks.
+func (c *TraceCache) loop() {
+	for {
+		select {
+		case ev := <-c.sideCh:
+			c.invalidate(ev.Block.Hash())
+		case <-c.sub.Err():
+			return
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// invalidate drops all the cached results of the given block.
+func (c *TraceCache) invalidate(hash common.Hash) {
+	for _, key := range c.cache.Keys() {
+		if key.block == hash {
+			c.cache.Remove(key)
+		}
+	}
+}
+
+// get retrieves the cached results of tracing a block.
+func (c *TraceCache) get(key traceCacheKey) ([]*txTraceResult, bool) {
+	if c == nil {
+		return nil, false
+	}
+	results, ok := c.cache.Get(key)
+	if ok {
+		traceCacheHitMeter.Mark(1)
+	} else {
+		traceCacheMissMeter.Mark(1)
+	}
+	return results, ok
+}
+
+// add caches the results of tracing a block. Failed traces are not cached,
+// as they might be transient, e.g. timeouts.
+func (c *TraceCache) add(key traceCacheKey, results []*txTraceResult) {
+	if c == nil {
+		return
+	}
+	for _, result := range results {
+		if result.Error != "" {
+			return
+		}
+	}
+	if c.cache.Add(key, results) {
+		traceCacheEvictMeter.Mark(1)
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/params/types/genesisT"
+	"github.com/ethereum/go-ethereum/params/vars"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+type testSideChain struct {
+	feed event.Feed
+}
+
+func (c *testSideChain) SubscribeChainSideEvent(ch chan<- core.ChainSideEvent) event.Subscription {
+	return c.feed.Subscribe(ch)
+}
+
+func TestTraceCacheKey(t *testing.T) {
+	var (
+		block       = common.Hash{0x01}
+		callTracer  = "callTracer"
+		otherTracer = "prestateTracer"
+	)
+	if _, ok := newTraceCacheKey(block, nil); ok {
+		t.Errorf("struct logger results are cacheable")
+	}
+	base, ok := newTraceCacheKey(block, &TraceConfig{Tracer: &callTracer})
+	if !ok {
+		t.Fatalf("tracer results are not cacheable")
+	}
+	same, _ := newTraceCacheKey(block, &TraceConfig{Tracer: &callTracer})
+	if base != same {
+		t.Errorf("keys of identical configs differ")
+	}
+	for i, config := range []*TraceConfig{
+		{Tracer: &otherTracer},
+		{Tracer: &callTracer, TracerConfig: json.RawMessage(`{"onlyTopCall":true}`)},
+	} {
+		if key, _ := newTraceCacheKey(block, config); key == base {
+			t.Errorf("config %d: key collides with the base config", i)
+		}
+	}
+	if key, _ := newTraceCacheKey(common.Hash{0x02}, &TraceConfig{Tracer: &callTracer}); key == base {
+		t.Errorf("keys of different blocks collide")
+	}
+}
+
+func TestTraceCache(t *testing.T) {
+	var (
+		chain  = new(testSideChain)
+		cache  = NewTraceCache(2, chain)
+		tracer = "callTracer"
+		keys   []traceCacheKey
+	)
+	defer cache.Stop()
+
+	for i := 0; i < 3; i++ {
+		key, _ := newTraceCacheKey(common.Hash{byte(i)}, &TraceConfig{Tracer: &tracer})
+		keys = append(keys, key)
+	}
+	cache.add(keys[0], []*txTraceResult{{Result: json.RawMessage(`{}`)}})
+	cache.add(keys[1], []*txTraceResult{{Error: "execution timeout"}})
+	if _, ok := cache.get(keys[0]); !ok {
+		t.Fatalf("cached results not found")
+	}
+	if _, ok := cache.get(keys[1]); ok {
+		t.Fatalf("failed results cached")
+	}
+	// The cache is bounded
+	cache.add(keys[1], []*txTraceResult{})
+	cache.add(keys[2], []*txTraceResult{})
+	if _, ok := cache.get(keys[0]); ok {
+		t.Fatalf("least recently used results not evicted")
+	}
+	// Side blocks are dropped
+	header := &types.Header{Number: big.NewInt(1)}
+	keys[1].block = header.Hash()
+	cache.add(keys[1], []*txTraceResult{})
+	chain.feed.Send(core.ChainSideEvent{Block: types.NewBlockWithHeader(header)})
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := cache.get(keys[1]); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("side block results not dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := cache.get(keys[2]); !ok {
+		t.Fatalf("canonical block results dropped")
+	}
+}
+
+func TestTraceBlockCached(t *testing.T) {
+	accounts := newAccounts(2)
+	genesis := &genesisT.Genesis{
+		Config: params.TestChainConfig,
+		Alloc: genesisT.GenesisAlloc{
+			accounts[0].addr: {Balance: big.NewInt(vars.Ether)},
+		},
+	}
+	backend := newTestBackend(t, 1, genesis, func(i int, b *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(uint64(i), accounts[1].addr, big.NewInt(1000), vars.TxGas, b.BaseFee(), nil), types.HomesteadSigner{}, accounts[0].key)
+		b.AddTx(tx)
+	})
+	defer backend.teardown()
+
+	var refs uint32
+	backend.refHook = func() { atomic.AddUint32(&refs, 1) }
+
+	api := NewAPI(backend)
+	api.cache = NewTraceCache(16, backend.chain)
+	defer api.cache.Stop()
+
+	block, err := api.blockByNumber(context.Background(), rpc.BlockNumber(1))
+	if err != nil {
+		t.Fatalf("failed to retrieve block: %v", err)
+	}
+	tracer := "callTracer"
+	config := &TraceConfig{Tracer: &tracer}
+	key, _ := newTraceCacheKey(block.Hash(), config)
+	cached := []*txTraceResult{{Result: json.RawMessage(`{"cached":true}`)}}
+	api.cache.add(key, cached)
+
+	results, err := api.traceBlock(context.Background(), block, config)
+	if err != nil {
+		t.Fatalf("failed to trace block: %v", err)
+	}
+	if len(results) != 1 || results[0] != cached[0] {
+		t.Fatalf("cached results not served: %v", results)
+	}
+	if atomic.LoadUint32(&refs) != 0 {
+		t.Fatalf("state accessed for cached results")
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package difftest compares the execution of transactions by the native EVM
+// interpreter and an EVMC one, reporting the first divergence between them.
+//
+// EVMC interpreters don't report the execution of single opcodes, so the steps
+// are only compared if both interpreters report them, which the reports state.
+// Otherwise, executions are compared by their call frames, their results, their
+// logs and the state they write, so a divergence within a call frame is only
+// caught by its outcome.
+package difftest
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+	"github.com/holiman/uint256"
+)
+
+// Divergence is the first difference between the executions of a transaction
+// by the native and the EVMC interpreters.
+type Divergence struct {
+	Kind   string `json:"kind"`   // What differs: pc, op, depth, gas, gasCost, stack, memory, storage, steps, call, result, state or logs
+	Where  string `json:"where"`  // Location of the difference in the execution
+	Native string `json:"native"` // Value of the native interpreter
+	EVMC   string `json:"evmc"`   // Value of the EVMC interpreter
+}
+
+func (d *Divergence) String() string {
+	return fmt.Sprintf("%s divergence at %s: native %s, evmc %s", d.Kind, d.Where, d.Native, d.EVMC)
+}
+
+// Summary is the outcome of the execution of a transaction by an interpreter.
+type Summary struct {
+	Steps       int           `json:"steps"`
+	Calls       int           `json:"calls"`
+	GasUsed     uint64        `json:"gasUsed"`
+	Failed      bool          `json:"failed"`
+	ReturnValue hexutil.Bytes `json:"returnValue"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// Report is the comparison of the executions of a transaction by the native
+// and the EVMC interpreters.
+type Report struct {
+	Native        *Summary    `json:"native"`
+	EVMC          *Summary    `json:"evmc"`
+	StepsCompared bool        `json:"stepsCompared"` // Whether the steps were compared, which requires both interpreters to report them
+	Divergence    *Divergence `json:"divergence"`    // First divergence, nil if the executions are identical
+}
+
+// callEvent is the entry into or the exit from a call frame.
+type callEvent struct {
+	exit  bool
+	typ   string // Type of the call on entry
+	from  common.Address
+	to    common.Address
+	input []byte // Input on entry, output on exit
+	gas   uint64 // Gas provided on entry, gas used on exit
+	value *big.Int
+	err   string
+}
+
+func (e *callEvent) String() string {
+	if e.exit {
+		return fmt.Sprintf("exit(gasUsed=%d, output=%#x, err=%q)", e.gas, e.input, e.err)
+	}
+	return fmt.Sprintf("%s(from=%s, to=%s, gas=%d, value=%v, input=%#x)", e.typ, e.from, e.to, e.gas, e.value, e.input)
+}
+
+// Recorder is an EVM logger recording the steps of the execution along with
+// the call frames entered and exited, to be compared with the execution by
+// another interpreter.
+type Recorder struct {
+	*logger.StructLogger
+	calls   []*callEvent
+	gasUsed uint64
+	output  []byte
+	err     error
+}
+
+// NewRecorder creates a new recorder, capturing the steps with the given
+// struct logger configuration.
+func NewRecorder(cfg *logger.Config) *Recorder {
+	return &Recorder{StructLogger: logger.NewStructLogger(cfg)}
+}
+
+// CaptureStart implements the EVMLogger interface to initialize the tracing operation.
+func (r *Recorder) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	r.StructLogger.CaptureStart(env, from, to, create, input, gas, value)
+	typ := vm.CALL
+	if create {
+		typ = vm.CREATE
+	}
+	r.enter(typ, from, to, input, gas, value)
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (r *Recorder) CaptureEnd(output []byte, gasUsed uint64, err error) {
+	r.StructLogger.CaptureEnd(output, gasUsed, err)
+	r.exit(output, gasUsed, err)
+	r.output, r.err = common.CopyBytes(output), err
+}
+
+// CaptureEnter is called when EVM enters a new scope (via call, create or selfdestruct).
+func (r *Recorder) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	r.StructLogger.CaptureEnter(typ, from, to, input, gas, value)
+	r.enter(typ, from, to, input, gas, value)
+}
+
+// CaptureExit is called when EVM exits a scope, even if the scope didn't
+// execute any code.
+func (r *Recorder) CaptureExit(output []byte, gasUsed uint64, err error) {
+	r.StructLogger.CaptureExit(output, gasUsed, err)
+	r.exit(output, gasUsed, err)
+}
+
+// CaptureTxStart implements the EVMLogger interface, recording the gas limit
+// to account the gas used by the transaction.
+func (r *Recorder) CaptureTxStart(gasLimit uint64) {
+	r.StructLogger.CaptureTxStart(gasLimit)
+	r.gasUsed = gasLimit
+}
+
+// CaptureTxEnd implements the EVMLogger interface, accounting the gas used by
+// the transaction.
+func (r *Recorder) CaptureTxEnd(restGas uint64) {
+	r.StructLogger.CaptureTxEnd(restGas)
+	r.gasUsed -= restGas
+}
+
+func (r *Recorder) enter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	ev := &callEvent{typ: typ.String(), from: from, to: to, input: common.CopyBytes(input), gas: gas}
+	if value != nil {
+		ev.value = new(big.Int).Set(value)
+	}
+	r.calls = append(r.calls, ev)
+}
+
+func (r *Recorder) exit(output []byte, gasUsed uint64, err error) {
+	ev := &callEvent{exit: true, input: common.CopyBytes(output), gas: gasUsed}
+	if err != nil {
+		ev.err = err.Error()
+	}
+	r.calls = append(r.calls, ev)
+}
+
+// Summary returns the outcome of the recorded execution.
+func (r *Recorder) Summary() *Summary {
+	s := &Summary{
+		Steps:       len(r.StructLogs()),
+		GasUsed:     r.gasUsed,
+		Failed:      r.err != nil,
+		ReturnValue: r.output,
+	}
+	for _, ev := range r.calls {
+		if !ev.exit {
+			s.Calls++
+		}
+	}
+	if r.err != nil {
+		s.Error = r.err.Error()
+	}
+	return s
+}
+
+// StepsComparable returns whether both interpreters reported the steps of
+// their executions, so that Compare compares them.
+func StepsComparable(native, evmc *Recorder) bool {
+	return len(native.StructLogs()) > 0 && len(evmc.StructLogs()) > 0
+}
+
+// Compare returns the first divergence between the executions recorded from
+// the native and the EVMC interpreters, or nil if they are identical. The steps
+// are only compared if StepsComparable, the call frames and results always.
+func Compare(native, evmc *Recorder) *Divergence {
+	// Compare the steps, if reported by both interpreters
+	nsteps, esteps := native.StructLogs(), evmc.StructLogs()
+	if StepsComparable(native, evmc) {
+		for i := 0; i < len(nsteps) && i < len(esteps); i++ {
+			if d := compareSteps(i, &nsteps[i], &esteps[i]); d != nil {
+				return d
+			}
+		}
+		if len(nsteps) != len(esteps) {
+			return &Divergence{Kind: "steps", Where: "end of execution", Native: fmt.Sprint(len(nsteps)), EVMC: fmt.Sprint(len(esteps))}
+		}
+	}
+	// Compare the call frames
+	for i := 0; i < len(native.calls) && i < len(evmc.calls); i++ {
+		if n, e := native.calls[i].String(), evmc.calls[i].String(); n != e {
+			return &Divergence{Kind: "call", Where: fmt.Sprintf("call event %d", i), Native: n, EVMC: e}
+		}
+	}
+	if len(native.calls) != len(evmc.calls) {
+		return &Divergence{Kind: "call", Where: "end of execution", Native: fmt.Sprintf("%d call events", len(native.calls)), EVMC: fmt.Sprintf("%d call events", len(evmc.calls))}
+	}
+	// Compare the results of the transaction, including the refunds
+	if native.gasUsed != evmc.gasUsed {
+		return &Divergence{Kind: "gas", Where: "end of transaction", Native: fmt.Sprint(native.gasUsed), EVMC: fmt.Sprint(evmc.gasUsed)}
+	}
+	if n, e := native.Summary(), evmc.Summary(); n.Error != e.Error || !bytes.Equal(n.ReturnValue, e.ReturnValue) {
+		return &Divergence{Kind: "result", Where: "end of transaction", Native: fmt.Sprintf("%#x (err %q)", n.ReturnValue, n.Error), EVMC: fmt.Sprintf("%#x (err %q)", e.ReturnValue, e.Error)}
+	}
+	return nil
+}
+
+// compareSteps returns the divergence between two steps, if any.
+func compareSteps(i int, native, evmc *logger.StructLog) *Divergence {
+	where := fmt.Sprintf("step %d (pc %d, op %v, depth %d)", i, native.Pc, native.Op, native.Depth)
+	diverge := func(kind string, n, e interface{}) *Divergence {
+		return &Divergence{Kind: kind, Where: where, Native: fmt.Sprint(n), EVMC: fmt.Sprint(e)}
+	}
+	switch {
+	case native.Pc != evmc.Pc:
+		return diverge("pc", native.Pc, evmc.Pc)
+	case native.Op != evmc.Op:
+		return diverge("op", native.Op, evmc.Op)
+	case native.Depth != evmc.Depth:
+		return diverge("depth", native.Depth, evmc.Depth)
+	case native.Gas != evmc.Gas:
+		return diverge("gas", native.Gas, evmc.Gas)
+	case native.GasCost != evmc.GasCost:
+		return diverge("gasCost", native.GasCost, evmc.GasCost)
+	}
+	if n, e := formatStack(native.Stack), formatStack(evmc.Stack); n != e {
+		return diverge("stack", n, e)
+	}
+	if !bytes.Equal(native.Memory, evmc.Memory) || native.MemorySize != evmc.MemorySize {
+		return diverge("memory", hexutil.Bytes(native.Memory), hexutil.Bytes(evmc.Memory))
+	}
+	if n, e := formatStorage(native.Storage), formatStorage(evmc.Storage); n != e {
+		return diverge("storage", n, e)
+	}
+	return nil
+}
+
+func formatStack(stack []uint256.Int) string {
+	items := make([]string, len(stack))
+	for i := range stack {
+		items[i] = stack[i].Hex()
+	}
+	return "[" + strings.Join(items, ", ") + "]"
+}
+
+func formatStorage(storage map[common.Hash]common.Hash) string {
+	items := make([]string, 0, len(storage))
+	for slot, value := range storage {
+		items = append(items, fmt.Sprintf("%x: %x", slot, value))
+	}
+	sort.Strings(items)
+	return "{" + strings.Join(items, ", ") + "}"
+}
+
+// CompareLogs returns the first divergence between the logs emitted by the
+// native and the EVMC interpreters, or nil if they are identical.
+func CompareLogs(native, evmc []*types.Log) *Divergence {
+	format := func(log *types.Log) string {
+		return fmt.Sprintf("address=%s, topics=%v, data=%#x", log.Address, log.Topics, log.Data)
+	}
+	for i := 0; i < len(native) && i < len(evmc); i++ {
+		if n, e := format(native[i]), format(evmc[i]); n != e {
+			return &Divergence{Kind: "logs", Where: fmt.Sprintf("log %d", i), Native: n, EVMC: e}
+		}
+	}
+	if len(native) != len(evmc) {
+		return &Divergence{Kind: "logs", Where: "end of transaction", Native: fmt.Sprintf("%d logs", len(native)), EVMC: fmt.Sprintf("%d logs", len(evmc))}
+	}
+	return nil
+}
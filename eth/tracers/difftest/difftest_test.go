@@ -0,0 +1,99 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package difftest
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/runtime"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+)
+
+// execute runs the code with a recorder, returning it along with the state.
+func execute(t *testing.T, code []byte) (*Recorder, *StateRecorder) {
+	t.Helper()
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	recorder := NewRecorder(&logger.Config{EnableMemory: true})
+	states := NewStateRecorder(statedb)
+	_, _, err := runtime.Execute(code, nil, &runtime.Config{
+		State:     statedb,
+		EVMConfig: vm.Config{Debug: true, Tracer: recorder},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recorder, states
+}
+
+func TestCompare(t *testing.T) {
+	var (
+		// PUSH1 0x01 PUSH1 0x00 MSTORE PUSH1 0x20 PUSH1 0x00 RETURN
+		code = []byte{0x60, 0x01, 0x60, 0x00, 0x52, 0x60, 0x20, 0x60, 0x00, 0xf3}
+		// Same, storing 0x02
+		other = []byte{0x60, 0x02, 0x60, 0x00, 0x52, 0x60, 0x20, 0x60, 0x00, 0xf3}
+	)
+	native, _ := execute(t, code)
+	evmc, _ := execute(t, code)
+	if d := Compare(native, evmc); d != nil {
+		t.Fatalf("unexpected divergence: %v", d)
+	}
+	if !StepsComparable(native, evmc) {
+		t.Fatal("steps of both executions not comparable")
+	}
+	// The stack diverges at the first step
+	evmc, _ = execute(t, other)
+	d := Compare(native, evmc)
+	if d == nil || d.Kind != "stack" || d.Where != "step 1 (pc 2, op PUSH1, depth 1)" {
+		t.Fatalf("divergence mismatch: have %v", d)
+	}
+	// Without the steps, the executions diverge in the output of the call
+	evmc = &Recorder{StructLogger: logger.NewStructLogger(nil)}
+	evmc.calls = append(evmc.calls, native.calls[0], &callEvent{exit: true, input: common.LeftPadBytes([]byte{0x02}, 32), gas: native.calls[1].gas})
+	if StepsComparable(native, evmc) {
+		t.Fatal("steps comparable without the steps of one execution")
+	}
+	d = Compare(native, evmc)
+	if d == nil || d.Kind != "call" || d.Where != "call event 1" {
+		t.Fatalf("divergence mismatch: have %v", d)
+	}
+}
+
+func TestCompareState(t *testing.T) {
+	var (
+		addr = common.HexToAddress("0xaa")
+		slot = common.HexToHash("0x01")
+	)
+	_, native := execute(t, nil)
+	_, evmc := execute(t, nil)
+
+	native.AddBalance(addr, big.NewInt(1))
+	native.SetState(addr, slot, common.HexToHash("0x01"))
+	evmc.AddBalance(addr, big.NewInt(1))
+	if d := CompareState(native, evmc); d == nil || d.Kind != "state" || d.Where != "account "+addr.Hex()+" storage "+slot.Hex() {
+		t.Fatalf("divergence mismatch: have %v", d)
+	}
+	evmc.SetState(addr, slot, common.HexToHash("0x01"))
+	if d := CompareState(native, evmc); d != nil {
+		t.Fatalf("unexpected divergence: %v", d)
+	}
+}
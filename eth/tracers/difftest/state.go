@@ -0,0 +1,164 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package difftest
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+var _ vm.StateDB = (*StateRecorder)(nil)
+
+// StateRecorder is a state database recording the accounts and the storage
+// slots written by a transaction, so the states resulting from different
+// interpreters can be compared without committing them.
+type StateRecorder struct {
+	*state.StateDB
+	writes map[common.Address]map[common.Hash]struct{}
+}
+
+// NewStateRecorder wraps the given state database to record the writes.
+func NewStateRecorder(db *state.StateDB) *StateRecorder {
+	return &StateRecorder{StateDB: db, writes: make(map[common.Address]map[common.Hash]struct{})}
+}
+
+func (s *StateRecorder) write(addr common.Address) map[common.Hash]struct{} {
+	slots, ok := s.writes[addr]
+	if !ok {
+		slots = make(map[common.Hash]struct{})
+		s.writes[addr] = slots
+	}
+	return slots
+}
+
+func (s *StateRecorder) CreateAccount(addr common.Address) {
+	s.write(addr)
+	s.StateDB.CreateAccount(addr)
+}
+
+func (s *StateRecorder) SubBalance(addr common.Address, amount *big.Int) {
+	s.write(addr)
+	s.StateDB.SubBalance(addr, amount)
+}
+
+func (s *StateRecorder) AddBalance(addr common.Address, amount *big.Int) {
+	s.write(addr)
+	s.StateDB.AddBalance(addr, amount)
+}
+
+func (s *StateRecorder) SetNonce(addr common.Address, nonce uint64) {
+	s.write(addr)
+	s.StateDB.SetNonce(addr, nonce)
+}
+
+func (s *StateRecorder) SetCode(addr common.Address, code []byte) {
+	s.write(addr)
+	s.StateDB.SetCode(addr, code)
+}
+
+func (s *StateRecorder) SetState(addr common.Address, key, value common.Hash) {
+	s.write(addr)[key] = struct{}{}
+	s.StateDB.SetState(addr, key, value)
+}
+
+func (s *StateRecorder) Suicide(addr common.Address) bool {
+	s.write(addr)
+	return s.StateDB.Suicide(addr)
+}
+
+// CompareState returns the first divergence between the states written by the
+// native and the EVMC interpreters, or nil if they are identical. The states
+// must be finalised.
+func CompareState(native, evmc *StateRecorder) *Divergence {
+	keys := make(map[common.Address]map[common.Hash]struct{})
+	for _, writes := range []map[common.Address]map[common.Hash]struct{}{native.writes, evmc.writes} {
+		for addr, slots := range writes {
+			if _, ok := keys[addr]; !ok {
+				keys[addr] = make(map[common.Hash]struct{})
+			}
+			for slot := range slots {
+				keys[addr][slot] = struct{}{}
+			}
+		}
+	}
+	return compareAccounts(keys, native.StateDB, evmc.StateDB)
+}
+
+// ComparePostState returns the first divergence between the committed states
+// resulting from the native and the EVMC interpreters, or nil if they are
+// identical. All the accounts and storage slots of the states are compared.
+func ComparePostState(native, evmc *state.StateDB) *Divergence {
+	keys := make(map[common.Address]map[common.Hash]struct{})
+	for _, db := range []*state.StateDB{native, evmc} {
+		dump := db.RawDump(&state.DumpConfig{SkipCode: true})
+		for addr, account := range dump.Accounts {
+			if _, ok := keys[addr]; !ok {
+				keys[addr] = make(map[common.Hash]struct{})
+			}
+			for slot := range account.Storage {
+				keys[addr][slot] = struct{}{}
+			}
+		}
+	}
+	return compareAccounts(keys, native, evmc)
+}
+
+// compareAccounts returns the first divergence between the given accounts and
+// storage slots of two states, in address and slot order.
+func compareAccounts(keys map[common.Address]map[common.Hash]struct{}, native, evmc *state.StateDB) *Divergence {
+	addrs := make([]common.Address, 0, len(keys))
+	for addr := range keys {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return bytes.Compare(addrs[i][:], addrs[j][:]) < 0 })
+
+	for _, addr := range addrs {
+		diverge := func(field string, n, e interface{}) *Divergence {
+			return &Divergence{Kind: "state", Where: fmt.Sprintf("account %s %s", addr, field), Native: fmt.Sprint(n), EVMC: fmt.Sprint(e)}
+		}
+		if n, e := native.Exist(addr), evmc.Exist(addr); n != e {
+			return diverge("existence", n, e)
+		}
+		if n, e := native.GetBalance(addr), evmc.GetBalance(addr); n.Cmp(e) != 0 {
+			return diverge("balance", n, e)
+		}
+		if n, e := native.GetNonce(addr), evmc.GetNonce(addr); n != e {
+			return diverge("nonce", n, e)
+		}
+		if n, e := native.GetCodeHash(addr), evmc.GetCodeHash(addr); n != e {
+			return diverge("code", n, e)
+		}
+		slots := make([]common.Hash, 0, len(keys[addr]))
+		for slot := range keys[addr] {
+			slots = append(slots, slot)
+		}
+		sort.Slice(slots, func(i, j int) bool { return bytes.Compare(slots[i][:], slots[j][:]) < 0 })
+
+		for _, slot := range slots {
+			if n, e := native.GetState(addr, slot), evmc.GetState(addr, slot); n != e {
+				return diverge(fmt.Sprintf("storage %s", slot), n, e)
+			}
+		}
+	}
+	return nil
+}
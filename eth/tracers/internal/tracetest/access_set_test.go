@@ -0,0 +1,193 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/eth/tracers/native"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/params/types/genesisT"
+	"github.com/ethereum/go-ethereum/tests"
+)
+
+// TestAccessSetTracer tests the accessSetTracer on the following:
+// Tx to A, A loads a slot, sets another one, stores the current value of a
+// third one and calls B with value, which sets a slot and reverts.
+// Expected: all the slots of A read, the set slot of A and the sender
+// written, nothing of B written.
+func TestAccessSetTracer(t *testing.T) {
+	var (
+		to     = common.HexToAddress("0x00000000000000000000000000000000deadbeef")
+		callee = common.HexToAddress("0x00000000000000000000000000000000000000cc")
+	)
+	privkey, err := crypto.HexToECDSA("0000000000000000deadbeef00000000000000000000000000000000deadbeef")
+	if err != nil {
+		t.Fatalf("err %v", err)
+	}
+	signer := types.NewEIP155Signer(big.NewInt(1))
+	tx, err := types.SignNewTx(privkey, signer, &types.LegacyTx{
+		GasPrice: big.NewInt(1),
+		Gas:      200000,
+		To:       &to,
+	})
+	if err != nil {
+		t.Fatalf("err %v", err)
+	}
+	origin, _ := signer.Sender(tx)
+	code := []byte{
+		byte(vm.PUSH1), 0x0, byte(vm.SLOAD), byte(vm.POP), // read slot 0
+		byte(vm.PUSH1), 0x1, byte(vm.PUSH1), 0x1, byte(vm.SSTORE), // write slot 1
+		byte(vm.PUSH1), 0x2, byte(vm.PUSH1), 0x2, byte(vm.SSTORE), // no-op write of slot 2
+	}
+	code = append(code, callWithValue(0xcc, 5)...)
+	code = append(code, byte(vm.STOP))
+	calleeCode := []byte{byte(vm.PUSH1), 0x1, byte(vm.PUSH1), 0x0, byte(vm.SSTORE), byte(vm.PUSH1), 0x0, byte(vm.DUP1), byte(vm.REVERT)}
+
+	var alloc = genesisT.GenesisAlloc{
+		to: genesisT.GenesisAccount{
+			Nonce:   1,
+			Code:    code,
+			Balance: big.NewInt(10),
+			Storage: map[common.Hash]common.Hash{common.BytesToHash([]byte{0x2}): common.BytesToHash([]byte{0x2})},
+		},
+		callee: genesisT.GenesisAccount{
+			Nonce: 1,
+			Code:  calleeCode,
+		},
+		origin: genesisT.GenesisAccount{
+			Nonce:   0,
+			Balance: big.NewInt(500000000000000),
+		},
+	}
+	set := traceAccessSet(t, tx, signer, alloc)
+	slot := func(n byte) common.Hash { return common.BytesToHash([]byte{n}) }
+
+	if have, want := set.Reads[to], (&native.AccountAccess{Code: true, Balance: true, Storage: []common.Hash{slot(0), slot(1), slot(2)}}); !reflect.DeepEqual(have, want) {
+		t.Errorf("reads of A mismatch: have %+v, want %+v", have, want)
+	}
+	if have, want := set.Reads[callee], (&native.AccountAccess{Code: true, Storage: []common.Hash{slot(0)}}); !reflect.DeepEqual(have, want) {
+		t.Errorf("reads of B mismatch: have %+v, want %+v", have, want)
+	}
+	if have, want := set.Writes[to], (&native.AccountAccess{Storage: []common.Hash{slot(1)}}); !reflect.DeepEqual(have, want) {
+		t.Errorf("writes of A mismatch: have %+v, want %+v", have, want)
+	}
+	if have, want := set.Writes[origin], (&native.AccountAccess{Balance: true, Nonce: true}); !reflect.DeepEqual(have, want) {
+		t.Errorf("writes of sender mismatch: have %+v, want %+v", have, want)
+	}
+	if len(set.Writes) != 2 {
+		t.Errorf("writes mismatch: have %+v", set.Writes)
+	}
+}
+
+// TestAccessSetTracerSelfSend tests the accessSetTracer on a tx sending value
+// from an account to itself, which leaves its balance unchanged apart from
+// the fees.
+func TestAccessSetTracerSelfSend(t *testing.T) {
+	privkey, err := crypto.HexToECDSA("0000000000000000deadbeef00000000000000000000000000000000deadbeef")
+	if err != nil {
+		t.Fatalf("err %v", err)
+	}
+	var (
+		signer = types.NewEIP155Signer(big.NewInt(1))
+		origin = crypto.PubkeyToAddress(privkey.PublicKey)
+	)
+	tx, err := types.SignNewTx(privkey, signer, &types.LegacyTx{
+		GasPrice: big.NewInt(1),
+		Gas:      21000,
+		To:       &origin,
+		Value:    big.NewInt(1000),
+	})
+	if err != nil {
+		t.Fatalf("err %v", err)
+	}
+	var alloc = genesisT.GenesisAlloc{
+		origin: genesisT.GenesisAccount{
+			Nonce:   0,
+			Balance: big.NewInt(500000000000000),
+		},
+	}
+	set := traceAccessSet(t, tx, signer, alloc)
+
+	if have, want := set.Reads[origin], (&native.AccountAccess{Code: true, Balance: true, Nonce: true}); !reflect.DeepEqual(have, want) {
+		t.Errorf("reads of sender mismatch: have %+v, want %+v", have, want)
+	}
+	if have, want := set.Writes[origin], (&native.AccountAccess{Balance: true, Nonce: true}); !reflect.DeepEqual(have, want) {
+		t.Errorf("writes of sender mismatch: have %+v, want %+v", have, want)
+	}
+	if len(set.Reads) != 1 || len(set.Writes) != 1 {
+		t.Errorf("access set mismatch: have %+v", set)
+	}
+}
+
+// traceAccessSet runs the tx on top of the given state with the
+// accessSetTracer, checking that tracing it twice yields the same result.
+func traceAccessSet(t *testing.T, tx *types.Transaction, signer types.Signer, alloc genesisT.GenesisAlloc) native.AccessSet {
+	t.Helper()
+
+	origin, _ := signer.Sender(tx)
+	var results [][]byte
+	for i := 0; i < 2; i++ {
+		_, statedb := tests.MakePreState(rawdb.NewMemoryDatabase(), alloc, false)
+		tracer, err := tracers.DefaultDirectory.New("accessSetTracer", new(tracers.Context), nil)
+		if err != nil {
+			t.Fatalf("failed to create access set tracer: %v", err)
+		}
+		context := vm.BlockContext{
+			CanTransfer: core.CanTransfer,
+			Transfer:    core.Transfer,
+			Coinbase:    common.HexToAddress("0xc0ffee"),
+			BlockNumber: new(big.Int).SetUint64(8000000),
+			Time:        5,
+			Difficulty:  big.NewInt(0x30000),
+			GasLimit:    uint64(6000000),
+		}
+		evm := vm.NewEVM(context, vm.TxContext{Origin: origin, GasPrice: tx.GasPrice()}, statedb, params.MainnetChainConfig, vm.Config{Debug: true, Tracer: tracer})
+		msg, err := core.TransactionToMessage(tx, signer, nil)
+		if err != nil {
+			t.Fatalf("failed to prepare transaction for tracing: %v", err)
+		}
+		st := core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(tx.Gas()))
+		if _, err = st.TransitionDb(); err != nil {
+			t.Fatalf("failed to execute transaction: %v", err)
+		}
+		res, err := tracer.GetResult()
+		if err != nil {
+			t.Fatalf("failed to retrieve trace result: %v", err)
+		}
+		results = append(results, res)
+	}
+	if !bytes.Equal(results[0], results[1]) {
+		t.Fatalf("results differ: %s != %s", results[0], results[1])
+	}
+	var set native.AccessSet
+	if err := json.Unmarshal(results[0], &set); err != nil {
+		t.Fatalf("failed to decode trace result: %v", err)
+	}
+	return set
+}
@@ -0,0 +1,178 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracetest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/eth/tracers/native"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/params/types/genesisT"
+	"github.com/ethereum/go-ethereum/params/vars"
+	"github.com/ethereum/go-ethereum/tests"
+)
+
+// TestGasProfilerTracer tests the gasProfilerTracer on the following:
+// Tx to A, A loads a slot twice, sets another one, reads the balance of an
+// account and calls B with a selector, which clears a slot.
+// Expected: the cold and warm accesses, the refund of B and the gas of A and B
+// adding up to the gas used by the tx.
+func TestGasProfilerTracer(t *testing.T) {
+	var (
+		to       = common.HexToAddress("0x00000000000000000000000000000000deadbeef")
+		callee   = common.HexToAddress("0x00000000000000000000000000000000000000cc")
+		selector = []byte{0xa9, 0x05, 0x9c, 0xbb}
+	)
+	privkey, err := crypto.HexToECDSA("0000000000000000deadbeef00000000000000000000000000000000deadbeef")
+	if err != nil {
+		t.Fatalf("err %v", err)
+	}
+	signer := types.NewEIP155Signer(big.NewInt(1))
+	tx, err := types.SignNewTx(privkey, signer, &types.LegacyTx{
+		GasPrice: big.NewInt(0),
+		Gas:      200000,
+		To:       &to,
+	})
+	if err != nil {
+		t.Fatalf("err %v", err)
+	}
+	origin, _ := signer.Sender(tx)
+	txContext := vm.TxContext{
+		Origin:   origin,
+		GasPrice: big.NewInt(0),
+	}
+	context := vm.BlockContext{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		Coinbase:    common.Address{},
+		BlockNumber: new(big.Int).SetUint64(13000000),
+		Time:        5,
+		Difficulty:  big.NewInt(0x30000),
+		GasLimit:    uint64(6000000),
+		BaseFee:     big.NewInt(0),
+	}
+	code := []byte{
+		byte(vm.PUSH1), 0x0, byte(vm.SLOAD), byte(vm.POP), // cold
+		byte(vm.PUSH1), 0x0, byte(vm.SLOAD), byte(vm.POP), // warm
+		byte(vm.PUSH1), 0x1, byte(vm.PUSH1), 0x1, byte(vm.SSTORE), // cold
+		byte(vm.PUSH1), 0xbb, byte(vm.BALANCE), byte(vm.POP), // cold
+		byte(vm.PUSH4), selector[0], selector[1], selector[2], selector[3],
+		byte(vm.PUSH1), 0xe0, byte(vm.SHL), byte(vm.PUSH1), 0x0, byte(vm.MSTORE), // mem[0:4] = selector
+		byte(vm.PUSH1), 0x0, byte(vm.PUSH1), 0x0, byte(vm.PUSH1), 0x4, byte(vm.PUSH1), 0x0, // in and outs
+		byte(vm.PUSH1), 0x0, byte(vm.PUSH1), 0xcc, byte(vm.GAS), byte(vm.CALL), byte(vm.POP), // cold
+		byte(vm.STOP),
+	}
+	calleeCode := []byte{byte(vm.PUSH1), 0x0, byte(vm.PUSH1), 0x0, byte(vm.SSTORE), byte(vm.STOP)}
+
+	var alloc = genesisT.GenesisAlloc{
+		to: genesisT.GenesisAccount{
+			Nonce: 1,
+			Code:  code,
+		},
+		callee: genesisT.GenesisAccount{
+			Nonce:   1,
+			Code:    calleeCode,
+			Storage: map[common.Hash]common.Hash{{}: common.BytesToHash([]byte{0x1})},
+		},
+		origin: genesisT.GenesisAccount{
+			Nonce:   0,
+			Balance: big.NewInt(500000000000000),
+		},
+	}
+	_, statedb := tests.MakePreState(rawdb.NewMemoryDatabase(), alloc, false)
+	// Create the tracer, the EVM environment and run it
+	tracer, err := tracers.DefaultDirectory.New("gasProfilerTracer", new(tracers.Context), json.RawMessage(`{"pprof":true}`))
+	if err != nil {
+		t.Fatalf("failed to create gas profiler tracer: %v", err)
+	}
+	evm := vm.NewEVM(context, txContext, statedb, params.MainnetChainConfig, vm.Config{Debug: true, Tracer: tracer})
+	msg, err := core.TransactionToMessage(tx, signer, nil)
+	if err != nil {
+		t.Fatalf("failed to prepare transaction for tracing: %v", err)
+	}
+	st := core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(tx.Gas()))
+	result, err := st.TransitionDb()
+	if err != nil {
+		t.Fatalf("failed to execute transaction: %v", err)
+	}
+	if result.Failed() {
+		t.Fatalf("transaction failed: %v", result.Err)
+	}
+	res, err := tracer.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve trace result: %v", err)
+	}
+	var profile native.GasProfile
+	if err := json.Unmarshal(res, &profile); err != nil {
+		t.Fatalf("failed to decode trace result: %v", err)
+	}
+	if profile.GasUsed != result.UsedGas || profile.IntrinsicGas != vars.TxGas || profile.Refund != 4800 {
+		t.Errorf("gas mismatch: have used %d, intrinsic %d, refund %d, want used %d", profile.GasUsed, profile.IntrinsicGas, profile.Refund, result.UsedGas)
+	}
+	// Opcodes account for all the execution gas
+	var opGas uint64
+	for _, stats := range profile.Opcodes {
+		opGas += stats.Gas
+	}
+	if execGas := profile.GasUsed + profile.Refund - profile.IntrinsicGas; opGas != execGas {
+		t.Errorf("opcode gas mismatch: have %d, want %d", opGas, execGas)
+	}
+	for op, want := range map[vm.OpCode]native.OpcodeGas{
+		vm.SLOAD:   {Count: 2, Gas: 2200, ColdAccesses: 1, WarmAccesses: 1, ColdAccessGas: 2000},
+		vm.SSTORE:  {Count: 2, Gas: 27100, Refund: 4800, ColdAccesses: 2, ColdAccessGas: 4200},
+		vm.BALANCE: {Count: 1, Gas: 2600, ColdAccesses: 1, ColdAccessGas: 2500},
+		vm.CALL:    {Count: 1, Gas: 2600, ColdAccesses: 1, ColdAccessGas: 2500},
+	} {
+		if have := profile.Opcodes[op.String()]; have == nil || *have != want {
+			t.Errorf("%v: stats mismatch: have %+v, want %+v", op, have, want)
+		}
+	}
+	caller, called := profile.Contracts[to], profile.Contracts[callee]
+	if caller == nil || called == nil {
+		t.Fatalf("contracts missing: %s", res)
+	}
+	if caller.Calls != 1 || caller.TotalGas != opGas || caller.SelfGas+called.TotalGas != caller.TotalGas {
+		t.Errorf("caller gas mismatch: %+v", caller)
+	}
+	if called.Calls != 1 || called.SelfGas != called.TotalGas {
+		t.Errorf("callee gas mismatch: %+v", called)
+	}
+	if sel := profile.Selectors["0xa9059cbb"]; sel == nil || sel.Calls != 1 || sel.Gas != called.TotalGas {
+		t.Errorf("selector gas mismatch: %+v", sel)
+	}
+	// The profile is gzipped
+	zr, err := gzip.NewReader(bytes.NewReader(profile.Pprof))
+	if err != nil {
+		t.Fatalf("failed to open pprof profile: %v", err)
+	}
+	blob, err := io.ReadAll(zr)
+	if err != nil || !bytes.Contains(blob, []byte("SSTORE")) || !bytes.Contains(blob, []byte(callee.Hex()+":0xa9059cbb")) {
+		t.Errorf("pprof profile mismatch: %x, err %v", blob, err)
+	}
+}
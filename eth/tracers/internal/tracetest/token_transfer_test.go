@@ -0,0 +1,159 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracetest
+
+import (
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/eth/tracers/native"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/params/types/genesisT"
+	"github.com/ethereum/go-ethereum/tests"
+)
+
+// logTransfer returns the code emitting an ERC-20 Transfer event of the given
+// amount between the given addresses.
+func logTransfer(from, to common.Address, amount byte) []byte {
+	code := []byte{byte(vm.PUSH1), amount, byte(vm.PUSH1), 0x0, byte(vm.MSTORE)} // mem[0:32] = amount
+	code = append(append(code, byte(vm.PUSH20)), to.Bytes()...)
+	code = append(append(code, byte(vm.PUSH20)), from.Bytes()...)
+	code = append(append(code, byte(vm.PUSH32)), crypto.Keccak256([]byte("Transfer(address,address,uint256)"))...)
+	return append(code, byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x0, byte(vm.LOG3))
+}
+
+// callWithValue returns the code calling the given address with the given value.
+func callWithValue(addr byte, value byte) []byte {
+	return []byte{
+		byte(vm.PUSH1), 0x0, byte(vm.DUP1), byte(vm.DUP1), byte(vm.DUP1), // in and outs zero
+		byte(vm.PUSH1), value, byte(vm.PUSH1), addr, byte(vm.GAS),
+		byte(vm.CALL), byte(vm.POP),
+	}
+}
+
+// TestTokenTransferTracer tests the tokenTransferTracer on the following:
+// Tx to A, A emits a token transfer, sends value to B and calls C, which emits
+// a token transfer and reverts.
+// Expected: the token transfer of A and the value sent to B.
+func TestTokenTransferTracer(t *testing.T) {
+	var (
+		to       = common.HexToAddress("0x00000000000000000000000000000000deadbeef")
+		receiver = common.HexToAddress("0x00000000000000000000000000000000000000bb")
+		reverter = common.HexToAddress("0x00000000000000000000000000000000000000cc")
+		alice    = common.HexToAddress("0xa1")
+		bob      = common.HexToAddress("0xb0")
+	)
+	privkey, err := crypto.HexToECDSA("0000000000000000deadbeef00000000000000000000000000000000deadbeef")
+	if err != nil {
+		t.Fatalf("err %v", err)
+	}
+	signer := types.NewEIP155Signer(big.NewInt(1))
+	tx, err := types.SignNewTx(privkey, signer, &types.LegacyTx{
+		GasPrice: big.NewInt(0),
+		Gas:      200000,
+		To:       &to,
+	})
+	if err != nil {
+		t.Fatalf("err %v", err)
+	}
+	origin, _ := signer.Sender(tx)
+	txContext := vm.TxContext{
+		Origin:   origin,
+		GasPrice: big.NewInt(1),
+	}
+	context := vm.BlockContext{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		Coinbase:    common.Address{},
+		BlockNumber: new(big.Int).SetUint64(8000000),
+		Time:        5,
+		Difficulty:  big.NewInt(0x30000),
+		GasLimit:    uint64(6000000),
+	}
+	code := logTransfer(alice, bob, 0x64)
+	code = append(code, callWithValue(0xbb, 5)...)
+	code = append(code, callWithValue(0xcc, 0)...)
+	code = append(code, byte(vm.STOP))
+
+	reverterCode := append(logTransfer(bob, alice, 0x64), byte(vm.PUSH1), 0x0, byte(vm.DUP1), byte(vm.REVERT))
+
+	var alloc = genesisT.GenesisAlloc{
+		to: genesisT.GenesisAccount{
+			Nonce:   1,
+			Code:    code,
+			Balance: big.NewInt(10),
+		},
+		reverter: genesisT.GenesisAccount{
+			Nonce: 1,
+			Code:  reverterCode,
+		},
+		origin: genesisT.GenesisAccount{
+			Nonce:   0,
+			Balance: big.NewInt(500000000000000),
+		},
+	}
+	_, statedb := tests.MakePreState(rawdb.NewMemoryDatabase(), alloc, false)
+	// Create the tracer, the EVM environment and run it
+	tracer, err := tracers.DefaultDirectory.New("tokenTransferTracer", &tracers.Context{TxHash: tx.Hash(), TxIndex: 3}, nil)
+	if err != nil {
+		t.Fatalf("failed to create token transfer tracer: %v", err)
+	}
+	evm := vm.NewEVM(context, txContext, statedb, params.MainnetChainConfig, vm.Config{Debug: true, Tracer: tracer})
+	msg, err := core.TransactionToMessage(tx, signer, nil)
+	if err != nil {
+		t.Fatalf("failed to prepare transaction for tracing: %v", err)
+	}
+	st := core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(tx.Gas()))
+	if _, err = st.TransitionDb(); err != nil {
+		t.Fatalf("failed to execute transaction: %v", err)
+	}
+	res, err := tracer.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve trace result: %v", err)
+	}
+	var transfers []*native.TokenTransfer
+	if err := json.Unmarshal(res, &transfers); err != nil {
+		t.Fatalf("failed to decode trace result: %v", err)
+	}
+	if len(transfers) != 2 {
+		t.Fatalf("transfers mismatch: have %s", res)
+	}
+	token := transfers[0]
+	if token.Type != native.TransferERC20 || token.From != alice || token.To != bob || token.Token == nil || *token.Token != to ||
+		token.Amount.ToInt().Int64() != 0x64 || len(token.CallPath) != 0 {
+		t.Errorf("token transfer mismatch: %+v", token)
+	}
+	value := transfers[1]
+	if value.Type != native.TransferNative || value.From != to || value.To != receiver || value.Token != nil ||
+		value.Amount.ToInt().Int64() != 5 || !reflect.DeepEqual(value.CallPath, []int{0}) {
+		t.Errorf("native transfer mismatch: %+v", value)
+	}
+	for i, transfer := range transfers {
+		if transfer.TransactionHash == nil || *transfer.TransactionHash != tx.Hash() || transfer.TransactionPosition == nil || *transfer.TransactionPosition != 3 {
+			t.Errorf("transfer %d: transaction mismatch: %+v", i, transfer)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/eth/tracers/native"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/params/types/genesisT"
+	"github.com/ethereum/go-ethereum/tests"
+)
+
+// TestVmTraceParity tests the vmTraceParity tracer on the following:
+// Tx to A, A stores a slot, writes memory and calls B, which stores a slot.
+// Expected: the effects of every instruction and the nested trace of B.
+func TestVmTraceParity(t *testing.T) {
+	var (
+		to     = common.HexToAddress("0x00000000000000000000000000000000deadbeef")
+		callee = common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	)
+	privkey, err := crypto.HexToECDSA("0000000000000000deadbeef00000000000000000000000000000000deadbeef")
+	if err != nil {
+		t.Fatalf("err %v", err)
+	}
+	signer := types.NewEIP155Signer(big.NewInt(1))
+	tx, err := types.SignNewTx(privkey, signer, &types.LegacyTx{
+		GasPrice: big.NewInt(0),
+		Gas:      100000,
+		To:       &to,
+	})
+	if err != nil {
+		t.Fatalf("err %v", err)
+	}
+	origin, _ := signer.Sender(tx)
+	txContext := vm.TxContext{
+		Origin:   origin,
+		GasPrice: big.NewInt(1),
+	}
+	context := vm.BlockContext{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		Coinbase:    common.Address{},
+		BlockNumber: new(big.Int).SetUint64(8000000),
+		Time:        5,
+		Difficulty:  big.NewInt(0x30000),
+		GasLimit:    uint64(6000000),
+	}
+	var (
+		code = []byte{
+			byte(vm.PUSH1), 0x2a, byte(vm.PUSH1), 0x0, byte(vm.SSTORE), // slot 0 = 0x2a
+			byte(vm.PUSH1), 0x2a, byte(vm.PUSH1), 0x0, byte(vm.MSTORE), // mem[0:32] = 0x2a
+			byte(vm.PUSH1), 0x0, byte(vm.DUP1), byte(vm.DUP1), byte(vm.DUP1), // in and outs zero
+			byte(vm.DUP1), byte(vm.PUSH1), 0xbb, byte(vm.GAS), // value=0,address=0xbb, gas=GAS
+			byte(vm.CALL),
+			byte(vm.STOP),
+		}
+		calleeCode = []byte{
+			byte(vm.PUSH1), 0x1, byte(vm.PUSH1), 0x0, byte(vm.SSTORE), // slot 0 = 1
+			byte(vm.STOP),
+		}
+	)
+	var alloc = genesisT.GenesisAlloc{
+		to: genesisT.GenesisAccount{
+			Nonce: 1,
+			Code:  code,
+		},
+		callee: genesisT.GenesisAccount{
+			Nonce: 1,
+			Code:  calleeCode,
+		},
+		origin: genesisT.GenesisAccount{
+			Nonce:   0,
+			Balance: big.NewInt(500000000000000),
+		},
+	}
+	_, statedb := tests.MakePreState(rawdb.NewMemoryDatabase(), alloc, false)
+	// Create the tracer, the EVM environment and run it
+	tracer, err := tracers.DefaultDirectory.New("vmTraceParity", nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create vmTrace tracer: %v", err)
+	}
+	evm := vm.NewEVM(context, txContext, statedb, params.MainnetChainConfig, vm.Config{Debug: true, Tracer: tracer})
+	msg, err := core.TransactionToMessage(tx, signer, nil)
+	if err != nil {
+		t.Fatalf("failed to prepare transaction for tracing: %v", err)
+	}
+	st := core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(tx.Gas()))
+	if _, err = st.TransitionDb(); err != nil {
+		t.Fatalf("failed to execute transaction: %v", err)
+	}
+	res, err := tracer.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve trace result: %v", err)
+	}
+	var trace native.VmTrace
+	if err := json.Unmarshal(res, &trace); err != nil {
+		t.Fatalf("failed to decode trace result: %v", err)
+	}
+	if !bytes.Equal(trace.Code, code) || len(trace.Ops) != 15 {
+		t.Fatalf("trace mismatch: code %x, %d ops", trace.Code, len(trace.Ops))
+	}
+	// Check the effects of the instructions
+	if push := trace.Ops[0].Ex.Push; len(push) != 1 || push[0].ToInt().Int64() != 0x2a {
+		t.Errorf("PUSH1 pushed items mismatch: %v", push)
+	}
+	if store := trace.Ops[2].Ex.Store; store == nil || store.Key.ToInt().Sign() != 0 || store.Val.ToInt().Int64() != 0x2a {
+		t.Errorf("SSTORE store mismatch: %+v", store)
+	}
+	if mem := trace.Ops[5].Ex.Mem; mem == nil || mem.Off != 0 || len(mem.Data) != 32 || mem.Data[31] != 0x2a {
+		t.Errorf("MSTORE memory mismatch: %+v", mem)
+	}
+	if push := trace.Ops[7].Ex.Push; len(push) != 2 {
+		t.Errorf("DUP1 pushed items mismatch: %v", push)
+	}
+	for i, op := range trace.Ops[:len(trace.Ops)-1] {
+		if next := trace.Ops[i+1]; op.Ex.Used < next.Cost {
+			t.Errorf("op %d: remaining gas %d below next op cost %d", i, op.Ex.Used, next.Cost)
+		}
+	}
+	// Check the nested trace of the call
+	call := trace.Ops[13]
+	if push := call.Ex.Push; len(push) != 1 || push[0].ToInt().Int64() != 1 {
+		t.Errorf("CALL pushed items mismatch: %v", push)
+	}
+	if call.Sub == nil || !bytes.Equal(call.Sub.Code, calleeCode) || len(call.Sub.Ops) != 4 {
+		t.Fatalf("nested trace mismatch: %+v", call.Sub)
+	}
+	if store := call.Sub.Ops[2].Ex.Store; store == nil || store.Val.ToInt().Int64() != 1 {
+		t.Errorf("nested SSTORE store mismatch: %+v", store)
+	}
+	for i, op := range trace.Ops {
+		if i != 13 && op.Sub != nil {
+			t.Errorf("op %d: unexpected nested trace", i)
+		}
+	}
+}
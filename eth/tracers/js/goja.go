@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"sync"
 
 	"github.com/dop251/goja"
 
@@ -34,6 +35,13 @@ import (
 
 const (
 	memoryPadLimit = 1024 * 1024
+
+	// memoryCheckInterval is the minimum number of tracer method invocations
+	// between two estimates of the memory of a tracer.
+	memoryCheckInterval = 64
+	// memoryCheckCost is the size of the JS state of a tracer whose estimate
+	// costs about as much as a tracer method invocation.
+	memoryCheckCost = 256
 )
 
 var assetTracers = make(map[string]string)
@@ -45,16 +53,9 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
-	type ctorFn = func(*tracers.Context, json.RawMessage) (tracers.Tracer, error)
-	lookup := func(code string) ctorFn {
-		return func(ctx *tracers.Context, cfg json.RawMessage) (tracers.Tracer, error) {
-			return newJsTracer(code, ctx, cfg)
-		}
-	}
-	for name, code := range assetTracers {
-		tracers.DefaultDirectory.Register(name, lookup(code), true)
+	if err := RegisterTracers(Limits{}); err != nil {
+		panic(err)
 	}
-	tracers.DefaultDirectory.RegisterJSEval(newJsTracer)
 }
 
 // bigIntProgram is compiled once and the exported function mostly invoked to convert
@@ -100,6 +101,7 @@ func fromBuf(vm *goja.Runtime, bufType goja.Value, buf goja.Value, allowString b
 // JS functions on the relevant EVM hooks. It uses Goja as its JS engine.
 type jsTracer struct {
 	vm                *goja.Runtime
+	rt                *jsRuntime // Runtime of the tracer, with vm
 	env               *vm.EVM
 	toBig             toBigFn               // Converts a hex string into a JS bigint
 	toBuf             toBufFn               // Converts a []byte into a JS buffer
@@ -112,6 +114,17 @@ type jsTracer struct {
 	err               error                 // Any error that should stop tracing
 	obj               *goja.Object          // Trace object
 
+	limits    Limits // Resource limits of the tracer
+	steps     uint64 // Number of tracer methods invoked
+	nextCheck uint64 // Number of invocations after which to estimate the memory
+	lastCheck uint64 // Number of invocations at the last estimate of the memory
+	lastSize  uint64 // Estimated memory at the last estimate
+
+	pool     *sync.Pool // Pool to return the runtime to once done, if any
+	lock     sync.Mutex // Protects stopped and released from concurrent Stop calls
+	stopped  bool       // Whether the runtime was interrupted
+	released bool       // Whether the runtime was returned to the pool
+
 	// Methods exposed by tracer
 	result goja.Callable
 	fault  goja.Callable
@@ -139,12 +152,45 @@ type jsTracer struct {
 // The methods `step`, `enter`, and `exit` are optional, but note that
 // `enter` and `exit` always go together.
 func newJsTracer(code string, ctx *tracers.Context, cfg json.RawMessage) (tracers.Tracer, error) {
-	vm := goja.New()
-	// By default field names are exported to JS as is, i.e. capitalized.
-	vm.SetFieldNameMapper(goja.UncapFieldNameMapper())
+	return newJsTracerWithLimits(code, Limits{}, nil, ctx, cfg)
+}
+
+// newJsTracerWithLimits instantiates a new JS tracer instance limited to the
+// given resources. If the memory is limited, the tracer runs on a sandboxed
+// runtime of the pool, if any.
+func newJsTracerWithLimits(code string, limits Limits, pool *sync.Pool, ctx *tracers.Context, cfg json.RawMessage) (tracers.Tracer, error) {
+	var (
+		rt  *jsRuntime
+		err error
+	)
+	switch {
+	case limits.Memory == 0:
+		rt, err = newJsRuntime(false)
+		pool = nil
+	case pool == nil:
+		rt, err = newJsRuntime(true)
+	default:
+		rt, err = newPooledRuntime(pool)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newJsTracerWithRuntime(rt, pool, limits, func(vm *goja.Runtime) (goja.Value, error) {
+		return vm.RunString("(" + code + ")")
+	}, ctx, cfg)
+}
+
+// newJsTracerWithRuntime instantiates a new JS tracer on the given runtime,
+// evaluating the tracer object with the given function. The runtime is
+// returned to the pool, if any, once the tracer is done.
+func newJsTracerWithRuntime(rt *jsRuntime, pool *sync.Pool, limits Limits, eval func(*goja.Runtime) (goja.Value, error), ctx *tracers.Context, cfg json.RawMessage) (tracers.Tracer, error) {
+	vm := rt.vm
 	t := &jsTracer{
-		vm:  vm,
-		ctx: make(map[string]goja.Value),
+		vm:     vm,
+		rt:     rt,
+		ctx:    make(map[string]goja.Value),
+		limits: limits,
+		pool:   pool,
 	}
 	if ctx == nil {
 		ctx = new(tracers.Context)
@@ -159,7 +205,7 @@ func newJsTracer(code string, ctx *tracers.Context, cfg json.RawMessage) (tracer
 
 	t.setTypeConverters()
 	t.setBuiltinFunctions()
-	ret, err := vm.RunString("(" + code + ")")
+	ret, err := eval(vm)
 	if err != nil {
 		return nil, err
 	}
@@ -272,6 +318,10 @@ func (t *jsTracer) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope
 	log.refund = t.env.StateDB.GetRefund()
 	log.depth = depth
 	log.err = err
+	if err := t.tick(); err != nil {
+		t.onError("step", err)
+		return
+	}
 	if _, err := t.step(t.obj, t.logValue, t.dbValue); err != nil {
 		t.onError("step", err)
 	}
@@ -284,6 +334,10 @@ func (t *jsTracer) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope
 	}
 	// Other log fields have been already set as part of the last CaptureState.
 	t.log.err = err
+	if err := t.tick(); err != nil {
+		t.onError("fault", err)
+		return
+	}
 	if _, err := t.fault(t.obj, t.logValue, t.dbValue); err != nil {
 		t.onError("fault", err)
 	}
@@ -316,6 +370,10 @@ func (t *jsTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Ad
 		t.frame.value = new(big.Int).SetBytes(value.Bytes())
 	}
 
+	if err := t.tick(); err != nil {
+		t.onError("enter", err)
+		return
+	}
 	if _, err := t.enter(t.obj, t.frameValue); err != nil {
 		t.onError("enter", err)
 	}
@@ -332,6 +390,13 @@ func (t *jsTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
 	t.frameResult.output = common.CopyBytes(output)
 	t.frameResult.err = err
 
+	if t.err != nil {
+		return
+	}
+	if err := t.tick(); err != nil {
+		t.onError("exit", err)
+		return
+	}
 	if _, err := t.exit(t.obj, t.frameResultValue); err != nil {
 		t.onError("exit", err)
 	}
@@ -339,6 +404,11 @@ func (t *jsTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
 
 // GetResult calls the Javascript 'result' function and returns its value, or any accumulated error
 func (t *jsTracer) GetResult() (json.RawMessage, error) {
+	if t.limits.Memory != 0 {
+		if err := t.checkMemory(); err != nil {
+			return nil, wrapError("result", err)
+		}
+	}
 	ctx := t.vm.ToValue(t.ctx)
 	res, err := t.result(t.obj, ctx, t.dbValue)
 	if err != nil {
@@ -348,14 +418,84 @@ func (t *jsTracer) GetResult() (json.RawMessage, error) {
 	if err != nil {
 		return nil, err
 	}
+	if t.limits.Memory != 0 && uint64(len(encoded)) > t.limits.Memory {
+		return nil, wrapError("result", fmt.Errorf("%w: %d bytes", errMemoryLimit, t.limits.Memory))
+	}
+	if t.err == nil {
+		t.release()
+	}
 	return json.RawMessage(encoded), t.err
 }
 
 // Stop terminates execution of the tracer at the first opportune moment.
 func (t *jsTracer) Stop(err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// The runtime may already be serving another tracer
+	if t.released {
+		return
+	}
+	t.stopped = true
 	t.vm.Interrupt(err)
 }
 
+// release returns the runtime of the tracer to the pool once the tracer is
+// done, unless it was interrupted or can't be reset to its initial state.
+func (t *jsTracer) release() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.pool == nil || t.released || t.stopped {
+		return
+	}
+	t.released = true
+	if ok, err := t.rt.reset(goja.Undefined()); err != nil || !ok.ToBoolean() {
+		return
+	}
+	t.pool.Put(t.rt)
+}
+
+// tick accounts the invocation of a tracer method against the step limit, and
+// estimates the memory of the tracer from time to time.
+func (t *jsTracer) tick() error {
+	t.steps++
+	if t.limits.Steps != 0 && t.steps > t.limits.Steps {
+		return fmt.Errorf("%w: %d steps", errStepLimit, t.limits.Steps)
+	}
+	if t.limits.Memory != 0 && t.steps >= t.nextCheck {
+		return t.checkMemory()
+	}
+	return nil
+}
+
+// checkMemory estimates the size of the JS state of the tracer against the
+// memory limit, and schedules the next estimate: late enough to amortize the
+// walk over the invocations in between, but before the state outgrows the
+// limit at the rate seen since the previous estimate.
+func (t *jsTracer) checkMemory() error {
+	size, err := t.rt.sizeOf(t.obj, t.limits.Memory)
+	if err != nil {
+		return err
+	}
+	if size > t.limits.Memory {
+		return fmt.Errorf("%w: %d bytes", errMemoryLimit, t.limits.Memory)
+	}
+	interval := size / memoryCheckCost
+	if size > t.lastSize && t.steps > t.lastCheck {
+		rate := (size-t.lastSize)/(t.steps-t.lastCheck) + 1
+		if until := (t.limits.Memory - size) / rate / 2; until < interval {
+			interval = until
+		}
+	}
+	if interval < memoryCheckInterval {
+		interval = memoryCheckInterval
+	}
+	t.lastCheck, t.lastSize = t.steps, size
+	t.nextCheck = t.steps + interval
+	return nil
+}
+
 // onError is called anytime the running JS code is interrupted
 // and returns an error. It in turn pings the EVM to cancel its
 // execution.
@@ -484,36 +624,10 @@ func (t *jsTracer) setBuiltinFunctions() {
 
 // setTypeConverters sets up utilities for converting Go types into those
 // suitable for JS consumption.
-func (t *jsTracer) setTypeConverters() error {
-	// Inject bigint logic.
-	// TODO: To be replaced after goja adds support for native JS bigint.
-	toBigCode, err := t.vm.RunProgram(bigIntProgram)
-	if err != nil {
-		return err
-	}
-	// Used to create JS bigint objects from go.
-	toBigFn, ok := goja.AssertFunction(toBigCode)
-	if !ok {
-		return errors.New("failed to bind bigInt func")
-	}
-	toBigWrapper := func(vm *goja.Runtime, val string) (goja.Value, error) {
-		return toBigFn(goja.Undefined(), vm.ToValue(val))
-	}
-	t.toBig = toBigWrapper
-	// NOTE: We need this workaround to create JS buffers because
-	// goja doesn't at the moment expose constructors for typed arrays.
-	//
-	// Cache uint8ArrayType once to be used every time for less overhead.
-	uint8ArrayType := t.vm.Get("Uint8Array")
-	toBufWrapper := func(vm *goja.Runtime, val []byte) (goja.Value, error) {
-		return toBuf(vm, uint8ArrayType, val)
-	}
-	t.toBuf = toBufWrapper
-	fromBufWrapper := func(vm *goja.Runtime, buf goja.Value, allowString bool) ([]byte, error) {
-		return fromBuf(vm, uint8ArrayType, buf, allowString)
-	}
-	t.fromBuf = fromBufWrapper
-	return nil
+func (t *jsTracer) setTypeConverters() {
+	t.toBig = t.rt.toBig
+	t.toBuf = t.rt.toBuf
+	t.fromBuf = t.rt.fromBuf
 }
 
 type opObj struct {
@@ -0,0 +1,454 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package js
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dop251/goja"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+)
+
+var (
+	errStepLimit   = errors.New("tracer step limit exceeded")
+	errMemoryLimit = errors.New("tracer memory limit exceeded")
+)
+
+// Limits are the resources a JS tracer may use while tracing a single
+// transaction. Zero values mean no limit.
+//
+// The memory of a tracer is estimated by walking the JS values reachable from
+// the tracer object and the globals, between the invocations of the tracer
+// methods. State captured only in closures is not accounted, and a single
+// invocation may allocate past the limit until it returns, bounded by the
+// timeout of the trace. Tracers limited in memory run on sandboxed runtimes,
+// whose builtins are frozen.
+type Limits struct {
+	Steps  uint64 // Maximum number of invocations of the tracer methods
+	Memory uint64 // Maximum estimated size in bytes of the JS state of the tracer
+}
+
+// sandboxProgram hardens a freshly initialised runtime by freezing the
+// builtins reachable from the global object, and returns the functions to
+// reset the global object to its initial state and to estimate the size of
+// the JS state of a tracer. The builtins it relies on are captured upfront, so
+// that tracers can't tamper with them.
+var sandboxProgram = goja.MustCompile("sandbox", `(function(global) {
+	var getNames = Object.getOwnPropertyNames, getSymbols = Object.getOwnPropertySymbols,
+		getDesc = Object.getOwnPropertyDescriptor, getProto = Object.getPrototypeOf,
+		isExtensible = Object.isExtensible, freeze = Object.freeze, is = Object.is,
+		apply = Reflect.apply, define = Reflect.defineProperty, del = Reflect.deleteProperty,
+		setProto = Reflect.setPrototypeOf, isView = ArrayBuffer.isView, Dict = Map,
+		dictGet = Map.prototype.get, dictSet = Map.prototype.set, dictHas = Map.prototype.has,
+		mapEach = Map.prototype.forEach, setEach = Set.prototype.forEach,
+		bufferLength = getDesc(ArrayBuffer.prototype, "byteLength").get,
+		arrayLength = getDesc(getProto(Uint8Array.prototype), "byteLength").get,
+		viewLength = getDesc(DataView.prototype, "byteLength").get;
+
+	function keys(obj) {
+		var names = getNames(obj), symbols = getSymbols(obj);
+		for (var i = 0; i < symbols.length; i++) {
+			names[names.length] = symbols[i];
+		}
+		return names;
+	}
+	function isObject(v) {
+		return (typeof v === "object" && v !== null) || typeof v === "function";
+	}
+	function same(a, b) {
+		return a !== undefined && is(a.value, b.value) && a.get === b.get && a.set === b.set &&
+			a.writable === b.writable && a.enumerable === b.enumerable && a.configurable === b.configurable;
+	}
+
+	// Assigning a property inherited from a frozen object fails, which would
+	// keep tracers from overriding e.g. toString on their own objects. The
+	// commonly overridden properties of the builtins are thus turned into
+	// accessors defining the property on the object assigned to.
+	function enable(obj, names) {
+		for (var i = 0; i < names.length; i++) {
+			(function(name, desc) {
+				if (desc === undefined || !desc.writable) {
+					return;
+				}
+				var value = desc.value;
+				define(obj, name, {
+					get: function() { return value; },
+					set: function(v) {
+						if (this !== obj && isObject(this)) {
+							define(this, name, {value: v, writable: true, enumerable: true, configurable: true});
+						}
+					},
+					enumerable: desc.enumerable,
+					configurable: desc.configurable
+				});
+			})(names[i], getDesc(obj, names[i]));
+		}
+	}
+	enable(Object.prototype, ["constructor", "toString", "toLocaleString", "valueOf", "hasOwnProperty", "isPrototypeOf", "propertyIsEnumerable"]);
+	enable(Function.prototype, ["constructor", "toString", "apply", "call", "bind"]);
+	enable(Array.prototype, ["constructor", "toString", "toLocaleString"]);
+	var errors = [Error, EvalError, RangeError, ReferenceError, SyntaxError, TypeError, URIError];
+	for (var i = 0; i < errors.length; i++) {
+		enable(errors[i].prototype, ["constructor", "name", "message", "toString"]);
+	}
+
+	// Freeze the builtins, so that only the global object needs a reset. The
+	// small integers cached by the bigint library are simple enough to be
+	// frozen without walking them. No tracer ran yet, so the builtins can be
+	// used as is until then.
+	var intrinsics = new Dict(), frozen = [], pending = [], cache = global.bigInt;
+	for (var i = -999; i < 1000; i++) {
+		if (isObject(cache[i])) {
+			intrinsics.set(cache[i], true);
+			frozen.push(cache[i]);
+		}
+	}
+	pending.push(getProto(cache[0]));
+
+	function collect(obj) {
+		var names = keys(obj);
+		for (var i = 0; i < names.length; i++) {
+			if (obj === cache && intrinsics.has(cache[names[i]])) {
+				continue;
+			}
+			var desc = getDesc(obj, names[i]);
+			pending.push(desc.value, desc.get, desc.set);
+		}
+		pending.push(getProto(obj));
+	}
+	collect(global);
+	while (pending.length > 0) {
+		var v = pending.pop();
+		if (!isObject(v) || v === global || intrinsics.has(v)) {
+			continue;
+		}
+		intrinsics.set(v, true);
+		frozen.push(v);
+		collect(v);
+	}
+	for (var i = 0; i < frozen.length; i++) {
+		freeze(frozen[i]);
+	}
+
+	// Record the state of the global object.
+	var globals = keys(global), descs = new Dict(), proto = getProto(global);
+	for (var i = 0; i < globals.length; i++) {
+		descs.set(globals[i], getDesc(global, globals[i]));
+	}
+
+	// reset restores the global object to its recorded state, reporting
+	// whether it succeeded.
+	function reset() {
+		if (getProto(global) !== proto && !setProto(global, proto)) {
+			return false;
+		}
+		if (!isExtensible(global)) {
+			return false;
+		}
+		var names = keys(global);
+		for (var i = 0; i < names.length; i++) {
+			if (!apply(dictHas, descs, [names[i]]) && !del(global, names[i])) {
+				return false;
+			}
+		}
+		for (var i = 0; i < globals.length; i++) {
+			var want = apply(dictGet, descs, [globals[i]]);
+			if (!same(getDesc(global, globals[i]), want) && !define(global, globals[i], want)) {
+				return false;
+			}
+		}
+		return true;
+	}
+
+	// size estimates the size in bytes of the values reachable from root, and
+	// from the properties added to or changed on the global object, excluding
+	// the builtins. It stops walking once the estimate exceeds limit.
+	function size(root, limit) {
+		var seen = new Dict(), pending = [root], total = 0;
+		function push(v) {
+			pending[pending.length] = v;
+		}
+		var names = keys(global);
+		for (var i = 0; i < names.length; i++) {
+			var desc = getDesc(global, names[i]), want = apply(dictGet, descs, [names[i]]);
+			if (want === undefined) {
+				total += 16;
+				push(names[i]);
+			} else if (same(desc, want)) {
+				continue;
+			}
+			push(desc.value);
+			push(desc.get);
+			push(desc.set);
+		}
+		while (pending.length > 0 && total <= limit) {
+			var v = pending[pending.length - 1];
+			pending.length--;
+
+			if (typeof v === "string") {
+				total += 16 + 2 * v.length;
+				continue;
+			}
+			if (!isObject(v)) {
+				total += 16;
+				continue;
+			}
+			if (v === global || apply(dictHas, intrinsics, [v]) || apply(dictHas, seen, [v])) {
+				continue;
+			}
+			apply(dictSet, seen, [v, true]);
+			total += 64;
+
+			if (isView(v)) {
+				try {
+					total += apply(arrayLength, v, []);
+				} catch (e) {
+					total += apply(viewLength, v, []);
+				}
+			} else {
+				try {
+					total += apply(bufferLength, v, []);
+				} catch (e) {}
+			}
+			try {
+				apply(mapEach, v, [function(value, key) { push(key); push(value); }]);
+			} catch (e) {}
+			try {
+				apply(setEach, v, [function(value) { push(value); }]);
+			} catch (e) {}
+
+			var names = keys(v);
+			for (var j = 0; j < names.length; j++) {
+				var desc = getDesc(v, names[j]);
+				total += 16;
+				push(names[j]);
+				if (desc !== undefined) {
+					push(desc.value);
+					push(desc.get);
+					push(desc.set);
+				}
+			}
+			push(getProto(v));
+		}
+		return total;
+	}
+	return {reset: reset, size: size};
+})(this)`, false)
+
+// jsRuntime is a JS runtime along with the utilities to convert Go types into
+// those suitable for JS consumption.
+type jsRuntime struct {
+	vm      *goja.Runtime
+	toBig   toBigFn
+	toBuf   toBufFn
+	fromBuf fromBufFn
+
+	reset goja.Callable // Restores the global object to its initial state, if sandboxed
+	size  goja.Callable // Estimates the size of the JS state of a tracer, if sandboxed
+}
+
+// newJsRuntime creates a new JS runtime with the type converters set up. A
+// sandboxed runtime has its builtins frozen, so that it can be reset for reuse
+// and the memory of its tracer estimated.
+func newJsRuntime(sandboxed bool) (*jsRuntime, error) {
+	vm := goja.New()
+	// By default field names are exported to JS as is, i.e. capitalized.
+	vm.SetFieldNameMapper(goja.UncapFieldNameMapper())
+
+	// Inject bigint logic.
+	// TODO: To be replaced after goja adds support for native JS bigint.
+	toBigCode, err := vm.RunProgram(bigIntProgram)
+	if err != nil {
+		return nil, err
+	}
+	// Used to create JS bigint objects from go.
+	toBigFn, ok := goja.AssertFunction(toBigCode)
+	if !ok {
+		return nil, errors.New("failed to bind bigInt func")
+	}
+	// NOTE: We need this workaround to create JS buffers because
+	// goja doesn't at the moment expose constructors for typed arrays.
+	//
+	// Cache uint8ArrayType once to be used every time for less overhead.
+	uint8ArrayType := vm.Get("Uint8Array")
+
+	rt := &jsRuntime{
+		vm: vm,
+		toBig: func(vm *goja.Runtime, val string) (goja.Value, error) {
+			return toBigFn(goja.Undefined(), vm.ToValue(val))
+		},
+		toBuf: func(vm *goja.Runtime, val []byte) (goja.Value, error) {
+			return toBuf(vm, uint8ArrayType, val)
+		},
+		fromBuf: func(vm *goja.Runtime, buf goja.Value, allowString bool) ([]byte, error) {
+			return fromBuf(vm, uint8ArrayType, buf, allowString)
+		},
+	}
+	if !sandboxed {
+		return rt, nil
+	}
+	// Harden the runtime last, once fully set up.
+	sandbox, err := vm.RunProgram(sandboxProgram)
+	if err != nil {
+		return nil, err
+	}
+	if rt.reset, ok = goja.AssertFunction(sandbox.ToObject(vm).Get("reset")); !ok {
+		return nil, errors.New("failed to bind reset func")
+	}
+	if rt.size, ok = goja.AssertFunction(sandbox.ToObject(vm).Get("size")); !ok {
+		return nil, errors.New("failed to bind size func")
+	}
+	return rt, nil
+}
+
+// sizeOf estimates the size of the JS state reachable from the given object,
+// stopping once it exceeds limit.
+func (rt *jsRuntime) sizeOf(obj *goja.Object, limit uint64) (uint64, error) {
+	size, err := rt.size(goja.Undefined(), obj, rt.vm.ToValue(limit))
+	if err != nil {
+		return 0, err
+	}
+	return uint64(size.ToInteger()), nil
+}
+
+// newPooledRuntime returns a sandboxed runtime of the pool, or a new one if
+// the pool is empty.
+func newPooledRuntime(pool *sync.Pool) (*jsRuntime, error) {
+	if rt, ok := pool.Get().(*jsRuntime); ok {
+		rt.vm.ClearInterrupt()
+		return rt, nil
+	}
+	return newJsRuntime(true)
+}
+
+// namedTracer is a JS tracer compiled once and registered under a name, along
+// with the sandboxed runtimes previously used by its instances. As the
+// builtins of those are frozen, named tracers can't change them.
+type namedTracer struct {
+	program *goja.Program
+	limits  Limits
+	pool    sync.Pool // Initialised *jsRuntime, reset after use
+}
+
+// newNamedTracer compiles the code of a named tracer.
+func newNamedTracer(name string, code string, limits Limits) (*namedTracer, error) {
+	program, err := goja.Compile(name, "("+code+")", false)
+	if err != nil {
+		return nil, fmt.Errorf("tracer %s: %v", name, err)
+	}
+	return &namedTracer{program: program, limits: limits}, nil
+}
+
+// New instantiates the tracer, on a runtime of a previous instance if there
+// is one available.
+func (n *namedTracer) New(ctx *tracers.Context, cfg json.RawMessage) (tracers.Tracer, error) {
+	rt, err := newPooledRuntime(&n.pool)
+	if err != nil {
+		return nil, err
+	}
+	return newJsTracerWithRuntime(rt, &n.pool, n.limits, func(vm *goja.Runtime) (goja.Value, error) {
+		return vm.RunProgram(n.program)
+	}, ctx, cfg)
+}
+
+// validate makes sure the tracer can be instantiated, keeping the runtime of
+// the instance for the first trace.
+func (n *namedTracer) validate() error {
+	t, err := n.New(new(tracers.Context), nil)
+	if err != nil {
+		return err
+	}
+	t.(*jsTracer).release()
+	return nil
+}
+
+// registerJsTracer compiles the code of a named tracer once, and registers it
+// in the default directory.
+func registerJsTracer(name string, code string, limits Limits) error {
+	tracer, err := newNamedTracer(name, code, limits)
+	if err != nil {
+		return err
+	}
+	tracers.DefaultDirectory.Register(name, tracer.New, true)
+	return nil
+}
+
+// RegisterTracers registers the built-in JS tracers and the evaluation of
+// user-provided JS tracer code in the default directory, limited to the given
+// resources. If the memory is limited, the user-provided tracers share a pool
+// of sandboxed runtimes.
+func RegisterTracers(limits Limits) error {
+	for name, code := range assetTracers {
+		if err := registerJsTracer(name, code, limits); err != nil {
+			return err
+		}
+	}
+	pool := new(sync.Pool)
+	tracers.DefaultDirectory.RegisterJSEval(func(code string, ctx *tracers.Context, cfg json.RawMessage) (tracers.Tracer, error) {
+		return newJsTracerWithLimits(code, limits, pool, ctx, cfg)
+	})
+	return nil
+}
+
+// LoadTracers loads the JS tracers of the *.js files in the given directory,
+// and registers them in the default directory under the name of the file
+// without the extension, limited to the given resources. Users may then
+// invoke the tracers by name instead of sending their code. It returns the
+// names of the loaded tracers.
+func LoadTracers(dir string, limits Limits) ([]string, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	loaded := make(map[string]*namedTracer)
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".js" {
+			continue
+		}
+		name := strings.TrimSuffix(file.Name(), ".js")
+		if tracers.DefaultDirectory.Has(name) {
+			return nil, fmt.Errorf("tracer %s already exists", name)
+		}
+		code, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		tracer, err := newNamedTracer(name, string(code), limits)
+		if err != nil {
+			return nil, err
+		}
+		// Make sure the tracer is valid before serving it
+		if err := tracer.validate(); err != nil {
+			return nil, fmt.Errorf("tracer %s: %v", name, err)
+		}
+		loaded[name] = tracer
+	}
+	names := make([]string, 0, len(loaded))
+	for name, tracer := range loaded {
+		tracers.DefaultDirectory.Register(name, tracer.New, true)
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,229 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package js
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestLimits(t *testing.T) {
+	for i, tt := range []struct {
+		limits Limits
+		code   string
+		want   string
+		fail   string
+	}{
+		{ // the default contract executes three steps
+			limits: Limits{Steps: 3},
+			code:   "{steps: 0, step: function() { this.steps++; }, fault: function() {}, result: function() { return this.steps; }}",
+			want:   `3`,
+		}, {
+			limits: Limits{Steps: 2},
+			code:   "{steps: 0, step: function() { this.steps++; }, fault: function() {}, result: function() { return this.steps; }}",
+			fail:   "tracer step limit exceeded: 2 steps    in server-side tracer function 'step'",
+		}, {
+			limits: Limits{Memory: 1024 * 1024},
+			code:   "{data: [], step: function() { this.data.push('x'.repeat(1024)); }, fault: function() {}, result: function() { return this.data.length; }}",
+			want:   `3`,
+		}, { // the state of the tracer object is accounted
+			limits: Limits{Memory: 1024 * 1024},
+			code:   "{data: [], step: function() { for (var i = 0; i < 1024; i++) { this.data.push('x'.repeat(1024)); } }, fault: function() {}, result: function() { return this.data.length; }}",
+			fail:   "tracer memory limit exceeded: 1048576 bytes",
+		}, { // and so are the globals
+			limits: Limits{Memory: 1024 * 1024},
+			code:   "{step: function() { data = new Map(); for (var i = 0; i < 1024; i++) { data.set(i, new Uint8Array(1024)); } }, fault: function() {}, result: function() { return data.size; }}",
+			fail:   "tracer memory limit exceeded: 1048576 bytes",
+		}, { // and the result
+			limits: Limits{Memory: 10},
+			code:   "{step: function() {}, fault: function() {}, result: function() { return 'much too long'; }}",
+			fail:   "tracer memory limit exceeded: 10 bytes    in server-side tracer function 'result'",
+		},
+	} {
+		tracer, err := newJsTracerWithLimits(tt.code, tt.limits, nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		have, err := runTrace(tracer, testCtx(), params.TestChainConfig, nil)
+		if tt.fail != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.fail) {
+				t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.fail)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+		} else if string(have) != tt.want {
+			t.Errorf("test %d: result mismatch: have %s, want %s", i, have, tt.want)
+		}
+	}
+}
+
+func TestLoadTracers(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"testCountTracer.js":  "{count: 0, step: function() { this.count++; }, fault: function() {}, result: function() { return this.count; }}",
+		"testGlobalTracer.js": "{step: function() { if (typeof seen === 'undefined') { seen = 0; } seen++; }, fault: function() {}, result: function() { return seen; }}",
+		"testProtoTracer.js":  "{step: function() { Array.prototype.seen = true; Math.max = function() { return 0; }; }, fault: function() {}, result: function() { var o = {}; o.toString = function() { return 'own'; }; return ([].seen || Math.max(1, 2) !== 2 || String(o) !== 'own') ? -1 : 3; }}",
+		"README.md":           "not a tracer",
+	}
+	for name, code := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(code), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names, err := LoadTracers(dir, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"testCountTracer", "testGlobalTracer", "testProtoTracer"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("loaded tracers mismatch: have %v, want %v", names, want)
+	}
+	if !tracers.DefaultDirectory.IsJS("testCountTracer") {
+		t.Error("loaded tracer not registered as JS")
+	}
+	// Run the tracer a few times, none seeing the state of the previous runs
+	// on the pooled runtimes
+	for i := 0; i < 3; i++ {
+		for _, name := range names {
+			tracer, err := tracers.DefaultDirectory.New(name, new(tracers.Context), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			have, err := runTrace(tracer, testCtx(), params.TestChainConfig, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(have) != `3` {
+				t.Errorf("run %d: %s result mismatch: have %s, want 3", i, name, have)
+			}
+		}
+	}
+	// Loading the tracers again collides with the registered ones
+	if _, err := LoadTracers(dir, Limits{}); err == nil {
+		t.Error("expected name collision error")
+	}
+	// Invalid tracers are rejected
+	bad := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bad, "badTracer.js"), []byte("{step: function() {}}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTracers(bad, Limits{}); err == nil {
+		t.Error("expected invalid tracer error")
+	}
+}
+
+func TestPooledRuntime(t *testing.T) {
+	// Keep the garbage collector from emptying the pool
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+
+	tracer, err := newNamedTracer("testPoolTracer", "{step: function() {}, fault: function() {}, result: function() { return typeof leaked; }}", Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tracer.validate(); err != nil {
+		t.Fatal(err)
+	}
+	rt, _ := tracer.pool.Get().(*jsRuntime)
+	if rt == nil {
+		t.Fatal("validated tracer left no runtime in the pool")
+	}
+	// Leak a global and let the tracer return the runtime to the pool
+	rt.vm.Set("leaked", true)
+	tracer.pool.Put(rt)
+
+	instance, err := tracer.New(new(tracers.Context), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instance.(*jsTracer).rt != rt {
+		t.Fatal("runtime not reused")
+	}
+	have, err := runTrace(instance, testCtx(), params.TestChainConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The runtime was reset after validation, not after the leak
+	if string(have) != `"boolean"` {
+		t.Errorf("result mismatch: have %s, want \"boolean\"", have)
+	}
+	rt, _ = tracer.pool.Get().(*jsRuntime)
+	if rt == nil {
+		t.Fatal("tracer did not return the runtime to the pool")
+	}
+	if leaked := rt.vm.Get("leaked"); leaked != nil {
+		t.Errorf("global leaked through the pool: %v", leaked)
+	}
+	// Stopped tracers don't return their runtime
+	instance, err = tracer.New(new(tracers.Context), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance.Stop(errors.New("stopped"))
+	runTrace(instance, testCtx(), params.TestChainConfig, nil)
+	if rt, _ := tracer.pool.Get().(*jsRuntime); rt != nil {
+		t.Error("stopped tracer returned the runtime to the pool")
+	}
+}
+
+func TestRegisterTracers(t *testing.T) {
+	defer RegisterTracers(Limits{})
+	// Keep the garbage collector from emptying the pool
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+
+	if err := RegisterTracers(Limits{Memory: 1024 * 1024}); err != nil {
+		t.Fatal(err)
+	}
+	// User-provided tracers share the sandboxed runtimes, none seeing the
+	// globals of the previous runs
+	code := "{step: function() { seen = (typeof seen === 'undefined' ? 0 : seen) + 1; }, fault: function() {}, result: function() { return seen; }}"
+	runtimes := make(map[*jsRuntime]bool)
+	for i := 0; i < 3; i++ {
+		tracer, err := tracers.DefaultDirectory.New(code, new(tracers.Context), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		runtimes[tracer.(*jsTracer).rt] = true
+
+		have, err := runTrace(tracer, testCtx(), params.TestChainConfig, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(have) != `3` {
+			t.Errorf("run %d: result mismatch: have %s, want 3", i, have)
+		}
+	}
+	if len(runtimes) != 1 {
+		t.Errorf("runtimes not reused: %d runtimes", len(runtimes))
+	}
+	// The built-in tracers are limited too
+	tracer, err := tracers.DefaultDirectory.New("callTracerLegacy", new(tracers.Context), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits := tracer.(*jsTracer).limits; limits.Memory != 1024*1024 {
+		t.Errorf("built-in tracer limits mismatch: have %+v", limits)
+	}
+}
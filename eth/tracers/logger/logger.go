@@ -111,6 +111,8 @@ type StructLogger struct {
 
 	storage  map[common.Address]Storage
 	logs     []StructLog
+	steps    int             // Number of logs captured, streamed or not
+	stream   func(StructLog) // Receiver of the logs, instead of buffering them
 	output   []byte
 	err      error
 	gasLimit uint64
@@ -131,11 +133,21 @@ func NewStructLogger(cfg *Config) *StructLogger {
 	return logger
 }
 
+// NewStreamingStructLogger returns a new logger handing each structured log to
+// the given function as soon as it is captured, instead of accumulating them.
+// The function may block to slow down the execution.
+func NewStreamingStructLogger(cfg *Config, stream func(StructLog)) *StructLogger {
+	logger := NewStructLogger(cfg)
+	logger.stream = stream
+	return logger
+}
+
 // Reset clears the data held by the logger.
 func (l *StructLogger) Reset() {
 	l.storage = make(map[common.Address]Storage)
 	l.output = make([]byte, 0)
 	l.logs = l.logs[:0]
+	l.steps = 0
 	l.err = nil
 }
 
@@ -153,7 +165,7 @@ func (l *StructLogger) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, s
 		return
 	}
 	// check if already accumulated the specified number of logs
-	if l.cfg.Limit != 0 && l.cfg.Limit <= len(l.logs) {
+	if l.cfg.Limit != 0 && l.cfg.Limit <= l.steps {
 		return
 	}
 
@@ -209,6 +221,11 @@ func (l *StructLogger) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, s
 	}
 	// create a new snapshot of the EVM.
 	log := StructLog{pc, op, gas, cost, mem, memory.Len(), stck, rdata, storage, depth, l.env.StateDB.GetRefund(), err}
+	l.steps++
+	if l.stream != nil {
+		l.stream(log)
+		return
+	}
 	l.logs = append(l.logs, log)
 }
 
@@ -269,7 +286,7 @@ func (l *StructLogger) CaptureTxEnd(restGas uint64) {
 	l.usedGas = l.gasLimit - restGas
 }
 
-// StructLogs returns the captured log entries.
+// StructLogs returns the captured log entries, which are empty if streamed.
 func (l *StructLogger) StructLogs() []StructLog { return l.logs }
 
 // Error returns the VM error captured by the trace.
@@ -427,36 +444,42 @@ type StructLogRes struct {
 func formatLogs(logs []StructLog) []StructLogRes {
 	formatted := make([]StructLogRes, len(logs))
 	for index, trace := range logs {
-		formatted[index] = StructLogRes{
-			Pc:            trace.Pc,
-			Op:            trace.Op.String(),
-			Gas:           trace.Gas,
-			GasCost:       trace.GasCost,
-			Depth:         trace.Depth,
-			Error:         trace.ErrorString(),
-			RefundCounter: trace.RefundCounter,
-		}
-		if trace.Stack != nil {
-			stack := make([]string, len(trace.Stack))
-			for i, stackValue := range trace.Stack {
-				stack[i] = stackValue.Hex()
-			}
-			formatted[index].Stack = &stack
+		formatted[index] = FormatLog(trace)
+	}
+	return formatted
+}
+
+// FormatLog formats an EVM returned structured log for json output.
+func FormatLog(trace StructLog) StructLogRes {
+	formatted := StructLogRes{
+		Pc:            trace.Pc,
+		Op:            trace.Op.String(),
+		Gas:           trace.Gas,
+		GasCost:       trace.GasCost,
+		Depth:         trace.Depth,
+		Error:         trace.ErrorString(),
+		RefundCounter: trace.RefundCounter,
+	}
+	if trace.Stack != nil {
+		stack := make([]string, len(trace.Stack))
+		for i, stackValue := range trace.Stack {
+			stack[i] = stackValue.Hex()
 		}
-		if trace.Memory != nil {
-			memory := make([]string, 0, (len(trace.Memory)+31)/32)
-			for i := 0; i+32 <= len(trace.Memory); i += 32 {
-				memory = append(memory, fmt.Sprintf("%x", trace.Memory[i:i+32]))
-			}
-			formatted[index].Memory = &memory
+		formatted.Stack = &stack
+	}
+	if trace.Memory != nil {
+		memory := make([]string, 0, (len(trace.Memory)+31)/32)
+		for i := 0; i+32 <= len(trace.Memory); i += 32 {
+			memory = append(memory, fmt.Sprintf("%x", trace.Memory[i:i+32]))
 		}
-		if trace.Storage != nil {
-			storage := make(map[string]string)
-			for i, storageValue := range trace.Storage {
-				storage[fmt.Sprintf("%x", i)] = fmt.Sprintf("%x", storageValue)
-			}
-			formatted[index].Storage = &storage
+		formatted.Memory = &memory
+	}
+	if trace.Storage != nil {
+		storage := make(map[string]string)
+		for i, storageValue := range trace.Storage {
+			storage[fmt.Sprintf("%x", i)] = fmt.Sprintf("%x", storageValue)
 		}
+		formatted.Storage = &storage
 	}
 	return formatted
 }
@@ -0,0 +1,298 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package native
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"sort"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+)
+
+func init() {
+	tracers.DefaultDirectory.Register("accessSetTracer", newAccessSetTracer, false)
+}
+
+// AccessSet is the state read and written by a tx.
+type AccessSet struct {
+	Reads  map[common.Address]*AccountAccess `json:"reads"`
+	Writes map[common.Address]*AccountAccess `json:"writes"`
+}
+
+// AccountAccess is the part of an account accessed by a tx.
+type AccountAccess struct {
+	Balance bool          `json:"balance,omitempty"`
+	Nonce   bool          `json:"nonce,omitempty"`
+	Code    bool          `json:"code,omitempty"`
+	Storage []common.Hash `json:"storage,omitempty"` // Sorted slots
+}
+
+// accountAccess is the part of an account accessed by a tx, while tracing.
+type accountAccess struct {
+	balance bool
+	nonce   bool
+	code    bool
+	storage map[common.Hash]struct{}
+}
+
+// export returns the access with the slots sorted.
+func (a *accountAccess) export() *AccountAccess {
+	access := &AccountAccess{Balance: a.balance, Nonce: a.nonce, Code: a.code}
+	for slot := range a.storage {
+		access.Storage = append(access.Storage, slot)
+	}
+	sort.Slice(access.Storage, func(i, j int) bool {
+		return bytes.Compare(access.Storage[i][:], access.Storage[j][:]) < 0
+	})
+	return access
+}
+
+// accountSnapshot is the state of an account before it was first accessed.
+type accountSnapshot struct {
+	balance  *big.Int
+	nonce    uint64
+	codeHash common.Hash
+	storage  map[common.Hash]common.Hash
+}
+
+type accessSetTracer struct {
+	noopTracer
+	env       *vm.EVM
+	reads     map[common.Address]*accountAccess
+	writes    map[common.Address]*accountAccess
+	pre       map[common.Address]*accountSnapshot
+	interrupt uint32 // Atomic flag to signal execution interruption
+	reason    error  // Textual reason for the interruption
+}
+
+// newAccessSetTracer returns a native go tracer which records the accounts,
+// balances, nonces, code and storage slots read and written by a tx, to study
+// the dependencies between the txs of a block.
+//
+// The writes are the changes of the state by the tx, as in the diff mode of
+// the prestateTracer, so reverted or no-op writes are left out. The payment
+// of the fees to the coinbase is left out too, unless the tx accesses the
+// coinbase itself, as it is commutative.
+func newAccessSetTracer(ctx *tracers.Context, _ json.RawMessage) (tracers.Tracer, error) {
+	return &accessSetTracer{
+		reads:  make(map[common.Address]*accountAccess),
+		writes: make(map[common.Address]*accountAccess),
+		pre:    make(map[common.Address]*accountSnapshot),
+	}, nil
+}
+
+// CaptureStart implements the EVMLogger interface to initialize the tracing operation.
+func (t *accessSetTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.env = env
+
+	// The sender is always charged and its nonce incremented
+	sender := t.read(from)
+	sender.balance, sender.nonce = true, true
+	writer := t.write(from)
+	writer.balance, writer.nonce = true, true
+
+	// The recipient is snapshotted after the value transfer, which leaves
+	// the balance of a self-send unchanged
+	t.snapshot(to)
+	if from != to {
+		t.pre[to].balance = new(big.Int).Sub(t.pre[to].balance, value)
+	}
+	if create {
+		t.pre[to].nonce, t.pre[to].codeHash = 0, types.EmptyCodeHash
+		t.read(to).nonce = true
+	}
+	t.read(to).code = true
+}
+
+// CaptureState implements the EVMLogger interface to trace a single step of VM execution.
+func (t *accessSetTracer) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	if err != nil {
+		return
+	}
+	if atomic.LoadUint32(&t.interrupt) > 0 {
+		return
+	}
+	var (
+		stack    = scope.Stack.Data()
+		stackLen = len(stack)
+		caller   = scope.Contract.Address()
+	)
+	switch {
+	case stackLen >= 1 && (op == vm.SLOAD || op == vm.SSTORE):
+		// Storing reads the slot too, as its gas cost depends on the value
+		t.readSlot(caller, common.Hash(stack[stackLen-1].Bytes32()))
+	case op == vm.SELFBALANCE:
+		t.read(caller).balance = true
+	case stackLen >= 1 && op == vm.BALANCE:
+		t.read(common.Address(stack[stackLen-1].Bytes20())).balance = true
+	case stackLen >= 1 && (op == vm.EXTCODECOPY || op == vm.EXTCODEHASH || op == vm.EXTCODESIZE):
+		t.read(common.Address(stack[stackLen-1].Bytes20())).code = true
+	case stackLen >= 1 && op == vm.SELFDESTRUCT:
+		t.read(caller).balance = true
+		t.read(common.Address(stack[stackLen-1].Bytes20())).balance = true
+	case stackLen >= 3 && (op == vm.CALL || op == vm.CALLCODE):
+		t.read(common.Address(stack[stackLen-2].Bytes20())).code = true
+		if stack[stackLen-3].Sign() != 0 {
+			t.read(caller).balance = true
+		}
+	case stackLen >= 2 && (op == vm.DELEGATECALL || op == vm.STATICCALL):
+		t.read(common.Address(stack[stackLen-2].Bytes20())).code = true
+	case op == vm.CREATE:
+		t.read(caller).nonce = true
+		addr := crypto.CreateAddress(caller, t.env.StateDB.GetNonce(caller))
+		t.read(addr).code = true
+		t.read(addr).nonce = true
+	case stackLen >= 4 && op == vm.CREATE2:
+		offset, size := stack[stackLen-2], stack[stackLen-3]
+		if !offset.IsUint64() || !size.IsUint64() {
+			return
+		}
+		// The memory is not yet expanded to cover the init code, the missing
+		// part is zero.
+		init := make([]byte, size.Uint64())
+		if start := offset.Uint64(); start < uint64(scope.Memory.Len()) {
+			copy(init, scope.Memory.Data()[start:])
+		}
+		addr := crypto.CreateAddress2(caller, stack[stackLen-4].Bytes32(), crypto.Keccak256(init))
+		t.read(addr).code = true
+		t.read(addr).nonce = true
+	}
+}
+
+// CaptureTxEnd collects the changes made to the accessed accounts.
+func (t *accessSetTracer) CaptureTxEnd(restGas uint64) {
+	if t.env == nil {
+		return
+	}
+	for addr, pre := range t.pre {
+		var (
+			db     = t.env.StateDB
+			access = &accountAccess{storage: make(map[common.Hash]struct{})}
+		)
+		if db.GetBalance(addr).Cmp(pre.balance) != 0 {
+			access.balance = true
+		}
+		if db.GetNonce(addr) != pre.nonce {
+			access.nonce = true
+		}
+		if codeHash(db.GetCodeHash(addr)) != pre.codeHash {
+			access.code = true
+		}
+		// Destructed accounts are dropped as a whole
+		if db.HasSuicided(addr) {
+			access.balance, access.nonce, access.code = true, true, true
+		}
+		for slot, value := range pre.storage {
+			if db.GetState(addr, slot) != value {
+				access.storage[slot] = struct{}{}
+			}
+		}
+		if !access.balance && !access.nonce && !access.code && len(access.storage) == 0 {
+			continue
+		}
+		writer := t.write(addr)
+		writer.balance = writer.balance || access.balance
+		writer.nonce = writer.nonce || access.nonce
+		writer.code = writer.code || access.code
+		for slot := range access.storage {
+			writer.storage[slot] = struct{}{}
+		}
+	}
+}
+
+// GetResult returns the read and write sets of the tx.
+func (t *accessSetTracer) GetResult() (json.RawMessage, error) {
+	set := &AccessSet{
+		Reads:  make(map[common.Address]*AccountAccess),
+		Writes: make(map[common.Address]*AccountAccess),
+	}
+	for addr, access := range t.reads {
+		set.Reads[addr] = access.export()
+	}
+	for addr, access := range t.writes {
+		set.Writes[addr] = access.export()
+	}
+	res, err := json.Marshal(set)
+	if err != nil {
+		return nil, err
+	}
+	return res, t.reason
+}
+
+// Stop terminates execution of the tracer at the first opportune moment.
+func (t *accessSetTracer) Stop(err error) {
+	t.reason = err
+	atomic.StoreUint32(&t.interrupt, 1)
+}
+
+// snapshot records the state of an account, if not yet accessed.
+func (t *accessSetTracer) snapshot(addr common.Address) {
+	if _, ok := t.pre[addr]; ok {
+		return
+	}
+	t.pre[addr] = &accountSnapshot{
+		balance:  t.env.StateDB.GetBalance(addr),
+		nonce:    t.env.StateDB.GetNonce(addr),
+		codeHash: codeHash(t.env.StateDB.GetCodeHash(addr)),
+		storage:  make(map[common.Hash]common.Hash),
+	}
+}
+
+// read returns the read access of an account, snapshotting it if needed.
+func (t *accessSetTracer) read(addr common.Address) *accountAccess {
+	t.snapshot(addr)
+	access, ok := t.reads[addr]
+	if !ok {
+		access = &accountAccess{storage: make(map[common.Hash]struct{})}
+		t.reads[addr] = access
+	}
+	return access
+}
+
+// readSlot records the read of a storage slot, snapshotting it if needed.
+func (t *accessSetTracer) readSlot(addr common.Address, slot common.Hash) {
+	t.read(addr).storage[slot] = struct{}{}
+	if _, ok := t.pre[addr].storage[slot]; !ok {
+		t.pre[addr].storage[slot] = t.env.StateDB.GetState(addr, slot)
+	}
+}
+
+// write returns the write access of an account.
+func (t *accessSetTracer) write(addr common.Address) *accountAccess {
+	access, ok := t.writes[addr]
+	if !ok {
+		access = &accountAccess{storage: make(map[common.Hash]struct{})}
+		t.writes[addr] = access
+	}
+	return access
+}
+
+// codeHash returns the code hash of an account, with non-existent accounts
+// having the hash of the empty code.
+func codeHash(hash common.Hash) common.Hash {
+	if hash == (common.Hash{}) {
+		return types.EmptyCodeHash
+	}
+	return hash
+}
@@ -0,0 +1,504 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package native
+
+import (
+	"encoding/json"
+	"math/big"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/params/vars"
+	"github.com/holiman/uint256"
+)
+
+func init() {
+	tracers.DefaultDirectory.Register("gasProfilerTracer", newGasProfilerTracer, false)
+}
+
+// GasProfile is the gas usage of a tx aggregated per opcode, per contract and
+// per function selector.
+type GasProfile struct {
+	GasUsed      uint64                          `json:"gasUsed"`      // Gas paid by the tx, after refunds
+	IntrinsicGas uint64                          `json:"intrinsicGas"` // Gas charged before execution
+	Refund       uint64                          `json:"refund"`       // Gas refunded at the end of the tx
+	Opcodes      map[string]*OpcodeGas           `json:"opcodes"`
+	Contracts    map[common.Address]*ContractGas `json:"contracts"`
+	Selectors    map[string]*SelectorGas         `json:"selectors"`
+	Pprof        []byte                          `json:"pprof,omitempty"` // Gzipped pprof profile of the gas usage
+}
+
+// OpcodeGas is the gas used by all the executions of an opcode. The gas of
+// the calls and creations excludes the gas used by the callee.
+type OpcodeGas struct {
+	Count         uint64 `json:"count"`
+	Gas           uint64 `json:"gas"`
+	Refund        int64  `json:"refund"`                  // Net change of the refund counter
+	ColdAccesses  uint64 `json:"coldAccesses,omitempty"`  // EIP-2929 accesses of cold accounts or slots
+	WarmAccesses  uint64 `json:"warmAccesses,omitempty"`  // EIP-2929 accesses of warm accounts or slots
+	ColdAccessGas uint64 `json:"coldAccessGas,omitempty"` // Gas paid on top of the warm cost for cold accesses
+}
+
+// ContractGas is the gas used by the calls to a contract.
+type ContractGas struct {
+	Calls    uint64 `json:"calls"`
+	SelfGas  uint64 `json:"selfGas"`  // Gas used by the contract code itself
+	TotalGas uint64 `json:"totalGas"` // Gas used including the subcalls
+}
+
+// SelectorGas is the gas used by the calls of a 4-byte function selector.
+type SelectorGas struct {
+	Calls uint64 `json:"calls"`
+	Gas   uint64 `json:"gas"` // Gas used including the subcalls
+}
+
+// gasProfilerOp is an executed opcode, whose gas usage is known once the next
+// opcode of its frame is executed or the frame exits.
+type gasProfilerOp struct {
+	op   vm.OpCode
+	gas  uint64 // Gas available before the opcode
+	cost uint64 // Gas cost reported by the interpreter
+
+	callOverhead uint64 // Call cost except the gas passed to the callee
+	callStipend  uint64 // Gas given to the callee for free
+	isCall       bool   // Whether the access of the call is to be classified
+}
+
+// gasProfilerFrame is the gas accounting of a call frame.
+type gasProfilerFrame struct {
+	typ      vm.OpCode
+	address  common.Address
+	selector string
+	labels   []string // Call stack of the frame, for the pprof profile
+	gas      uint64   // Gas given to the frame
+	pending  *gasProfilerOp
+	executed bool   // Whether any code was executed in the frame
+	subGas   uint64 // Gas used by the subcalls of the pending opcode
+	children uint64 // Gas used by all the subcalls
+}
+
+type gasProfilerTracerConfig struct {
+	Pprof bool `json:"pprof"` // If true, the result contains a gzipped pprof profile
+}
+
+type gasProfilerTracer struct {
+	env       *vm.EVM
+	config    gasProfilerTracerConfig
+	profile   *GasProfile
+	callstack []*gasProfilerFrame
+	eip2929   bool   // Whether cold and warm accesses are priced differently
+	refund    uint64 // Refund counter at the last step
+	gasLimit  uint64
+	execGas   uint64 // Gas used by the execution, before refunds
+	samples   *gasSamples
+	interrupt uint32 // Atomic flag to signal execution interruption
+	reason    error  // Textual reason for the interruption
+}
+
+// newGasProfilerTracer returns a native go tracer which aggregates the gas
+// used by a tx per opcode, per contract and per 4-byte function selector,
+// including the refunds and the surcharges of cold state accesses.
+//
+// The gas usage can be exported as a pprof profile, where each call frame is a
+// function and each opcode a leaf of it:
+//
+//	> debug.traceTransaction("0x...", {tracer: "gasProfilerTracer", tracerConfig: {pprof: true}})
+//
+// The base64 encoded "pprof" field of the result, once decoded to a file, can
+// be examined with `go tool pprof -http=: <file>`.
+func newGasProfilerTracer(ctx *tracers.Context, cfg json.RawMessage) (tracers.Tracer, error) {
+	var config gasProfilerTracerConfig
+	if cfg != nil {
+		if err := json.Unmarshal(cfg, &config); err != nil {
+			return nil, err
+		}
+	}
+	t := &gasProfilerTracer{
+		config: config,
+		profile: &GasProfile{
+			Opcodes:   make(map[string]*OpcodeGas),
+			Contracts: make(map[common.Address]*ContractGas),
+			Selectors: make(map[string]*SelectorGas),
+		},
+	}
+	if config.Pprof {
+		t.samples = newGasSamples()
+	}
+	return t, nil
+}
+
+func (t *gasProfilerTracer) CaptureTxStart(gasLimit uint64) {
+	t.gasLimit = gasLimit
+}
+
+func (t *gasProfilerTracer) CaptureTxEnd(restGas uint64) {
+	if t.gasLimit < restGas {
+		return
+	}
+	t.profile.GasUsed = t.gasLimit - restGas
+	if spent := t.profile.IntrinsicGas + t.execGas; spent > t.profile.GasUsed {
+		t.profile.Refund = spent - t.profile.GasUsed
+	}
+}
+
+// CaptureStart implements the EVMLogger interface to initialize the tracing operation.
+func (t *gasProfilerTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.env = env
+	t.eip2929 = env.ChainConfig().IsEnabled(env.ChainConfig().GetEIP2929Transition, env.Context.BlockNumber)
+	t.refund = env.StateDB.GetRefund()
+	if t.gasLimit > gas {
+		t.profile.IntrinsicGas = t.gasLimit - gas
+	}
+	typ := vm.CALL
+	if create {
+		typ = vm.CREATE
+	}
+	t.enter(typ, to, input, gas)
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *gasProfilerTracer) CaptureEnd(output []byte, gasUsed uint64, err error) {
+	if len(t.callstack) == 0 {
+		return
+	}
+	t.execGas = gasUsed
+	t.exit(gasUsed)
+}
+
+// CaptureState implements the EVMLogger interface to trace a single step of VM execution.
+func (t *gasProfilerTracer) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	if err != nil || len(t.callstack) == 0 {
+		return
+	}
+	if atomic.LoadUint32(&t.interrupt) > 0 {
+		return
+	}
+	frame := t.callstack[len(t.callstack)-1]
+	t.settle(frame, gas)
+
+	stats := t.opcode(op)
+	stats.Count++
+
+	// Refunds are added while charging the gas of the opcode
+	if refund := t.env.StateDB.GetRefund(); refund != t.refund {
+		stats.Refund += int64(refund) - int64(t.refund)
+		t.refund = refund
+	}
+	pending := &gasProfilerOp{op: op, gas: gas, cost: cost}
+	if t.eip2929 {
+		t.classifyAccess(pending, stats, scope)
+	}
+	frame.pending, frame.executed = pending, true
+}
+
+// CaptureFault implements the EVMLogger interface to trace an execution fault.
+func (t *gasProfilerTracer) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, _ *vm.ScopeContext, depth int, err error) {
+}
+
+// CaptureEnter is called when EVM enters a new scope (via call, create or selfdestruct).
+func (t *gasProfilerTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	if len(t.callstack) == 0 {
+		return
+	}
+	// The gas passed to the callee completes the cost breakdown of the call
+	if parent := t.callstack[len(t.callstack)-1]; parent.pending != nil && parent.pending.isCall {
+		pending := parent.pending
+		pending.isCall = false
+
+		if gas >= pending.callStipend && pending.callOverhead >= gas-pending.callStipend {
+			rest := pending.callOverhead - (gas - pending.callStipend)
+			cold := vars.ColdAccountAccessCostEIP2929 - vars.WarmStorageReadCostEIP2929
+			switch {
+			case isCallSurcharge(rest):
+				t.opcode(pending.op).WarmAccesses++
+			case rest >= cold && isCallSurcharge(rest-cold):
+				stats := t.opcode(pending.op)
+				stats.ColdAccesses++
+				stats.ColdAccessGas += cold
+			}
+		}
+	}
+	t.enter(typ, to, input, gas)
+}
+
+// CaptureExit is called when EVM exits a scope, even if the scope didn't
+// execute any code.
+func (t *gasProfilerTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	if len(t.callstack) <= 1 {
+		return
+	}
+	t.exit(gasUsed)
+
+	// Refunds of failed frames are reverted on exit, charge it to the call
+	if refund := t.env.StateDB.GetRefund(); refund != t.refund {
+		if parent := t.callstack[len(t.callstack)-1]; parent.pending != nil {
+			t.opcode(parent.pending.op).Refund += int64(refund) - int64(t.refund)
+		}
+		t.refund = refund
+	}
+}
+
+// GetResult returns the gas profile of the tx.
+func (t *gasProfilerTracer) GetResult() (json.RawMessage, error) {
+	if t.samples != nil {
+		profile, err := t.samples.encode()
+		if err != nil {
+			return nil, err
+		}
+		t.profile.Pprof = profile
+	}
+	res, err := json.Marshal(t.profile)
+	if err != nil {
+		return nil, err
+	}
+	return res, t.reason
+}
+
+// Stop terminates execution of the tracer at the first opportune moment.
+func (t *gasProfilerTracer) Stop(err error) {
+	t.reason = err
+	atomic.StoreUint32(&t.interrupt, 1)
+}
+
+// opcode returns the aggregated gas usage of an opcode.
+func (t *gasProfilerTracer) opcode(op vm.OpCode) *OpcodeGas {
+	stats, ok := t.profile.Opcodes[op.String()]
+	if !ok {
+		stats = new(OpcodeGas)
+		t.profile.Opcodes[op.String()] = stats
+	}
+	return stats
+}
+
+// enter pushes a new call frame.
+func (t *gasProfilerTracer) enter(typ vm.OpCode, to common.Address, input []byte, gas uint64) {
+	frame := &gasProfilerFrame{typ: typ, address: to, gas: gas}
+	if typ != vm.CREATE && typ != vm.CREATE2 && len(input) >= 4 {
+		frame.selector = bytesToHex(input[:4])
+	}
+	label := to.Hex()
+	if frame.selector != "" {
+		label += ":" + frame.selector
+	}
+	if len(t.callstack) > 0 {
+		parent := t.callstack[len(t.callstack)-1]
+		frame.labels = append(append([]string{}, parent.labels...), label)
+	} else {
+		frame.labels = []string{label}
+	}
+	t.callstack = append(t.callstack, frame)
+}
+
+// exit pops the innermost call frame, which used the given amount of gas.
+func (t *gasProfilerTracer) exit(gasUsed uint64) {
+	frame := t.callstack[len(t.callstack)-1]
+	t.callstack = t.callstack[:len(t.callstack)-1]
+
+	if gasUsed <= frame.gas {
+		t.settle(frame, frame.gas-gasUsed)
+	}
+	if len(t.callstack) > 0 {
+		parent := t.callstack[len(t.callstack)-1]
+		parent.subGas += gasUsed
+		parent.children += gasUsed
+	}
+	// Selfdestructs don't execute any code of the beneficiary
+	if frame.typ == vm.SELFDESTRUCT {
+		return
+	}
+	var self uint64
+	if gasUsed > frame.children {
+		self = gasUsed - frame.children
+	}
+	contract, ok := t.profile.Contracts[frame.address]
+	if !ok {
+		contract = new(ContractGas)
+		t.profile.Contracts[frame.address] = contract
+	}
+	contract.Calls++
+	contract.SelfGas += self
+	contract.TotalGas += gasUsed
+
+	if frame.selector != "" {
+		selector, ok := t.profile.Selectors[frame.selector]
+		if !ok {
+			selector = new(SelectorGas)
+			t.profile.Selectors[frame.selector] = selector
+		}
+		selector.Calls++
+		selector.Gas += gasUsed
+	}
+	// Precompiles use gas without executing any opcodes
+	if !frame.executed && self > 0 && t.samples != nil {
+		t.samples.add(frame.labels, "", self)
+	}
+}
+
+// settle accounts the gas used by the pending opcode of the frame, given the
+// gas left after it, excluding the gas used by its subcalls.
+func (t *gasProfilerTracer) settle(frame *gasProfilerFrame, gas uint64) {
+	pending := frame.pending
+	if pending == nil {
+		return
+	}
+	frame.pending = nil
+
+	var used uint64
+	if pending.gas > gas+frame.subGas {
+		used = pending.gas - gas - frame.subGas
+	}
+	frame.subGas = 0
+
+	t.opcode(pending.op).Gas += used
+	if t.samples != nil && used > 0 {
+		t.samples.add(frame.labels, pending.op.String(), used)
+	}
+}
+
+// classifyAccess determines from the cost of an opcode whether it accessed a
+// cold or a warm account or storage slot, as priced by EIP-2929. The access
+// list itself is already updated by the time the opcode is traced.
+func (t *gasProfilerTracer) classifyAccess(pending *gasProfilerOp, stats *OpcodeGas, scope *vm.ScopeContext) {
+	var (
+		cost  = pending.cost
+		stack = scope.Stack.Data()
+		cold  bool
+		warm  bool
+		extra uint64 // Gas paid for the cold access
+	)
+	switch pending.op {
+	case vm.SLOAD:
+		cold, warm = cost == vars.ColdSloadCostEIP2929, cost == vars.WarmStorageReadCostEIP2929
+		extra = vars.ColdSloadCostEIP2929 - vars.WarmStorageReadCostEIP2929
+
+	case vm.SSTORE:
+		// Cold slots are charged on top of the warm cost of the write
+		isWarmCost := func(cost uint64) bool {
+			return cost == vars.WarmStorageReadCostEIP2929 || cost == vars.SstoreSetGasEIP2200 ||
+				cost == vars.SstoreResetGasEIP2200-vars.ColdSloadCostEIP2929
+		}
+		warm = isWarmCost(cost)
+		cold = cost >= vars.ColdSloadCostEIP2929 && isWarmCost(cost-vars.ColdSloadCostEIP2929)
+		extra = vars.ColdSloadCostEIP2929
+
+	case vm.BALANCE, vm.EXTCODESIZE, vm.EXTCODEHASH:
+		cold, warm = cost == vars.ColdAccountAccessCostEIP2929, cost == vars.WarmStorageReadCostEIP2929
+		extra = vars.ColdAccountAccessCostEIP2929 - vars.WarmStorageReadCostEIP2929
+
+	case vm.EXTCODECOPY:
+		if len(stack) < 4 {
+			return
+		}
+		length := &stack[len(stack)-4]
+		size, ok := memoryRegion(&stack[len(stack)-2], length)
+		if !ok {
+			return
+		}
+		base := vars.WarmStorageReadCostEIP2929 + memoryExpansionGas(uint64(scope.Memory.Len()), size) +
+			vars.CopyGas*((length.Uint64()+31)/32)
+		extra = vars.ColdAccountAccessCostEIP2929 - vars.WarmStorageReadCostEIP2929
+		cold, warm = cost == base+extra, cost == base
+
+	case vm.SELFDESTRUCT:
+		// Cold beneficiaries are charged in full, without the constant warm cost
+		if cost < vars.SelfdestructGasEIP150 {
+			return
+		}
+		rest := cost - vars.SelfdestructGasEIP150
+		cold = rest == vars.ColdAccountAccessCostEIP2929 || rest == vars.ColdAccountAccessCostEIP2929+vars.CreateBySelfdestructGas
+		warm = rest == 0 || rest == vars.CreateBySelfdestructGas
+		extra = vars.ColdAccountAccessCostEIP2929
+
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		// The cost includes the gas passed to the callee, which is only known
+		// when entering it
+		inArg := 2
+		if pending.op == vm.CALL || pending.op == vm.CALLCODE {
+			inArg = 3
+		}
+		if len(stack) < inArg+4 {
+			return
+		}
+		back := func(n int) *uint256.Int { return &stack[len(stack)-1-n] }
+		if inArg == 3 && back(2).Sign() != 0 {
+			pending.callStipend = vars.CallStipend
+		}
+		in, inOk := memoryRegion(back(inArg), back(inArg+1))
+		out, outOk := memoryRegion(back(inArg+2), back(inArg+3))
+		if !inOk || !outOk {
+			return
+		}
+		size := in
+		if out > size {
+			size = out
+		}
+		base := vars.WarmStorageReadCostEIP2929 + memoryExpansionGas(uint64(scope.Memory.Len()), size)
+		if cost < base {
+			return
+		}
+		pending.callOverhead, pending.isCall = cost-base, true
+		return
+
+	default:
+		return
+	}
+	switch {
+	case cold:
+		stats.ColdAccesses++
+		stats.ColdAccessGas += extra
+	case warm:
+		stats.WarmAccesses++
+	}
+}
+
+// isCallSurcharge reports whether the given gas is a combination of the
+// value transfer and the account creation surcharges of calls.
+func isCallSurcharge(gas uint64) bool {
+	switch gas {
+	case 0, vars.CallValueTransferGas, vars.CallNewAccountGas, vars.CallValueTransferGas + vars.CallNewAccountGas:
+		return true
+	}
+	return false
+}
+
+// memoryRegion returns the memory size required by the region of the given
+// offset and length, or false if it doesn't fit in 64 bits.
+func memoryRegion(offset, length *uint256.Int) (uint64, bool) {
+	if length.IsZero() {
+		return 0, true
+	}
+	if !offset.IsUint64() || !length.IsUint64() {
+		return 0, false
+	}
+	size := offset.Uint64() + length.Uint64()
+	return size, size >= offset.Uint64()
+}
+
+// memoryExpansionGas returns the gas cost of expanding the memory from the
+// current size to cover the given number of bytes.
+func memoryExpansionGas(current, size uint64) uint64 {
+	fee := func(size uint64) uint64 {
+		words := (size + 31) / 32
+		return words*vars.MemoryGas + words*words/vars.QuadCoeffDiv
+	}
+	if size <= current {
+		return 0
+	}
+	return fee(size) - fee(current)
+}
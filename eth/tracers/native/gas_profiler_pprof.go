@@ -0,0 +1,201 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package native
+
+import (
+	"bytes"
+	"compress/gzip"
+	"sort"
+	"strings"
+)
+
+// Field numbers of the pprof profile.proto messages.
+const (
+	pprofProfileSampleType  = 1
+	pprofProfileSample      = 2
+	pprofProfileLocation    = 4
+	pprofProfileFunction    = 5
+	pprofProfileStringTable = 6
+
+	pprofValueTypeType = 1
+	pprofValueTypeUnit = 2
+
+	pprofSampleLocationID = 1
+	pprofSampleValue      = 2
+
+	pprofLocationID   = 1
+	pprofLocationLine = 4
+
+	pprofLineFunctionID = 1
+
+	pprofFunctionID   = 1
+	pprofFunctionName = 2
+)
+
+// gasSample is the gas used at a call stack.
+type gasSample struct {
+	stack []string // Function names, outermost first
+	gas   uint64
+}
+
+// gasSamples aggregates the gas used per call stack, to be exported as a pprof
+// profile.
+type gasSamples struct {
+	samples map[string]*gasSample
+}
+
+func newGasSamples() *gasSamples {
+	return &gasSamples{samples: make(map[string]*gasSample)}
+}
+
+// add accounts gas used by the given call frames, and the opcode executed by
+// the innermost one if any.
+func (s *gasSamples) add(frames []string, op string, gas uint64) {
+	stack := frames
+	if op != "" {
+		stack = append(append([]string{}, frames...), op)
+	}
+	key := strings.Join(stack, ";")
+	sample, ok := s.samples[key]
+	if !ok {
+		sample = &gasSample{stack: stack}
+		s.samples[key] = sample
+	}
+	sample.gas += gas
+}
+
+// encode returns the gzipped pprof profile of the samples, where every
+// distinct name of the call stacks is a function.
+func (s *gasSamples) encode() ([]byte, error) {
+	var (
+		strs      = []string{""} // The first string must be empty
+		strIndex  = map[string]uint64{"": 0}
+		functions = make(map[string]uint64)
+		profile   pprofBuffer
+	)
+	str := func(s string) uint64 {
+		if index, ok := strIndex[s]; ok {
+			return index
+		}
+		strIndex[s] = uint64(len(strs))
+		strs = append(strs, s)
+		return strIndex[s]
+	}
+	keys := make([]string, 0, len(s.samples))
+	for key := range s.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var valueType pprofBuffer
+	valueType.uint64(pprofValueTypeType, str("gas"))
+	valueType.uint64(pprofValueTypeUnit, str("gas"))
+	profile.message(pprofProfileSampleType, &valueType)
+
+	var names []string
+	for _, key := range keys {
+		sample := s.samples[key]
+
+		// Locations are listed from the innermost one
+		ids := make([]uint64, len(sample.stack))
+		for i, name := range sample.stack {
+			id, ok := functions[name]
+			if !ok {
+				id = uint64(len(functions) + 1)
+				functions[name] = id
+				names = append(names, name)
+			}
+			ids[len(ids)-1-i] = id
+		}
+		var msg pprofBuffer
+		msg.packed(pprofSampleLocationID, ids)
+		msg.packed(pprofSampleValue, []uint64{sample.gas})
+		profile.message(pprofProfileSample, &msg)
+	}
+	// Every function has a single location of the same id
+	for i, name := range names {
+		id := uint64(i + 1)
+
+		var line, location, function pprofBuffer
+		line.uint64(pprofLineFunctionID, id)
+		location.uint64(pprofLocationID, id)
+		location.message(pprofLocationLine, &line)
+		profile.message(pprofProfileLocation, &location)
+
+		function.uint64(pprofFunctionID, id)
+		function.uint64(pprofFunctionName, str(name))
+		profile.message(pprofProfileFunction, &function)
+	}
+	for _, s := range strs {
+		profile.string(pprofProfileStringTable, s)
+	}
+	var out bytes.Buffer
+	zw := gzip.NewWriter(&out)
+	if _, err := zw.Write(profile.data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// pprofBuffer is a minimal protocol buffers encoder for the pprof messages.
+type pprofBuffer struct {
+	data []byte
+}
+
+func (b *pprofBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+// uint64 encodes a varint field, omitting zero values.
+func (b *pprofBuffer) uint64(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.varint(uint64(field) << 3)
+	b.varint(x)
+}
+
+// bytes encodes a length delimited field.
+func (b *pprofBuffer) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+func (b *pprofBuffer) string(field int, s string) {
+	b.bytes(field, []byte(s))
+}
+
+func (b *pprofBuffer) message(field int, msg *pprofBuffer) {
+	b.bytes(field, msg.data)
+}
+
+// packed encodes a packed repeated varint field.
+func (b *pprofBuffer) packed(field int, xs []uint64) {
+	var packed pprofBuffer
+	for _, x := range xs {
+		packed.varint(x)
+	}
+	b.bytes(field, packed.data)
+}
@@ -101,6 +101,16 @@ func (t *muxTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
 	}
 }
 
+// CapturePreEVM passes the EVM to the tracers which capture the state before
+// the transaction executes, such as the stateDiffTracer.
+func (t *muxTracer) CapturePreEVM(env *vm.EVM) {
+	for _, t := range t.tracers {
+		if capturer, ok := t.(vm.EVMLogger_StateCapturer); ok {
+			capturer.CapturePreEVM(env)
+		}
+	}
+}
+
 func (t *muxTracer) CaptureTxStart(gasLimit uint64) {
 	for _, t := range t.tracers {
 		t.CaptureTxStart(gasLimit)
@@ -0,0 +1,302 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package native
+
+import (
+	"encoding/json"
+	"math/big"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+)
+
+func init() {
+	tracers.DefaultDirectory.Register("tokenTransferTracer", newTokenTransferTracer, false)
+}
+
+// Kinds of transfers reported by the tokenTransferTracer.
+const (
+	TransferNative  = "native"
+	TransferERC20   = "erc20"
+	TransferERC721  = "erc721"
+	TransferERC1155 = "erc1155"
+)
+
+var (
+	// Transfer(address indexed from, address indexed to, uint256 value) of
+	// ERC-20, or Transfer(address indexed from, address indexed to, uint256
+	// indexed tokenId) of ERC-721.
+	transferEventTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+	// TransferSingle(address indexed operator, address indexed from, address
+	// indexed to, uint256 id, uint256 value) of ERC-1155.
+	transferSingleEventTopic = crypto.Keccak256Hash([]byte("TransferSingle(address,address,address,uint256,uint256)"))
+
+	// TransferBatch(address indexed operator, address indexed from, address
+	// indexed to, uint256[] ids, uint256[] values) of ERC-1155.
+	transferBatchEventTopic = crypto.Keccak256Hash([]byte("TransferBatch(address,address,address,uint256[],uint256[])"))
+)
+
+// TokenTransfer is a single transfer of value, either native or of a token,
+// made by a successful call frame.
+type TokenTransfer struct {
+	Type                string          `json:"type"`
+	From                common.Address  `json:"from"`
+	To                  common.Address  `json:"to"`
+	Token               *common.Address `json:"token,omitempty"`   // Token contract, nil for native transfers
+	TokenID             *hexutil.Big    `json:"tokenId,omitempty"` // Transferred token of ERC-721 and ERC-1155
+	Amount              *hexutil.Big    `json:"amount"`
+	CallPath            []int           `json:"callPath"` // Trace address of the call frame making the transfer
+	TransactionHash     *common.Hash    `json:"transactionHash,omitempty"`
+	TransactionPosition *uint64         `json:"transactionPosition,omitempty"`
+}
+
+// tokenTransferFrame holds the transfers of a call frame and its successful
+// children, which are dropped if the frame fails.
+type tokenTransferFrame struct {
+	path      []int
+	children  int
+	transfers []*TokenTransfer
+}
+
+type tokenTransferTracer struct {
+	ctx       *tracers.Context
+	callstack []*tokenTransferFrame
+	transfers []*TokenTransfer
+	interrupt uint32 // Atomic flag to signal execution interruption
+	reason    error  // Textual reason for the interruption
+}
+
+// newTokenTransferTracer returns a native go tracer which reports the native
+// value transfers and the ERC-20, ERC-721 and ERC-1155 token transfers of a tx
+// in one list, attributed to the call frames making them.
+func newTokenTransferTracer(ctx *tracers.Context, _ json.RawMessage) (tracers.Tracer, error) {
+	return &tokenTransferTracer{ctx: ctx, transfers: []*TokenTransfer{}}, nil
+}
+
+func (t *tokenTransferTracer) CaptureTxStart(gasLimit uint64) {}
+
+func (t *tokenTransferTracer) CaptureTxEnd(restGas uint64) {}
+
+// CaptureStart implements the EVMLogger interface to initialize the tracing operation.
+func (t *tokenTransferTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.callstack = []*tokenTransferFrame{{path: []int{}}}
+	t.addNative(from, to, value)
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *tokenTransferTracer) CaptureEnd(output []byte, gasUsed uint64, err error) {
+	if err == nil && len(t.callstack) > 0 {
+		t.transfers = append(t.transfers, t.callstack[0].transfers...)
+	}
+	t.callstack = nil
+}
+
+// CaptureState implements the EVMLogger interface to trace a single step of VM execution.
+func (t *tokenTransferTracer) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	if err != nil || op < vm.LOG3 || op > vm.LOG4 {
+		return
+	}
+	if atomic.LoadUint32(&t.interrupt) > 0 {
+		return
+	}
+	var (
+		stack  = scope.Stack.Data()
+		size   = int(op - vm.LOG0)
+		topics = make([]common.Hash, size)
+	)
+	if len(stack) < size+2 {
+		return
+	}
+	for i := 0; i < size; i++ {
+		topics[i] = common.Hash(stack[len(stack)-3-i].Bytes32())
+	}
+	// The memory is not yet expanded to cover the logged data, the
+	// missing part is zero.
+	mStart, mSize := stack[len(stack)-1], stack[len(stack)-2]
+	if !mStart.IsUint64() || !mSize.IsUint64() {
+		return
+	}
+	data := make([]byte, mSize.Uint64())
+	if start := mStart.Uint64(); start < uint64(scope.Memory.Len()) {
+		copy(data, scope.Memory.Data()[start:])
+	}
+	t.addToken(scope.Contract.Address(), topics, data)
+}
+
+// CaptureFault implements the EVMLogger interface to trace an execution fault.
+func (t *tokenTransferTracer) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, _ *vm.ScopeContext, depth int, err error) {
+}
+
+// CaptureEnter is called when EVM enters a new scope (via call, create or selfdestruct).
+func (t *tokenTransferTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	if len(t.callstack) == 0 {
+		return
+	}
+	parent := t.callstack[len(t.callstack)-1]
+	path := append(append([]int{}, parent.path...), parent.children)
+	parent.children++
+
+	t.callstack = append(t.callstack, &tokenTransferFrame{path: path})
+	switch typ {
+	case vm.CALL, vm.CREATE, vm.CREATE2, vm.SELFDESTRUCT:
+		// Delegate calls don't move value, call codes move it to the caller itself
+		t.addNative(from, to, value)
+	}
+}
+
+// CaptureExit is called when EVM exits a scope, even if the scope didn't
+// execute any code.
+func (t *tokenTransferTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	if len(t.callstack) <= 1 {
+		return
+	}
+	frame := t.callstack[len(t.callstack)-1]
+	t.callstack = t.callstack[:len(t.callstack)-1]
+
+	// The transfers of failed frames are reverted
+	if err == nil {
+		parent := t.callstack[len(t.callstack)-1]
+		parent.transfers = append(parent.transfers, frame.transfers...)
+	}
+}
+
+// GetResult returns the transfers made by the tx.
+func (t *tokenTransferTracer) GetResult() (json.RawMessage, error) {
+	res, err := json.Marshal(t.transfers)
+	if err != nil {
+		return nil, err
+	}
+	return res, t.reason
+}
+
+// Stop terminates execution of the tracer at the first opportune moment.
+func (t *tokenTransferTracer) Stop(err error) {
+	t.reason = err
+	atomic.StoreUint32(&t.interrupt, 1)
+}
+
+// add records a transfer made by the innermost call frame.
+func (t *tokenTransferTracer) add(transfer *TokenTransfer) {
+	frame := t.callstack[len(t.callstack)-1]
+	transfer.CallPath = frame.path
+	if t.ctx != nil && t.ctx.TxHash != (common.Hash{}) {
+		position := uint64(t.ctx.TxIndex)
+		transfer.TransactionHash, transfer.TransactionPosition = &t.ctx.TxHash, &position
+	}
+	frame.transfers = append(frame.transfers, transfer)
+}
+
+// addNative records a transfer of native value, if any.
+func (t *tokenTransferTracer) addNative(from, to common.Address, value *big.Int) {
+	if value == nil || value.Sign() == 0 {
+		return
+	}
+	t.add(&TokenTransfer{
+		Type:   TransferNative,
+		From:   from,
+		To:     to,
+		Amount: (*hexutil.Big)(new(big.Int).Set(value)),
+	})
+}
+
+// addToken records the token transfers emitted by the given log, if any.
+func (t *tokenTransferTracer) addToken(token common.Address, topics []common.Hash, data []byte) {
+	word := func(i int) *big.Int {
+		if len(data) < 32*(i+1) {
+			return nil
+		}
+		return new(big.Int).SetBytes(data[32*i : 32*(i+1)])
+	}
+	address := func(topic common.Hash) common.Address {
+		return common.BytesToAddress(topic[12:])
+	}
+	switch {
+	case topics[0] == transferEventTopic && len(topics) == 3:
+		if amount := word(0); amount != nil {
+			t.add(&TokenTransfer{
+				Type:   TransferERC20,
+				From:   address(topics[1]),
+				To:     address(topics[2]),
+				Token:  &token,
+				Amount: (*hexutil.Big)(amount),
+			})
+		}
+	case topics[0] == transferEventTopic && len(topics) == 4:
+		t.add(&TokenTransfer{
+			Type:    TransferERC721,
+			From:    address(topics[1]),
+			To:      address(topics[2]),
+			Token:   &token,
+			TokenID: (*hexutil.Big)(topics[3].Big()),
+			Amount:  (*hexutil.Big)(big.NewInt(1)),
+		})
+	case topics[0] == transferSingleEventTopic && len(topics) == 4:
+		if id, amount := word(0), word(1); id != nil && amount != nil {
+			t.add(&TokenTransfer{
+				Type:    TransferERC1155,
+				From:    address(topics[2]),
+				To:      address(topics[3]),
+				Token:   &token,
+				TokenID: (*hexutil.Big)(id),
+				Amount:  (*hexutil.Big)(amount),
+			})
+		}
+	case topics[0] == transferBatchEventTopic && len(topics) == 4:
+		ids, amounts := abiUintArray(data, 0), abiUintArray(data, 1)
+		if ids == nil || len(ids) != len(amounts) {
+			return
+		}
+		for i := range ids {
+			t.add(&TokenTransfer{
+				Type:    TransferERC1155,
+				From:    address(topics[2]),
+				To:      address(topics[3]),
+				Token:   &token,
+				TokenID: (*hexutil.Big)(ids[i]),
+				Amount:  (*hexutil.Big)(amounts[i]),
+			})
+		}
+	}
+}
+
+// abiUintArray decodes the ABI encoded uint256[] argument at the given position
+// of the data, or returns nil if the encoding is invalid.
+func abiUintArray(data []byte, arg int) []*big.Int {
+	if len(data) < 32*(arg+1) {
+		return nil
+	}
+	offset := new(big.Int).SetBytes(data[32*arg : 32*(arg+1)])
+	if !offset.IsUint64() || offset.Uint64()+32 > uint64(len(data)) {
+		return nil
+	}
+	start := offset.Uint64()
+	length := new(big.Int).SetBytes(data[start : start+32])
+	if !length.IsUint64() || length.Uint64() > (uint64(len(data))-start-32)/32 {
+		return nil
+	}
+	items := make([]*big.Int, length.Uint64())
+	for i := range items {
+		pos := start + 32 + uint64(i)*32
+		items[i] = new(big.Int).SetBytes(data[pos : pos+32])
+	}
+	return items
+}
@@ -0,0 +1,311 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package native
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/holiman/uint256"
+)
+
+func init() {
+	tracers.DefaultDirectory.Register("vmTraceParity", newVmTraceParityTracer, false)
+}
+
+// VmTrace is the vmTrace of a single call frame in the format of the Parity
+// (OpenEthereum) trace module.
+type VmTrace struct {
+	Code hexutil.Bytes `json:"code"`
+	Ops  []*VmTraceOp  `json:"ops"`
+}
+
+// VmTraceOp is a single executed instruction of a vmTrace.
+type VmTraceOp struct {
+	Cost uint64     `json:"cost"`
+	Ex   *VmTraceEx `json:"ex"` // Nil if the instruction failed
+	Pc   uint64     `json:"pc"`
+	Sub  *VmTrace   `json:"sub"` // Trace of the call frame entered by the instruction
+}
+
+// VmTraceEx holds the effects of an executed instruction.
+type VmTraceEx struct {
+	Mem   *VmTraceMem   `json:"mem"`
+	Push  []hexutil.Big `json:"push"`
+	Store *VmTraceStore `json:"store"`
+	Used  uint64        `json:"used"` // Gas remaining after the instruction
+}
+
+// VmTraceMem is the memory region written by an instruction.
+type VmTraceMem struct {
+	Data hexutil.Bytes `json:"data"`
+	Off  uint64        `json:"off"`
+}
+
+// VmTraceStore is the storage slot written by an instruction.
+type VmTraceStore struct {
+	Key hexutil.Big `json:"key"`
+	Val hexutil.Big `json:"val"`
+}
+
+// vmTraceFrame tracks the instruction of a call frame awaiting its effects,
+// which are only known once the next instruction of the same frame starts.
+type vmTraceFrame struct {
+	trace *VmTrace
+
+	pending *VmTraceOp
+	gasLeft uint64        // Gas left after charging the pending instruction
+	pushes  int           // Number of stack items pushed by the pending instruction
+	memOff  uint64        // Offset of the memory written by the pending instruction
+	memSize uint64        // Size of the memory written by the pending instruction
+	store   *VmTraceStore // Storage written by the pending instruction
+}
+
+type vmTraceParityTracer struct {
+	env               *vm.EVM
+	root              *VmTrace
+	callstack         []*vmTraceFrame  // Nil entries for frames not traced, such as precompiles
+	activePrecompiles []common.Address // Updated on CaptureStart based on given rules
+	interrupt         uint32           // Atomic flag to signal execution interruption
+	reason            error            // Textual reason for the interruption
+}
+
+// newVmTraceParityTracer returns a native go tracer which records the executed
+// instructions of a tx in Parity's vmTrace format, and implements vm.EVMLogger.
+func newVmTraceParityTracer(ctx *tracers.Context, _ json.RawMessage) (tracers.Tracer, error) {
+	return &vmTraceParityTracer{}, nil
+}
+
+func (t *vmTraceParityTracer) CaptureTxStart(gasLimit uint64) {}
+
+func (t *vmTraceParityTracer) CaptureTxEnd(restGas uint64) {}
+
+// CaptureStart implements the EVMLogger interface to initialize the tracing operation.
+func (t *vmTraceParityTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.env = env
+	t.activePrecompiles = env.ActivePrecompiles()
+
+	t.root = t.newTrace(to, create, input)
+	t.callstack = []*vmTraceFrame{{trace: t.root}}
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *vmTraceParityTracer) CaptureEnd(output []byte, gasUsed uint64, err error) {
+	t.finalize(nil, nil)
+	t.callstack = nil
+}
+
+// CaptureState implements the EVMLogger interface to trace a single step of VM execution.
+func (t *vmTraceParityTracer) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	if atomic.LoadUint32(&t.interrupt) > 0 {
+		t.env.Cancel()
+		return
+	}
+	frame := t.frame()
+	if frame == nil {
+		return
+	}
+	t.finalize(scope, &gas)
+
+	traceOp := &VmTraceOp{Cost: cost, Pc: pc}
+	frame.trace.Ops = append(frame.trace.Ops, traceOp)
+	if err != nil {
+		// The instruction failed before execution, no effects to track
+		return
+	}
+	frame.pending, frame.pushes = traceOp, stackPushes(op)
+	if gas > cost {
+		frame.gasLeft = gas - cost
+	} else {
+		frame.gasLeft = 0
+	}
+	frame.memOff, frame.memSize, frame.store = 0, 0, nil
+
+	var (
+		stack = scope.Stack.Data()
+		size  = len(stack)
+	)
+	peek := func(n int) *uint256.Int {
+		if n < size {
+			return &stack[size-1-n]
+		}
+		return new(uint256.Int)
+	}
+	switch op {
+	case vm.MSTORE:
+		frame.memOff, frame.memSize = peek(0).Uint64(), 32
+	case vm.MSTORE8:
+		frame.memOff, frame.memSize = peek(0).Uint64(), 1
+	case vm.CALLDATACOPY, vm.CODECOPY, vm.RETURNDATACOPY:
+		frame.memOff, frame.memSize = peek(0).Uint64(), peek(2).Uint64()
+	case vm.EXTCODECOPY:
+		frame.memOff, frame.memSize = peek(1).Uint64(), peek(3).Uint64()
+	case vm.CALL, vm.CALLCODE:
+		frame.memOff, frame.memSize = peek(5).Uint64(), peek(6).Uint64()
+	case vm.DELEGATECALL, vm.STATICCALL:
+		frame.memOff, frame.memSize = peek(4).Uint64(), peek(5).Uint64()
+	case vm.SSTORE:
+		frame.store = &VmTraceStore{
+			Key: hexutil.Big(*peek(0).ToBig()),
+			Val: hexutil.Big(*peek(1).ToBig()),
+		}
+	}
+}
+
+// CaptureFault implements the EVMLogger interface to trace an execution fault.
+func (t *vmTraceParityTracer) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, _ *vm.ScopeContext, depth int, err error) {
+	// The pending instruction failed during execution, drop its effects
+	if frame := t.frame(); frame != nil && frame.pending != nil {
+		frame.pending.Ex, frame.pending = nil, nil
+	}
+}
+
+// CaptureEnter is called when EVM enters a new scope (via call, create or selfdestruct).
+func (t *vmTraceParityTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	parent := t.frame()
+	if parent == nil || parent.pending == nil || typ == vm.SELFDESTRUCT || t.isPrecompiled(to) {
+		t.callstack = append(t.callstack, nil)
+		return
+	}
+	trace := t.newTrace(to, typ == vm.CREATE || typ == vm.CREATE2, input)
+	parent.pending.Sub = trace
+	t.callstack = append(t.callstack, &vmTraceFrame{trace: trace})
+}
+
+// CaptureExit is called when EVM exits a scope, even if the scope didn't
+// execute any code.
+func (t *vmTraceParityTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	if len(t.callstack) <= 1 {
+		return
+	}
+	t.finalize(nil, nil)
+	t.callstack = t.callstack[:len(t.callstack)-1]
+}
+
+// GetResult returns the vmTrace of the top level call frame.
+func (t *vmTraceParityTracer) GetResult() (json.RawMessage, error) {
+	if t.root == nil {
+		return nil, errors.New("no call frame traced")
+	}
+	res, err := json.Marshal(t.root)
+	if err != nil {
+		return nil, err
+	}
+	return res, t.reason
+}
+
+// Stop terminates execution of the tracer at the first opportune moment.
+func (t *vmTraceParityTracer) Stop(err error) {
+	t.reason = err
+	atomic.StoreUint32(&t.interrupt, 1)
+}
+
+// frame returns the innermost call frame, nil if it isn't traced.
+func (t *vmTraceParityTracer) frame() *vmTraceFrame {
+	if len(t.callstack) == 0 {
+		return nil
+	}
+	return t.callstack[len(t.callstack)-1]
+}
+
+// newTrace creates the vmTrace of a call frame executing the given address'
+// code, or the given init code for contract creations.
+func (t *vmTraceParityTracer) newTrace(to common.Address, create bool, input []byte) *VmTrace {
+	trace := &VmTrace{Ops: []*VmTraceOp{}}
+	if create {
+		trace.Code = common.CopyBytes(input)
+	} else {
+		trace.Code = common.CopyBytes(t.env.StateDB.GetCode(to))
+	}
+	if trace.Code == nil {
+		trace.Code = []byte{}
+	}
+	return trace
+}
+
+// finalize fills in the effects of the innermost frame's pending instruction,
+// reading them from the frame's scope after the instruction executed. Without a
+// scope the frame is exiting and only the remaining gas is known.
+func (t *vmTraceParityTracer) finalize(scope *vm.ScopeContext, gas *uint64) {
+	frame := t.frame()
+	if frame == nil || frame.pending == nil {
+		return
+	}
+	op := frame.pending
+	frame.pending = nil
+
+	// Calls refund the unused gas of the entered frame, so prefer the gas reported
+	// by the next instruction over the one left after charging the instruction.
+	ex := &VmTraceEx{Push: []hexutil.Big{}, Store: frame.store, Used: frame.gasLeft}
+	if gas != nil {
+		ex.Used = *gas
+	}
+	if scope != nil {
+		stack := scope.Stack.Data()
+		for i := frame.pushes; i > 0; i-- {
+			if i <= len(stack) {
+				ex.Push = append(ex.Push, hexutil.Big(*stack[len(stack)-i].ToBig()))
+			}
+		}
+		if frame.memSize > 0 && frame.memOff+frame.memSize <= uint64(scope.Memory.Len()) {
+			ex.Mem = &VmTraceMem{
+				Data: scope.Memory.GetCopy(int64(frame.memOff), int64(frame.memSize)),
+				Off:  frame.memOff,
+			}
+		}
+	}
+	op.Ex = ex
+}
+
+// isPrecompiled returns whether the addr is a precompile.
+func (t *vmTraceParityTracer) isPrecompiled(addr common.Address) bool {
+	for _, p := range t.activePrecompiles {
+		if p == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// stackPushes returns the number of stack items reported as pushed by the given
+// instruction. As in Parity, duplications and swaps report all the items they
+// touched.
+func stackPushes(op vm.OpCode) int {
+	switch {
+	case op >= vm.PUSH0 && op <= vm.PUSH32:
+		return 1
+	case op >= vm.DUP1 && op <= vm.DUP16:
+		return int(op-vm.DUP1) + 2
+	case op >= vm.SWAP1 && op <= vm.SWAP16:
+		return int(op-vm.SWAP1) + 2
+	case op >= vm.LOG0 && op <= vm.LOG4:
+		return 0
+	}
+	switch op {
+	case vm.STOP, vm.POP, vm.MSTORE, vm.MSTORE8, vm.SSTORE, vm.TSTORE, vm.JUMP, vm.JUMPI, vm.JUMPDEST,
+		vm.CALLDATACOPY, vm.CODECOPY, vm.EXTCODECOPY, vm.RETURNDATACOPY,
+		vm.RETURN, vm.REVERT, vm.SELFDESTRUCT, vm.INVALID:
+		return 0
+	}
+	return 1
+}
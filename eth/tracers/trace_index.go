@@ -0,0 +1,302 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	// traceIndexSection is the number of blocks indexed together. Sections are
+	// kept small, so that a non-archive node still has the state needed to trace
+	// them once they are confirmed.
+	traceIndexSection = 32
+
+	// traceIndexConfirms is the number of confirmations a section needs before
+	// being indexed.
+	traceIndexConfirms = 16
+
+	// traceIndexThrottling is the time to wait between indexing two consecutive
+	// sections, preventing the catch up of an archive node from hogging resources.
+	traceIndexThrottling = 100 * time.Millisecond
+
+	// traceIndexTracer is the tracer whose results are indexed.
+	traceIndexTracer = "callTracerParity"
+)
+
+// indexedTrace is the subset of a callTracerParity trace needed to index and
+// filter it by the involved addresses.
+type indexedTrace struct {
+	Action struct {
+		From          *common.Address `json:"from"`
+		To            *common.Address `json:"to"`
+		Address       *common.Address `json:"address"`       // Self destructed contract
+		RefundAddress *common.Address `json:"refundAddress"` // Self destruct beneficiary
+	} `json:"action"`
+	Result *struct {
+		Address *common.Address `json:"address"` // Created contract
+	} `json:"result"`
+}
+
+// sender returns the address the trace originates from.
+func (t *indexedTrace) sender() *common.Address {
+	if t.Action.From != nil {
+		return t.Action.From
+	}
+	return t.Action.Address
+}
+
+// recipient returns the address the trace is directed to.
+func (t *indexedTrace) recipient() *common.Address {
+	switch {
+	case t.Action.RefundAddress != nil:
+		return t.Action.RefundAddress
+	case t.Result != nil && t.Result.Address != nil:
+		return t.Result.Address
+	}
+	return t.Action.To
+}
+
+// matches returns whether the trace was sent from and to the given addresses,
+// nil addresses matching any.
+func (t *indexedTrace) matches(from, to *common.Address) bool {
+	if from != nil && (t.sender() == nil || *t.sender() != *from) {
+		return false
+	}
+	if to != nil && (t.recipient() == nil || *t.recipient() != *to) {
+		return false
+	}
+	return true
+}
+
+// traceAddresses returns the addresses involved in the given transaction traces.
+func traceAddresses(traces [][]byte) (map[common.Address]struct{}, error) {
+	addresses := make(map[common.Address]struct{})
+	for _, blob := range traces {
+		var txTraces []*indexedTrace
+		if err := json.Unmarshal(blob, &txTraces); err != nil {
+			return nil, err
+		}
+		for _, trace := range txTraces {
+			for _, addr := range []*common.Address{trace.sender(), trace.recipient()} {
+				if addr != nil {
+					addresses[*addr] = struct{}{}
+				}
+			}
+		}
+	}
+	return addresses, nil
+}
+
+// deleteBlockTraces removes the indexed call traces of the given block together
+// with the address index entries pointing to them.
+func deleteBlockTraces(db ethdb.Database, batch ethdb.KeyValueWriter, number uint64, hash common.Hash) {
+	addresses, err := traceAddresses(rawdb.ReadBlockTraces(db, hash, number))
+	if err != nil {
+		log.Error("Failed to decode indexed traces", "number", number, "hash", hash, "err", err)
+	}
+	for addr := range addresses {
+		rawdb.DeleteTraceAddressIndex(batch, addr, number, hash)
+	}
+	rawdb.DeleteBlockTraces(batch, hash, number)
+}
+
+// TraceIndexer implements a core.ChainIndexer, storing the call traces of the
+// canonical chain in the format of the Parity trace module, together with an
+// index of the blocks every address was involved in. The index allows serving
+// trace_block and trace_filter without re-executing the blocks.
+type TraceIndexer struct {
+	api     *API
+	db      ethdb.Database // database instance to write index data and metadata into
+	history uint64         // number of recent blocks to keep indexed, 0 for all
+
+	section uint64      // section number being processed currently
+	batch   ethdb.Batch // pending writes of the section being processed
+}
+
+// NewTraceIndexer returns a chain indexer that stores the call traces of the
+// canonical chain for fast trace filtering. If history is non-zero, only the
+// given number of recent blocks is kept indexed.
+func NewTraceIndexer(backend Backend, db ethdb.Database, history uint64) *core.ChainIndexer {
+	b := &TraceIndexer{
+		api:     &API{backend: backend}, // Bypass the trace cache
+		db:      db,
+		history: history,
+	}
+	table := rawdb.NewTable(db, string(rawdb.TraceIndexPrefix))
+	indexer := core.NewChainIndexer(db, table, b, traceIndexSection, traceIndexConfirms, traceIndexThrottling, "traces")
+	b.skipUnavailable(indexer)
+	return indexer
+}
+
+// skipUnavailable checkpoints the indexer past the sections which are either
+// outside of the retained history, or can't be traced since their state was
+// pruned, e.g. on a non-archive node enabling the index or restarting after
+// being offline for a long time.
+func (b *TraceIndexer) skipUnavailable(indexer *core.ChainIndexer) {
+	head, err := b.api.backend.HeaderByNumber(context.Background(), rpc.LatestBlockNumber)
+	if err != nil || head.Number.Uint64()+1 < traceIndexConfirms+traceIndexSection {
+		return
+	}
+	var (
+		stored, _, _ = indexer.Sections()
+		known        = (head.Number.Uint64() + 1 - traceIndexConfirms) / traceIndexSection
+		first        = stored
+	)
+	if known <= stored {
+		return
+	}
+	if b.history > 0 && head.Number.Uint64()+1 > b.history {
+		if limit := (head.Number.Uint64() + 1 - b.history) / traceIndexSection; limit > first {
+			first = limit
+		}
+	}
+	if first >= known || !b.traceable(first) {
+		first = known - 1
+	}
+	if first == stored {
+		return
+	}
+	number := first * traceIndexSection
+	log.Warn("Skipping untraceable blocks from trace index", "from", stored*traceIndexSection, "to", number-1)
+
+	rawdb.WriteTraceIndexTail(b.db, number)
+	indexer.AddCheckpoint(first-1, rawdb.ReadCanonicalHash(b.db, number-1))
+}
+
+// traceable returns whether the state needed to trace the given section is
+// available without regenerating it from far away.
+func (b *TraceIndexer) traceable(section uint64) bool {
+	ctx := context.Background()
+	block, err := b.api.blockByNumber(ctx, rpc.BlockNumber((section+1)*traceIndexSection-1))
+	if err != nil {
+		return false
+	}
+	_, release, err := b.api.backend.StateAtBlock(ctx, block, 0, nil, true, false)
+	if err != nil {
+		return false
+	}
+	release()
+	return true
+}
+
+// Reset implements core.ChainIndexerBackend, starting a new trace index section
+// and dropping any leftover data of the section from before a reorg.
+func (b *TraceIndexer) Reset(ctx context.Context, section uint64, prevHead common.Hash) error {
+	b.section, b.batch = section, b.db.NewBatch()
+
+	first := section * traceIndexSection
+	for _, nh := range rawdb.ReadAllBlockTracesInRange(b.db, first, first+traceIndexSection-1) {
+		deleteBlockTraces(b.db, b.batch, nh.Number, nh.Hash)
+	}
+	return nil
+}
+
+// Process implements core.ChainIndexerBackend, tracing all the transactions of
+// a block and adding the results into the index.
+func (b *TraceIndexer) Process(ctx context.Context, header *types.Header) error {
+	var (
+		number = header.Number.Uint64()
+		hash   = header.Hash()
+		traces = [][]byte{}
+	)
+	if number > 0 {
+		block, err := b.api.blockByNumberAndHash(ctx, rpc.BlockNumber(number), hash)
+		if err != nil {
+			return err
+		}
+		tracer := traceIndexTracer
+		results, err := b.api.traceBlock(ctx, block, &TraceConfig{Tracer: &tracer})
+		if err != nil {
+			return err
+		}
+		for _, result := range results {
+			if result.Error != "" {
+				return errors.New(result.Error)
+			}
+			traces = append(traces, result.Result.(json.RawMessage))
+		}
+	}
+	addresses, err := traceAddresses(traces)
+	if err != nil {
+		return err
+	}
+	for addr := range addresses {
+		rawdb.WriteTraceAddressIndex(b.batch, addr, number, hash)
+	}
+	rawdb.WriteBlockTraces(b.batch, hash, number, traces)
+	return nil
+}
+
+// Commit implements core.ChainIndexerBackend, writing the section out into the
+// database and pruning the sections falling out of the retained history.
+func (b *TraceIndexer) Commit() error {
+	var (
+		first = b.section * traceIndexSection
+		tail  = rawdb.ReadTraceIndexTail(b.db)
+	)
+	if tail == nil {
+		tail = &first
+	}
+	if b.history > 0 && first+traceIndexSection > b.history {
+		if limit := (first + traceIndexSection - b.history) / traceIndexSection * traceIndexSection; limit > *tail {
+			tail = &limit
+		}
+	}
+	b.prune(b.batch, *tail)
+	rawdb.WriteTraceIndexTail(b.batch, *tail)
+
+	if err := b.batch.Write(); err != nil {
+		return err
+	}
+	b.batch = nil
+	return nil
+}
+
+// Prune implements core.ChainIndexerBackend, deleting the indexed traces of the
+// blocks older than the given threshold.
+func (b *TraceIndexer) Prune(threshold uint64) error {
+	if tail := rawdb.ReadTraceIndexTail(b.db); tail == nil || *tail >= threshold {
+		return nil
+	}
+	batch := b.db.NewBatch()
+	b.prune(batch, threshold)
+	rawdb.WriteTraceIndexTail(batch, threshold)
+	return batch.Write()
+}
+
+// prune adds the deletion of the indexed traces older than the given block to
+// the batch.
+func (b *TraceIndexer) prune(batch ethdb.KeyValueWriter, threshold uint64) {
+	if threshold == 0 {
+		return
+	}
+	for _, nh := range rawdb.ReadAllBlockTracesInRange(b.db, 0, threshold-1) {
+		deleteBlockTraces(b.db, batch, nh.Number, nh.Hash)
+	}
+}
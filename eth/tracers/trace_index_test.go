@@ -0,0 +1,229 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/params/types/genesisT"
+	"github.com/ethereum/go-ethereum/params/vars"
+)
+
+func TestFilterTraces(t *testing.T) {
+	var (
+		a = common.HexToAddress("0xaa")
+		b = common.HexToAddress("0xbb")
+		c = common.HexToAddress("0xcc")
+	)
+	traces := fmt.Sprintf(`[
+		{"action":{"from":"%s","to":"%s","callType":"call"},"type":"call"},
+		{"action":{"from":"%s"},"result":{"address":"%s"},"type":"create"},
+		{"action":{"address":"%s","refundAddress":"%s"},"type":"suicide"}
+	]`, a.Hex(), b.Hex(), b.Hex(), c.Hex(), c.Hex(), a.Hex())
+
+	addresses, err := traceAddresses([][]byte{[]byte(traces)})
+	if err != nil {
+		t.Fatalf("failed to collect addresses: %v", err)
+	}
+	if len(addresses) != 3 {
+		t.Fatalf("addresses mismatch: have %v", addresses)
+	}
+	var tests = []struct {
+		from, to *common.Address
+		want     []string
+	}{
+		{&a, nil, []string{"call"}},
+		{nil, &b, []string{"call"}},
+		{&b, &c, []string{"create"}},
+		{&c, &a, []string{"suicide"}},
+		{&a, &c, nil},
+	}
+	for i, tt := range tests {
+		filter := &traceFilter{from: tt.from, to: tt.to}
+		blob, err := filter.filter([]byte(traces))
+		if err != nil {
+			t.Fatalf("test %d: failed to filter traces: %v", i, err)
+		}
+		if tt.want == nil {
+			if blob != nil {
+				t.Errorf("test %d: unexpected traces: %s", i, blob)
+			}
+			continue
+		}
+		var have []struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(blob, &have); err != nil {
+			t.Fatalf("test %d: failed to decode traces: %v", i, err)
+		}
+		if len(have) != len(tt.want) || have[0].Type != tt.want[0] {
+			t.Errorf("test %d: traces mismatch: have %s, want %v", i, blob, tt.want)
+		}
+	}
+}
+
+func TestTraceFilterPagination(t *testing.T) {
+	var (
+		a = common.HexToAddress("0xaa")
+		b = common.HexToAddress("0xbb")
+	)
+	tx := func(n int) json.RawMessage {
+		var traces []string
+		for i := 0; i < n; i++ {
+			traces = append(traces, fmt.Sprintf(`{"action":{"from":"%s","to":"%s","value":"0x%x"},"type":"call"}`, a.Hex(), b.Hex(), i))
+		}
+		return json.RawMessage("[" + strings.Join(traces, ",") + "]")
+	}
+	blocks := []*blockTraceResult{
+		{Block: 1, Traces: []*txTraceResult{{Result: tx(2)}, {Result: tx(1)}}},
+		{Block: 2, Traces: []*txTraceResult{{Error: "failed"}}},
+		{Block: 3, Traces: []*txTraceResult{{Result: tx(3)}}},
+	}
+	filter := newTraceFilter(TraceFilterArgs{FromAddress: &a, After: 2, Count: 2})
+
+	var kept []uint64
+	for _, block := range blocks {
+		result := filter.apply(block)
+		if result == nil {
+			continue
+		}
+		for _, res := range result.Traces {
+			if res.Error != "" {
+				continue
+			}
+			var traces []json.RawMessage
+			if err := json.Unmarshal(res.Result.(json.RawMessage), &traces); err != nil {
+				t.Fatal(err)
+			}
+			for range traces {
+				kept = append(kept, uint64(block.Block))
+			}
+		}
+	}
+	// The first two traces of block 1 are skipped, the remaining one and the
+	// first one of block 3 are kept.
+	if want := []uint64{1, 3}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept traces mismatch: have %v, want %v", kept, want)
+	}
+	if !filter.done() {
+		t.Error("filter not done after count traces")
+	}
+	if newTraceFilter(TraceFilterArgs{FromBlock: 1, ToBlock: 2}) != nil {
+		t.Error("filter created without filtering arguments")
+	}
+}
+
+func TestTraceIndexerCleanup(t *testing.T) {
+	var (
+		db      = rawdb.NewMemoryDatabase()
+		indexer = &TraceIndexer{db: db}
+		addr    = common.HexToAddress("0xaa")
+		traces  = [][]byte{[]byte(fmt.Sprintf(`[{"action":{"from":"%s"},"type":"call"}]`, addr.Hex()))}
+	)
+	// Index a few blocks in the first two sections, with a reorged one
+	for _, number := range []uint64{1, 2, traceIndexSection + 1} {
+		for _, hash := range []common.Hash{{byte(number)}, {byte(number), 0x01}} {
+			rawdb.WriteBlockTraces(db, hash, number, traces)
+			rawdb.WriteTraceAddressIndex(db, addr, number, hash)
+		}
+	}
+	rawdb.WriteTraceIndexTail(db, 0)
+
+	// Reprocessing the second section drops its leftovers
+	if err := indexer.Reset(context.Background(), 1, common.Hash{}); err != nil {
+		t.Fatalf("failed to reset section: %v", err)
+	}
+	if err := indexer.Commit(); err != nil {
+		t.Fatalf("failed to commit section: %v", err)
+	}
+	if blocks := rawdb.ReadTraceAddressBlocks(db, addr, 0, 2*traceIndexSection); len(blocks) != 4 {
+		t.Fatalf("address index mismatch after reset: have %d blocks", len(blocks))
+	}
+	// Pruning drops the traces and the address index of old blocks
+	if err := indexer.Prune(2); err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+	if blocks := rawdb.ReadAllBlockTracesInRange(db, 0, 2*traceIndexSection); len(blocks) != 2 || blocks[0].Number != 2 {
+		t.Fatalf("block traces mismatch after prune: have %d blocks", len(blocks))
+	}
+	if blocks := rawdb.ReadTraceAddressBlocks(db, addr, 0, 2*traceIndexSection); len(blocks) != 2 {
+		t.Fatalf("address index mismatch after prune: have %d blocks", len(blocks))
+	}
+	if tail := rawdb.ReadTraceIndexTail(db); tail == nil || *tail != 2 {
+		t.Fatalf("tail mismatch: have %v, want 2", tail)
+	}
+}
+
+func TestTraceIndexedFallback(t *testing.T) {
+	accounts := newAccounts(2)
+	genesis := &genesisT.Genesis{
+		Config: params.TestChainConfig,
+		Alloc: genesisT.GenesisAlloc{
+			accounts[0].addr: {Balance: big.NewInt(vars.Ether)},
+		},
+	}
+	signer := types.HomesteadSigner{}
+	backend := newTestBackend(t, 3, genesis, func(i int, b *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(uint64(i), accounts[1].addr, big.NewInt(1000), vars.TxGas, b.BaseFee(), nil), signer, accounts[0].key)
+		b.AddTx(tx)
+	})
+	api := NewTraceAPI(NewAPI(backend))
+
+	// The native tracers can't be imported here, stand in for the indexed one
+	if !DefaultDirectory.Has(traceIndexTracer) {
+		DefaultDirectory.Register(traceIndexTracer, func(*Context, json.RawMessage) (Tracer, error) {
+			return logger.NewStructLogger(nil), nil
+		}, false)
+	}
+	// Index the traces of all blocks but the middle one
+	db := backend.ChainDb()
+	for _, number := range []uint64{1, 3} {
+		rawdb.WriteBlockTraces(db, rawdb.ReadCanonicalHash(db, number), number, [][]byte{[]byte(`["indexed"]`)})
+	}
+	config := setTraceConfigDefaultTracer(nil)
+	resCh := api.traceIndexed(1, 3, nil, config, nil)
+
+	var next uint64 = 1
+	for result := range resCh {
+		if uint64(result.Block) != next {
+			t.Fatalf("block mismatch: have %d, want %d", result.Block, next)
+		}
+		if len(result.Traces) != 1 || result.Traces[0].Error != "" {
+			t.Fatalf("block %d: unexpected traces %+v", next, result.Traces[0])
+		}
+		blob, _ := json.Marshal(result.Traces[0].Result)
+		if indexed := string(blob) == `["indexed"]`; indexed != (next != 2) {
+			t.Errorf("block %d: unexpected trace source: %s", next, blob)
+		}
+		next++
+	}
+	if next != 4 {
+		t.Errorf("missing blocks: streamed up to %d", next-1)
+	}
+}
@@ -19,6 +19,7 @@ package tracers
 
 import (
 	"encoding/json"
+	"errors"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -74,6 +75,12 @@ func (d *directory) RegisterJSEval(f jsCtorFn) {
 	d.jsEval = f
 }
 
+// DisableJSEval stops the directory from evaluating user-provided JS code,
+// so that only the registered tracers can be invoked.
+func (d *directory) DisableJSEval() {
+	d.jsEval = nil
+}
+
 // New returns a new instance of a tracer, by iterating through the
 // registered lookups. Name is either name of an existing tracer
 // or an arbitrary JS code.
@@ -82,9 +89,18 @@ func (d *directory) New(name string, ctx *Context, cfg json.RawMessage) (Tracer,
 		return elem.ctor(ctx, cfg)
 	}
 	// Assume JS code
+	if d.jsEval == nil {
+		return nil, errors.New("unknown tracer, evaluation of JS tracer code is disabled")
+	}
 	return d.jsEval(name, ctx, cfg)
 }
 
+// Has returns whether a tracer of the given name is registered.
+func (d *directory) Has(name string) bool {
+	_, ok := d.elems[name]
+	return ok
+}
+
 // IsJS will return true if the given tracer will evaluate
 // JS code. Because code evaluation has high overhead, this
 // info will be used in determining fast and slow code paths.
@@ -93,5 +109,5 @@ func (d *directory) IsJS(name string) bool {
 		return elem.isJS
 	}
 	// JS eval will execute JS code
-	return true
+	return d.jsEval != nil
 }
@@ -17,6 +17,7 @@
 package tracers
 
 import (
+	"encoding/json"
 	"math/big"
 	"testing"
 
@@ -110,3 +111,22 @@ func BenchmarkTransactionTrace(b *testing.B) {
 		tracer.Reset()
 	}
 }
+
+func TestDirectoryDisableJSEval(t *testing.T) {
+	d := directory{elems: make(map[string]elem)}
+	d.Register("named", func(*Context, json.RawMessage) (Tracer, error) { return nil, nil }, true)
+	d.RegisterJSEval(func(string, *Context, json.RawMessage) (Tracer, error) { return nil, nil })
+	if !d.IsJS("{}") {
+		t.Fatal("JS code not evaluated")
+	}
+	d.DisableJSEval()
+	if d.IsJS("{}") {
+		t.Error("JS code evaluated after disabling")
+	}
+	if _, err := d.New("{}", nil, nil); err == nil {
+		t.Error("expected error for JS code")
+	}
+	if _, err := d.New("named", nil, nil); err != nil {
+		t.Errorf("named tracer rejected: %v", err)
+	}
+}
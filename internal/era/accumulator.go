@@ -0,0 +1,109 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package era
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// accumulatorDepth is the depth of the merkle tree of the header records,
+// holding MaxEra1Size leaves.
+const accumulatorDepth = 13
+
+// zeroHashes are the roots of the empty subtrees of every height.
+var zeroHashes = func() [accumulatorDepth + 1]common.Hash {
+	var hashes [accumulatorDepth + 1]common.Hash
+	for i := 1; i <= accumulatorDepth; i++ {
+		hashes[i] = hashPair(hashes[i-1], hashes[i-1])
+	}
+	return hashes
+}()
+
+// ComputeAccumulator calculates the accumulator root of an epoch, the SSZ hash
+// tree root of the List[HeaderRecord, 8192] of the block hashes along with
+// their total difficulties.
+func ComputeAccumulator(hashes []common.Hash, tds []*big.Int) (common.Hash, error) {
+	if len(hashes) != len(tds) {
+		return common.Hash{}, fmt.Errorf("hash and total difficulty count mismatch: %d != %d", len(hashes), len(tds))
+	}
+	if len(hashes) > MaxEra1Size {
+		return common.Hash{}, fmt.Errorf("too many header records: %d > %d", len(hashes), MaxEra1Size)
+	}
+	level := make([]common.Hash, len(hashes))
+	for i, hash := range hashes {
+		td, err := uint256LE(tds[i])
+		if err != nil {
+			return common.Hash{}, err
+		}
+		level[i] = hashPair(hash, td)
+	}
+	// Merkleize the records, padding every level with the empty subtrees
+	for depth := 0; depth < accumulatorDepth; depth++ {
+		next := make([]common.Hash, (len(level)+1)/2)
+		for i := range next {
+			left, right := level[2*i], zeroHashes[depth]
+			if 2*i+1 < len(level) {
+				right = level[2*i+1]
+			}
+			next[i] = hashPair(left, right)
+		}
+		level = next
+	}
+	root := zeroHashes[accumulatorDepth]
+	if len(level) > 0 {
+		root = level[0]
+	}
+	// Mix in the length of the list
+	var length common.Hash
+	binary.LittleEndian.PutUint64(length[:], uint64(len(hashes)))
+	return hashPair(root, length), nil
+}
+
+// hashPair returns the sha256 hash of the concatenation of two nodes.
+func hashPair(a, b common.Hash) common.Hash {
+	h := sha256.New()
+	h.Write(a[:])
+	h.Write(b[:])
+	return common.BytesToHash(h.Sum(nil))
+}
+
+// uint256LE encodes a non-negative integer as 32 little endian bytes.
+func uint256LE(n *big.Int) (common.Hash, error) {
+	if n.Sign() < 0 || n.BitLen() > 256 {
+		return common.Hash{}, fmt.Errorf("invalid uint256: %v", n)
+	}
+	var out common.Hash
+	n.FillBytes(out[:])
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out, nil
+}
+
+// fromUint256LE decodes 32 little endian bytes into an integer.
+func fromUint256LE(b []byte) *big.Int {
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	return new(big.Int).SetBytes(be)
+}
@@ -0,0 +1,152 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package era
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/golang/snappy"
+)
+
+// Builder writes the blocks of an epoch into an Era1 file:
+//
+//	era1       := Version | block-tuple* | Accumulator | BlockIndex
+//	block-tuple := CompressedHeader | CompressedBody | CompressedReceipts | TotalDifficulty
+//
+// The block index holds the offsets of the block tuples relative to the start
+// of the index record, allowing any block to be looked up in constant time.
+type Builder struct {
+	w       *e2Writer
+	written uint64
+
+	start   *uint64
+	offsets []uint64
+	hashes  []common.Hash
+	tds     []*big.Int
+
+	buf    *bytes.Buffer
+	snappy *snappy.Writer
+}
+
+// NewBuilder creates a builder writing into the given stream.
+func NewBuilder(w io.Writer) *Builder {
+	buf := new(bytes.Buffer)
+	return &Builder{
+		w:      newE2Writer(w),
+		buf:    buf,
+		snappy: snappy.NewBufferedWriter(buf),
+	}
+}
+
+// Add appends a block along with its receipts and total difficulty. The
+// blocks must be added in order and must not cross an epoch boundary.
+func (b *Builder) Add(block *types.Block, receipts types.Receipts, td *big.Int) error {
+	number := block.NumberU64()
+	if b.start == nil {
+		if number%MaxEra1Size != 0 {
+			return fmt.Errorf("epoch must start at a multiple of %d, have block %d", MaxEra1Size, number)
+		}
+		if _, err := b.write(TypeVersion, nil); err != nil {
+			return err
+		}
+		b.start = &number
+	}
+	if want := *b.start + uint64(len(b.offsets)); number != want {
+		return fmt.Errorf("non-contiguous block: have %d, want %d", number, want)
+	}
+	if len(b.offsets) == MaxEra1Size {
+		return fmt.Errorf("epoch full, %d blocks", MaxEra1Size)
+	}
+	b.offsets = append(b.offsets, b.written)
+	b.hashes = append(b.hashes, block.Hash())
+	b.tds = append(b.tds, new(big.Int).Set(td))
+
+	if err := b.writeCompressed(TypeCompressedHeader, block.Header()); err != nil {
+		return err
+	}
+	if err := b.writeCompressed(TypeCompressedBody, block.Body()); err != nil {
+		return err
+	}
+	if err := b.writeCompressed(TypeCompressedReceipts, receipts); err != nil {
+		return err
+	}
+	encoded, err := uint256LE(td)
+	if err != nil {
+		return err
+	}
+	_, err = b.write(TypeTotalDifficulty, encoded[:])
+	return err
+}
+
+// Finalize writes the accumulator and the block index, returning the
+// accumulator root identifying the epoch.
+func (b *Builder) Finalize() (common.Hash, error) {
+	if b.start == nil {
+		return common.Hash{}, errors.New("finalizing empty era1 file")
+	}
+	root, err := ComputeAccumulator(b.hashes, b.tds)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if _, err := b.write(TypeAccumulator, root[:]); err != nil {
+		return common.Hash{}, err
+	}
+	// The offsets are relative to the start of the index record
+	var (
+		count = uint64(len(b.offsets))
+		index = make([]byte, 16+8*count)
+		base  = int64(b.written)
+	)
+	binary.LittleEndian.PutUint64(index, *b.start)
+	for i, offset := range b.offsets {
+		binary.LittleEndian.PutUint64(index[8+8*i:], uint64(int64(offset)-base))
+	}
+	binary.LittleEndian.PutUint64(index[8+8*count:], count)
+	if _, err := b.write(TypeBlockIndex, index); err != nil {
+		return common.Hash{}, err
+	}
+	return root, nil
+}
+
+// write writes a raw record, tracking the written size.
+func (b *Builder) write(typ uint16, data []byte) (int, error) {
+	n, err := b.w.Write(typ, data)
+	b.written += uint64(n)
+	return n, err
+}
+
+// writeCompressed writes the snappy framed RLP encoding of the given value.
+func (b *Builder) writeCompressed(typ uint16, val interface{}) error {
+	b.buf.Reset()
+	b.snappy.Reset(b.buf)
+	if err := rlp.Encode(b.snappy, val); err != nil {
+		return err
+	}
+	if err := b.snappy.Flush(); err != nil {
+		return err
+	}
+	_, err := b.write(typ, b.buf.Bytes())
+	return err
+}
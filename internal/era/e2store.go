@@ -0,0 +1,123 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package era
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// headerSize is the size of the header of an e2store record: a 2 byte type,
+// a 4 byte data length and 2 reserved bytes, all little endian.
+const headerSize = 8
+
+// maxRecordSize caps the length of the data of a single record, guarding the
+// readers against allocating for corrupted lengths.
+const maxRecordSize = 1 << 30
+
+// record is a single type-length-value entry of an e2store file.
+type record struct {
+	Type   uint16
+	Length uint32
+	Data   []byte
+}
+
+// e2Writer writes records sequentially into an e2store file.
+type e2Writer struct {
+	w io.Writer
+}
+
+// newE2Writer creates a record writer on top of the given stream.
+func newE2Writer(w io.Writer) *e2Writer {
+	return &e2Writer{w: w}
+}
+
+// Write writes a record of the given type, returning the number of bytes
+// written including the header.
+func (w *e2Writer) Write(typ uint16, data []byte) (int, error) {
+	if len(data) > maxRecordSize {
+		return 0, fmt.Errorf("record too large: %d bytes", len(data))
+	}
+	var header [headerSize]byte
+	binary.LittleEndian.PutUint16(header[0:], typ)
+	binary.LittleEndian.PutUint32(header[2:], uint32(len(data)))
+	n, err := w.w.Write(header[:])
+	if err != nil {
+		return n, err
+	}
+	m, err := w.w.Write(data)
+	return n + m, err
+}
+
+// e2Reader reads records at arbitrary offsets of an e2store file.
+type e2Reader struct {
+	r io.ReaderAt
+}
+
+// newE2Reader creates a record reader on top of the given file.
+func newE2Reader(r io.ReaderAt) *e2Reader {
+	return &e2Reader{r: r}
+}
+
+// ReadHeader reads the type and data length of the record at the given offset.
+func (r *e2Reader) ReadHeader(off int64) (uint16, uint32, error) {
+	var header [headerSize]byte
+	if _, err := r.r.ReadAt(header[:], off); err != nil {
+		return 0, 0, err
+	}
+	if reserved := binary.LittleEndian.Uint16(header[6:]); reserved != 0 {
+		return 0, 0, fmt.Errorf("reserved bytes of record at %d not zero: %#x", off, reserved)
+	}
+	length := binary.LittleEndian.Uint32(header[2:])
+	if length > maxRecordSize {
+		return 0, 0, fmt.Errorf("record at %d too large: %d bytes", off, length)
+	}
+	return binary.LittleEndian.Uint16(header[0:]), length, nil
+}
+
+// ReadAt reads the record at the given offset, returning it along with its
+// total size including the header.
+func (r *e2Reader) ReadAt(off int64) (*record, int64, error) {
+	typ, length, err := r.ReadHeader(off)
+	if err != nil {
+		return nil, 0, err
+	}
+	rec := &record{Type: typ, Length: length, Data: make([]byte, length)}
+	if _, err := r.r.ReadAt(rec.Data, off+headerSize); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	return rec, headerSize + int64(length), nil
+}
+
+// SectionReader returns a reader of the data of the record at the given
+// offset, checking its type. The reader spans the data only, which allows the
+// compressed records to be decoded as a stream.
+func (r *e2Reader) SectionReader(off int64, typ uint16) (*io.SectionReader, int64, error) {
+	have, length, err := r.ReadHeader(off)
+	if err != nil {
+		return nil, 0, err
+	}
+	if have != typ {
+		return nil, 0, fmt.Errorf("invalid record type at %d: have %#x, want %#x", off, have, typ)
+	}
+	return io.NewSectionReader(r.r, off+headerSize, int64(length)), headerSize + int64(length), nil
+}
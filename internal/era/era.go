@@ -0,0 +1,311 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package era implements the Era1 flat-file format of the chain history. An
+// Era1 file holds the blocks, receipts and total difficulties of an epoch of
+// 8192 blocks, along with an offset index and the accumulator root of the
+// epoch, allowing the archives to be verified independently of the network.
+package era
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/golang/snappy"
+)
+
+// The e2store record types of the Era1 format.
+const (
+	TypeVersion            uint16 = 0x3265
+	TypeCompressedHeader   uint16 = 0x03
+	TypeCompressedBody     uint16 = 0x04
+	TypeCompressedReceipts uint16 = 0x05
+	TypeTotalDifficulty    uint16 = 0x06
+	TypeAccumulator        uint16 = 0x07
+	TypeBlockIndex         uint16 = 0x3266
+)
+
+// MaxEra1Size is the number of blocks in an epoch.
+const MaxEra1Size = 8192
+
+// Filename returns the name of the Era1 file of the given epoch, suffixed by
+// the first bytes of its accumulator root.
+func Filename(network string, epoch int, root common.Hash) string {
+	return fmt.Sprintf("%s-%05d-%s.era1", network, epoch, root.Hex()[2:10])
+}
+
+// ReadDir returns the Era1 files of the given network in the directory, sorted
+// by epoch. The epochs are required to be contiguous.
+func ReadDir(dir, network string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory %s: %w", dir, err)
+	}
+	var (
+		next  = uint64(0)
+		files []string
+	)
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".era1" {
+			continue
+		}
+		parts := strings.Split(entry.Name(), "-")
+		if len(parts) != 3 || parts[0] != network {
+			continue
+		}
+		epoch, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed era1 filename: %s", entry.Name())
+		}
+		if epoch+1 == next {
+			return nil, fmt.Errorf("duplicate epoch %d", epoch)
+		}
+		if epoch != next {
+			return nil, fmt.Errorf("missing epoch %d", next)
+		}
+		next++
+		files = append(files, entry.Name())
+	}
+	return files, nil
+}
+
+// ReadAtSeekCloser is the file an Era1 archive is read from.
+type ReadAtSeekCloser interface {
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+// Era reads the blocks of an Era1 file.
+type Era struct {
+	f     ReadAtSeekCloser
+	s     *e2Reader
+	start uint64 // Number of the first block
+	count uint64 // Number of blocks
+	index int64  // Offset of the block index record
+}
+
+// Open opens the Era1 file at the given path.
+func Open(filename string) (*Era, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	e, err := From(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// From reads the Era1 archive from the given file, locating its block index.
+func From(f ReadAtSeekCloser) (*Era, error) {
+	length, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	s := newE2Reader(f)
+	if typ, _, err := s.ReadHeader(0); err != nil {
+		return nil, err
+	} else if typ != TypeVersion {
+		return nil, fmt.Errorf("invalid version record type: %#x", typ)
+	}
+	// The block count is the last field of the trailing block index
+	if length < 8 {
+		return nil, fmt.Errorf("era1 file too short: %d bytes", length)
+	}
+	var buf [8]byte
+	if _, err := f.ReadAt(buf[:], length-8); err != nil {
+		return nil, err
+	}
+	count := binary.LittleEndian.Uint64(buf[:])
+	if count == 0 || count > MaxEra1Size {
+		return nil, fmt.Errorf("invalid block count: %d", count)
+	}
+	index := length - headerSize - int64(16+8*count)
+	if index < 0 {
+		return nil, fmt.Errorf("era1 file too short for %d blocks", count)
+	}
+	typ, size, err := s.ReadHeader(index)
+	if err != nil {
+		return nil, err
+	}
+	if typ != TypeBlockIndex || uint64(size) != 16+8*count {
+		return nil, fmt.Errorf("invalid block index record: type %#x, size %d", typ, size)
+	}
+	if _, err := f.ReadAt(buf[:], index+headerSize); err != nil {
+		return nil, err
+	}
+	return &Era{
+		f:     f,
+		s:     s,
+		start: binary.LittleEndian.Uint64(buf[:]),
+		count: count,
+		index: index,
+	}, nil
+}
+
+// Close closes the underlying file.
+func (e *Era) Close() error {
+	return e.f.Close()
+}
+
+// Start returns the number of the first block of the archive.
+func (e *Era) Start() uint64 {
+	return e.start
+}
+
+// Count returns the number of blocks in the archive.
+func (e *Era) Count() uint64 {
+	return e.count
+}
+
+// GetBlockByNumber returns the block with the given number.
+func (e *Era) GetBlockByNumber(number uint64) (*types.Block, error) {
+	off, err := e.blockOffset(number)
+	if err != nil {
+		return nil, err
+	}
+	header := new(types.Header)
+	n, err := e.readCompressed(off, TypeCompressedHeader, header)
+	if err != nil {
+		return nil, err
+	}
+	body := new(types.Body)
+	if _, err := e.readCompressed(off+n, TypeCompressedBody, body); err != nil {
+		return nil, err
+	}
+	block := types.NewBlockWithHeader(header).WithBody(body.Transactions, body.Uncles)
+	if body.Withdrawals != nil {
+		block = block.WithWithdrawals(body.Withdrawals)
+	}
+	return block, nil
+}
+
+// GetReceiptsByNumber returns the receipts of the block with the given number,
+// in their consensus form without the derived fields.
+func (e *Era) GetReceiptsByNumber(number uint64) (types.Receipts, error) {
+	off, err := e.skip(number, 2)
+	if err != nil {
+		return nil, err
+	}
+	var receipts types.Receipts
+	if _, err := e.readCompressed(off, TypeCompressedReceipts, &receipts); err != nil {
+		return nil, err
+	}
+	return receipts, nil
+}
+
+// GetTotalDifficultyByNumber returns the total difficulty of the chain up to
+// and including the block with the given number.
+func (e *Era) GetTotalDifficultyByNumber(number uint64) (*big.Int, error) {
+	off, err := e.skip(number, 3)
+	if err != nil {
+		return nil, err
+	}
+	rec, _, err := e.s.ReadAt(off)
+	if err != nil {
+		return nil, err
+	}
+	if rec.Type != TypeTotalDifficulty || len(rec.Data) != 32 {
+		return nil, fmt.Errorf("invalid total difficulty record: type %#x, size %d", rec.Type, len(rec.Data))
+	}
+	return fromUint256LE(rec.Data), nil
+}
+
+// InitialTD returns the total difficulty of the parent of the first block.
+func (e *Era) InitialTD() (*big.Int, error) {
+	block, err := e.GetBlockByNumber(e.start)
+	if err != nil {
+		return nil, err
+	}
+	td, err := e.GetTotalDifficultyByNumber(e.start)
+	if err != nil {
+		return nil, err
+	}
+	return td.Sub(td, block.Difficulty()), nil
+}
+
+// Accumulator returns the accumulator root recorded in the archive.
+func (e *Era) Accumulator() (common.Hash, error) {
+	off, err := e.skip(e.start+e.count-1, 4)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	rec, _, err := e.s.ReadAt(off)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if rec.Type != TypeAccumulator || len(rec.Data) != common.HashLength {
+		return common.Hash{}, fmt.Errorf("invalid accumulator record: type %#x, size %d", rec.Type, len(rec.Data))
+	}
+	return common.BytesToHash(rec.Data), nil
+}
+
+// blockOffset returns the offset of the block tuple of the given block.
+func (e *Era) blockOffset(number uint64) (int64, error) {
+	if number < e.start || number >= e.start+e.count {
+		return 0, fmt.Errorf("block %d out of range [%d, %d)", number, e.start, e.start+e.count)
+	}
+	var buf [8]byte
+	if _, err := e.f.ReadAt(buf[:], e.index+headerSize+8+8*int64(number-e.start)); err != nil {
+		return 0, err
+	}
+	off := e.index + int64(binary.LittleEndian.Uint64(buf[:]))
+	if off < 0 || off >= e.index {
+		return 0, fmt.Errorf("invalid offset %d of block %d", off, number)
+	}
+	return off, nil
+}
+
+// skip returns the offset of the given record of the block tuple of a block.
+func (e *Era) skip(number uint64, records int) (int64, error) {
+	off, err := e.blockOffset(number)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < records; i++ {
+		_, length, err := e.s.ReadHeader(off)
+		if err != nil {
+			return 0, err
+		}
+		off += headerSize + int64(length)
+	}
+	return off, nil
+}
+
+// readCompressed decodes the snappy framed RLP value of the record at the
+// given offset, returning the size of the record.
+func (e *Era) readCompressed(off int64, typ uint16, val interface{}) (int64, error) {
+	r, n, err := e.s.SectionReader(off, typ)
+	if err != nil {
+		return 0, err
+	}
+	if err := rlp.Decode(snappy.NewReader(r), val); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,175 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package era
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// Tests that the blocks, receipts and total difficulties written into an Era1
+// file are read back identically.
+func TestEra1Roundtrip(t *testing.T) {
+	var (
+		blocks   []*types.Block
+		receipts []types.Receipts
+		tds      []*big.Int
+		td       = new(big.Int)
+		parent   common.Hash
+	)
+	for i := 0; i < 128; i++ {
+		header := &types.Header{
+			ParentHash: parent,
+			Number:     big.NewInt(int64(i)),
+			Difficulty: big.NewInt(int64(1000 + i)),
+			GasLimit:   8_000_000,
+		}
+		var (
+			txs []*types.Transaction
+			rs  types.Receipts
+		)
+		for j := 0; j < i%4; j++ {
+			txs = append(txs, types.NewTransaction(uint64(j), common.Address{byte(i)}, big.NewInt(int64(j)), 21000, big.NewInt(1), nil))
+			rs = append(rs, &types.Receipt{
+				Status:            types.ReceiptStatusSuccessful,
+				CumulativeGasUsed: uint64(21000 * (j + 1)),
+				Logs:              []*types.Log{{Address: common.Address{byte(j)}, Topics: []common.Hash{{byte(i)}}, Data: []byte{byte(j)}}},
+			})
+		}
+		for _, r := range rs {
+			r.Bloom = types.CreateBloom(types.Receipts{r})
+		}
+		block := types.NewBlock(header, txs, nil, rs, trie.NewStackTrie(nil))
+		td.Add(td, block.Difficulty())
+
+		blocks = append(blocks, block)
+		receipts = append(receipts, rs)
+		tds = append(tds, new(big.Int).Set(td))
+		parent = block.Hash()
+	}
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "era1"))
+	if err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	builder := NewBuilder(f)
+	for i, block := range blocks {
+		if err := builder.Add(block, receipts[i], tds[i]); err != nil {
+			t.Fatalf("failed to add block %d: %v", i, err)
+		}
+	}
+	root, err := builder.Finalize()
+	if err != nil {
+		t.Fatalf("failed to finalize: %v", err)
+	}
+	f.Close()
+
+	var hashes []common.Hash
+	for _, block := range blocks {
+		hashes = append(hashes, block.Hash())
+	}
+	if want, _ := ComputeAccumulator(hashes, tds); root != want {
+		t.Fatalf("accumulator mismatch: have %x, want %x", root, want)
+	}
+	e, err := Open(filepath.Join(dir, "era1"))
+	if err != nil {
+		t.Fatalf("failed to open era1 file: %v", err)
+	}
+	defer e.Close()
+
+	if e.Start() != 0 || e.Count() != uint64(len(blocks)) {
+		t.Fatalf("range mismatch: have %d+%d, want 0+%d", e.Start(), e.Count(), len(blocks))
+	}
+	if have, err := e.Accumulator(); err != nil || have != root {
+		t.Fatalf("recorded accumulator mismatch: have %x (%v), want %x", have, err, root)
+	}
+	if have, err := e.InitialTD(); err != nil || have.Sign() != 0 {
+		t.Fatalf("initial total difficulty mismatch: have %v (%v), want 0", have, err)
+	}
+	for i, want := range blocks {
+		number := uint64(i)
+		have, err := e.GetBlockByNumber(number)
+		if err != nil {
+			t.Fatalf("failed to read block %d: %v", i, err)
+		}
+		if have.Hash() != want.Hash() || types.DeriveSha(have.Transactions(), trie.NewStackTrie(nil)) != want.TxHash() {
+			t.Fatalf("block %d mismatch", i)
+		}
+		rs, err := e.GetReceiptsByNumber(number)
+		if err != nil {
+			t.Fatalf("failed to read receipts %d: %v", i, err)
+		}
+		if len(rs) != len(receipts[i]) || types.DeriveSha(rs, trie.NewStackTrie(nil)) != want.ReceiptHash() {
+			t.Fatalf("receipts %d mismatch", i)
+		}
+		for j, r := range rs {
+			if !reflect.DeepEqual(r.Logs[0].Topics, receipts[i][j].Logs[0].Topics) {
+				t.Fatalf("receipt %d/%d log mismatch", i, j)
+			}
+		}
+		td, err := e.GetTotalDifficultyByNumber(number)
+		if err != nil || td.Cmp(tds[i]) != 0 {
+			t.Fatalf("total difficulty %d mismatch: have %v (%v), want %v", i, td, err, tds[i])
+		}
+	}
+	if _, err := e.GetBlockByNumber(uint64(len(blocks))); err == nil {
+		t.Fatalf("block beyond the archive returned")
+	}
+}
+
+// Tests that blocks not aligned to the epochs are rejected.
+func TestEra1Alignment(t *testing.T) {
+	builder := NewBuilder(new(discard))
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Difficulty: common.Big1})
+	if err := builder.Add(block, nil, common.Big1); err == nil {
+		t.Fatalf("unaligned epoch accepted")
+	}
+	if _, err := builder.Finalize(); err == nil {
+		t.Fatalf("empty epoch finalized")
+	}
+}
+
+// Tests that the accumulator of an empty epoch equals the root of the empty
+// list, and that the records are order sensitive.
+func TestAccumulator(t *testing.T) {
+	empty, err := ComputeAccumulator(nil, nil)
+	if err != nil {
+		t.Fatalf("failed to compute empty accumulator: %v", err)
+	}
+	if want := hashPair(zeroHashes[accumulatorDepth], common.Hash{}); empty != want {
+		t.Fatalf("empty accumulator mismatch: have %x, want %x", empty, want)
+	}
+	a, _ := ComputeAccumulator([]common.Hash{{1}, {2}}, []*big.Int{big.NewInt(1), big.NewInt(2)})
+	b, _ := ComputeAccumulator([]common.Hash{{2}, {1}}, []*big.Int{big.NewInt(2), big.NewInt(1)})
+	if a == b {
+		t.Fatalf("accumulator insensitive to the record order")
+	}
+	if _, err := ComputeAccumulator([]common.Hash{{1}}, nil); err == nil {
+		t.Fatalf("mismatching records accepted")
+	}
+}
+
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) { return len(p), nil }
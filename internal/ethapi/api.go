@@ -2061,6 +2061,165 @@ func (s *TransactionAPI) Resend(ctx context.Context, sendArgs TransactionArgs, g
 	return common.Hash{}, fmt.Errorf("transaction %#x not found", matchTx.Hash())
 }
 
+// ReplacementArgs represents the fee settings used when replacing or cancelling
+// a pending transaction. Explicit fees take precedence, otherwise the fees of the
+// original transaction are bumped by PriceBump percent. Either way the resulting
+// fees must satisfy the minimum price bump enforced by the transaction pool.
+type ReplacementArgs struct {
+	GasPrice             *hexutil.Big `json:"gasPrice"`
+	MaxFeePerGas         *hexutil.Big `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *hexutil.Big `json:"maxPriorityFeePerGas"`
+	PriceBump            *uint64      `json:"priceBump"`
+}
+
+// ReplacementResult is the outcome of a transaction replacement or cancellation.
+type ReplacementResult struct {
+	Hash     common.Hash     `json:"hash"`
+	Replaced common.Hash     `json:"replaced"`
+	Accepted bool            `json:"accepted"`
+	Error    string          `json:"error,omitempty"`
+	Tx       *RPCTransaction `json:"tx"`
+}
+
+// ReplaceTransaction re-signs the pending transaction identified by hash with the
+// same nonce and bumped fees, and submits it to the transaction pool. The sender
+// of the original transaction must be managed by the node's account manager.
+func (s *TransactionAPI) ReplaceTransaction(ctx context.Context, hash common.Hash, args *ReplacementArgs) (*ReplacementResult, error) {
+	return s.replaceTransaction(ctx, hash, args, false)
+}
+
+// CancelTransaction replaces the pending transaction identified by hash with a
+// zero value self-send using the same nonce and bumped fees, effectively
+// cancelling the original transaction once the replacement is mined.
+func (s *TransactionAPI) CancelTransaction(ctx context.Context, hash common.Hash, args *ReplacementArgs) (*ReplacementResult, error) {
+	return s.replaceTransaction(ctx, hash, args, true)
+}
+
+// replaceTransaction implements both ReplaceTransaction and CancelTransaction.
+func (s *TransactionAPI) replaceTransaction(ctx context.Context, hash common.Hash, args *ReplacementArgs, cancel bool) (*ReplacementResult, error) {
+	if args == nil {
+		args = new(ReplacementArgs)
+	}
+	orig := s.b.GetPoolTransaction(hash)
+	if orig == nil {
+		if tx, _, _, _, _ := s.b.GetTransaction(ctx, hash); tx != nil {
+			return nil, fmt.Errorf("transaction %#x already mined", hash)
+		}
+		return nil, fmt.Errorf("transaction %#x not found", hash)
+	}
+	from, err := types.Sender(s.signer, orig)
+	if err != nil {
+		return nil, err
+	}
+	s.nonceLock.LockAddr(from)
+	defer s.nonceLock.UnlockAddr(from)
+
+	minBump := s.b.TxPoolPriceBump()
+	bump := minBump
+	if args.PriceBump != nil {
+		if *args.PriceBump < minBump {
+			return nil, fmt.Errorf("price bump %d%% below the pool minimum of %d%%", *args.PriceBump, minBump)
+		}
+		bump = *args.PriceBump
+	}
+	tx, err := newReplacementTx(orig, from, args, bump, minBump, cancel)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTxFee(tx.GasFeeCap(), tx.Gas(), s.b.RPCTxFeeCap()); err != nil {
+		return nil, err
+	}
+	signed, err := s.sign(from, tx)
+	if err != nil {
+		return nil, err
+	}
+	result := &ReplacementResult{
+		Hash:     signed.Hash(),
+		Replaced: hash,
+		Tx:       NewRPCPendingTransaction(signed, s.b.CurrentHeader(), s.b.ChainConfig()),
+	}
+	if _, err := SubmitTransaction(ctx, s.b, signed); err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Accepted = true
+	}
+	return result, nil
+}
+
+// newReplacementTx assembles an unsigned transaction replacing orig. The fees are
+// taken from args if set, or bumped by bump percent otherwise; in both cases they
+// must be at least minBump percent above the original ones. If cancel is set, the
+// replacement is a zero value self-send instead of a copy of the original.
+func newReplacementTx(orig *types.Transaction, from common.Address, args *ReplacementArgs, bump, minBump uint64, cancel bool) (*types.Transaction, error) {
+	var (
+		to    = orig.To()
+		value = orig.Value()
+		data  = orig.Data()
+		gas   = orig.Gas()
+		al    = orig.AccessList()
+	)
+	if cancel {
+		to, value, data, gas, al = &from, new(big.Int), nil, vars.TxGas, nil
+	}
+	// pickFee returns the explicitly requested fee if any, or the bumped original.
+	pickFee := func(name string, old *big.Int, want *hexutil.Big) (*big.Int, error) {
+		min := bumpedPrice(old, minBump)
+		if want == nil {
+			return bumpedPrice(old, bump), nil
+		}
+		if want.ToInt().Cmp(min) < 0 {
+			return nil, fmt.Errorf("replacement %s too low: have %v, want at least %v", name, want.ToInt(), min)
+		}
+		return new(big.Int).Set(want.ToInt()), nil
+	}
+	switch orig.Type() {
+	case types.LegacyTxType, types.AccessListTxType:
+		if args.MaxFeePerGas != nil || args.MaxPriorityFeePerGas != nil {
+			return nil, errors.New("maxFeePerGas and maxPriorityFeePerGas are not applicable to legacy transactions, use gasPrice")
+		}
+		price, err := pickFee("gasPrice", orig.GasPrice(), args.GasPrice)
+		if err != nil {
+			return nil, err
+		}
+		if orig.Type() == types.LegacyTxType {
+			return types.NewTx(&types.LegacyTx{Nonce: orig.Nonce(), GasPrice: price, Gas: gas, To: to, Value: value, Data: data}), nil
+		}
+		return types.NewTx(&types.AccessListTx{ChainID: orig.ChainId(), Nonce: orig.Nonce(), GasPrice: price, Gas: gas, To: to, Value: value, Data: data, AccessList: al}), nil
+
+	case types.DynamicFeeTxType:
+		if args.GasPrice != nil {
+			return nil, errors.New("gasPrice is not applicable to dynamic fee transactions, use maxFeePerGas and maxPriorityFeePerGas")
+		}
+		feeCap, err := pickFee("maxFeePerGas", orig.GasFeeCap(), args.MaxFeePerGas)
+		if err != nil {
+			return nil, err
+		}
+		tipCap, err := pickFee("maxPriorityFeePerGas", orig.GasTipCap(), args.MaxPriorityFeePerGas)
+		if err != nil {
+			return nil, err
+		}
+		if feeCap.Cmp(tipCap) < 0 {
+			return nil, fmt.Errorf("maxFeePerGas (%v) < maxPriorityFeePerGas (%v)", feeCap, tipCap)
+		}
+		return types.NewTx(&types.DynamicFeeTx{ChainID: orig.ChainId(), Nonce: orig.Nonce(), GasTipCap: tipCap, GasFeeCap: feeCap, Gas: gas, To: to, Value: value, Data: data, AccessList: al}), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported transaction type %d", orig.Type())
+	}
+}
+
+// bumpedPrice returns price increased by percent, mirroring the threshold used by
+// the transaction pool for replacements. The result is always strictly greater
+// than price, which matters for Wei-level prices.
+func bumpedPrice(price *big.Int, percent uint64) *big.Int {
+	bumped := new(big.Int).Mul(price, new(big.Int).SetUint64(100+percent))
+	bumped.Div(bumped, big.NewInt(100))
+	if bumped.Cmp(price) <= 0 {
+		bumped.Add(price, common.Big1)
+	}
+	return bumped
+}
+
 // DebugAPI is the collection of Ethereum APIs exposed over the debugging
 // namespace.
 type DebugAPI struct {
@@ -22,6 +22,7 @@ import (
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/params"
@@ -158,3 +159,51 @@ func allTransactionTypes(addr common.Address, config ctypes.ChainConfigurator) [
 		},
 	}
 }
+
+func TestReplacementTransaction(t *testing.T) {
+	var (
+		from = common.Address{0xaa}
+		to   = common.Address{0xbb}
+	)
+	legacy := types.NewTx(&types.LegacyTx{Nonce: 3, GasPrice: big.NewInt(100), Gas: 50000, To: &to, Value: big.NewInt(7), Data: []byte{1}})
+	dynamic := types.NewTx(&types.DynamicFeeTx{ChainID: big.NewInt(1), Nonce: 4, GasTipCap: big.NewInt(2), GasFeeCap: big.NewInt(200), Gas: 50000, To: &to, Value: big.NewInt(7)})
+
+	// Default bump of a legacy transaction keeps everything but the price
+	tx, err := newReplacementTx(legacy, from, new(ReplacementArgs), 10, 10, false)
+	if err != nil {
+		t.Fatalf("failed to replace legacy transaction: %v", err)
+	}
+	if tx.Nonce() != 3 || tx.GasPrice().Uint64() != 110 || *tx.To() != to || tx.Value().Uint64() != 7 || tx.Gas() != 50000 {
+		t.Fatalf("unexpected legacy replacement: nonce %d price %v to %v value %v gas %d", tx.Nonce(), tx.GasPrice(), tx.To(), tx.Value(), tx.Gas())
+	}
+	// Cancellation is a zero value self-send
+	tx, err = newReplacementTx(legacy, from, new(ReplacementArgs), 20, 10, true)
+	if err != nil {
+		t.Fatalf("failed to cancel legacy transaction: %v", err)
+	}
+	if tx.Nonce() != 3 || tx.GasPrice().Uint64() != 120 || *tx.To() != from || tx.Value().Sign() != 0 || len(tx.Data()) != 0 || tx.Gas() != 21000 {
+		t.Fatalf("unexpected cancellation: nonce %d price %v to %v value %v gas %d", tx.Nonce(), tx.GasPrice(), tx.To(), tx.Value(), tx.Gas())
+	}
+	// Wei-level dynamic fees must still strictly increase
+	tx, err = newReplacementTx(dynamic, from, new(ReplacementArgs), 10, 10, false)
+	if err != nil {
+		t.Fatalf("failed to replace dynamic fee transaction: %v", err)
+	}
+	if tx.GasTipCap().Uint64() != 3 || tx.GasFeeCap().Uint64() != 220 {
+		t.Fatalf("unexpected dynamic fee replacement: tip %v feecap %v", tx.GasTipCap(), tx.GasFeeCap())
+	}
+	// Explicit fees below the minimum bump are rejected
+	if _, err := newReplacementTx(legacy, from, &ReplacementArgs{GasPrice: (*hexutil.Big)(big.NewInt(105))}, 10, 10, false); err == nil {
+		t.Fatalf("underpriced replacement accepted")
+	}
+	if _, err := newReplacementTx(dynamic, from, &ReplacementArgs{GasPrice: (*hexutil.Big)(big.NewInt(500))}, 10, 10, false); err == nil {
+		t.Fatalf("gasPrice accepted for dynamic fee transaction")
+	}
+	tx, err = newReplacementTx(legacy, from, &ReplacementArgs{GasPrice: (*hexutil.Big)(big.NewInt(500))}, 10, 10, false)
+	if err != nil {
+		t.Fatalf("failed to replace with explicit price: %v", err)
+	}
+	if tx.GasPrice().Uint64() != 500 {
+		t.Fatalf("explicit price ignored: have %v", tx.GasPrice())
+	}
+}
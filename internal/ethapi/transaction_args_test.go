@@ -325,6 +325,7 @@ func (b *backendMock) GetPoolTransaction(txHash common.Hash) *types.Transaction
 func (b *backendMock) GetPoolNonce(ctx context.Context, addr common.Address) (uint64, error) {
 	return 0, nil
 }
+func (b *backendMock) TxPoolPriceBump() uint64          { return 10 }
 func (b *backendMock) Stats() (pending int, queued int) { return 0, 0 }
 func (b *backendMock) TxPoolContent() (map[common.Address]types.Transactions, map[common.Address]types.Transactions) {
 	return nil, nil
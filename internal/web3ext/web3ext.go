@@ -168,6 +168,28 @@ web3._extend({
 			call: 'admin_sleepBlocks',
 			params: 2
 		}),
+		new web3._extend.Method({
+			name: 'pruneState',
+			call: 'admin_pruneState'
+		}),
+		new web3._extend.Method({
+			name: 'pruningProgress',
+			call: 'admin_pruningProgress'
+		}),
+		new web3._extend.Method({
+			name: 'checkSnapshotIntegrity',
+			call: 'admin_checkSnapshotIntegrity',
+			params: 1,
+			inputFormatter: [null]
+		}),
+		new web3._extend.Method({
+			name: 'snapshotIntegrityProgress',
+			call: 'admin_snapshotIntegrityProgress'
+		}),
+		new web3._extend.Method({
+			name: 'snapshotIntegrityReport',
+			call: 'admin_snapshotIntegrityReport'
+		}),
 		new web3._extend.Method({
 			name: 'startHTTP',
 			call: 'admin_startHTTP',
@@ -429,6 +451,18 @@ web3._extend({
 			params: 2,
 			inputFormatter: [web3._extend.formatters.inputBlockNumberFormatter, null]
 		}),
+		new web3._extend.Method({
+			name: 'diffTransaction',
+			call: 'debug_diffTransaction',
+			params: 2,
+			inputFormatter: [null, null]
+		}),
+		new web3._extend.Method({
+			name: 'traceBlockAccessSets',
+			call: 'debug_traceBlockAccessSets',
+			params: 2,
+			inputFormatter: [web3._extend.formatters.inputBlockNumberFormatter, null]
+		}),
 		new web3._extend.Method({
 			name: 'traceBlockByHash',
 			call: 'debug_traceBlockByHash',
@@ -511,6 +545,12 @@ web3._extend({
 			call: 'debug_setTrieFlushInterval',
 			params: 1
 		}),
+		new web3._extend.Method({
+			name: 'executionWitness',
+			call: 'debug_executionWitness',
+			params: 1,
+			inputFormatter: [web3._extend.formatters.inputBlockNumberFormatter]
+		}),
 	],
 	properties: []
 });
@@ -537,6 +577,28 @@ web3._extend({
 			params: 3,
 			inputFormatter: [web3._extend.formatters.inputTransactionFormatter, web3._extend.utils.fromDecimal, web3._extend.utils.fromDecimal]
 		}),
+		new web3._extend.Method({
+			name: 'sendBundle',
+			call: 'eth_sendBundle',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'callBundle',
+			call: 'eth_callBundle',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'replaceTransaction',
+			call: 'eth_replaceTransaction',
+			params: 2,
+			inputFormatter: [null, null]
+		}),
+		new web3._extend.Method({
+			name: 'cancelTransaction',
+			call: 'eth_cancelTransaction',
+			params: 2,
+			inputFormatter: [null, null]
+		}),
 		new web3._extend.Method({
 			name: 'signTransaction',
 			call: 'eth_signTransaction',
@@ -687,6 +749,19 @@ web3._extend({
 			params: 1,
 			inputFormatter: [web3._extend.utils.fromDecimal]
 		}),
+		new web3._extend.Method({
+			name: 'getBlockTemplate',
+			call: 'miner_getBlockTemplate'
+		}),
+		new web3._extend.Method({
+			name: 'submitBlockTemplate',
+			call: 'miner_submitBlockTemplate',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'minedBlocks',
+			call: 'miner_minedBlocks'
+		}),
 		new web3._extend.Method({
 			name: 'setRecommitInterval',
 			call: 'miner_setRecommitInterval',
@@ -827,6 +902,27 @@ web3._extend({
 				});
 			}, web3._extend.formatters.inputBlockNumberFormatter, null]
 		}),
+		new web3._extend.Method({
+			name: 'replayTransaction',
+			call: 'trace_replayTransaction',
+			params: 2
+		}),
+		new web3._extend.Method({
+			name: 'replayBlockTransactions',
+			call: 'trace_replayBlockTransactions',
+			params: 2,
+			inputFormatter: [web3._extend.formatters.inputBlockNumberFormatter, null]
+		}),
+		new web3._extend.Method({
+			name: 'get',
+			call: 'trace_get',
+			params: 2
+		}),
+		new web3._extend.Method({
+			name: 'rawTransaction',
+			call: 'trace_rawTransaction',
+			params: 2
+		}),
 	],
 	properties: []
 });
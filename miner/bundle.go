@@ -0,0 +1,204 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	// maxBundles is the maximum number of bundles kept in the bundle store.
+	maxBundles = 1024
+
+	// maxBundlesPerSender is the maximum number of bundles kept in the bundle
+	// store per sender of the first bundled transaction.
+	maxBundlesPerSender = 16
+
+	// maxBundleFutureBlocks is the number of blocks ahead of the chain head
+	// bundles may target.
+	maxBundleFutureBlocks = 25
+)
+
+var (
+	errEmptyBundle       = errors.New("bundle contains no transactions")
+	errBundleStoreFull   = errors.New("bundle store full")
+	errBundleKnown       = errors.New("bundle already known")
+	errBundleStale       = errors.New("bundle block number not above current head")
+	errBundleTooFar      = errors.New("bundle block number too far ahead of current head")
+	errBundleSenderLimit = errors.New("too many bundles from sender")
+)
+
+// Bundle is an ordered list of transactions which must be included in a block
+// atomically and in order, at the top of the block. If any of the transactions
+// fails or reverts (and is not explicitly allowed to revert), the entire bundle
+// is left out of the block.
+type Bundle struct {
+	Txs               types.Transactions // Transactions to include, in order
+	BlockNumber       uint64             // Number of the block the bundle is valid for
+	MinTimestamp      uint64             // Minimum block timestamp the bundle is valid for, 0 if unbounded
+	MaxTimestamp      uint64             // Maximum block timestamp the bundle is valid for, 0 if unbounded
+	RevertingTxHashes []common.Hash      // Transactions which are allowed to revert without dropping the bundle
+}
+
+// Hash returns the identifier of the bundle, the hash of its transaction hashes.
+func (b *Bundle) Hash() common.Hash {
+	hashes := make([]byte, 0, len(b.Txs)*common.HashLength)
+	for _, tx := range b.Txs {
+		hashes = append(hashes, tx.Hash().Bytes()...)
+	}
+	return crypto.Keccak256Hash(hashes)
+}
+
+// mayRevert reports whether the transaction with the given hash is allowed to
+// revert without invalidating the bundle.
+func (b *Bundle) mayRevert(hash common.Hash) bool {
+	for _, h := range b.RevertingTxHashes {
+		if h == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// validFor reports whether the bundle may be included in a block with the given
+// number and timestamp.
+func (b *Bundle) validFor(number, timestamp uint64) bool {
+	if b.BlockNumber != number {
+		return false
+	}
+	if b.MinTimestamp != 0 && timestamp < b.MinTimestamp {
+		return false
+	}
+	if b.MaxTimestamp != 0 && timestamp > b.MaxTimestamp {
+		return false
+	}
+	return true
+}
+
+// bundleStore keeps the bundles submitted for upcoming blocks, in submission order.
+type bundleStore struct {
+	bundles []*Bundle
+	known   map[common.Hash]common.Address // Sender of the first transaction of each bundle
+	senders map[common.Address]int         // Number of kept bundles per sender
+	lock    sync.RWMutex
+}
+
+func newBundleStore() *bundleStore {
+	return &bundleStore{
+		known:   make(map[common.Hash]common.Address),
+		senders: make(map[common.Address]int),
+	}
+}
+
+// add inserts a new bundle into the store. The bundle must target one of the
+// next maxBundleFutureBlocks blocks after the given head. If the store is full,
+// the bundle targeting the farthest block is evicted, the oldest one of them if
+// there are several. Bundles targeting blocks farther than all kept ones are
+// rejected in that case.
+func (s *bundleStore) add(bundle *Bundle, head uint64, sender common.Address) error {
+	if len(bundle.Txs) == 0 {
+		return errEmptyBundle
+	}
+	if bundle.BlockNumber <= head {
+		return fmt.Errorf("%w: target %d, head %d", errBundleStale, bundle.BlockNumber, head)
+	}
+	if bundle.BlockNumber > head+maxBundleFutureBlocks {
+		return fmt.Errorf("%w: target %d, head %d, max %d blocks ahead", errBundleTooFar, bundle.BlockNumber, head, maxBundleFutureBlocks)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	hash := bundle.Hash()
+	if _, ok := s.known[hash]; ok {
+		return errBundleKnown
+	}
+	if s.senders[sender] >= maxBundlesPerSender {
+		return fmt.Errorf("%w %v: max %d", errBundleSenderLimit, sender, maxBundlesPerSender)
+	}
+	if len(s.bundles) >= maxBundles {
+		victim := 0
+		for i, kept := range s.bundles {
+			if kept.BlockNumber > s.bundles[victim].BlockNumber {
+				victim = i
+			}
+		}
+		if s.bundles[victim].BlockNumber < bundle.BlockNumber {
+			return errBundleStoreFull
+		}
+		s.drop(s.bundles[victim])
+		copy(s.bundles[victim:], s.bundles[victim+1:])
+		s.bundles[len(s.bundles)-1] = nil
+		s.bundles = s.bundles[:len(s.bundles)-1]
+	}
+	s.bundles = append(s.bundles, bundle)
+	s.known[hash] = sender
+	s.senders[sender]++
+	return nil
+}
+
+// drop removes the bookkeeping of the given bundle. The bundle itself must be
+// removed from the bundle list by the caller.
+func (s *bundleStore) drop(bundle *Bundle) {
+	hash := bundle.Hash()
+	sender := s.known[hash]
+	delete(s.known, hash)
+
+	if s.senders[sender]--; s.senders[sender] <= 0 {
+		delete(s.senders, sender)
+	}
+}
+
+// pending returns the bundles eligible for a block with the given number and
+// timestamp, and drops all bundles targeting earlier blocks.
+func (s *bundleStore) pending(number, timestamp uint64) []*Bundle {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var (
+		kept     = s.bundles[:0]
+		eligible []*Bundle
+	)
+	for _, bundle := range s.bundles {
+		if bundle.BlockNumber < number {
+			s.drop(bundle)
+			continue
+		}
+		kept = append(kept, bundle)
+		if bundle.validFor(number, timestamp) {
+			eligible = append(eligible, bundle)
+		}
+	}
+	for i := len(kept); i < len(s.bundles); i++ {
+		s.bundles[i] = nil
+	}
+	s.bundles = kept
+	return eligible
+}
+
+// all returns all bundles currently kept in the store.
+func (s *bundleStore) all() []*Bundle {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return append([]*Bundle(nil), s.bundles...)
+}
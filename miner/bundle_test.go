@@ -0,0 +1,199 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/params/vars"
+)
+
+func TestBundleStore(t *testing.T) {
+	store := newBundleStore()
+	if err := store.add(&Bundle{BlockNumber: 1}, 0, common.Address{}); err != errEmptyBundle {
+		t.Fatalf("empty bundle error mismatch: have %v, want %v", err, errEmptyBundle)
+	}
+	var (
+		tx1 = types.NewTransaction(0, common.Address{}, new(big.Int), 0, new(big.Int), nil)
+		tx2 = types.NewTransaction(1, common.Address{}, new(big.Int), 0, new(big.Int), nil)
+	)
+	bundles := []*Bundle{
+		{Txs: types.Transactions{tx1}, BlockNumber: 1},
+		{Txs: types.Transactions{tx1, tx2}, BlockNumber: 2},
+		{Txs: types.Transactions{tx2}, BlockNumber: 2, MinTimestamp: 10},
+		{Txs: types.Transactions{tx2, tx1}, BlockNumber: 2, MaxTimestamp: 5},
+	}
+	for i, bundle := range bundles {
+		if err := store.add(bundle, 0, common.Address{}); err != nil {
+			t.Fatalf("bundle %d: failed to add: %v", i, err)
+		}
+	}
+	if err := store.add(&Bundle{Txs: types.Transactions{tx1}, BlockNumber: 1}, 0, common.Address{}); err != errBundleKnown {
+		t.Fatalf("duplicate bundle error mismatch: have %v, want %v", err, errBundleKnown)
+	}
+	if pending := store.pending(1, 0); len(pending) != 1 || pending[0] != bundles[0] {
+		t.Fatalf("pending bundles mismatch for block 1: %v", pending)
+	}
+	if pending := store.pending(2, 7); len(pending) != 1 || pending[0] != bundles[1] {
+		t.Fatalf("pending bundles mismatch for block 2: %v", pending)
+	}
+	if have := len(store.all()); have != 3 {
+		t.Fatalf("stale bundles not dropped: have %d, want %d", have, 3)
+	}
+	if pending := store.pending(3, 0); len(pending) != 0 {
+		t.Fatalf("pending bundles mismatch for block 3: %v", pending)
+	}
+	if have := len(store.all()); have != 0 {
+		t.Fatalf("stale bundles not dropped: have %d, want %d", have, 0)
+	}
+}
+
+func TestBundleStoreLimits(t *testing.T) {
+	store := newBundleStore()
+
+	// newBundle creates a unique bundle targeting the given block.
+	nonce := uint64(0)
+	newBundle := func(number uint64) *Bundle {
+		nonce++
+		return &Bundle{Txs: types.Transactions{types.NewTransaction(nonce, common.Address{}, new(big.Int), 0, new(big.Int), nil)}, BlockNumber: number}
+	}
+	if err := store.add(newBundle(10), 10, common.Address{}); !errors.Is(err, errBundleStale) {
+		t.Fatalf("stale bundle error mismatch: have %v, want %v", err, errBundleStale)
+	}
+	if err := store.add(newBundle(11+maxBundleFutureBlocks), 10, common.Address{}); !errors.Is(err, errBundleTooFar) {
+		t.Fatalf("far bundle error mismatch: have %v, want %v", err, errBundleTooFar)
+	}
+	// Fill the allowance of a single sender
+	for i := 0; i < maxBundlesPerSender; i++ {
+		if err := store.add(newBundle(11), 10, common.Address{0x01}); err != nil {
+			t.Fatalf("bundle %d: failed to add: %v", i, err)
+		}
+	}
+	if err := store.add(newBundle(11), 10, common.Address{0x01}); !errors.Is(err, errBundleSenderLimit) {
+		t.Fatalf("sender limit error mismatch: have %v, want %v", err, errBundleSenderLimit)
+	}
+	// Fill the store with bundles of distinct senders targeting the farthest
+	// block, followed by nearer ones.
+	var far []*Bundle
+	for i := maxBundlesPerSender; i < maxBundles; i++ {
+		number := uint64(12)
+		if i < maxBundlesPerSender+4 {
+			number = 10 + maxBundleFutureBlocks
+		}
+		bundle := newBundle(number)
+		if number > 12 {
+			far = append(far, bundle)
+		}
+		if err := store.add(bundle, 10, common.Address{0x02, byte(i), byte(i >> 8)}); err != nil {
+			t.Fatalf("bundle %d: failed to add: %v", i, err)
+		}
+	}
+	// A new bundle must evict the oldest of the farthest ones
+	if err := store.add(newBundle(11), 10, common.Address{0x03}); err != nil {
+		t.Fatalf("failed to add bundle to full store: %v", err)
+	}
+	bundles := store.all()
+	if len(bundles) != maxBundles {
+		t.Fatalf("bundle count mismatch: have %d, want %d", len(bundles), maxBundles)
+	}
+	for _, bundle := range bundles {
+		if bundle == far[0] {
+			t.Fatal("farthest oldest bundle not evicted")
+		}
+	}
+	// A bundle farther than all kept ones must be rejected while full
+	if err := store.add(newBundle(11+maxBundleFutureBlocks), 11, common.Address{0x04}); !errors.Is(err, errBundleStoreFull) {
+		t.Fatalf("full store error mismatch: have %v, want %v", err, errBundleStoreFull)
+	}
+	store.pending(12, 0)
+	if err := store.add(newBundle(11+maxBundleFutureBlocks), 11, common.Address{0x04}); err != nil {
+		t.Fatalf("failed to add bundle after pruning: %v", err)
+	}
+	// Dropped bundles must release the allowance of their sender
+	if err := store.add(newBundle(13), 12, common.Address{0x01}); err != nil {
+		t.Fatalf("sender allowance not released: %v", err)
+	}
+}
+
+func TestCommitBundles(t *testing.T) {
+	engine := ethash.NewFaker()
+	defer engine.Close()
+
+	w, b := newTestWorker(t, ethashChainConfig, engine, rawdb.NewMemoryDatabase(), 0)
+	defer w.close()
+
+	var (
+		signer = types.LatestSigner(params.TestChainConfig)
+		price  = big.NewInt(10 * vars.InitialBaseFee)
+
+		// Plain transfer, replacing the pooled transaction with the same nonce
+		transfer = types.MustSignNewTx(testBankKey, signer, &types.LegacyTx{
+			Nonce:    0,
+			To:       &testUserAddress,
+			Value:    big.NewInt(2000),
+			Gas:      vars.TxGas,
+			GasPrice: price,
+		})
+		// Contract creation reverting in its init code
+		reverting = types.MustSignNewTx(testBankKey, signer, &types.LegacyTx{
+			Nonce:    1,
+			Value:    new(big.Int),
+			Gas:      100000,
+			GasPrice: price,
+			Data:     common.FromHex("0x60006000fd"),
+		})
+	)
+	if err := w.bundles.add(&Bundle{Txs: types.Transactions{transfer}, BlockNumber: 1}, 0, testBankAddress); err != nil {
+		t.Fatalf("failed to add bundle: %v", err)
+	}
+	if err := w.bundles.add(&Bundle{Txs: types.Transactions{reverting}, BlockNumber: 1}, 0, testBankAddress); err != nil {
+		t.Fatalf("failed to add bundle: %v", err)
+	}
+	block, _, err := w.getSealingBlock(b.chain.Genesis().Hash(), uint64(time.Now().Unix()), testBankAddress, common.Hash{}, nil, false)
+	if err != nil {
+		t.Fatalf("failed to generate block: %v", err)
+	}
+	if txs := block.Transactions(); len(txs) != 1 || txs[0].Hash() != transfer.Hash() {
+		t.Fatalf("block transactions mismatch: have %d txs, want only the bundled transfer", len(txs))
+	}
+	// Allowing the revert includes the bundle
+	allowed := types.MustSignNewTx(testBankKey, signer, &types.LegacyTx{
+		Nonce:    1,
+		Value:    new(big.Int),
+		Gas:      100001,
+		GasPrice: price,
+		Data:     common.FromHex("0x60006000fd"),
+	})
+	if err := w.bundles.add(&Bundle{Txs: types.Transactions{allowed}, BlockNumber: 1, RevertingTxHashes: []common.Hash{allowed.Hash()}}, 0, testBankAddress); err != nil {
+		t.Fatalf("failed to add bundle: %v", err)
+	}
+	block, _, err = w.getSealingBlock(b.chain.Genesis().Hash(), uint64(time.Now().Unix()), testBankAddress, common.Hash{}, nil, false)
+	if err != nil {
+		t.Fatalf("failed to generate block: %v", err)
+	}
+	if txs := block.Transactions(); len(txs) != 2 || txs[0].Hash() != transfer.Hash() || txs[1].Hash() != allowed.Hash() {
+		t.Fatalf("block transactions mismatch: have %d txs, want the bundled transfer and revert", len(txs))
+	}
+}
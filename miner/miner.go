@@ -57,6 +57,10 @@ type Config struct {
 	Noverify   bool           // Disable remote mining solution verification(only useful in ethash).
 
 	NewPayloadTimeout time.Duration // The maximum time allowance for creating a new payload
+
+	UncleRemote   string           `toml:",omitempty"` // Remote uncle inclusion policy (allow, prefer or deny)
+	UncleMaxDepth uint64           `toml:",omitempty"` // Maximum depth of included uncles, 0 if unlimited
+	UncleDeny     []common.Address `toml:",omitempty"` // Coinbases whose blocks are never included as uncles
 }
 
 // DefaultConfig contains default settings for miner.
@@ -246,6 +250,52 @@ func (miner *Miner) DisablePreseal() {
 	miner.worker.disablePreseal()
 }
 
+// BlockTemplate returns the candidate block currently being sealed, including
+// the fees paid by each transaction. If the miner is not sealing on top of the
+// chain head, a new candidate is assembled.
+func (miner *Miner) BlockTemplate() (*BlockTemplate, error) {
+	return miner.worker.blockTemplate()
+}
+
+// SubmitBlockTemplate validates an externally constructed, ordered transaction
+// list by executing it on top of the chain head, and seals the resulting block
+// instead of the internally built one until the next chain head arrives.
+func (miner *Miner) SubmitBlockTemplate(txs types.Transactions) (*BlockTemplate, error) {
+	return miner.worker.submitTemplate(txs)
+}
+
+// AddBundle submits a transaction bundle for inclusion at the top of the block
+// with the bundle's target number. The bundles kept are limited per sender of
+// the first bundled transaction.
+func (miner *Miner) AddBundle(bundle *Bundle) error {
+	if len(bundle.Txs) == 0 {
+		return errEmptyBundle
+	}
+	sender, err := types.Sender(types.LatestSigner(miner.worker.chainConfig), bundle.Txs[0])
+	if err != nil {
+		return err
+	}
+	head := miner.worker.chain.CurrentBlock().Number.Uint64()
+	return miner.worker.bundles.add(bundle, head, sender)
+}
+
+// Bundles returns the transaction bundles currently awaiting inclusion.
+func (miner *Miner) Bundles() []*Bundle {
+	return miner.worker.bundles.all()
+}
+
+// MinedBlocks returns the recently mined local blocks together with their chain
+// inclusion status and realized reward.
+func (miner *Miner) MinedBlocks() []*MinedBlock {
+	return miner.worker.unconfirmed.Mined()
+}
+
+// SubscribeMinedBlocks starts delivering locally mined blocks to the given
+// channel once their chain inclusion status is final.
+func (miner *Miner) SubscribeMinedBlocks(ch chan<- *MinedBlock) event.Subscription {
+	return miner.worker.unconfirmed.SubscribeMined(ch)
+}
+
 // SubscribePendingLogs starts delivering logs from pending transactions
 // to the given channel.
 func (miner *Miner) SubscribePendingLogs(ch chan<- []*types.Log) event.Subscription {
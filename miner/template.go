@@ -0,0 +1,192 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+var errNotMining = errors.New("miner not running")
+
+// BlockTemplate is a fully assembled candidate block, as handed to the consensus
+// engine for sealing, together with the fees paid by each of its transactions.
+type BlockTemplate struct {
+	Header       *types.Header `json:"header"`
+	SealHash     common.Hash   `json:"sealHash"`
+	Uncles       []common.Hash `json:"uncles"`
+	Transactions []*TemplateTx `json:"transactions"`
+	Fees         *hexutil.Big  `json:"fees"`
+}
+
+// TemplateTx is a transaction included in a block template.
+type TemplateTx struct {
+	Hash    common.Hash    `json:"hash"`
+	Raw     hexutil.Bytes  `json:"raw"`
+	GasUsed hexutil.Uint64 `json:"gasUsed"`
+	Fee     *hexutil.Big   `json:"fee"`
+}
+
+// newBlockTemplate creates the template describing the given candidate block.
+func (w *worker) newBlockTemplate(block *types.Block, receipts []*types.Receipt) (*BlockTemplate, error) {
+	template := &BlockTemplate{
+		Header:       block.Header(),
+		SealHash:     w.engine.SealHash(block.Header()),
+		Uncles:       make([]common.Hash, 0, len(block.Uncles())),
+		Transactions: make([]*TemplateTx, 0, len(block.Transactions())),
+		Fees:         (*hexutil.Big)(totalFees(block, receipts)),
+	}
+	for _, uncle := range block.Uncles() {
+		template.Uncles = append(template.Uncles, uncle.Hash())
+	}
+	for i, tx := range block.Transactions() {
+		raw, err := tx.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		tip, _ := tx.EffectiveGasTip(block.BaseFee())
+		template.Transactions = append(template.Transactions, &TemplateTx{
+			Hash:    tx.Hash(),
+			Raw:     raw,
+			GasUsed: hexutil.Uint64(receipts[i].GasUsed),
+			Fee:     (*hexutil.Big)(new(big.Int).Mul(tip, new(big.Int).SetUint64(receipts[i].GasUsed))),
+		})
+	}
+	return template, nil
+}
+
+// blockTemplate returns the candidate block currently being sealed, or assembles
+// a new one on top of the chain head if the miner isn't sealing on it.
+func (w *worker) blockTemplate() (*BlockTemplate, error) {
+	head := w.chain.CurrentBlock()
+
+	w.pendingMu.RLock()
+	task := w.latestTask
+	w.pendingMu.RUnlock()
+
+	if w.isRunning() && task != nil && task.block.ParentHash() == head.Hash() {
+		return w.newBlockTemplate(task.block, task.receipts)
+	}
+	req := &getWorkReq{
+		params: &generateParams{
+			timestamp:  uint64(time.Now().Unix()),
+			parentHash: head.Hash(),
+			coinbase:   w.etherbase(),
+		},
+		result: make(chan *newPayloadResult, 1),
+	}
+	select {
+	case w.getWorkCh <- req:
+		result := <-req.result
+		if result.err != nil {
+			return nil, result.err
+		}
+		return w.newBlockTemplate(result.block, result.receipts)
+	case <-w.exitCh:
+		return nil, errors.New("miner closed")
+	}
+}
+
+// submitTemplateReq represents a request to seal an externally constructed
+// transaction list on top of the chain head.
+type submitTemplateReq struct {
+	txs    types.Transactions
+	result chan *submitTemplateResult
+}
+
+// submitTemplateResult is the outcome of a block template submission.
+type submitTemplateResult struct {
+	template *BlockTemplate
+	err      error
+}
+
+// submitTemplate validates the given ordered transaction list by executing it
+// on top of the chain head, and hands the resulting block to the consensus
+// engine for sealing in place of the internally built one.
+func (w *worker) submitTemplate(txs types.Transactions) (*BlockTemplate, error) {
+	req := &submitTemplateReq{txs: txs, result: make(chan *submitTemplateResult, 1)}
+	select {
+	case w.submitTemplateCh <- req:
+		result := <-req.result
+		return result.template, result.err
+	case <-w.exitCh:
+		return nil, errors.New("miner closed")
+	}
+}
+
+// commitTemplate builds and commits the sealing block for an externally
+// submitted transaction list. Every transaction must execute successfully and
+// in the given order, otherwise the template is rejected.
+func (w *worker) commitTemplate(txs types.Transactions) (*BlockTemplate, error) {
+	if !w.isRunning() {
+		return nil, errNotMining
+	}
+	coinbase := w.etherbase()
+	if coinbase == (common.Address{}) {
+		return nil, errors.New("refusing to mine without etherbase")
+	}
+	start := time.Now()
+	env, err := w.prepareWork(&generateParams{
+		timestamp: uint64(start.Unix()),
+		coinbase:  coinbase,
+	})
+	if err != nil {
+		return nil, err
+	}
+	env.gasPool = new(core.GasPool).AddGas(env.header.GasLimit)
+	for i, tx := range txs {
+		if _, err := types.Sender(env.signer, tx); err != nil {
+			env.discard()
+			return nil, fmt.Errorf("tx %d (%x): %w", i, tx.Hash(), err)
+		}
+		if tx.Protected() && !w.chainConfig.IsEnabled(w.chainConfig.GetEIP155Transition, env.header.Number) {
+			env.discard()
+			return nil, fmt.Errorf("tx %d (%x): replay protected transaction before EIP155", i, tx.Hash())
+		}
+		env.state.SetTxContext(tx.Hash(), env.tcount)
+		if _, err := w.commitTransaction(env, tx); err != nil {
+			env.discard()
+			return nil, fmt.Errorf("tx %d (%x): %w", i, tx.Hash(), err)
+		}
+		env.tcount++
+	}
+	block, err := w.commit(env.copy(), nil, true, start)
+	if err != nil {
+		env.discard()
+		return nil, err
+	}
+	if block == nil {
+		env.discard()
+		return nil, errNotMining
+	}
+	if w.current != nil {
+		w.current.discard()
+	}
+	w.current = env
+	w.externalParent = env.header.ParentHash
+
+	log.Info("Committed external block template", "number", env.header.Number, "txs", env.tcount, "gas", env.header.GasUsed)
+	return w.newBlockTemplate(block, env.receipts)
+}
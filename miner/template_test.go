@@ -0,0 +1,112 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/params/vars"
+)
+
+func TestBlockTemplate(t *testing.T) {
+	engine := ethash.NewFaker()
+	defer engine.Close()
+
+	w, _ := newTestWorker(t, ethashChainConfig, engine, rawdb.NewMemoryDatabase(), 0)
+	defer w.close()
+
+	// Without sealing, a fresh template including the pooled transaction is built
+	template, err := w.blockTemplate()
+	if err != nil {
+		t.Fatalf("failed to retrieve block template: %v", err)
+	}
+	if len(template.Transactions) != 1 || template.Transactions[0].Hash != pendingTxs[0].Hash() {
+		t.Fatalf("template transactions mismatch: have %d, want 1", len(template.Transactions))
+	}
+	if template.Header.Number.Uint64() != 1 || template.SealHash != engine.SealHash(template.Header) {
+		t.Fatalf("template header mismatch: number %d, sealhash %x", template.Header.Number, template.SealHash)
+	}
+	want := new(big.Int).Mul(new(big.Int).SetUint64(vars.TxGas), new(big.Int).Sub(pendingTxs[0].GasPrice(), template.Header.BaseFee))
+	if template.Fees.ToInt().Cmp(want) != 0 || template.Transactions[0].Fee.ToInt().Cmp(want) != 0 {
+		t.Fatalf("template fees mismatch: have %v, want %v", template.Fees, want)
+	}
+	// Submitting a template requires the miner to be running
+	if _, err := w.submitTemplate(nil); err != errNotMining {
+		t.Fatalf("template submission error mismatch: have %v, want %v", err, errNotMining)
+	}
+}
+
+func TestSubmitBlockTemplate(t *testing.T) {
+	engine := ethash.NewFaker()
+	defer engine.Close()
+
+	w, _ := newTestWorker(t, ethashChainConfig, engine, rawdb.NewMemoryDatabase(), 0)
+	defer w.close()
+
+	tasks := make(chan *task, 16)
+	w.newTaskHook = func(task *task) { tasks <- task }
+	w.skipSealHook = func(task *task) bool { return true }
+	w.start()
+
+	var (
+		signer   = types.LatestSigner(params.TestChainConfig)
+		external = types.MustSignNewTx(testBankKey, signer, &types.LegacyTx{
+			Nonce:    0,
+			To:       &testUserAddress,
+			Value:    big.NewInt(5000),
+			Gas:      vars.TxGas,
+			GasPrice: big.NewInt(2 * vars.InitialBaseFee),
+		})
+		invalid = types.MustSignNewTx(testBankKey, signer, &types.LegacyTx{
+			Nonce:    5,
+			To:       &testUserAddress,
+			Value:    big.NewInt(5000),
+			Gas:      vars.TxGas,
+			GasPrice: big.NewInt(2 * vars.InitialBaseFee),
+		})
+	)
+	if _, err := w.submitTemplate(types.Transactions{external, invalid}); err == nil {
+		t.Fatalf("invalid template accepted")
+	}
+	template, err := w.submitTemplate(types.Transactions{external})
+	if err != nil {
+		t.Fatalf("failed to submit template: %v", err)
+	}
+	if len(template.Transactions) != 1 || template.Transactions[0].Hash != external.Hash() {
+		t.Fatalf("template transactions mismatch: have %d, want 1", len(template.Transactions))
+	}
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case task := <-tasks:
+			if txs := task.block.Transactions(); len(txs) == 1 && txs[0].Hash() == external.Hash() {
+				if sealhash := w.engine.SealHash(task.block.Header()); sealhash != template.SealHash {
+					t.Fatalf("sealed block mismatch: have %x, want %x", sealhash, template.SealHash)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatalf("external template not sealed")
+		}
+	}
+}
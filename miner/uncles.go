@@ -0,0 +1,133 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/params/mutations"
+	"github.com/ethereum/go-ethereum/params/types/ctypes"
+	"github.com/ethereum/go-ethereum/params/vars"
+)
+
+// Remote uncle inclusion policies.
+const (
+	UncleRemoteAllow  = "allow"  // Include remote uncles after the locally mined ones
+	UncleRemotePrefer = "prefer" // Include remote uncles before the locally mined ones
+	UncleRemoteDeny   = "deny"   // Never include remote uncles
+)
+
+// Names of the uncle policies an uncle can be rejected by.
+const (
+	unclePolicyRemote   = "remote"
+	unclePolicyDepth    = "depth"
+	unclePolicyCoinbase = "coinbase"
+)
+
+// uncleRewardMetrics tracks the number of uncles and the accumulated nephew
+// rewards, in Gwei, either collected or forgone.
+type uncleRewardMetrics struct {
+	count  metrics.Meter
+	reward metrics.Counter
+}
+
+func newUncleRewardMetrics(name string) *uncleRewardMetrics {
+	return &uncleRewardMetrics{
+		count:  metrics.NewRegisteredMeter("miner/uncles/"+name+"/count", nil),
+		reward: metrics.NewRegisteredCounter("miner/uncles/"+name+"/reward", nil),
+	}
+}
+
+// mark accounts for uncles worth the given nephew reward in Wei.
+func (m *uncleRewardMetrics) mark(uncles int, reward *big.Int) {
+	m.count.Mark(int64(uncles))
+	m.reward.Inc(new(big.Int).Div(reward, big.NewInt(vars.GWei)).Int64())
+}
+
+var (
+	uncleCollectedMetrics = newUncleRewardMetrics("collected")
+	uncleForgoneMetrics   = map[string]*uncleRewardMetrics{
+		unclePolicyRemote:   newUncleRewardMetrics("forgone/" + unclePolicyRemote),
+		unclePolicyDepth:    newUncleRewardMetrics("forgone/" + unclePolicyDepth),
+		unclePolicyCoinbase: newUncleRewardMetrics("forgone/" + unclePolicyCoinbase),
+	}
+)
+
+// unclePolicy decides which valid side blocks are included as uncles.
+type unclePolicy struct {
+	remote   string                      // Remote uncle inclusion policy
+	maxDepth uint64                      // Maximum distance between the sealing block and the uncle, 0 if unlimited
+	deny     map[common.Address]struct{} // Coinbases never included as uncles
+}
+
+// newUnclePolicy creates the uncle policy described by the miner config,
+// sanitizing any invalid settings.
+func newUnclePolicy(config *Config) *unclePolicy {
+	policy := &unclePolicy{
+		remote:   config.UncleRemote,
+		maxDepth: config.UncleMaxDepth,
+		deny:     make(map[common.Address]struct{}),
+	}
+	switch policy.remote {
+	case UncleRemoteAllow, UncleRemotePrefer, UncleRemoteDeny:
+	case "":
+		policy.remote = UncleRemoteAllow
+	default:
+		log.Warn("Sanitizing invalid remote uncle policy", "provided", policy.remote, "updated", UncleRemoteAllow)
+		policy.remote = UncleRemoteAllow
+	}
+	for _, coinbase := range config.UncleDeny {
+		policy.deny[coinbase] = struct{}{}
+	}
+	return policy
+}
+
+// reject returns the name of the policy rejecting the given uncle for inclusion
+// in the block with the given header, or an empty string if it is acceptable.
+func (p *unclePolicy) reject(header, uncle *types.Header, local bool) string {
+	if !local && p.remote == UncleRemoteDeny {
+		return unclePolicyRemote
+	}
+	if p.maxDepth > 0 && new(big.Int).Sub(header.Number, uncle.Number).Uint64() > p.maxDepth {
+		return unclePolicyDepth
+	}
+	if _, ok := p.deny[uncle.Coinbase]; ok {
+		return unclePolicyCoinbase
+	}
+	return ""
+}
+
+// order returns the uncle candidate sets in the order they should be included.
+// Denied remote uncles are still returned, so they are accounted as forgone.
+func (p *unclePolicy) order(local, remote map[common.Hash]*types.Block) []map[common.Hash]*types.Block {
+	if p.remote == UncleRemotePrefer {
+		return []map[common.Hash]*types.Block{remote, local}
+	}
+	return []map[common.Hash]*types.Block{local, remote}
+}
+
+// nephewReward returns the extra reward the miner of the block with the given
+// header earns by including the given uncles.
+func nephewReward(config ctypes.ChainConfigurator, header *types.Header, uncles []*types.Header) *big.Int {
+	with, _ := mutations.GetRewards(config, header, uncles)
+	without, _ := mutations.GetRewards(config, header, nil)
+	return with.Sub(with, without)
+}
@@ -0,0 +1,95 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestUnclePolicy(t *testing.T) {
+	var (
+		header = &types.Header{Number: big.NewInt(10)}
+		denied = common.HexToAddress("0xdead")
+	)
+	tests := []struct {
+		config Config
+		uncle  *types.Header
+		local  bool
+		want   string
+	}{
+		{Config{}, &types.Header{Number: big.NewInt(3)}, false, ""},
+		{Config{UncleRemote: "bogus"}, &types.Header{Number: big.NewInt(9)}, false, ""},
+		{Config{UncleRemote: UncleRemoteDeny}, &types.Header{Number: big.NewInt(9)}, false, unclePolicyRemote},
+		{Config{UncleRemote: UncleRemoteDeny}, &types.Header{Number: big.NewInt(9)}, true, ""},
+		{Config{UncleMaxDepth: 2}, &types.Header{Number: big.NewInt(8)}, false, ""},
+		{Config{UncleMaxDepth: 2}, &types.Header{Number: big.NewInt(7)}, true, unclePolicyDepth},
+		{Config{UncleDeny: []common.Address{denied}}, &types.Header{Number: big.NewInt(9), Coinbase: denied}, true, unclePolicyCoinbase},
+		{Config{UncleDeny: []common.Address{denied}}, &types.Header{Number: big.NewInt(9)}, false, ""},
+	}
+	for i, tt := range tests {
+		if have := newUnclePolicy(&tt.config).reject(header, tt.uncle, tt.local); have != tt.want {
+			t.Errorf("test %d: rejecting policy mismatch: have %q, want %q", i, have, tt.want)
+		}
+	}
+	local := map[common.Hash]*types.Block{}
+	remote := map[common.Hash]*types.Block{}
+	if order := newUnclePolicy(&Config{UncleRemote: UncleRemotePrefer}).order(local, remote); len(order) != 2 || len(order[0]) != len(remote) {
+		t.Errorf("preferred remote uncles not ordered first")
+	}
+}
+
+func TestUncleDenyCoinbase(t *testing.T) {
+	engine := ethash.NewFaker()
+	defer engine.Close()
+
+	w, b := newTestWorker(t, ethashChainConfig, engine, rawdb.NewMemoryDatabase(), 1)
+	defer w.close()
+
+	// The uncle policy is only ever accessed by the main loop, which is idle until
+	// the side blocks are posted.
+	w.unclePolicy = newUnclePolicy(&Config{UncleDeny: []common.Address{b.uncleBlock.Coinbase()}})
+
+	allowed := b.newRandomUncle()
+	w.postSideBlock(core.ChainSideEvent{Block: b.uncleBlock})
+	w.postSideBlock(core.ChainSideEvent{Block: allowed})
+
+	// Side blocks are processed in order, wait until the allowed one is included
+	for deadline := time.Now().Add(3 * time.Second); ; {
+		template, err := w.blockTemplate()
+		if err != nil {
+			t.Fatalf("failed to retrieve block template: %v", err)
+		}
+		if len(template.Uncles) > 0 {
+			if len(template.Uncles) != 1 || template.Uncles[0] != allowed.Hash() {
+				t.Fatalf("uncles mismatch: have %v, want only %x", template.Uncles, allowed.Hash())
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("allowed uncle not included")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
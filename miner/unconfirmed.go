@@ -18,13 +18,40 @@ package miner
 
 import (
 	"container/ring"
+	"math/big"
 	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params/mutations"
+	"github.com/ethereum/go-ethereum/params/types/ctypes"
 )
 
+// minedHistoryLimit is the maximum number of locally mined blocks with a final
+// status retained for reporting.
+const minedHistoryLimit = 1024
+
+// Chain inclusion statuses of locally mined blocks.
+const (
+	MinedBlockPending   = "pending"   // Not yet deep enough to be final
+	MinedBlockCanonical = "canonical" // Part of the canonical chain
+	MinedBlockUncled    = "uncled"    // Included as an uncle by a canonical block
+	MinedBlockOrphaned  = "orphaned"  // Neither canonical nor included as an uncle
+)
+
+// MinedBlock describes a locally mined block, its chain inclusion status and
+// the consensus reward realized by its miner.
+type MinedBlock struct {
+	Number     hexutil.Uint64 `json:"number"`
+	Hash       common.Hash    `json:"hash"`
+	Status     string         `json:"status"`
+	IncludedIn *common.Hash   `json:"includedIn,omitempty"` // Canonical block including the uncle
+	Reward     *hexutil.Big   `json:"reward"`
+}
+
 // chainRetriever is used by the unconfirmed block set to verify whether a previously
 // mined block is part of the canonical chain or not.
 type chainRetriever interface {
@@ -33,6 +60,9 @@ type chainRetriever interface {
 
 	// GetBlockByNumber retrieves the canonical block associated with a block number.
 	GetBlockByNumber(number uint64) *types.Block
+
+	// Config retrieves the chain's fork configuration.
+	Config() ctypes.ChainConfigurator
 }
 
 // unconfirmedBlock is a small collection of metadata about a locally mined block
@@ -50,7 +80,10 @@ type unconfirmedBlocks struct {
 	chain  chainRetriever // Blockchain to verify canonical status through
 	depth  uint           // Depth after which to discard previous blocks
 	blocks *ring.Ring     // Block infos to allow canonical chain cross checks
+	mined  []*MinedBlock  // Recently mined blocks with a final status, oldest first
 	lock   sync.Mutex     // Protects the fields from concurrent access
+
+	minedFeed event.Feed // Feed announcing mined blocks reaching a final status
 }
 
 // newUnconfirmedBlocks returns new data structure to track currently unconfirmed blocks.
@@ -85,13 +118,50 @@ func (set *unconfirmedBlocks) Insert(index uint64, hash common.Hash) {
 	log.Info("🔨 mined potential block", "number", index, "hash", hash)
 }
 
+// Mined returns the recently mined blocks, the ones with a final status first,
+// followed by the ones still pending confirmation.
+func (set *unconfirmedBlocks) Mined() []*MinedBlock {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+
+	blocks := make([]*MinedBlock, len(set.mined))
+	copy(blocks, set.mined)
+	if set.blocks != nil {
+		set.blocks.Do(func(item interface{}) {
+			block := item.(*unconfirmedBlock)
+			blocks = append(blocks, &MinedBlock{
+				Number: hexutil.Uint64(block.index),
+				Hash:   block.hash,
+				Status: MinedBlockPending,
+			})
+		})
+	}
+	return blocks
+}
+
+// SubscribeMined registers a subscription for locally mined blocks reaching a
+// final chain inclusion status.
+func (set *unconfirmedBlocks) SubscribeMined(ch chan<- *MinedBlock) event.Subscription {
+	return set.minedFeed.Subscribe(ch)
+}
+
 // Shift drops all unconfirmed blocks from the set which exceed the unconfirmed sets depth
 // allowance, checking them against the canonical chain for inclusion or staleness
 // report.
 func (set *unconfirmedBlocks) Shift(height uint64) {
+	// Announce the final statuses without holding the lock, subscribers may be slow
+	for _, mined := range set.shift(height) {
+		set.minedFeed.Send(mined)
+	}
+}
+
+// shift is the internal version of Shift, returning the dropped blocks together
+// with their final status.
+func (set *unconfirmedBlocks) shift(height uint64) []*MinedBlock {
 	set.lock.Lock()
 	defer set.lock.Unlock()
 
+	var resolved []*MinedBlock
 	for set.blocks != nil {
 		// Retrieve the next unconfirmed block and abort if too fresh
 		next := set.blocks.Value.(*unconfirmedBlock)
@@ -99,20 +169,36 @@ func (set *unconfirmedBlocks) Shift(height uint64) {
 			break
 		}
 		// Block seems to exceed depth allowance, check for canonical status
+		mined := &MinedBlock{
+			Number: hexutil.Uint64(next.index),
+			Hash:   next.hash,
+			Status: MinedBlockOrphaned,
+			Reward: new(hexutil.Big),
+		}
 		header := set.chain.GetHeaderByNumber(next.index)
 		switch {
 		case header == nil:
 			log.Warn("Failed to retrieve header of mined block", "number", next.index, "hash", next.hash)
+			mined = nil
 		case header.Hash() == next.hash:
 			log.Info("🔗 block reached canonical chain", "number", next.index, "hash", next.hash)
+			mined.Status = MinedBlockCanonical
+			if block := set.chain.GetBlockByNumber(next.index); block != nil {
+				reward, _ := set.rewards(block)
+				mined.Reward = (*hexutil.Big)(reward)
+			}
 		default:
 			// Block is not canonical, check whether we have an uncle or a lost block
 			included := false
 			for number := next.index; !included && number < next.index+uint64(set.depth) && number <= height; number++ {
 				if block := set.chain.GetBlockByNumber(number); block != nil {
-					for _, uncle := range block.Uncles() {
+					for i, uncle := range block.Uncles() {
 						if uncle.Hash() == next.hash {
 							included = true
+
+							hash := block.Hash()
+							_, rewards := set.rewards(block)
+							mined.Status, mined.IncludedIn, mined.Reward = MinedBlockUncled, &hash, (*hexutil.Big)(rewards[i])
 							break
 						}
 					}
@@ -124,6 +210,12 @@ func (set *unconfirmedBlocks) Shift(height uint64) {
 				log.Info("😱 block lost", "number", next.index, "hash", next.hash)
 			}
 		}
+		if mined != nil {
+			resolved = append(resolved, mined)
+			if set.mined = append(set.mined, mined); len(set.mined) > minedHistoryLimit {
+				set.mined = set.mined[len(set.mined)-minedHistoryLimit:]
+			}
+		}
 		// Drop the block out of the ring
 		if set.blocks.Value == set.blocks.Next().Value {
 			set.blocks = nil
@@ -133,4 +225,20 @@ func (set *unconfirmedBlocks) Shift(height uint64) {
 			set.blocks = set.blocks.Move(1)
 		}
 	}
+	return resolved
+}
+
+// rewards returns the consensus rewards of the given canonical block's miner and
+// of its uncles' miners. Only proof-of-work chains pay such rewards.
+func (set *unconfirmedBlocks) rewards(block *types.Block) (*big.Int, []*big.Int) {
+	uncles := block.Uncles()
+	config := set.chain.Config()
+	if config.GetConsensusEngineType() != ctypes.ConsensusEngineT_Ethash {
+		rewards := make([]*big.Int, len(uncles))
+		for i := range rewards {
+			rewards[i] = new(big.Int)
+		}
+		return new(big.Int), rewards
+	}
+	return mutations.GetRewards(config, block.Header(), uncles)
 }
@@ -17,9 +17,18 @@
 package miner
 
 import (
+	"math/big"
+	"reflect"
 	"testing"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/params/types/ctypes"
+	"github.com/ethereum/go-ethereum/params/types/genesisT"
 )
 
 // noopChainRetriever is an implementation of headerRetriever that always
@@ -32,6 +41,9 @@ func (r *noopChainRetriever) GetHeaderByNumber(number uint64) *types.Header {
 func (r *noopChainRetriever) GetBlockByNumber(number uint64) *types.Block {
 	return nil
 }
+func (r *noopChainRetriever) Config() ctypes.ChainConfigurator {
+	return params.TestChainConfig
+}
 
 // Tests that inserting blocks into the unconfirmed set accumulates them until
 // the desired depth is reached, after which they begin to be dropped.
@@ -85,3 +97,87 @@ func TestUnconfirmedShifts(t *testing.T) {
 		t.Errorf("unconfirmed count mismatch: have %d, want %d", n, 0)
 	}
 }
+
+// testChainRetriever is an implementation of chainRetriever serving a fixed
+// canonical chain.
+type testChainRetriever struct {
+	blocks map[uint64]*types.Block
+}
+
+func (r *testChainRetriever) GetHeaderByNumber(number uint64) *types.Header {
+	if block := r.blocks[number]; block != nil {
+		return block.Header()
+	}
+	return nil
+}
+func (r *testChainRetriever) GetBlockByNumber(number uint64) *types.Block {
+	return r.blocks[number]
+}
+func (r *testChainRetriever) Config() ctypes.ChainConfigurator {
+	return params.TestChainConfig
+}
+
+// Tests that mined blocks are reported with their final chain inclusion status
+// and realized reward once they exceed the depth allowance.
+func TestUnconfirmedMinedStatus(t *testing.T) {
+	engine := ethash.NewFaker()
+	defer engine.Close()
+
+	// Create a canonical chain including a competing block of the second one as an uncle
+	gspec := &genesisT.Genesis{Config: params.TestChainConfig}
+	_, blocks, _ := core.GenerateChainWithGenesis(gspec, engine, 6, func(i int, gen *core.BlockGen) {
+		if i == 2 {
+			gen.AddUncle(&types.Header{
+				ParentHash: gen.PrevBlock(0).Hash(),
+				Number:     big.NewInt(2),
+				Coinbase:   common.Address{0x01},
+			})
+		}
+	})
+	chain := &testChainRetriever{blocks: make(map[uint64]*types.Block)}
+	for _, block := range blocks {
+		chain.blocks[block.NumberU64()] = block
+	}
+	var (
+		uncle  = blocks[2].Uncles()[0].Hash()
+		orphan = common.Hash{0x02}
+	)
+	pool := newUnconfirmedBlocks(chain, 3)
+
+	mined := make(chan *MinedBlock, 3)
+	sub := pool.SubscribeMined(mined)
+	defer sub.Unsubscribe()
+
+	pool.Insert(1, blocks[0].Hash())
+	pool.Insert(2, uncle)
+	pool.Insert(2, orphan)
+	for _, block := range pool.Mined() {
+		if block.Status != MinedBlockPending {
+			t.Fatalf("block %x: status mismatch: have %s, want %s", block.Hash, block.Status, MinedBlockPending)
+		}
+	}
+	pool.Shift(6)
+
+	var (
+		blockReward = ctypes.EthashBlockReward(params.TestChainConfig, big.NewInt(1))
+		uncleReward = new(big.Int).Div(new(big.Int).Mul(blockReward, big.NewInt(7)), big.NewInt(8))
+		includedIn  = blocks[2].Hash()
+	)
+	want := []*MinedBlock{
+		{Number: 1, Hash: blocks[0].Hash(), Status: MinedBlockCanonical, Reward: (*hexutil.Big)(blockReward)},
+		{Number: 2, Hash: uncle, Status: MinedBlockUncled, IncludedIn: &includedIn, Reward: (*hexutil.Big)(uncleReward)},
+		{Number: 2, Hash: orphan, Status: MinedBlockOrphaned, Reward: new(hexutil.Big)},
+	}
+	have := pool.Mined()
+	if len(have) != len(want) {
+		t.Fatalf("mined block count mismatch: have %d, want %d", len(have), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(have[i], want[i]) {
+			t.Errorf("mined block %d mismatch: have %+v, want %+v", i, have[i], want[i])
+		}
+		if announced := <-mined; announced != have[i] {
+			t.Errorf("announced block %d mismatch: have %+v, want %+v", i, announced, have[i])
+		}
+	}
+}
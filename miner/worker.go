@@ -174,9 +174,10 @@ type newWorkReq struct {
 
 // newPayloadResult represents a result struct corresponds to payload generation.
 type newPayloadResult struct {
-	err   error
-	block *types.Block
-	fees  *big.Int
+	err      error
+	block    *types.Block
+	fees     *big.Int
+	receipts []*types.Receipt
 }
 
 // getWorkReq represents a request for getting a new sealing work with provided parameters.
@@ -215,6 +216,7 @@ type worker struct {
 	// Channels
 	newWorkCh          chan *newWorkReq
 	getWorkCh          chan *getWorkReq
+	submitTemplateCh   chan *submitTemplateReq
 	taskCh             chan *task
 	resultCh           chan *types.Block
 	startCh            chan struct{}
@@ -228,6 +230,10 @@ type worker struct {
 	localUncles  map[common.Hash]*types.Block // A set of side blocks generated locally as the possible uncle blocks.
 	remoteUncles map[common.Hash]*types.Block // A set of side blocks as the possible uncle blocks.
 	unconfirmed  *unconfirmedBlocks           // A set of locally mined blocks pending canonicalness confirmations.
+	bundles      *bundleStore                 // A set of transaction bundles to be included at the top of blocks.
+
+	unclePolicy   *unclePolicy           // The policy deciding which valid side blocks are included as uncles.
+	forgoneUncles map[common.Hash]uint64 // Numbers of the uncles rejected by the uncle policy, tracked to meter them once.
 
 	mu       sync.RWMutex // The lock used to protect the coinbase and extra fields
 	coinbase common.Address
@@ -235,6 +241,9 @@ type worker struct {
 
 	pendingMu    sync.RWMutex
 	pendingTasks map[common.Hash]*task
+	latestTask   *task // The most recent task pushed to the consensus engine
+
+	externalParent common.Hash // Parent of the externally submitted block template being sealed, if any
 
 	snapshotMu       sync.RWMutex // The lock used to protect the snapshots below
 	snapshotBlock    *types.Block
@@ -285,6 +294,9 @@ func newWorker(config *Config, chainConfig ctypes.ChainConfigurator, engine cons
 		localUncles:        make(map[common.Hash]*types.Block),
 		remoteUncles:       make(map[common.Hash]*types.Block),
 		unconfirmed:        newUnconfirmedBlocks(eth.BlockChain(), sealingLogAtDepth),
+		bundles:            newBundleStore(),
+		unclePolicy:        newUnclePolicy(config),
+		forgoneUncles:      make(map[common.Hash]uint64),
 		coinbase:           config.Etherbase,
 		extra:              config.ExtraData,
 		pendingTasks:       make(map[common.Hash]*task),
@@ -293,6 +305,7 @@ func newWorker(config *Config, chainConfig ctypes.ChainConfigurator, engine cons
 		chainSideCh:        make(chan core.ChainSideEvent, chainSideChanSize),
 		newWorkCh:          make(chan *newWorkReq),
 		getWorkCh:          make(chan *getWorkReq),
+		submitTemplateCh:   make(chan *submitTemplateReq),
 		taskCh:             make(chan *task),
 		resultCh:           make(chan *types.Block, resultQueueSize),
 		startCh:            make(chan struct{}, 1),
@@ -577,12 +590,18 @@ func (w *worker) mainLoop() {
 			w.commitWork(req.interrupt, req.noempty, req.timestamp)
 
 		case req := <-w.getWorkCh:
-			block, fees, err := w.generateWork(req.params)
+			block, fees, receipts, err := w.generateWork(req.params)
 			req.result <- &newPayloadResult{
-				err:   err,
-				block: block,
-				fees:  fees,
+				err:      err,
+				block:    block,
+				fees:     fees,
+				receipts: receipts,
 			}
+
+		case req := <-w.submitTemplateCh:
+			template, err := w.commitTemplate(req.txs)
+			req.result <- &submitTemplateResult{template: template, err: err}
+
 		case ev := <-w.chainSideCh:
 			// Short circuit for duplicate side blocks
 			if _, exist := w.localUncles[ev.Block.Hash()]; exist {
@@ -600,7 +619,7 @@ func (w *worker) mainLoop() {
 			// If our sealing block contains less than 2 uncle blocks,
 			// add the new uncle block if valid and regenerate a new
 			// sealing block for higher profit.
-			if w.isRunning() && w.current != nil && len(w.current.uncles) < 2 {
+			if w.isRunning() && w.current != nil && len(w.current.uncles) < 2 && w.externalParent == (common.Hash{}) {
 				start := time.Now()
 				if err := w.commitUncle(w.current, ev.Block.Header()); err == nil {
 					w.commit(w.current.copy(), nil, true, start)
@@ -619,6 +638,11 @@ func (w *worker) mainLoop() {
 					delete(w.remoteUncles, hash)
 				}
 			}
+			for hash, number := range w.forgoneUncles {
+				if number+staleThreshold <= chainHead.Number.Uint64() {
+					delete(w.forgoneUncles, hash)
+				}
+			}
 
 		case ev := <-w.txsCh:
 			// Apply transactions to the pending state if we're not sealing
@@ -704,6 +728,7 @@ func (w *worker) taskLoop() {
 			}
 			w.pendingMu.Lock()
 			w.pendingTasks[sealHash] = task
+			w.latestTask = task
 			w.pendingMu.Unlock()
 
 			if err := w.engine.Seal(w.chain, task.block, w.resultCh, stopCh); err != nil {
@@ -786,6 +811,10 @@ func (w *worker) resultLoop() {
 			// Insert the block into the set of pending ones to resultLoop for confirmations
 			w.unconfirmed.Insert(block.NumberU64(), block.Hash())
 
+			if uncles := block.Uncles(); len(uncles) > 0 {
+				uncleCollectedMetrics.mark(len(uncles), nephewReward(w.chainConfig, block.Header(), uncles))
+			}
+
 		case <-w.exitCh:
 			return
 		}
@@ -843,6 +872,14 @@ func (w *worker) commitUncle(env *environment, uncle *types.Header) error {
 	if env.family.Contains(hash) {
 		return errors.New("uncle already included")
 	}
+	_, local := w.localUncles[hash]
+	if policy := w.unclePolicy.reject(env.header, uncle, local); policy != "" {
+		if _, exist := w.forgoneUncles[hash]; !exist {
+			w.forgoneUncles[hash] = uncle.Number.Uint64()
+			uncleForgoneMetrics[policy].mark(1, nephewReward(w.chainConfig, env.header, []*types.Header{uncle}))
+		}
+		return fmt.Errorf("uncle rejected by %s policy", policy)
+	}
 	env.uncles[hash] = uncle
 	return nil
 }
@@ -972,6 +1009,62 @@ func (w *worker) commitTransactions(env *environment, txs *types.TransactionsByP
 	return nil
 }
 
+// commitBundle executes the transactions of the given bundle on top of the
+// sealing block. If any transaction fails or reverts without being allowed to,
+// the sealing block is left untouched and an error is returned.
+func (w *worker) commitBundle(env *environment, bundle *Bundle) error {
+	// Transactions are finalised one by one, so state snapshots can't be used
+	// to roll back the whole bundle. Execute it on a copy instead.
+	work := env.copy()
+	for _, tx := range bundle.Txs {
+		if tx.Protected() && !w.chainConfig.IsEnabled(w.chainConfig.GetEIP155Transition, work.header.Number) {
+			work.discard()
+			return fmt.Errorf("replay protected transaction %x before EIP155", tx.Hash())
+		}
+		work.state.SetTxContext(tx.Hash(), work.tcount)
+		if _, err := w.commitTransaction(work, tx); err != nil {
+			work.discard()
+			return fmt.Errorf("transaction %x failed: %w", tx.Hash(), err)
+		}
+		if receipt := work.receipts[len(work.receipts)-1]; receipt.Status == types.ReceiptStatusFailed && !bundle.mayRevert(tx.Hash()) {
+			work.discard()
+			return fmt.Errorf("transaction %x reverted", tx.Hash())
+		}
+		work.tcount++
+	}
+	// The bundle was applied successfully, adopt the modified environment and
+	// restart the prefetcher on its state.
+	env.discard()
+	*env = *work
+	env.state.StartPrefetcher("miner")
+	return nil
+}
+
+// commitBundles includes all bundles eligible for the sealing block, in
+// submission order. Bundles which cannot be included atomically are skipped.
+func (w *worker) commitBundles(env *environment, interrupt *int32) error {
+	bundles := w.bundles.pending(env.header.Number.Uint64(), env.header.Time)
+	if len(bundles) == 0 {
+		return nil
+	}
+	if env.gasPool == nil {
+		env.gasPool = new(core.GasPool).AddGas(env.header.GasLimit)
+	}
+	for _, bundle := range bundles {
+		if interrupt != nil {
+			if signal := atomic.LoadInt32(interrupt); signal != commitInterruptNone {
+				return signalToErr(signal)
+			}
+		}
+		if err := w.commitBundle(env, bundle); err != nil {
+			log.Debug("Bundle skipped", "hash", bundle.Hash(), "txs", len(bundle.Txs), "err", err)
+			continue
+		}
+		log.Debug("Committed bundle", "hash", bundle.Hash(), "txs", len(bundle.Txs))
+	}
+	return nil
+}
+
 // generateParams wraps various of settings for generating sealing task.
 type generateParams struct {
 	timestamp   uint64            // The timstamp for sealing task
@@ -1081,9 +1174,10 @@ func (w *worker) prepareWork(genParams *generateParams) (*environment, error) {
 				}
 			}
 		}
-		// Prefer to locally generated uncle
-		commitUncles(w.localUncles)
-		commitUncles(w.remoteUncles)
+		// Locally generated uncles are preferred, unless configured otherwise
+		for _, uncles := range w.unclePolicy.order(w.localUncles, w.remoteUncles) {
+			commitUncles(uncles)
+		}
 	}
 	return env, nil
 }
@@ -1092,6 +1186,10 @@ func (w *worker) prepareWork(genParams *generateParams) (*environment, error) {
 // into the given sealing block. The transaction selection and ordering strategy can
 // be customized with the plugin in the future.
 func (w *worker) fillTransactions(interrupt *int32, env *environment) error {
+	// Place the submitted bundles at the top of the block
+	if err := w.commitBundles(env, interrupt); err != nil {
+		return err
+	}
 	// Split the pending transactions into locals and remotes
 	// Fill the block with all available pending transactions.
 	pending := w.eth.TxPool().Pending(true)
@@ -1118,10 +1216,10 @@ func (w *worker) fillTransactions(interrupt *int32, env *environment) error {
 }
 
 // generateWork generates a sealing block based on the given parameters.
-func (w *worker) generateWork(params *generateParams) (*types.Block, *big.Int, error) {
+func (w *worker) generateWork(params *generateParams) (*types.Block, *big.Int, []*types.Receipt, error) {
 	work, err := w.prepareWork(params)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	defer work.discard()
 
@@ -1139,9 +1237,9 @@ func (w *worker) generateWork(params *generateParams) (*types.Block, *big.Int, e
 	}
 	block, err := w.engine.FinalizeAndAssemble(w.chain, work.header, work.state, work.txs, work.unclelist(), work.receipts, params.withdrawals)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
-	return block, totalFees(block, work.receipts), nil
+	return block, totalFees(block, work.receipts), work.receipts, nil
 }
 
 // commitWork generates several new sealing tasks based on the parent block
@@ -1149,6 +1247,14 @@ func (w *worker) generateWork(params *generateParams) (*types.Block, *big.Int, e
 func (w *worker) commitWork(interrupt *int32, noempty bool, timestamp int64) {
 	start := time.Now()
 
+	// Keep sealing an externally submitted template until a new head arrives.
+	if w.externalParent != (common.Hash{}) {
+		if w.externalParent == w.chain.CurrentBlock().Hash() {
+			return
+		}
+		w.externalParent = common.Hash{}
+	}
+
 	// Set the coinbase if the worker is running or it's required
 	var coinbase common.Address
 	if w.isRunning() {
@@ -1211,10 +1317,12 @@ func (w *worker) commitWork(interrupt *int32, noempty bool, timestamp int64) {
 }
 
 // commit runs any post-transaction state modifications, assembles the final block
-// and commits new work if consensus engine is running.
+// and commits new work if consensus engine is running, returning the block sent
+// for sealing, if any.
 // Note the assumption is held that the mutation is allowed to the passed env, do
 // the deep copy first.
-func (w *worker) commit(env *environment, interval func(), update bool, start time.Time) error {
+func (w *worker) commit(env *environment, interval func(), update bool, start time.Time) (*types.Block, error) {
+	var block *types.Block
 	if w.isRunning() {
 		if interval != nil {
 			interval()
@@ -1223,9 +1331,10 @@ func (w *worker) commit(env *environment, interval func(), update bool, start ti
 		// https://github.com/ethereum/go-ethereum/issues/24299
 		env := env.copy()
 		// Withdrawals are set to nil here, because this is only called in PoW.
-		block, err := w.engine.FinalizeAndAssemble(w.chain, env.header, env.state, env.txs, env.unclelist(), env.receipts, nil)
+		var err error
+		block, err = w.engine.FinalizeAndAssemble(w.chain, env.header, env.state, env.txs, env.unclelist(), env.receipts, nil)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		// If we're post merge, just ignore
 		if !w.isTTDReached(block.Header()) {
@@ -1248,7 +1357,7 @@ func (w *worker) commit(env *environment, interval func(), update bool, start ti
 	if update {
 		w.updateSnapshot(env)
 	}
-	return nil
+	return block, nil
 }
 
 // getSealingBlock generates the sealing block based on the given parameters.
@@ -23,6 +23,7 @@ import (
 	"reflect"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/VictoriaMetrics/fastcache"
@@ -87,9 +88,17 @@ type Database struct {
 	childrenSize common.StorageSize // Storage size of the external children tracking
 	preimages    *preimageStore     // The store for caching preimages
 
+	path *pathDatabase // Path-based node database, nil in hash-based scheme
+
+	flushHook atomic.Pointer[FlushHook] // Hook invoked on every node flushed to disk, nil if unset
+
 	lock sync.RWMutex
 }
 
+// FlushHook is invoked on every trie node flushed from the dirty cache to the
+// disk, along with the batch the node is written into.
+type FlushHook func(batch ethdb.KeyValueWriter, hash common.Hash)
+
 // rawNode is a simple binary blob used to differentiate between collapsed trie
 // nodes and already encoded RLP binary blobs (while at the same time store them
 // in the same cache fields).
@@ -265,9 +274,10 @@ func expandNode(hash hashNode, n node) node {
 
 // Config defines all necessary options for database.
 type Config struct {
-	Cache     int    // Memory allowance (MB) to use for caching trie nodes in memory
-	Journal   string // Journal of clean cache to survive node restarts
-	Preimages bool   // Flag whether the preimage of trie key is recorded
+	Cache     int         // Memory allowance (MB) to use for caching trie nodes in memory
+	Journal   string      // Journal of clean cache to survive node restarts
+	Preimages bool        // Flag whether the preimage of trie key is recorded
+	PathDB    *PathConfig // Configs of the path-based scheme, nil for the hash-based scheme
 }
 
 // NewDatabase creates a new trie database to store ephemeral trie content before
@@ -301,6 +311,9 @@ func NewDatabaseWithConfig(diskdb ethdb.Database, config *Config) *Database {
 		}},
 		preimages: preimage,
 	}
+	if config != nil && config.PathDB != nil {
+		db.path = newPathDatabase(diskdb, cleans, config.PathDB)
+	}
 	return db
 }
 
@@ -380,6 +393,9 @@ func (db *Database) node(hash common.Hash) node {
 // Node retrieves an encoded cached trie node from memory. If it cannot be found
 // cached, the method queries the persistent database for the content.
 func (db *Database) Node(hash common.Hash) ([]byte, error) {
+	if db.path != nil {
+		return nil, errPathUnsupported
+	}
 	// It doesn't make sense to retrieve the metaroot
 	if hash == (common.Hash{}) {
 		return nil, errors.New("not found")
@@ -438,6 +454,9 @@ func (db *Database) Nodes() []common.Hash {
 // and external node(e.g. storage trie root), all internal trie nodes
 // are referenced together by database itself.
 func (db *Database) Reference(child common.Hash, parent common.Hash) {
+	if db.path != nil {
+		return
+	}
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
@@ -467,6 +486,9 @@ func (db *Database) reference(child common.Hash, parent common.Hash) {
 
 // Dereference removes an existing reference from a root node.
 func (db *Database) Dereference(root common.Hash) {
+	if db.path != nil {
+		return
+	}
 	// Sanity check to ensure that the meta-root is not removed
 	if root == (common.Hash{}) {
 		log.Error("Attempted to dereference the trie cache meta root")
@@ -546,6 +568,9 @@ func (db *Database) dereference(child common.Hash, parent common.Hash) {
 // Note, this method is a non-synchronized mutator. It is unsafe to call this
 // concurrently with other mutators.
 func (db *Database) Cap(limit common.StorageSize) error {
+	if db.path != nil {
+		return nil
+	}
 	// Create a database batch to flush persistent data out. It is important that
 	// outside code doesn't see an inconsistent state (referenced data removed from
 	// memory cache during commit but not yet in persistent storage). This is ensured
@@ -572,6 +597,9 @@ func (db *Database) Cap(limit common.StorageSize) error {
 		// Fetch the oldest referenced node and push into the batch
 		node := db.dirties[oldest]
 		rawdb.WriteLegacyTrieNode(batch, oldest, node.rlp())
+		if hook := db.flushHook.Load(); hook != nil {
+			(*hook)(batch, oldest)
+		}
 
 		// If we exceeded the ideal batch size, commit and reset
 		if batch.ValueSize() >= ethdb.IdealBatchSize {
@@ -633,6 +661,9 @@ func (db *Database) Cap(limit common.StorageSize) error {
 // Note, this method is a non-synchronized mutator. It is unsafe to call this
 // concurrently with other mutators.
 func (db *Database) Commit(node common.Hash, report bool) error {
+	if db.path != nil {
+		return db.path.commit(node)
+	}
 	// Create a database batch to flush persistent data out. It is important that
 	// outside code doesn't see an inconsistent state (referenced data removed from
 	// memory cache during commit but not yet in persistent storage). This is ensured
@@ -704,6 +735,9 @@ func (db *Database) commit(hash common.Hash, batch ethdb.Batch, uncacher *cleane
 	}
 	// If we've reached an optimal batch size, commit and start over
 	rawdb.WriteLegacyTrieNode(batch, hash, node.rlp())
+	if hook := db.flushHook.Load(); hook != nil {
+		(*hook)(batch, hash)
+	}
 	if batch.ValueSize() >= ethdb.IdealBatchSize {
 		if err := batch.Write(); err != nil {
 			return err
@@ -771,6 +805,9 @@ func (c *cleaner) Delete(key []byte) error {
 // Update inserts the dirty nodes in provided nodeset into database and
 // link the account trie with multiple storage tries if necessary.
 func (db *Database) Update(nodes *MergedNodeSet) error {
+	if db.path != nil {
+		return errPathUnsupported
+	}
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
@@ -821,6 +858,14 @@ func (db *Database) Size() (common.StorageSize, common.StorageSize) {
 	db.lock.RLock()
 	defer db.lock.RUnlock()
 
+	if db.path != nil {
+		var preimageSize common.StorageSize
+		if db.preimages != nil {
+			preimageSize = db.preimages.size()
+		}
+		return db.path.size(), preimageSize
+	}
+
 	// db.dirtiesSize only contains the useful data in the cache, but when reporting
 	// the total memory consumption, the maintenance metadata is also needed to be
 	// counted.
@@ -835,6 +880,9 @@ func (db *Database) Size() (common.StorageSize, common.StorageSize) {
 
 // GetReader retrieves a node reader belonging to the given state root.
 func (db *Database) GetReader(root common.Hash) Reader {
+	if db.path != nil {
+		return db.path.reader(root)
+	}
 	return newHashReader(db)
 }
 
@@ -914,7 +962,94 @@ func (db *Database) CommitPreimages() error {
 	return db.preimages.commit(true)
 }
 
+// SetFlushHook sets the hook invoked on every trie node flushed to disk, or
+// clears it if nil. It's only supported in hash-based scheme.
+func (db *Database) SetFlushHook(hook FlushHook) {
+	if hook == nil {
+		db.flushHook.Store(nil)
+		return
+	}
+	db.flushHook.Store(&hook)
+}
+
+// ResetCleanCache drops all the nodes from the clean cache, e.g. after some
+// nodes are deleted from the disk underneath.
+func (db *Database) ResetCleanCache() {
+	if db.cleans != nil {
+		db.cleans.Reset()
+	}
+}
+
 // Scheme returns the node scheme used in the database.
 func (db *Database) Scheme() string {
+	if db.path != nil {
+		return rawdb.PathScheme
+	}
 	return rawdb.HashScheme
 }
+
+// UpdateState inserts the dirty nodes of the state transition from the parent
+// root to the given root into the database. In hash-based scheme it's the same
+// as Update, in path-based scheme the nodes are kept as a new in-memory layer.
+func (db *Database) UpdateState(root common.Hash, parent common.Hash, nodes *MergedNodeSet) error {
+	if db.path != nil {
+		return db.path.update(root, parent, nodes)
+	}
+	return db.Update(nodes)
+}
+
+// Initialized returns an indicator if the state of the given genesis root is
+// already written into the database.
+func (db *Database) Initialized(genesisRoot common.Hash) bool {
+	if db.path != nil {
+		return db.path.initialized()
+	}
+	return rawdb.HasLegacyTrieNode(db.diskdb, genesisRoot)
+}
+
+// Recoverable returns an indicator if the state with the given root can be
+// recovered from the state histories. It's always false in hash-based scheme.
+func (db *Database) Recoverable(root common.Hash) bool {
+	if db.path == nil {
+		return false
+	}
+	return db.path.recoverable(root)
+}
+
+// Recover reverts the persisted state to the given root with the state
+// histories, dropping all the in-memory layers. It's only supported in
+// path-based scheme.
+func (db *Database) Recover(root common.Hash) error {
+	if db.path == nil {
+		return errors.New("not supported in hash-based scheme")
+	}
+	return db.path.recover(root)
+}
+
+// Enable activates the state with the given root persisted by an external
+// writer, e.g. the snap sync, dropping all the in-memory layers and the state
+// histories. It's a no-op in hash-based scheme.
+func (db *Database) Enable(root common.Hash) error {
+	if db.path == nil {
+		return nil
+	}
+	return db.path.enable(root)
+}
+
+// Reset wipes the state from the database, so that the genesis state can be
+// committed again. It's a no-op in hash-based scheme.
+func (db *Database) Reset() error {
+	if db.path == nil {
+		return nil
+	}
+	return db.path.reset()
+}
+
+// Journal saves the in-memory layers up to the given root into the database,
+// to be restored at the next start. It's a no-op in hash-based scheme.
+func (db *Database) Journal(root common.Hash) error {
+	if db.path == nil {
+		return nil
+	}
+	return db.path.journal(root)
+}
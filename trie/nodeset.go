@@ -36,18 +36,15 @@ type memoryNode struct {
 // memoryNodeSize is the raw size of a memoryNode data structure without any
 // node data included. It's an approximate size, but should be a lot better
 // than not counting them.
-// nolint:unused
 var memoryNodeSize = int(reflect.TypeOf(memoryNode{}).Size())
 
 // memorySize returns the total memory size used by this node.
-// nolint:unused
 func (n *memoryNode) memorySize(pathlen int) int {
 	return int(n.size) + memoryNodeSize + pathlen
 }
 
 // rlp returns the raw rlp encoded blob of the cached trie node, either directly
 // from the cache, or by regenerating it from the collapsed node.
-// nolint:unused
 func (n *memoryNode) rlp() []byte {
 	if node, ok := n.node.(rawNode); ok {
 		return node
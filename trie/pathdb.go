@@ -0,0 +1,475 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/VictoriaMetrics/fastcache"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+const (
+	// maxDiffLayers is the maximum number of diff layers kept in memory on top
+	// of the disk layer, the deeper states are flattened into the disk.
+	maxDiffLayers = 128
+
+	// defaultDirtyCache is the default memory allowance of the dirty nodes
+	// buffered in the disk layer before they are flushed to the disk.
+	defaultDirtyCache = 64 * 1024 * 1024
+)
+
+var (
+	pathCleanHitMeter    = metrics.NewRegisteredMeter("trie/path/clean/hit", nil)
+	pathCleanMissMeter   = metrics.NewRegisteredMeter("trie/path/clean/miss", nil)
+	pathDirtyHitMeter    = metrics.NewRegisteredMeter("trie/path/dirty/hit", nil)
+	pathDiskReadMeter    = metrics.NewRegisteredMeter("trie/path/disk/read", nil)
+	pathFlushTimeTimer   = metrics.NewRegisteredResettingTimer("trie/path/flush/time", nil)
+	pathFlushNodesMeter  = metrics.NewRegisteredMeter("trie/path/flush/nodes", nil)
+	pathFlushSizeMeter   = metrics.NewRegisteredMeter("trie/path/flush/size", nil)
+	pathHistorySizeMeter = metrics.NewRegisteredMeter("trie/path/history/size", nil)
+	pathDiffLayersGauge  = metrics.NewRegisteredGauge("trie/path/difflayers", nil)
+)
+
+var (
+	// errPathStale is returned when a layer is accessed after it was flattened
+	// into the disk layer.
+	errPathStale = errors.New("layer stale")
+
+	// errPathUnsupported is returned when a hash-based operation is invoked on
+	// a path-based database.
+	errPathUnsupported = errors.New("not supported in path-based scheme")
+
+	// errPathReadOnly is returned when a mutation is attempted on a read-only
+	// path-based database.
+	errPathReadOnly = errors.New("read only")
+
+	// errUnexpectedNode is returned when the node stored at a path doesn't
+	// match the requested hash.
+	errUnexpectedNode = errors.New("unexpected node")
+
+	// errStateUnrecoverable is returned when the state can't be reverted to,
+	// for lack of the state histories.
+	errStateUnrecoverable = errors.New("state is unrecoverable")
+)
+
+// PathConfig contains the settings of the path-based node database.
+type PathConfig struct {
+	StateHistory uint64 // Number of recent states to keep the reverse diffs for, 0 keeps them all
+	DirtyCache   int    // Memory allowance (MB) of the dirty nodes buffered before flushing to disk
+	ReadOnly     bool   // Flag whether the database is opened in read only mode
+}
+
+// nodeMap is the set of trie nodes keyed by trie owner and node path. Deleted
+// nodes are kept with an empty hash.
+type nodeMap map[common.Hash]map[string]*memoryNode
+
+// pathDatabase stores the trie nodes keyed by trie owner and node path, so
+// that only a single version of each node is persisted. The recent states are
+// maintained as in-memory diff layers on top of the persisted disk layer, and
+// the state histories of the flattened layers are kept to revert the disk
+// state to older versions.
+type pathDatabase struct {
+	diskdb ethdb.Database   // Persistent storage for the trie nodes
+	cleans *fastcache.Cache // GC friendly memory cache of clean node RLPs, keyed by owner and path
+	config PathConfig
+
+	layers map[common.Hash]pathLayer // Live layers of the tree, keyed by state root
+	lock   sync.RWMutex
+}
+
+// newPathDatabase opens the path-based node database, restoring the layers
+// journalled at the last shutdown if any.
+func newPathDatabase(diskdb ethdb.Database, cleans *fastcache.Cache, config *PathConfig) *pathDatabase {
+	db := &pathDatabase{
+		diskdb: diskdb,
+		cleans: cleans,
+		config: *config,
+	}
+	if db.config.DirtyCache == 0 {
+		db.config.DirtyCache = defaultDirtyCache / 1024 / 1024
+	}
+	head := db.loadJournal()
+	if head == nil {
+		head = db.loadDiskLayer()
+	}
+	db.layers = make(map[common.Hash]pathLayer)
+	for l := head; l != nil; l = l.parentLayer() {
+		db.layers[l.rootHash()] = l
+	}
+	// The state histories above the disk layer belong to the states lost in
+	// the dirty buffer on a crash, drop them.
+	if !db.config.ReadOnly {
+		db.truncateHistories(db.disk().id)
+	}
+	pathDiffLayersGauge.Update(int64(len(db.layers) - 1))
+	return db
+}
+
+// loadDiskLayer creates the disk layer from the persisted state.
+func (db *pathDatabase) loadDiskLayer() *diskLayer {
+	root, id := db.persisted()
+	return newDiskLayer(root, id, db, newNodeBuffer(db.bufferLimit(), nil, 0))
+}
+
+// bufferLimit returns the memory allowance of the dirty node buffer in bytes.
+func (db *pathDatabase) bufferLimit() uint64 {
+	return uint64(db.config.DirtyCache) * 1024 * 1024
+}
+
+// disk returns the current disk layer. The tree lock is expected to be held.
+func (db *pathDatabase) disk() *diskLayer {
+	for _, l := range db.layers {
+		for {
+			if disk, ok := l.(*diskLayer); ok {
+				return disk
+			}
+			l = l.parentLayer()
+		}
+	}
+	return nil
+}
+
+// reader returns the node reader of the state with the given root, or nil if
+// the state is not available. The zero root is treated as the empty state.
+func (db *pathDatabase) reader(root common.Hash) Reader {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	if root == (common.Hash{}) {
+		root = types.EmptyRootHash
+	}
+	l, ok := db.layers[root]
+	if !ok {
+		return nil
+	}
+	return &pathReader{layer: l}
+}
+
+// update adds the dirty nodes of the state transition from parent to root as
+// a new diff layer, flattening the deepest layers into the disk if there are
+// too many of them.
+func (db *pathDatabase) update(root common.Hash, parent common.Hash, nodes *MergedNodeSet) error {
+	if db.config.ReadOnly {
+		return errPathReadOnly
+	}
+	// Hash-based scheme tolerates the no-op updates, do the same
+	if root == parent {
+		return nil
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if _, ok := db.layers[root]; ok {
+		return nil
+	}
+	base, ok := db.layers[parent]
+	if !ok {
+		return fmt.Errorf("parent state %#x missing", parent)
+	}
+	set := make(nodeMap)
+	for owner, subset := range nodes.sets {
+		current := make(map[string]*memoryNode, len(subset.nodes))
+		for path, n := range subset.nodes {
+			if n.isDeleted() {
+				current[path] = n
+				continue
+			}
+			blob := n.rlp()
+			current[path] = &memoryNode{hash: n.hash, size: uint16(len(blob)), node: rawNode(blob)}
+		}
+		set[owner] = current
+	}
+	db.layers[root] = newDiffLayer(base, root, base.stateID()+1, set)
+
+	if err := db.cap(root, maxDiffLayers); err != nil {
+		return err
+	}
+	pathDiffLayersGauge.Update(int64(len(db.layers) - 1))
+	return nil
+}
+
+// cap traverses downwards the layers from the given root until the number of
+// allowed diff layers are crossed. All the layers beyond are flattened into
+// the disk layer. The tree lock is expected to be held.
+func (db *pathDatabase) cap(root common.Hash, layers int) error {
+	diff, ok := db.layers[root].(*diffLayer)
+	if !ok {
+		return nil
+	}
+	for i := 0; i < layers-1; i++ {
+		parent, ok := diff.parentLayer().(*diffLayer)
+		if !ok {
+			return nil
+		}
+		diff = parent
+	}
+	parent, ok := diff.parentLayer().(*diffLayer)
+	if !ok {
+		return nil
+	}
+	base, err := parent.persist(false)
+	if err != nil {
+		return err
+	}
+	diff.lock.Lock()
+	diff.parent = base
+	diff.lock.Unlock()
+
+	db.removeStale()
+	db.layers[base.root] = base
+	return nil
+}
+
+// removeStale drops all the layers which are flattened or built on top of a
+// flattened layer. The tree lock is expected to be held.
+func (db *pathDatabase) removeStale() {
+	alive := make(map[pathLayer]bool)
+
+	var check func(l pathLayer) bool
+	check = func(l pathLayer) bool {
+		if res, ok := alive[l]; ok {
+			return res
+		}
+		var res bool
+		switch l := l.(type) {
+		case *diskLayer:
+			res = !l.isStale()
+		case *diffLayer:
+			res = !l.isStale() && check(l.parentLayer())
+		}
+		alive[l] = res
+		return res
+	}
+	for root, l := range db.layers {
+		if !check(l) {
+			delete(db.layers, root)
+		}
+	}
+}
+
+// commit flattens all the layers below the given root into the disk layer and
+// flushes the buffered nodes into the disk. All the other layers are dropped.
+func (db *pathDatabase) commit(root common.Hash) error {
+	if db.config.ReadOnly {
+		return errPathReadOnly
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	l, ok := db.layers[root]
+	if !ok {
+		return fmt.Errorf("state %#x missing", root)
+	}
+	var disk *diskLayer
+	switch l := l.(type) {
+	case *diskLayer:
+		if err := l.flush(); err != nil {
+			return err
+		}
+		disk = l
+	case *diffLayer:
+		base, err := l.persist(true)
+		if err != nil {
+			return err
+		}
+		disk = base
+	}
+	db.layers = map[common.Hash]pathLayer{disk.root: disk}
+	pathDiffLayersGauge.Update(0)
+	return nil
+}
+
+// size returns the memory used by the diff layers and the dirty node buffer.
+func (db *pathDatabase) size() common.StorageSize {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	var size common.StorageSize
+	for _, l := range db.layers {
+		switch l := l.(type) {
+		case *diffLayer:
+			size += common.StorageSize(l.memory)
+		case *diskLayer:
+			size += common.StorageSize(l.buffer.size)
+		}
+	}
+	return size
+}
+
+// initialized reports whether the state was ever written into the database.
+func (db *pathDatabase) initialized() bool {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	return db.disk().root != types.EmptyRootHash
+}
+
+// recoverable reports whether the disk state can be reverted to the given root
+// with the state histories.
+func (db *pathDatabase) recoverable(root common.Hash) bool {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	id := rawdb.ReadStateID(db.diskdb, root)
+	if id == nil {
+		return false
+	}
+	return db.checkHistories(root, *id, db.disk().id) == nil
+}
+
+// checkHistories ensures the state histories reverting the disk state to the
+// given root and id are available.
+func (db *pathDatabase) checkHistories(root common.Hash, id uint64, head uint64) error {
+	if id >= head {
+		return fmt.Errorf("%w: state %d is not below the disk state %d", errStateUnrecoverable, id, head)
+	}
+	if tail := rawdb.ReadStateHistoryTail(db.diskdb); tail != nil && *tail > id+1 {
+		return fmt.Errorf("%w: state history %d pruned, tail %d", errStateUnrecoverable, id+1, *tail)
+	}
+	h, err := readHistory(db.diskdb, id+1)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errStateUnrecoverable, err)
+	}
+	if h.Parent != root {
+		return fmt.Errorf("%w: state %d is %#x, not %#x", errStateUnrecoverable, id, h.Parent, root)
+	}
+	return nil
+}
+
+// recover reverts the disk state to the given root with the state histories.
+// All the diff layers are dropped.
+func (db *pathDatabase) recover(root common.Hash) error {
+	if db.config.ReadOnly {
+		return errPathReadOnly
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	id := rawdb.ReadStateID(db.diskdb, root)
+	if id == nil {
+		return fmt.Errorf("%w: state %#x unknown", errStateUnrecoverable, root)
+	}
+	disk := db.disk()
+	if err := db.checkHistories(root, *id, disk.id); err != nil {
+		return err
+	}
+	// Flush the buffered nodes, the histories revert the persisted state
+	if err := disk.flush(); err != nil {
+		return err
+	}
+	disk.markStale()
+	for current := disk.id; current > *id; current-- {
+		if err := revertHistory(db.diskdb, current); err != nil {
+			return err
+		}
+	}
+	if db.cleans != nil {
+		db.cleans.Reset()
+	}
+	disk = newDiskLayer(root, *id, db, newNodeBuffer(db.bufferLimit(), nil, 0))
+	db.layers = map[common.Hash]pathLayer{root: disk}
+	pathDiffLayersGauge.Update(0)
+
+	log.Info("Reverted state with histories", "root", root, "id", *id)
+	return nil
+}
+
+// enable resets the database to the state persisted in the disk by another
+// writer, e.g. the snap sync. The persisted state must have the given root.
+// All the layers and state histories are dropped.
+func (db *pathDatabase) enable(root common.Hash) error {
+	if db.config.ReadOnly {
+		return errPathReadOnly
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if stored, _ := db.persisted(); stored != root {
+		return fmt.Errorf("state root mismatch: stored %x, synced %x", stored, root)
+	}
+	db.disk().markStale()
+	db.truncateHistories(0)
+
+	batch := db.diskdb.NewBatch()
+	rawdb.WritePersistentStateID(batch, 0)
+	rawdb.WriteStateID(batch, root, 0)
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	if db.cleans != nil {
+		db.cleans.Reset()
+	}
+	db.layers = map[common.Hash]pathLayer{root: newDiskLayer(root, 0, db, newNodeBuffer(db.bufferLimit(), nil, 0))}
+	pathDiffLayersGauge.Update(0)
+
+	log.Info("Enabled path-based state", "root", root)
+	return nil
+}
+
+// reset wipes all the trie nodes and state histories from the database,
+// leaving an empty state.
+func (db *pathDatabase) reset() error {
+	if db.config.ReadOnly {
+		return errPathReadOnly
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.disk().markStale()
+	db.truncateHistories(0)
+	if err := rawdb.DeletePathTrieNodes(db.diskdb); err != nil {
+		return err
+	}
+	rawdb.WritePersistentStateID(db.diskdb, 0)
+	if db.cleans != nil {
+		db.cleans.Reset()
+	}
+	root := types.EmptyRootHash
+	db.layers = map[common.Hash]pathLayer{root: newDiskLayer(root, 0, db, newNodeBuffer(db.bufferLimit(), nil, 0))}
+	pathDiffLayersGauge.Update(0)
+
+	log.Info("Wiped path-based state")
+	return nil
+}
+
+// pathReader is the node reader of a state in the path-based database.
+type pathReader struct {
+	layer pathLayer
+}
+
+// Node retrieves the trie node with the given node path and hash.
+func (r *pathReader) Node(owner common.Hash, path []byte, hash common.Hash) (node, error) {
+	blob, err := r.layer.node(owner, path, hash)
+	if err != nil {
+		return nil, err
+	}
+	return decodeNode(hash.Bytes(), blob)
+}
+
+// NodeBlob retrieves the RLP-encoded trie node blob with the given node path
+// and hash.
+func (r *pathReader) NodeBlob(owner common.Hash, path []byte, hash common.Hash) ([]byte, error) {
+	return r.layer.node(owner, path, hash)
+}
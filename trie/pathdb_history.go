@@ -0,0 +1,195 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// stateHistory is the reverse diff of a state transition, holding the nodes
+// changed by the transition as they were before it. Applying the history to
+// the state with the id of the history reverts it to its parent.
+type stateHistory struct {
+	Parent common.Hash    // Root of the state before the transition
+	Root   common.Hash    // Root of the state after the transition
+	Nodes  []historyNodes // Original nodes, sorted by owner
+}
+
+// historyNodes is the set of original nodes of a trie.
+type historyNodes struct {
+	Owner common.Hash
+	Nodes []historyNode // Original nodes, sorted by path
+}
+
+// historyNode is the original node at a path, empty if there was none.
+type historyNode struct {
+	Path []byte
+	Blob []byte
+}
+
+// writeHistory stores the state history reverting the given diff layer to the
+// disk layer it's built on, pruning the histories beyond the configured limit.
+// The disk layer lock is expected to be held.
+func writeHistory(db *pathDatabase, disk *diskLayer, bottom *diffLayer) error {
+	h := &stateHistory{Parent: disk.root, Root: bottom.root}
+	for owner, subset := range bottom.nodes {
+		entry := historyNodes{Owner: owner}
+		for path := range subset {
+			entry.Nodes = append(entry.Nodes, historyNode{Path: []byte(path), Blob: disk.blob(owner, []byte(path))})
+		}
+		sort.Slice(entry.Nodes, func(i, j int) bool {
+			return bytes.Compare(entry.Nodes[i].Path, entry.Nodes[j].Path) < 0
+		})
+		h.Nodes = append(h.Nodes, entry)
+	}
+	sort.Slice(h.Nodes, func(i, j int) bool {
+		return bytes.Compare(h.Nodes[i].Owner[:], h.Nodes[j].Owner[:]) < 0
+	})
+	blob, err := rlp.EncodeToBytes(h)
+	if err != nil {
+		return err
+	}
+	batch := db.diskdb.NewBatch()
+	rawdb.WriteStateHistory(batch, bottom.id, blob)
+	rawdb.WriteStateID(batch, bottom.root, bottom.id)
+	if disk.id == 0 {
+		rawdb.WriteStateID(batch, disk.root, 0)
+	}
+	if limit := db.config.StateHistory; limit > 0 && bottom.id > limit {
+		pruneHistories(db.diskdb, batch, bottom.id-limit+1)
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	pathHistorySizeMeter.Mark(int64(len(blob)))
+	return nil
+}
+
+// readHistory retrieves the state history with the given id.
+func readHistory(db ethdb.KeyValueReader, id uint64) (*stateHistory, error) {
+	blob := rawdb.ReadStateHistory(db, id)
+	if len(blob) == 0 {
+		return nil, fmt.Errorf("state history %d not found", id)
+	}
+	h := new(stateHistory)
+	if err := rlp.DecodeBytes(blob, h); err != nil {
+		return nil, fmt.Errorf("state history %d corrupted: %v", id, err)
+	}
+	return h, nil
+}
+
+// revertHistory applies the state history with the given id to the persisted
+// state, reverting it to its parent. The history is dropped along with the
+// lookup of the reverted state, in the same batch as the nodes are reverted.
+func revertHistory(db ethdb.KeyValueStore, id uint64) error {
+	h, err := readHistory(db, id)
+	if err != nil {
+		return err
+	}
+	batch := db.NewBatch()
+	for _, entry := range h.Nodes {
+		for _, n := range entry.Nodes {
+			switch {
+			case len(n.Blob) == 0 && entry.Owner == (common.Hash{}):
+				rawdb.DeleteAccountTrieNode(batch, n.Path)
+			case len(n.Blob) == 0:
+				rawdb.DeleteStorageTrieNode(batch, entry.Owner, n.Path)
+			case entry.Owner == (common.Hash{}):
+				rawdb.WriteAccountTrieNode(batch, n.Path, n.Blob)
+			default:
+				rawdb.WriteStorageTrieNode(batch, entry.Owner, n.Path, n.Blob)
+			}
+		}
+	}
+	if stored := rawdb.ReadStateID(db, h.Root); stored != nil && *stored == id {
+		rawdb.DeleteStateID(batch, h.Root)
+	}
+	rawdb.DeleteStateHistory(batch, id)
+	rawdb.WritePersistentStateID(batch, id-1)
+	return batch.Write()
+}
+
+// pruneHistories deletes the state histories below the given id into the
+// batch, along with the lookups of the states that can't be reverted to any
+// longer.
+func pruneHistories(db ethdb.KeyValueReader, batch ethdb.KeyValueWriter, tail uint64) {
+	oldTail := uint64(1)
+	if stored := rawdb.ReadStateHistoryTail(db); stored != nil {
+		oldTail = *stored
+	}
+	for id := oldTail; id < tail; id++ {
+		h, err := readHistory(db, id)
+		if err != nil {
+			// Histories written before the limit was configured might be
+			// missing, the tail is updated nonetheless.
+			continue
+		}
+		if stored := rawdb.ReadStateID(db, h.Parent); stored != nil && *stored == id-1 {
+			rawdb.DeleteStateID(batch, h.Parent)
+		}
+		rawdb.DeleteStateHistory(batch, id)
+	}
+	if tail > oldTail {
+		rawdb.WriteStateHistoryTail(batch, tail)
+	}
+}
+
+// truncateHistories deletes the state histories above the given id, which
+// belong to the states that are no longer in the disk layer. All histories
+// are deleted if the id is zero.
+func (db *pathDatabase) truncateHistories(head uint64) {
+	var (
+		batch = db.diskdb.NewBatch()
+		start = head + 1
+		count int
+	)
+	if head == 0 {
+		if tail := rawdb.ReadStateHistoryTail(db.diskdb); tail != nil {
+			start = *tail
+		}
+	}
+	for id := start; ; id++ {
+		h, err := readHistory(db.diskdb, id)
+		if err != nil {
+			break
+		}
+		if stored := rawdb.ReadStateID(db.diskdb, h.Root); stored != nil && *stored == id {
+			rawdb.DeleteStateID(batch, h.Root)
+		}
+		rawdb.DeleteStateHistory(batch, id)
+		count++
+	}
+	if head == 0 && start != 1 {
+		rawdb.WriteStateHistoryTail(batch, 1)
+		count++
+	}
+	if count == 0 {
+		return
+	}
+	if err := batch.Write(); err != nil {
+		log.Crit("Failed to truncate state histories", "err", err)
+	}
+	log.Info("Truncated state histories", "head", head, "count", count)
+}
@@ -0,0 +1,204 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// journalVersion is the version of the journal format, journals of other
+// versions are discarded.
+const journalVersion uint64 = 0
+
+// pathJournal is the serialized form of the in-memory layers, saved at
+// shutdown to be restored at the next start.
+type pathJournal struct {
+	Version     uint64
+	Persisted   common.Hash    // Root of the persisted state the layers are built on
+	PersistedID uint64         // Id of the persisted state the layers are built on
+	Disk        journalLayer   // Disk layer along with the buffered nodes
+	Diffs       []journalLayer // Diff layers from the bottom-most one
+}
+
+// journalLayer is the serialized form of a layer.
+type journalLayer struct {
+	Root  common.Hash
+	ID    uint64
+	Nodes []journalNodes
+}
+
+// journalNodes is the serialized form of the nodes of a trie, where deleted
+// nodes have an empty blob.
+type journalNodes struct {
+	Owner common.Hash
+	Paths [][]byte
+	Blobs [][]byte
+}
+
+// encodeNodes converts the nodes into their serialized form.
+func encodeNodes(nodes nodeMap) []journalNodes {
+	res := make([]journalNodes, 0, len(nodes))
+	for owner, subset := range nodes {
+		entry := journalNodes{Owner: owner}
+		for path, n := range subset {
+			entry.Paths = append(entry.Paths, []byte(path))
+			if n.isDeleted() {
+				entry.Blobs = append(entry.Blobs, nil)
+			} else {
+				entry.Blobs = append(entry.Blobs, n.rlp())
+			}
+		}
+		res = append(res, entry)
+	}
+	return res
+}
+
+// decodeNodes converts the nodes from their serialized form.
+func decodeNodes(entries []journalNodes) (nodeMap, error) {
+	nodes := make(nodeMap)
+	for _, entry := range entries {
+		if len(entry.Paths) != len(entry.Blobs) {
+			return nil, fmt.Errorf("invalid journal nodes of %x: %d paths, %d blobs", entry.Owner, len(entry.Paths), len(entry.Blobs))
+		}
+		subset := make(map[string]*memoryNode, len(entry.Paths))
+		for i, path := range entry.Paths {
+			blob := entry.Blobs[i]
+			if len(blob) == 0 {
+				subset[string(path)] = &memoryNode{}
+				continue
+			}
+			subset[string(path)] = &memoryNode{hash: crypto.Keccak256Hash(blob), size: uint16(len(blob)), node: rawNode(blob)}
+		}
+		nodes[entry.Owner] = subset
+	}
+	return nodes, nil
+}
+
+// journal saves the layers from the disk layer up to the given root, so that
+// they can be restored at the next start. The other layers are discarded.
+func (db *pathDatabase) journal(root common.Hash) error {
+	if db.config.ReadOnly {
+		return errPathReadOnly
+	}
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	l, ok := db.layers[root]
+	if !ok {
+		return fmt.Errorf("state %#x missing", root)
+	}
+	var diffs []*diffLayer
+	for {
+		diff, ok := l.(*diffLayer)
+		if !ok {
+			break
+		}
+		diffs = append(diffs, diff)
+		l = diff.parentLayer()
+	}
+	disk := l.(*diskLayer)
+	if disk.isStale() {
+		return errPathStale
+	}
+	persisted, persistedID := db.persisted()
+	journal := &pathJournal{
+		Version:     journalVersion,
+		Persisted:   persisted,
+		PersistedID: persistedID,
+		Disk:        journalLayer{Root: disk.root, ID: disk.id, Nodes: encodeNodes(disk.buffer.nodes)},
+	}
+	for i := len(diffs) - 1; i >= 0; i-- {
+		journal.Diffs = append(journal.Diffs, journalLayer{Root: diffs[i].root, ID: diffs[i].id, Nodes: encodeNodes(diffs[i].nodes)})
+	}
+	blob, err := rlp.EncodeToBytes(journal)
+	if err != nil {
+		return err
+	}
+	rawdb.WriteTrieJournal(db.diskdb, blob)
+
+	log.Info("Persisted trie layers", "root", root, "layers", len(diffs), "size", common.StorageSize(len(blob)))
+	return nil
+}
+
+// loadJournal restores the layers saved at the last shutdown, returning the
+// top-most one, or nil if there is no usable journal. The journal is consumed
+// unless the database is read only.
+func (db *pathDatabase) loadJournal() pathLayer {
+	blob := rawdb.ReadTrieJournal(db.diskdb)
+	if len(blob) == 0 {
+		return nil
+	}
+	if !db.config.ReadOnly {
+		rawdb.DeleteTrieJournal(db.diskdb)
+	}
+	head, err := db.decodeJournal(blob)
+	if err != nil {
+		log.Warn("Discarded trie layers journal", "err", err)
+		return nil
+	}
+	log.Info("Loaded trie layers journal", "root", head.rootHash(), "id", head.stateID())
+	return head
+}
+
+// decodeJournal restores the layers from the serialized journal, checking
+// that it belongs to the persisted state.
+func (db *pathDatabase) decodeJournal(blob []byte) (pathLayer, error) {
+	var journal pathJournal
+	if err := rlp.DecodeBytes(blob, &journal); err != nil {
+		return nil, err
+	}
+	if journal.Version != journalVersion {
+		return nil, fmt.Errorf("unsupported journal version %d", journal.Version)
+	}
+	// The journal is only valid with the persisted state it was saved over
+	root, id := db.persisted()
+	if journal.Persisted != root || journal.PersistedID != id {
+		return nil, fmt.Errorf("journal over state %#x (%d), persisted state %#x (%d)", journal.Persisted, journal.PersistedID, root, id)
+	}
+	nodes, err := decodeNodes(journal.Disk.Nodes)
+	if err != nil {
+		return nil, err
+	}
+	var head pathLayer = newDiskLayer(journal.Disk.Root, journal.Disk.ID, db, newNodeBuffer(db.bufferLimit(), nodes, journal.Disk.ID-id))
+	for _, diff := range journal.Diffs {
+		nodes, err := decodeNodes(diff.Nodes)
+		if err != nil {
+			return nil, err
+		}
+		if diff.ID != head.stateID()+1 {
+			return nil, fmt.Errorf("journal layer %d on top of layer %d", diff.ID, head.stateID())
+		}
+		head = newDiffLayer(head, diff.Root, diff.ID, nodes)
+	}
+	return head, nil
+}
+
+// persisted returns the root and id of the state persisted in the disk.
+func (db *pathDatabase) persisted() (common.Hash, uint64) {
+	root := types.EmptyRootHash
+	if blob, hash := rawdb.ReadAccountTrieNode(db.diskdb, nil); len(blob) > 0 {
+		root = hash
+	}
+	return root, rawdb.ReadPersistentStateID(db.diskdb)
+}
@@ -0,0 +1,380 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/fastcache"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// pathLayer is a state of the path-based database, either the persisted disk
+// layer or an in-memory diff layer on top of it.
+type pathLayer interface {
+	// rootHash returns the root of the state.
+	rootHash() common.Hash
+
+	// stateID returns the id of the state, incremented by one with every
+	// state transition.
+	stateID() uint64
+
+	// parentLayer returns the layer the state was built on, or nil for the
+	// disk layer.
+	parentLayer() pathLayer
+
+	// node retrieves the RLP-encoded trie node with the given owner, path
+	// and hash, erroring if the stored node doesn't match the hash.
+	node(owner common.Hash, path []byte, hash common.Hash) ([]byte, error)
+}
+
+// diffLayer is the set of trie nodes changed by a state transition, kept in
+// memory on top of its parent layer.
+type diffLayer struct {
+	root   common.Hash
+	id     uint64
+	nodes  nodeMap
+	memory uint64 // Approximate memory used by the nodes
+
+	parent pathLayer // Parent layer, replaced when flattened into the disk
+	stale  bool      // Flag whether the layer was flattened into the disk
+	lock   sync.RWMutex
+}
+
+// newDiffLayer creates a diff layer on top of the given parent.
+func newDiffLayer(parent pathLayer, root common.Hash, id uint64, nodes nodeMap) *diffLayer {
+	dl := &diffLayer{
+		root:   root,
+		id:     id,
+		nodes:  nodes,
+		parent: parent,
+	}
+	for _, subset := range nodes {
+		for path, n := range subset {
+			dl.memory += uint64(n.memorySize(len(path)))
+		}
+	}
+	return dl
+}
+
+func (dl *diffLayer) rootHash() common.Hash { return dl.root }
+func (dl *diffLayer) stateID() uint64       { return dl.id }
+
+func (dl *diffLayer) parentLayer() pathLayer {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	return dl.parent
+}
+
+func (dl *diffLayer) isStale() bool {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	return dl.stale
+}
+
+// node implements pathLayer, falling back to the parent layer if the node
+// wasn't changed by the layer.
+func (dl *diffLayer) node(owner common.Hash, path []byte, hash common.Hash) ([]byte, error) {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	if subset, ok := dl.nodes[owner]; ok {
+		if n, ok := subset[string(path)]; ok {
+			if n.hash != hash {
+				return nil, fmt.Errorf("%w %x!=%x (owner %x, path %x)", errUnexpectedNode, n.hash, hash, owner, path)
+			}
+			pathDirtyHitMeter.Mark(1)
+			return n.rlp(), nil
+		}
+	}
+	return dl.parent.node(owner, path, hash)
+}
+
+// persist flattens the layer and all its ancestors into the disk layer,
+// returning the new disk layer. The buffered nodes are flushed to the disk if
+// forced, or if the buffer is full.
+func (dl *diffLayer) persist(force bool) (*diskLayer, error) {
+	if parent, ok := dl.parentLayer().(*diffLayer); ok {
+		base, err := parent.persist(false)
+		if err != nil {
+			return nil, err
+		}
+		dl.lock.Lock()
+		dl.parent = base
+		dl.lock.Unlock()
+	}
+	disk, ok := dl.parentLayer().(*diskLayer)
+	if !ok {
+		return nil, fmt.Errorf("unexpected parent layer %T", dl.parentLayer())
+	}
+	base, err := disk.commit(dl, force)
+	if err != nil {
+		return nil, err
+	}
+	dl.lock.Lock()
+	dl.stale = true
+	dl.lock.Unlock()
+	return base, nil
+}
+
+// diskLayer is the state persisted in the disk, along with the nodes of the
+// flattened diff layers buffered in memory until flushed.
+type diskLayer struct {
+	root   common.Hash
+	id     uint64
+	db     *pathDatabase
+	buffer *nodeBuffer // Nodes flattened but not yet flushed, shared with the next disk layer
+
+	stale bool // Flag whether the layer was replaced by a newer disk layer
+	lock  sync.RWMutex
+}
+
+func newDiskLayer(root common.Hash, id uint64, db *pathDatabase, buffer *nodeBuffer) *diskLayer {
+	return &diskLayer{root: root, id: id, db: db, buffer: buffer}
+}
+
+func (dl *diskLayer) rootHash() common.Hash  { return dl.root }
+func (dl *diskLayer) stateID() uint64        { return dl.id }
+func (dl *diskLayer) parentLayer() pathLayer { return nil }
+
+func (dl *diskLayer) isStale() bool {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	return dl.stale
+}
+
+func (dl *diskLayer) markStale() {
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	dl.stale = true
+}
+
+// node implements pathLayer, reading the node from the dirty buffer, the clean
+// cache or the disk in order.
+func (dl *diskLayer) node(owner common.Hash, path []byte, hash common.Hash) ([]byte, error) {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	if dl.stale {
+		return nil, errPathStale
+	}
+	if n, ok := dl.buffer.node(owner, path); ok {
+		if n.hash != hash {
+			return nil, fmt.Errorf("%w %x!=%x (owner %x, path %x)", errUnexpectedNode, n.hash, hash, owner, path)
+		}
+		pathDirtyHitMeter.Mark(1)
+		return n.rlp(), nil
+	}
+	key := cacheKey(owner, path)
+	if dl.db.cleans != nil {
+		if blob := dl.db.cleans.Get(nil, key); len(blob) > 0 {
+			if crypto.Keccak256Hash(blob) == hash {
+				pathCleanHitMeter.Mark(1)
+				return blob, nil
+			}
+		}
+		pathCleanMissMeter.Mark(1)
+	}
+	var (
+		blob  []byte
+		nHash common.Hash
+	)
+	if owner == (common.Hash{}) {
+		blob, nHash = rawdb.ReadAccountTrieNode(dl.db.diskdb, path)
+	} else {
+		blob, nHash = rawdb.ReadStorageTrieNode(dl.db.diskdb, owner, path)
+	}
+	pathDiskReadMeter.Mark(int64(len(blob)))
+
+	if nHash != hash {
+		return nil, fmt.Errorf("%w %x!=%x (owner %x, path %x)", errUnexpectedNode, nHash, hash, owner, path)
+	}
+	if dl.db.cleans != nil && len(blob) > 0 {
+		dl.db.cleans.Set(key, blob)
+	}
+	return blob, nil
+}
+
+// blob retrieves the node stored at the given path regardless of its hash,
+// nil if there is none. The layer lock is expected to be held.
+func (dl *diskLayer) blob(owner common.Hash, path []byte) []byte {
+	if n, ok := dl.buffer.node(owner, path); ok {
+		if n.isDeleted() {
+			return nil
+		}
+		return n.rlp()
+	}
+	if owner == (common.Hash{}) {
+		blob, _ := rawdb.ReadAccountTrieNode(dl.db.diskdb, path)
+		return blob
+	}
+	blob, _ := rawdb.ReadStorageTrieNode(dl.db.diskdb, owner, path)
+	return blob
+}
+
+// commit flattens the given diff layer, built on the disk layer, into a new
+// disk layer. The state history reverting the diff layer is stored first.
+func (dl *diskLayer) commit(bottom *diffLayer, force bool) (*diskLayer, error) {
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	if dl.stale {
+		return nil, errPathStale
+	}
+	if err := writeHistory(dl.db, dl, bottom); err != nil {
+		return nil, err
+	}
+	dl.stale = true
+
+	ndl := newDiskLayer(bottom.root, bottom.id, dl.db, dl.buffer.commit(bottom.nodes))
+	if err := ndl.buffer.flush(dl.db.diskdb, dl.db.cleans, ndl.id, force); err != nil {
+		return nil, err
+	}
+	return ndl, nil
+}
+
+// flush writes all the buffered nodes into the disk.
+func (dl *diskLayer) flush() error {
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	if dl.stale {
+		return errPathStale
+	}
+	return dl.buffer.flush(dl.db.diskdb, dl.db.cleans, dl.id, true)
+}
+
+// nodeBuffer is the set of nodes of the flattened diff layers, buffered to
+// aggregate the writes of the nodes updated repeatedly.
+type nodeBuffer struct {
+	layers uint64  // Number of the diff layers merged into the buffer
+	size   uint64  // Approximate memory used by the nodes
+	limit  uint64  // Memory allowance, the buffer is flushed once reached
+	nodes  nodeMap // Buffered nodes, keyed by owner and path
+}
+
+func newNodeBuffer(limit uint64, nodes nodeMap, layers uint64) *nodeBuffer {
+	if nodes == nil {
+		nodes = make(nodeMap)
+	}
+	b := &nodeBuffer{layers: layers, limit: limit, nodes: nodes}
+	for _, subset := range nodes {
+		for path, n := range subset {
+			b.size += uint64(n.memorySize(len(path)))
+		}
+	}
+	return b
+}
+
+// node retrieves the buffered node with the given owner and path.
+func (b *nodeBuffer) node(owner common.Hash, path []byte) (*memoryNode, bool) {
+	subset, ok := b.nodes[owner]
+	if !ok {
+		return nil, false
+	}
+	n, ok := subset[string(path)]
+	return n, ok
+}
+
+// commit merges the nodes of a diff layer into the buffer in place.
+func (b *nodeBuffer) commit(nodes nodeMap) *nodeBuffer {
+	for owner, subset := range nodes {
+		current, ok := b.nodes[owner]
+		if !ok {
+			current = make(map[string]*memoryNode, len(subset))
+			b.nodes[owner] = current
+		}
+		for path, n := range subset {
+			if orig, ok := current[path]; ok {
+				b.size -= uint64(orig.memorySize(len(path)))
+			}
+			current[path] = n
+			b.size += uint64(n.memorySize(len(path)))
+		}
+	}
+	b.layers++
+	return b
+}
+
+// flush writes the buffered nodes into the disk along with the id of the
+// state they belong to, if forced or if the memory allowance is reached.
+func (b *nodeBuffer) flush(db ethdb.KeyValueStore, cleans *fastcache.Cache, id uint64, force bool) error {
+	if b.size <= b.limit && !force {
+		return nil
+	}
+	var (
+		start = time.Now()
+		batch = db.NewBatch()
+		count int
+	)
+	for owner, subset := range b.nodes {
+		for path, n := range subset {
+			if n.isDeleted() {
+				if owner == (common.Hash{}) {
+					rawdb.DeleteAccountTrieNode(batch, []byte(path))
+				} else {
+					rawdb.DeleteStorageTrieNode(batch, owner, []byte(path))
+				}
+				if cleans != nil {
+					cleans.Del(cacheKey(owner, []byte(path)))
+				}
+			} else {
+				blob := n.rlp()
+				if owner == (common.Hash{}) {
+					rawdb.WriteAccountTrieNode(batch, []byte(path), blob)
+				} else {
+					rawdb.WriteStorageTrieNode(batch, owner, []byte(path), blob)
+				}
+				if cleans != nil {
+					cleans.Set(cacheKey(owner, []byte(path)), blob)
+				}
+			}
+			count++
+		}
+	}
+	rawdb.WritePersistentStateID(batch, id)
+
+	size := batch.ValueSize()
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	pathFlushTimeTimer.UpdateSince(start)
+	pathFlushNodesMeter.Mark(int64(count))
+	pathFlushSizeMeter.Mark(int64(size))
+
+	log.Debug("Persisted buffered trie nodes", "layers", b.layers, "nodes", count, "size", common.StorageSize(size), "id", id, "elapsed", common.PrettyDuration(time.Since(start)))
+	b.nodes, b.size, b.layers = make(nodeMap), 0, 0
+	return nil
+}
+
+// cacheKey returns the key of a node in the clean cache.
+func cacheKey(owner common.Hash, path []byte) []byte {
+	if owner == (common.Hash{}) {
+		return path
+	}
+	return append(owner.Bytes(), path...)
+}
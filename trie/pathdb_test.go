@@ -0,0 +1,219 @@
+// Copyright 2023 The core-geth Authors
+// This file is part of the core-geth library.
+//
+// The core-geth library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The core-geth library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the core-geth library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// pathTester builds a chain of states over a path-based database, where each
+// state updates all the keys with a value specific to the state.
+type pathTester struct {
+	disk  ethdb.Database
+	db    *Database
+	roots []common.Hash // State roots, the first one is the empty state
+}
+
+func newPathTester(t *testing.T, history uint64, states int) *pathTester {
+	disk := rawdb.NewMemoryDatabase()
+	tester := &pathTester{
+		disk:  disk,
+		db:    NewDatabaseWithConfig(disk, &Config{PathDB: &PathConfig{StateHistory: history}}),
+		roots: []common.Hash{types.EmptyRootHash},
+	}
+	for i := 1; i <= states; i++ {
+		tester.extend(t, i)
+	}
+	return tester
+}
+
+// extend creates a new state on top of the last one.
+func (tester *pathTester) extend(t *testing.T, n int) {
+	parent := tester.roots[len(tester.roots)-1]
+	tr, err := New(TrieID(parent), tester.db)
+	if err != nil {
+		t.Fatalf("failed to open state %d: %v", n-1, err)
+	}
+	for i := 0; i < 16; i++ {
+		tr.Update([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%02d-%d", i, n)))
+	}
+	root, set := tr.Commit(false)
+	if err := tester.db.UpdateState(root, parent, NewWithNodeSet(set)); err != nil {
+		t.Fatalf("failed to update state %d: %v", n, err)
+	}
+	tester.roots = append(tester.roots, root)
+}
+
+// check ensures the state with the given number is readable from db.
+func (tester *pathTester) check(t *testing.T, db *Database, n int) {
+	t.Helper()
+	tr, err := New(TrieID(tester.roots[n]), db)
+	if err != nil {
+		t.Fatalf("failed to open state %d: %v", n, err)
+	}
+	for i := 0; i < 16; i++ {
+		key := fmt.Sprintf("key-%02d", i)
+		want := fmt.Sprintf("value-%02d-%d", i, n)
+		if have, err := tr.TryGet([]byte(key)); err != nil || string(have) != want {
+			t.Fatalf("state %d, key %s: have %q (err %v), want %q", n, key, have, err, want)
+		}
+	}
+}
+
+func TestPathDatabaseLayers(t *testing.T) {
+	tester := newPathTester(t, 0, 8)
+	for i := 1; i <= 8; i++ {
+		tester.check(t, tester.db, i)
+	}
+	// Flatten all but the last two diff layers into the disk
+	tester.db.path.lock.Lock()
+	if err := tester.db.path.cap(tester.roots[8], 2); err != nil {
+		t.Fatalf("failed to cap layers: %v", err)
+	}
+	tester.db.path.lock.Unlock()
+
+	for i := 0; i < 6; i++ {
+		if tester.db.path.reader(tester.roots[i]) != nil {
+			t.Fatalf("state %d still available after flattening", i)
+		}
+	}
+	for i := 6; i <= 8; i++ {
+		tester.check(t, tester.db, i)
+	}
+	if disk := tester.db.path.disk(); disk.root != tester.roots[6] || disk.id != 6 {
+		t.Fatalf("disk layer mismatch: have %#x (%d), want %#x (6)", disk.root, disk.id, tester.roots[6])
+	}
+	for id := uint64(1); id <= 6; id++ {
+		if len(rawdb.ReadStateHistory(tester.disk, id)) == 0 {
+			t.Fatalf("state history %d missing", id)
+		}
+	}
+	if len(rawdb.ReadStateHistory(tester.disk, 7)) != 0 {
+		t.Fatal("state history of diff layer persisted")
+	}
+}
+
+func TestPathDatabaseCommit(t *testing.T) {
+	tester := newPathTester(t, 0, 4)
+	if err := tester.db.Commit(tester.roots[4], false); err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	if root, id := tester.db.path.persisted(); root != tester.roots[4] || id != 4 {
+		t.Fatalf("persisted state mismatch: have %#x (%d), want %#x (4)", root, id, tester.roots[4])
+	}
+	// Reopen the database without any journal, the persisted state is loaded
+	db := NewDatabaseWithConfig(tester.disk, &Config{PathDB: &PathConfig{}})
+	tester.check(t, db, 4)
+	if !db.Initialized(common.Hash{}) {
+		t.Fatal("database not initialized")
+	}
+}
+
+func TestPathDatabaseJournal(t *testing.T) {
+	tester := newPathTester(t, 0, 6)
+	tester.db.path.lock.Lock()
+	if err := tester.db.path.cap(tester.roots[6], 3); err != nil {
+		t.Fatalf("failed to cap layers: %v", err)
+	}
+	tester.db.path.lock.Unlock()
+
+	if err := tester.db.Journal(tester.roots[6]); err != nil {
+		t.Fatalf("failed to journal layers: %v", err)
+	}
+	db := NewDatabaseWithConfig(tester.disk, &Config{PathDB: &PathConfig{}})
+	for i := 3; i <= 6; i++ {
+		tester.check(t, db, i)
+	}
+	if len(rawdb.ReadTrieJournal(tester.disk)) != 0 {
+		t.Fatal("journal not consumed")
+	}
+	// The journal is consumed, a crash loses all the layers along with the
+	// buffered nodes, and the histories of the lost states are dropped
+	db = NewDatabaseWithConfig(tester.disk, &Config{PathDB: &PathConfig{}})
+	if db.path.reader(tester.roots[3]) != nil {
+		t.Fatal("buffered state restored without journal")
+	}
+	if db.Initialized(common.Hash{}) {
+		t.Fatal("database initialized without persisted state")
+	}
+	for id := uint64(1); id <= 3; id++ {
+		if len(rawdb.ReadStateHistory(tester.disk, id)) != 0 {
+			t.Fatalf("state history %d of lost state not dropped", id)
+		}
+	}
+}
+
+func TestPathDatabaseRecover(t *testing.T) {
+	tester := newPathTester(t, 0, 6)
+	if err := tester.db.Commit(tester.roots[6], false); err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	if tester.db.Recoverable(tester.roots[6]) {
+		t.Fatal("disk state reported as recoverable")
+	}
+	for i := 0; i < 6; i++ {
+		if !tester.db.Recoverable(tester.roots[i]) {
+			t.Fatalf("state %d not recoverable", i)
+		}
+	}
+	if err := tester.db.Recover(tester.roots[2]); err != nil {
+		t.Fatalf("failed to recover state: %v", err)
+	}
+	tester.check(t, tester.db, 2)
+	if root, id := tester.db.path.persisted(); root != tester.roots[2] || id != 2 {
+		t.Fatalf("persisted state mismatch: have %#x (%d), want %#x (2)", root, id, tester.roots[2])
+	}
+	for id := uint64(3); id <= 6; id++ {
+		if len(rawdb.ReadStateHistory(tester.disk, id)) != 0 {
+			t.Fatalf("reverted state history %d not deleted", id)
+		}
+	}
+	// The reverted states can be rebuilt on top of the recovered one
+	tester.roots = tester.roots[:3]
+	tester.extend(t, 3)
+	tester.check(t, tester.db, 3)
+}
+
+func TestPathDatabasePrunedHistory(t *testing.T) {
+	tester := newPathTester(t, 2, 6)
+	if err := tester.db.Commit(tester.roots[6], false); err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		if tester.db.Recoverable(tester.roots[i]) {
+			t.Fatalf("state %d recoverable with pruned histories", i)
+		}
+	}
+	for i := 4; i < 6; i++ {
+		if !tester.db.Recoverable(tester.roots[i]) {
+			t.Fatalf("state %d not recoverable", i)
+		}
+	}
+	if err := tester.db.Recover(tester.roots[3]); err == nil {
+		t.Fatal("recovered state with pruned histories")
+	}
+	if err := tester.db.Recover(tester.roots[4]); err != nil {
+		t.Fatalf("failed to recover state: %v", err)
+	}
+	tester.check(t, tester.db, 4)
+}